@@ -0,0 +1,351 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"github.com/RA341/receipt-processor-challenge/config"
+	"github.com/RA341/receipt-processor-challenge/models"
+	"github.com/RA341/receipt-processor-challenge/service"
+	u "github.com/RA341/receipt-processor-challenge/utils"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type AdminHandler struct {
+	srv *service.ReceiptService
+}
+
+func NewAdminHandler(srv *service.ReceiptService) (string, *AdminHandler) {
+	return "/admin/", &AdminHandler{srv: srv}
+}
+
+// ServeHTTP handles the /admin path.
+func (ah *AdminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// The segments should look like: "", "admin", "{resource}", ...
+	pathSegments := strings.Split(strings.TrimSuffix(r.URL.Path, "/"), "/")
+
+	switch {
+	case r.Method == http.MethodPost && len(pathSegments) == 3 && pathSegments[2] == "campaigns":
+		ah.PostCampaign(w, r)
+	case r.Method == http.MethodGet && len(pathSegments) == 3 && pathSegments[2] == "campaigns":
+		ah.GetCampaigns(w, r)
+	case len(pathSegments) >= 3 && pathSegments[2] == "retailers":
+		ah.serveRetailers(w, r, pathSegments[3:])
+	case r.Method == http.MethodGet && len(pathSegments) == 3 && pathSegments[2] == "dead-letters":
+		sendJsonResponse(w, ah.srv.ListDeadLetters())
+	case r.Method == http.MethodPost && len(pathSegments) == 5 && pathSegments[2] == "dead-letters" && pathSegments[4] == "retry":
+		ah.PostRetryDeadLetter(w, pathSegments[3])
+	case len(pathSegments) >= 3 && pathSegments[2] == "webhooks":
+		ah.serveWebhooks(w, r, pathSegments[3:])
+	case len(pathSegments) >= 3 && pathSegments[2] == "reviews":
+		ah.serveReviews(w, r, pathSegments[3:])
+	case len(pathSegments) >= 3 && pathSegments[2] == "rules":
+		ah.serveRules(w, r, pathSegments[3:])
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		slog.Warn(fmt.Sprintf("Method %s not supported", r.Method), slog.String("path", r.URL.Path))
+	}
+}
+
+func (ah *AdminHandler) PostCampaign(w http.ResponseWriter, r *http.Request) {
+	var campaign models.Campaign
+	if err := readJsonBody(w, r, &campaign); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	created, err := ah.srv.CreateCampaign(campaign)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	sendJsonResponseWithStatus(w, http.StatusCreated, created)
+}
+
+// GetCampaigns lists campaigns, ?active=<RFC3339 timestamp> only returns the ones running at that time
+func (ah *AdminHandler) GetCampaigns(w http.ResponseWriter, r *http.Request) {
+	var activeAt time.Time
+	if active := r.URL.Query().Get("active"); active != "" {
+		parsed, err := time.Parse(time.RFC3339, active)
+		if err != nil {
+			http.Error(w, "invalid active timestamp: must be RFC3339", http.StatusBadRequest)
+			return
+		}
+		activeAt = parsed
+	}
+
+	sendJsonResponse(w, ah.srv.ListCampaigns(activeAt))
+}
+
+// serveRetailers handles the retailer catalog CRUD under /admin/retailers
+func (ah *AdminHandler) serveRetailers(w http.ResponseWriter, r *http.Request, rest []string) {
+	switch {
+	case r.Method == http.MethodGet && len(rest) == 0:
+		sendJsonResponse(w, ah.srv.ListRetailers())
+	case r.Method == http.MethodPost && len(rest) == 0:
+		ah.PostRetailer(w, r)
+	case r.Method == http.MethodGet && len(rest) == 1:
+		retailer, err := ah.srv.GetRetailer(rest[0])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		sendJsonResponse(w, retailer)
+	case r.Method == http.MethodPut && len(rest) == 1:
+		ah.PutRetailer(w, r, rest[0])
+	case r.Method == http.MethodDelete && len(rest) == 1:
+		if err := ah.srv.DeleteRetailer(rest[0]); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		slog.Warn(fmt.Sprintf("Method %s not supported", r.Method), slog.String("path", r.URL.Path))
+	}
+}
+
+func (ah *AdminHandler) PostRetailer(w http.ResponseWriter, r *http.Request) {
+	var retailer models.Retailer
+	if err := readJsonBody(w, r, &retailer); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	created, err := ah.srv.CreateRetailer(retailer)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	sendJsonResponseWithStatus(w, http.StatusCreated, created)
+}
+
+func (ah *AdminHandler) PutRetailer(w http.ResponseWriter, r *http.Request, id string) {
+	var retailer models.Retailer
+	if err := readJsonBody(w, r, &retailer); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	retailer.Id = id
+
+	if _, err := ah.srv.GetRetailer(id); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	updated, err := ah.srv.UpdateRetailer(retailer)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	sendJsonResponse(w, updated)
+}
+
+// serveWebhooks handles the webhook subscriptions under /admin/webhooks
+func (ah *AdminHandler) serveWebhooks(w http.ResponseWriter, r *http.Request, rest []string) {
+	switch {
+	case r.Method == http.MethodGet && len(rest) == 0:
+		sendJsonResponse(w, ah.srv.ListWebhooks())
+	case r.Method == http.MethodPost && len(rest) == 0:
+		ah.PostWebhook(w, r)
+	case r.Method == http.MethodGet && len(rest) == 1:
+		subscription, err := ah.srv.GetWebhook(rest[0])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		sendJsonResponse(w, subscription)
+	case r.Method == http.MethodDelete && len(rest) == 1:
+		if err := ah.srv.DeleteWebhook(rest[0]); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodGet && len(rest) == 2 && rest[1] == "deliveries":
+		deliveries, err := ah.srv.ListWebhookDeliveries(rest[0])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		sendJsonResponse(w, deliveries)
+	case r.Method == http.MethodPost && len(rest) == 4 && rest[1] == "deliveries" && rest[3] == "redeliver":
+		err := ah.srv.RedeliverWebhook(rest[0], rest[2])
+		switch {
+		case errors.Is(err, service.ErrWebhookNotFound) || errors.Is(err, service.ErrDeliveryNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		default:
+			w.WriteHeader(http.StatusAccepted)
+		}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		slog.Warn(fmt.Sprintf("Method %s not supported", r.Method), slog.String("path", r.URL.Path))
+	}
+}
+
+func (ah *AdminHandler) PostWebhook(w http.ResponseWriter, r *http.Request) {
+	var subscription models.WebhookSubscription
+	if err := readJsonBody(w, r, &subscription); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	created, err := ah.srv.CreateWebhook(subscription)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	sendJsonResponseWithStatus(w, http.StatusCreated, created)
+}
+
+func (ah *AdminHandler) PostRetryDeadLetter(w http.ResponseWriter, receiptId string) {
+	err := ah.srv.RetryDeadLetter(receiptId)
+	switch {
+	case errors.Is(err, service.ErrQueueFull):
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, QueueFullErr, http.StatusServiceUnavailable)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusNotFound)
+	default:
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// RejectRequest is the body of POST /admin/reviews/{id}/reject
+type RejectRequest struct {
+	Reason string `json:"reason"`
+}
+
+// serveReviews handles the review queue under /admin/reviews
+func (ah *AdminHandler) serveReviews(w http.ResponseWriter, r *http.Request, rest []string) {
+	actor := actorFrom(r)
+
+	switch {
+	case r.Method == http.MethodGet && len(rest) == 0:
+		sendJsonResponse(w, ah.srv.ListReviews())
+	case r.Method == http.MethodGet && len(rest) == 1 && rest[0] == "audit":
+		sendJsonResponse(w, ah.srv.ReviewAudit(r.URL.Query().Get("receiptId")))
+	case r.Method == http.MethodPost && len(rest) == 2 && rest[1] == "claim":
+		item, err := ah.srv.ClaimReview(rest[0], actor)
+		if err != nil {
+			sendReviewError(w, err)
+			return
+		}
+		sendJsonResponse(w, item)
+	case r.Method == http.MethodPost && len(rest) == 2 && rest[1] == "release":
+		if err := ah.srv.ReleaseReview(rest[0], actor); err != nil {
+			sendReviewError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPost && len(rest) == 2 && rest[1] == "approve":
+		record, err := ah.srv.ApproveReview(rest[0], actor)
+		if err != nil {
+			sendReviewError(w, err)
+			return
+		}
+		sendJsonResponse(w, record)
+	case r.Method == http.MethodPost && len(rest) == 2 && rest[1] == "reject":
+		ah.PostRejectReview(w, r, rest[0], actor)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		slog.Warn(fmt.Sprintf("Method %s not supported", r.Method), slog.String("path", r.URL.Path))
+	}
+}
+
+func (ah *AdminHandler) PostRejectReview(w http.ResponseWriter, r *http.Request, receiptId, actor string) {
+	var request RejectRequest
+	if err := readJsonBody(w, r, &request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	record, err := ah.srv.RejectReview(receiptId, actor, request.Reason)
+	if err != nil {
+		sendReviewError(w, err)
+		return
+	}
+	sendJsonResponse(w, record)
+}
+
+func sendReviewError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, service.ErrReviewNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, service.ErrReviewClaimed):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
+
+// serveRules handles the live rule management under /admin/rules, every change takes ?dryRun=true
+// to validate it without applying it
+func (ah *AdminHandler) serveRules(w http.ResponseWriter, r *http.Request, rest []string) {
+	edit := service.RuleEdit{Actor: actorFrom(r), DryRun: r.URL.Query().Get("dryRun") == "true"}
+
+	switch {
+	case r.Method == http.MethodGet && len(rest) == 0:
+		sendJsonResponse(w, ah.srv.ActiveRules())
+		return
+	case r.Method == http.MethodGet && len(rest) == 1 && rest[0] == "history":
+		sendJsonResponse(w, ah.srv.RuleHistory(r.URL.Query().Get("rule")))
+		return
+	case r.Method == http.MethodPost && len(rest) == 0:
+		edit.Action = models.RuleCreate
+	case r.Method == http.MethodPut && len(rest) == 1:
+		edit.Action, edit.Name = models.RuleUpdate, rest[0]
+	case r.Method == http.MethodPost && len(rest) == 2 && (rest[1] == models.RuleEnable || rest[1] == models.RuleDisable):
+		edit.Action, edit.Name = rest[1], rest[0]
+	case r.Method == http.MethodDelete && len(rest) == 1:
+		edit.Action, edit.Name = models.RuleDelete, rest[0]
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		slog.Warn(fmt.Sprintf("Method %s not supported", r.Method), slog.String("path", r.URL.Path))
+		return
+	}
+
+	// the rule is required to create or update one, the sample receipts are always optional
+	needsRule := edit.Action == models.RuleCreate || edit.Action == models.RuleUpdate
+	if needsRule || (r.Body != http.NoBody && r.ContentLength != 0) {
+		var request models.RuleChangeRequest
+		if err := readJsonBody(w, r, &request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		edit.Rule, edit.Receipts = request.Rule, request.Receipts
+	}
+
+	response, err := ah.srv.EditRules(edit)
+	switch {
+	case errors.Is(err, service.ErrRuleNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, service.ErrRuleExists):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, service.ErrRulesNotSaved):
+		slog.Error("Rule change not applied", u.ErrLog(err))
+		http.Error(w, InternalErr, http.StatusInternalServerError)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case response.Applied && edit.Action == models.RuleCreate:
+		sendJsonResponseWithStatus(w, http.StatusCreated, response)
+	default:
+		sendJsonResponse(w, response)
+	}
+}
+
+// readJsonBody decodes an admin request body, rejecting unknown fields
+func readJsonBody(w http.ResponseWriter, r *http.Request, v any) error {
+	err := decodeJsonBody(w, r, v, config.Get().Receipts.MaxBodyBytes, true)
+	if errors.Is(err, errBodyTooLarge) {
+		return fmt.Errorf("request body is larger than %d bytes", config.Get().Receipts.MaxBodyBytes)
+	}
+	return err
+}
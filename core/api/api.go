@@ -1,34 +1,146 @@
 package api
 
 import (
+	"context"
 	"fmt"
+	"github.com/RA341/receipt-processor-challenge/config"
 	"github.com/RA341/receipt-processor-challenge/service"
 	u "github.com/RA341/receipt-processor-challenge/utils"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 )
 
+// shutdownTimeout bounds how long in-flight requests get to finish once the server is asked to stop
+const shutdownTimeout = 30 * time.Second
+
+// StartServer serves the api until an interrupt or SIGTERM, then stops taking requests, waits for the
+// in-flight ones and lets the queued receipts and webhook deliveries finish before returning
 func StartServer(addr string) {
 	mux := http.NewServeMux()
-	registerEndpoints(mux)
+	receiptSrv := registerEndpoints(mux)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// event streams only end when their request context does, so shutting down cancels them
+	streams, cancelStreams := context.WithCancel(context.Background())
+	server := &http.Server{
+		Addr:    addr,
+		Handler: mux,
+		BaseContext: func(net.Listener) context.Context {
+			return streams
+		},
+	}
+	server.RegisterOnShutdown(cancelStreams)
+
+	serveErr := make(chan error, 1)
+	go func() {
+		slog.Info("Server listening on", slog.String("addr", addr))
+		serveErr <- server.ListenAndServe()
+	}()
 
-	slog.Info("Server listening on", slog.String("addr", addr))
-	if err := http.ListenAndServe(addr, mux); err != nil {
+	select {
+	case err := <-serveErr:
 		slog.Error("Unable to start server ", u.ErrLog(err))
+		receiptSrv.Close()
 		os.Exit(1)
+	case <-ctx.Done():
 	}
+	stop()
+
+	slog.Info("Shutting down, waiting for in-flight requests")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Warn("In-flight requests did not finish in time", u.ErrLog(err))
+	}
+	receiptSrv.Close()
+	slog.Info("Server stopped")
 }
 
-func registerEndpoints(mux *http.ServeMux) {
+// registerEndpoints adds the api to the mux and returns the receipt service behind it, which the caller closes
+func registerEndpoints(mux *http.ServeMux) *service.ReceiptService {
 	receiptSrv, err := initServices()
 	if err != nil {
 		slog.Error("Unable to initialize services:", u.ErrLog(err))
 		os.Exit(1)
 	}
 
+	protect, err := initAuth()
+	if err != nil {
+		slog.Error("Unable to initialize auth:", u.ErrLog(err))
+		os.Exit(1)
+	}
+
+	limitIP, limitPrincipal := initRateLimits()
+	// the per ip limit comes first so requests failing authentication are limited too
+	guard := func(scopeFor scopeResolver, next http.Handler) http.Handler {
+		return limitIP(protect(scopeFor, limitPrincipal(next)))
+	}
+
 	baseRoute, rHandler := NewReceiptHandler(receiptSrv)
-	mux.Handle(baseRoute, rHandler)
+	mux.Handle(baseRoute, guard(receiptScope, rHandler))
+	// listing is served on /receipts without the trailing slash as well
+	mux.Handle(strings.TrimSuffix(baseRoute, "/"), guard(receiptScope, rHandler))
+
+	memberRoute, mHandler := NewMemberHandler(receiptSrv)
+	mux.Handle(memberRoute, guard(readScope, mHandler))
+
+	eventsRoute, eHandler := NewEventsHandler(receiptSrv)
+	mux.Handle(eventsRoute, guard(readScope, eHandler))
+
+	reportsRoute, rpHandler := NewReportsHandler(receiptSrv)
+	mux.Handle(reportsRoute, guard(readScope, rpHandler))
+
+	rulesRoute, ruHandler := NewRulesHandler(receiptSrv)
+	mux.Handle(rulesRoute, guard(adminScope, ruHandler))
+
+	adminRoute, aHandler := NewAdminHandler(receiptSrv)
+	mux.Handle(adminRoute, guard(adminScope, aHandler))
+	return receiptSrv
+}
+
+// initRateLimits returns the per ip and the per principal rate limiting middleware,
+// which are no-ops if rate limits are disabled
+func initRateLimits() (ip, principal func(http.Handler) http.Handler) {
+	cfg := config.Get().RateLimits
+	if !cfg.Enabled {
+		noop := func(next http.Handler) http.Handler {
+			return next
+		}
+		return noop, noop
+	}
+
+	limiter := NewRateLimiter(cfg, service.NewMemoryRateLimitStore())
+	return limiter.LimitIP, limiter.LimitPrincipal
+}
+
+// initAuth returns a wrapper that requires the scope on a handler, which is a no-op if auth is disabled
+func initAuth() (func(scopeResolver, http.Handler) http.Handler, error) {
+	cfg := config.Get().Auth
+	if !cfg.Enabled {
+		slog.Warn("Auth is disabled, anyone who can reach the server can submit receipts")
+		return func(_ scopeResolver, next http.Handler) http.Handler {
+			return next
+		}, nil
+	}
+
+	keys, err := service.NewAPIKeyStore(cfg.KeysFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load api keys: %v", err)
+	}
+
+	var jwt *service.JWTVerifier
+	if cfg.JWT.JWKS != "" {
+		jwt = service.NewJWTVerifier(cfg.JWT)
+	}
+	return NewAuthenticator(keys, jwt).Require, nil
 }
 
 func initServices() (*service.ReceiptService, error) {
@@ -37,6 +149,31 @@ func initServices() (*service.ReceiptService, error) {
 		return nil, fmt.Errorf("unable to connect to db: %v", err)
 	}
 
-	srv := service.NewReceiptService(db)
+	categorizer, err := service.NewCategorizer(config.Get().Categories)
+	if err != nil {
+		return nil, fmt.Errorf("unable to build item categorizer: %v", err)
+	}
+
+	// registers the plugins, so they load before the rule files that refer to them
+	rules, err := service.RulesWithPlugins(config.Get().Plugins)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load plugin rules: %v", err)
+	}
+
+	experiment, err := service.NewExperiment(config.Get().Experiment)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load experiment: %v", err)
+	}
+
+	registry, err := service.LoadRuleRegistry(config.Get().Rules.HistoryFile, rules)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load rule history: %v", err)
+	}
+
+	srv := service.NewReceiptService(db,
+		service.WithRuleRegistry(registry),
+		service.WithCategorizer(categorizer),
+		service.WithExperiment(experiment),
+	)
 	return srv, nil
 }
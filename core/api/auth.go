@@ -0,0 +1,132 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"github.com/RA341/receipt-processor-challenge/service"
+	u "github.com/RA341/receipt-processor-challenge/utils"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+const (
+	apiKeyHeader        = "X-API-Key"
+	authorizationHeader = "Authorization"
+	bearerPrefix        = "Bearer "
+)
+
+var (
+	UnauthorizedErr = "Missing or invalid credentials."
+	ForbiddenErr    = "Not allowed to access this resource."
+)
+
+// Principal is the authenticated caller of a request, either an API key client or a member with a JWT
+type Principal struct {
+	ClientId string
+	MemberId string
+	Scopes   []string
+}
+
+// canAccessMember reports whether the caller may see data of the member,
+// members with a JWT only see their own, API key clients and admins see everything
+func (p Principal) canAccessMember(memberId string) bool {
+	return p.MemberId == "" || p.MemberId == memberId || service.HasScope(p.Scopes, service.ScopeAdmin)
+}
+
+type principalKey struct{}
+
+func withPrincipal(ctx context.Context, principal Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// principalFrom returns the caller, ok is false if auth is disabled
+func principalFrom(ctx context.Context) (Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(Principal)
+	return principal, ok
+}
+
+// actorFrom names the caller for audit trails
+func actorFrom(r *http.Request) string {
+	principal, ok := principalFrom(r.Context())
+	switch {
+	case !ok:
+		return "anonymous"
+	case principal.ClientId != "":
+		return "client:" + principal.ClientId
+	default:
+		return "member:" + principal.MemberId
+	}
+}
+
+// scopeResolver returns the scope required for the request
+type scopeResolver func(r *http.Request) string
+
+// receiptScope needs admin to void receipts, submit to post them and read for everything else
+func receiptScope(r *http.Request) string {
+	if r.Method == http.MethodPost && (strings.HasSuffix(r.URL.Path, "/void") || r.URL.Path == "/receipts/import") {
+		return service.ScopeAdmin
+	}
+	if r.Method == http.MethodPost {
+		return service.ScopeSubmit
+	}
+	return service.ScopeRead
+}
+
+func readScope(*http.Request) string {
+	return service.ScopeRead
+}
+
+func adminScope(*http.Request) string {
+	return service.ScopeAdmin
+}
+
+type Authenticator struct {
+	keys *service.APIKeyStore
+	// jwt is nil unless a JWKS is configured
+	jwt *service.JWTVerifier
+}
+
+func NewAuthenticator(keys *service.APIKeyStore, jwt *service.JWTVerifier) *Authenticator {
+	return &Authenticator{keys: keys, jwt: jwt}
+}
+
+// Require wraps next so it is only reached with an API key or bearer JWT holding the scope returned by scopeFor
+func (a *Authenticator) Require(scopeFor scopeResolver, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, err := a.authenticate(r)
+		if err != nil {
+			if !errors.Is(err, service.ErrInvalidAPIKey) && !errors.Is(err, service.ErrInvalidToken) {
+				slog.Error("Unable to authenticate request", u.ErrLog(err))
+			}
+			if a.jwt != nil {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="receipts"`)
+			}
+			http.Error(w, UnauthorizedErr, http.StatusUnauthorized)
+			return
+		}
+
+		if !service.HasScope(principal.Scopes, scopeFor(r)) {
+			http.Error(w, ForbiddenErr, http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(withPrincipal(r.Context(), principal)))
+	})
+}
+
+func (a *Authenticator) authenticate(r *http.Request) (Principal, error) {
+	if authorization := r.Header.Get(authorizationHeader); a.jwt != nil && strings.HasPrefix(authorization, bearerPrefix) {
+		claims, err := a.jwt.Verify(strings.TrimPrefix(authorization, bearerPrefix))
+		if err != nil {
+			return Principal{}, err
+		}
+		return Principal{MemberId: claims.MemberId, Scopes: claims.Scopes}, nil
+	}
+
+	key, err := a.keys.Authenticate(r.Header.Get(apiKeyHeader))
+	if err != nil {
+		return Principal{}, err
+	}
+	return Principal{ClientId: key.ClientId, Scopes: key.Scopes}, nil
+}
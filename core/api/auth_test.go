@@ -0,0 +1,127 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/RA341/receipt-processor-challenge/models"
+	"github.com/RA341/receipt-processor-challenge/service"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestAuthenticator_Require(t *testing.T) {
+	keys, _ := service.NewAPIKeyStore("")
+	submitKey, _, _ := keys.Create("pos-1", []string{service.ScopeSubmit})
+	readKey, _, _ := keys.Create("dashboard", []string{service.ScopeRead})
+
+	receiptSrv, err := initServices()
+	if err != nil {
+		t.Fatalf("Failed to init services: %v", err)
+	}
+	_, rHandler := NewReceiptHandler(receiptSrv)
+	handler := NewAuthenticator(keys, nil).Require(receiptScope, rHandler)
+
+	bodyBytes, err := os.ReadFile("../../examples/simple-receipt.json")
+	if err != nil {
+		t.Fatalf("Failed to load request body: %v", err)
+	}
+
+	cases := map[string]struct {
+		key            string
+		expectedStatus int
+	}{
+		"no key":      {key: "", expectedStatus: http.StatusUnauthorized},
+		"invalid key": {key: "rpk_nope.nope", expectedStatus: http.StatusUnauthorized},
+		"wrong scope": {key: readKey, expectedStatus: http.StatusForbidden},
+		"valid key":   {key: submitKey, expectedStatus: http.StatusOK},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/receipts/process", bytes.NewReader(bodyBytes))
+			req.Header.Set(apiKeyHeader, c.key)
+			resp := httptest.NewRecorder()
+
+			handler.ServeHTTP(resp, req)
+
+			if resp.Code != c.expectedStatus {
+				fatalErr(t, "handler returned wrong status code", resp.Code, c.expectedStatus)
+			}
+			if resp.Code != http.StatusOK {
+				return
+			}
+
+			var responseBody models.IdResponse
+			if err := json.Unmarshal(resp.Body.Bytes(), &responseBody); err != nil {
+				t.Fatalf("Could not unmarshal response body: %v\nBody: %s", err, resp.Body.String())
+			}
+			record, err := receiptSrv.GetReceiptById(responseBody.Id)
+			if err != nil {
+				t.Fatalf("Failed to get receipt: %v", err)
+			}
+			if record.ClientId != "pos-1" {
+				fatalErr(t, "receipt was attributed to the wrong client", record.ClientId, "pos-1")
+			}
+		})
+	}
+}
+
+func TestReceiptHandler_MemberIsolation(t *testing.T) {
+	receiptSrv, err := initServices()
+	if err != nil {
+		t.Fatalf("Failed to init services: %v", err)
+	}
+	_, handler := NewReceiptHandler(receiptSrv)
+
+	bodyBytes, err := os.ReadFile("../../examples/simple-receipt.json")
+	if err != nil {
+		t.Fatalf("Failed to load request body: %v", err)
+	}
+
+	asMember := func(req *http.Request, memberId string) *http.Request {
+		principal := Principal{MemberId: memberId, Scopes: []string{service.ScopeSubmit, service.ScopeRead}}
+		return req.WithContext(withPrincipal(req.Context(), principal))
+	}
+
+	req := asMember(httptest.NewRequest(http.MethodPost, "/receipts/process", bytes.NewReader(bodyBytes)), "member-1")
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	if resp.Code != http.StatusOK {
+		fatalErr(t, "handler returned wrong status code", resp.Code, http.StatusOK)
+	}
+
+	var responseBody models.IdResponse
+	if err := json.Unmarshal(resp.Body.Bytes(), &responseBody); err != nil {
+		t.Fatalf("Could not unmarshal response body: %v\nBody: %s", err, resp.Body.String())
+	}
+	record, _ := receiptSrv.GetReceiptById(responseBody.Id)
+	if record.Receipt.MemberId != "member-1" {
+		fatalErr(t, "receipt was not assigned to the token's member", record.Receipt.MemberId, "member-1")
+	}
+
+	target := "/receipts/" + responseBody.Id + "/points"
+	cases := map[string]int{
+		"member-1": http.StatusOK,
+		"member-2": http.StatusNotFound,
+	}
+	for memberId, expectedStatus := range cases {
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, asMember(httptest.NewRequest(http.MethodGet, target, nil), memberId))
+		if resp.Code != expectedStatus {
+			fatalErr(t, "handler returned wrong status code for "+memberId, resp.Code, expectedStatus)
+		}
+	}
+
+	// submitting a receipt on behalf of another member is not allowed
+	var receipt map[string]any
+	_ = json.Unmarshal(bodyBytes, &receipt)
+	receipt["memberId"] = "member-2"
+	otherBody, _ := json.Marshal(receipt)
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, asMember(httptest.NewRequest(http.MethodPost, "/receipts/process", bytes.NewReader(otherBody)), "member-1"))
+	if resp.Code != http.StatusForbidden {
+		fatalErr(t, "handler returned wrong status code", resp.Code, http.StatusForbidden)
+	}
+}
@@ -0,0 +1,106 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/RA341/receipt-processor-challenge/config"
+	"github.com/RA341/receipt-processor-challenge/models"
+	"github.com/RA341/receipt-processor-challenge/service"
+	u "github.com/RA341/receipt-processor-challenge/utils"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type EventsHandler struct {
+	srv *service.ReceiptService
+	cfg config.EventsConfig
+}
+
+func NewEventsHandler(srv *service.ReceiptService) (string, *EventsHandler) {
+	return "/events/", &EventsHandler{srv: srv, cfg: config.Get().Events}
+}
+
+// ServeHTTP handles the /events path.
+func (eh *EventsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/events/receipts":
+		eh.StreamReceipts(w, r)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		slog.Warn(fmt.Sprintf("Method %s not supported", r.Method), slog.String("path", r.URL.Path))
+	}
+}
+
+// StreamReceipts sends receipt events as Server-Sent Events, optionally filtered by ?retailer= and ?member=.
+// Clients resume with the Last-Event-ID header, and are disconnected if they fall too far behind
+func (eh *EventsHandler) StreamReceipts(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, InternalErr, http.StatusInternalServerError)
+		return
+	}
+
+	retailer := r.URL.Query().Get("retailer")
+	member := r.URL.Query().Get("member")
+	// members only see their own receipts
+	if principal, ok := principalFrom(r.Context()); ok && !principal.canAccessMember(member) {
+		member = principal.MemberId
+	}
+
+	filter := func(event models.ReceiptEvent) bool {
+		return (retailer == "" || strings.EqualFold(event.Retailer, retailer)) &&
+			(member == "" || event.MemberId == member)
+	}
+
+	lastEventId := r.Header.Get("Last-Event-ID")
+	if lastEventId == "" {
+		lastEventId = r.URL.Query().Get("lastEventId")
+	}
+	stream := eh.srv.Events().Stream(lastEventId, eh.cfg.StreamBuffer, filter)
+	defer stream.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(eh.cfg.Heartbeat.Duration)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case event, ok := <-stream.C:
+			if !ok {
+				if stream.Overflowed() {
+					slog.Warn("Dropped slow event stream client", slog.String("remote", r.RemoteAddr))
+				}
+				return
+			}
+			if err := writeEvent(w, event); err != nil {
+				slog.Warn("Unable to write event to client", u.ErrLog(err))
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, event models.ReceiptEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", event.Id, event.Type, data)
+	return err
+}
@@ -0,0 +1,64 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"github.com/RA341/receipt-processor-challenge/models"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEventsHandler_StreamReceipts(t *testing.T) {
+	receiptSrv, err := initServices()
+	if err != nil {
+		t.Fatalf("Failed to init services: %v", err)
+	}
+	_, handler := NewEventsHandler(receiptSrv)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	// published before connecting, replayed with Last-Event-ID
+	first := receiptSrv.Events().Publish(models.ReceiptEvent{Type: models.EventReceiptScored, Retailer: "Target", Points: 1})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/events/receipts?retailer=target", nil)
+	req.Header.Set("Last-Event-ID", "0")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if ctype := resp.Header.Get("Content-Type"); ctype != "text/event-stream" {
+		fatalErr(t, "handler returned wrong Content-Type", ctype, "text/event-stream")
+	}
+
+	receiptSrv.Events().Publish(models.ReceiptEvent{Type: models.EventReceiptScored, Retailer: "Walmart", Points: 2})
+	receiptSrv.Events().Publish(models.ReceiptEvent{Type: models.EventReceiptVoided, Retailer: "Target", Points: 3})
+
+	var events []models.ReceiptEvent
+	var ids []string
+	scanner := bufio.NewScanner(resp.Body)
+	for len(events) < 2 && scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "id: "):
+			ids = append(ids, strings.TrimPrefix(line, "id: "))
+		case strings.HasPrefix(line, "data: "):
+			var event models.ReceiptEvent
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event); err != nil {
+				t.Fatalf("Failed to decode event: %v", err)
+			}
+			events = append(events, event)
+		}
+	}
+
+	if len(events) != 2 || events[0].Id != first.Id || events[1].Points != 3 || ids[1] != events[1].Id {
+		t.Fatalf("Expected the replayed and the matching new event, got %+v", events)
+	}
+}
@@ -0,0 +1,55 @@
+package api
+
+import (
+	"fmt"
+	"github.com/RA341/receipt-processor-challenge/service"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+var (
+	MemberNotFoundErr = "No member found for that ID."
+)
+
+type MemberHandler struct {
+	srv *service.ReceiptService
+}
+
+func NewMemberHandler(srv *service.ReceiptService) (string, *MemberHandler) {
+	return "/members/", &MemberHandler{srv: srv}
+}
+
+// ServeHTTP handles the /members path.
+func (mh *MemberHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// The segments should look like: "", "members", "{id}", "tier"
+	pathSegments := strings.Split(r.URL.Path, "/")
+
+	switch {
+	case r.Method == http.MethodGet && len(pathSegments) == 4 && pathSegments[3] == "tier":
+		mh.GetMemberTier(w, r, pathSegments[2])
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		slog.Warn(fmt.Sprintf("Method %s not supported", r.Method), slog.String("path", r.URL.Path))
+	}
+}
+
+func (mh *MemberHandler) GetMemberTier(w http.ResponseWriter, r *http.Request, memberId string) {
+	if !idRegex.MatchString(memberId) {
+		http.Error(w, MemberNotFoundErr, http.StatusNotFound)
+		return
+	}
+
+	if principal, ok := principalFrom(r.Context()); ok && !principal.canAccessMember(memberId) {
+		http.Error(w, MemberNotFoundErr, http.StatusNotFound)
+		return
+	}
+
+	tier, err := mh.srv.GetMemberTier(memberId)
+	if err != nil {
+		http.Error(w, MemberNotFoundErr, http.StatusNotFound)
+		return
+	}
+
+	sendJsonResponse(w, tier)
+}
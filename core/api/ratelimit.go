@@ -0,0 +1,167 @@
+package api
+
+import (
+	"context"
+	"github.com/RA341/receipt-processor-challenge/config"
+	"github.com/RA341/receipt-processor-challenge/service"
+	u "github.com/RA341/receipt-processor-challenge/utils"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	TooManyRequestsErr = "Too many requests, slow down."
+)
+
+// RateLimiter enforces the per route token bucket limits for api key clients, members and source ips
+type RateLimiter struct {
+	store             service.RateLimitStore
+	routes            []config.RouteLimitConfig
+	trustProxyHeaders bool
+	trustedProxies    int
+}
+
+func NewRateLimiter(cfg config.RateLimitsConfig, store service.RateLimitStore) *RateLimiter {
+	return &RateLimiter{
+		store:             store,
+		routes:            cfg.Routes,
+		trustProxyHeaders: cfg.TrustProxyHeaders,
+		trustedProxies:    max(cfg.TrustedProxies, 1),
+	}
+}
+
+type bucketCheck struct {
+	key   string
+	limit *config.LimitConfig
+}
+
+// LimitIP wraps next so requests over the route's per ip limit get a 429, it runs before
+// authentication so requests with missing or invalid credentials use up the ip's bucket too
+func (rl *RateLimiter) LimitIP(next http.Handler) http.Handler {
+	return rl.limit(next, false)
+}
+
+// LimitPrincipal wraps next so requests over the route's per client or per member limit get a 429,
+// it needs to run after authentication to see the client and member
+func (rl *RateLimiter) LimitPrincipal(next http.Handler) http.Handler {
+	return rl.limit(next, true)
+}
+
+// Limit applies both the per ip and the per principal limits
+func (rl *RateLimiter) Limit(next http.Handler) http.Handler {
+	return rl.LimitIP(rl.LimitPrincipal(next))
+}
+
+// ipLimitKey carries the per ip result to the per principal limits, so the headers report the tightest of them
+type ipLimitKey struct{}
+
+func (rl *RateLimiter) limit(next http.Handler, principalLimits bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route, ok := rl.match(r)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var checks []bucketCheck
+		// report the most restrictive bucket in the headers
+		var tightest *service.RateLimitResult
+		if !principalLimits {
+			checks = append(checks, bucketCheck{key: "ip:" + rl.sourceIp(r), limit: route.PerIP})
+		} else {
+			tightest, _ = r.Context().Value(ipLimitKey{}).(*service.RateLimitResult)
+			if principal, ok := principalFrom(r.Context()); ok {
+				if principal.ClientId != "" {
+					checks = append(checks, bucketCheck{key: "client:" + principal.ClientId, limit: route.PerClient})
+				}
+				if principal.MemberId != "" {
+					checks = append(checks, bucketCheck{key: "member:" + principal.MemberId, limit: route.PerMember})
+				}
+			}
+		}
+
+		for _, check := range checks {
+			if check.limit == nil {
+				continue
+			}
+
+			result, err := rl.store.Take(route.Name+"|"+check.key, service.RateLimit{
+				Requests: check.limit.Requests,
+				Per:      check.limit.Per.Duration,
+				Burst:    check.limit.Burst,
+			})
+			if err != nil {
+				// fail open, an unavailable store shouldn't take the service down
+				slog.Error("Unable to check rate limit", slog.String("key", check.key), u.ErrLog(err))
+				continue
+			}
+
+			if tightest == nil || !result.Allowed || result.Remaining < tightest.Remaining {
+				tightest = &result
+			}
+			if !result.Allowed {
+				break
+			}
+		}
+
+		if tightest == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("RateLimit-Limit", strconv.FormatInt(tightest.Limit, 10))
+		w.Header().Set("RateLimit-Remaining", strconv.FormatInt(tightest.Remaining, 10))
+		w.Header().Set("RateLimit-Reset", ceilSeconds(tightest.Reset))
+
+		if !tightest.Allowed {
+			w.Header().Set("Retry-After", ceilSeconds(tightest.RetryAfter))
+			http.Error(w, TooManyRequestsErr, http.StatusTooManyRequests)
+			slog.Warn("Rate limit exceeded", slog.String("route", route.Name), slog.String("path", r.URL.Path))
+			return
+		}
+
+		if !principalLimits {
+			r = r.WithContext(context.WithValue(r.Context(), ipLimitKey{}, tightest))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (rl *RateLimiter) match(r *http.Request) (config.RouteLimitConfig, bool) {
+	for _, route := range rl.routes {
+		if route.Method != "" && !strings.EqualFold(route.Method, r.Method) {
+			continue
+		}
+		if strings.HasPrefix(r.URL.Path, route.Path) {
+			return route, true
+		}
+	}
+	return config.RouteLimitConfig{}, false
+}
+
+func (rl *RateLimiter) sourceIp(r *http.Request) string {
+	// proxies append the address they got the request from, so only the entries
+	// added by the trusted proxies are real, anything left of them the client made up
+	if forwarded := r.Header.Values("X-Forwarded-For"); rl.trustProxyHeaders && len(forwarded) > 0 {
+		entries := strings.Split(strings.Join(forwarded, ","), ",")
+		entry := entries[max(len(entries)-rl.trustedProxies, 0)]
+		if ip := strings.TrimSpace(entry); ip != "" {
+			return ip
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func ceilSeconds(d time.Duration) string {
+	return strconv.FormatInt(int64(math.Ceil(d.Seconds())), 10)
+}
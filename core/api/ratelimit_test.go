@@ -0,0 +1,141 @@
+package api
+
+import (
+	"github.com/RA341/receipt-processor-challenge/config"
+	"github.com/RA341/receipt-processor-challenge/service"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter_Limit(t *testing.T) {
+	cfg := config.RateLimitsConfig{
+		Enabled: true,
+		Routes: []config.RouteLimitConfig{{
+			Name:      "process",
+			Method:    http.MethodPost,
+			Path:      "/receipts/process",
+			PerIP:     &config.LimitConfig{Requests: 2, Per: config.Duration{Duration: time.Minute}},
+			PerMember: &config.LimitConfig{Requests: 1, Per: config.Duration{Duration: time.Minute}},
+		}},
+	}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := NewRateLimiter(cfg, service.NewMemoryRateLimitStore()).Limit(ok)
+
+	send := func(method, remoteAddr, memberId string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/receipts/process", nil)
+		req.RemoteAddr = remoteAddr
+		if memberId != "" {
+			req = req.WithContext(withPrincipal(req.Context(), Principal{MemberId: memberId}))
+		}
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		return resp
+	}
+
+	if resp := send(http.MethodPost, "10.0.0.1:1234", ""); resp.Code != http.StatusOK || resp.Header().Get("RateLimit-Remaining") != "1" {
+		fatalErr(t, "first request", resp.Code, http.StatusOK)
+	}
+
+	// the member bucket allows one request, even from another ip
+	if resp := send(http.MethodPost, "10.0.0.2:1234", "member-1"); resp.Code != http.StatusOK {
+		fatalErr(t, "first member request", resp.Code, http.StatusOK)
+	}
+	resp := send(http.MethodPost, "10.0.0.3:1234", "member-1")
+	if resp.Code != http.StatusTooManyRequests {
+		fatalErr(t, "second member request", resp.Code, http.StatusTooManyRequests)
+	}
+	if resp.Header().Get("Retry-After") != "60" || resp.Header().Get("RateLimit-Remaining") != "0" {
+		t.Fatalf("Unexpected rate limit headers: %v", resp.Header())
+	}
+
+	if resp := send(http.MethodPost, "10.0.0.1:5678", ""); resp.Code != http.StatusOK {
+		fatalErr(t, "second ip request", resp.Code, http.StatusOK)
+	}
+	if resp := send(http.MethodPost, "10.0.0.1:5678", ""); resp.Code != http.StatusTooManyRequests {
+		fatalErr(t, "third ip request", resp.Code, http.StatusTooManyRequests)
+	}
+
+	// other routes are not limited
+	if resp := send(http.MethodGet, "10.0.0.1:5678", ""); resp.Code != http.StatusOK || resp.Header().Get("RateLimit-Limit") != "" {
+		fatalErr(t, "unlimited route", resp.Code, http.StatusOK)
+	}
+}
+
+func TestRateLimiter_BeforeAuth(t *testing.T) {
+	cfg := config.RateLimitsConfig{
+		Enabled: true,
+		Routes: []config.RouteLimitConfig{{
+			Name:  "process",
+			Path:  "/receipts/process",
+			PerIP: &config.LimitConfig{Requests: 2, Per: config.Duration{Duration: time.Minute}},
+		}},
+	}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	keys, _ := service.NewAPIKeyStore("")
+	limiter := NewRateLimiter(cfg, service.NewMemoryRateLimitStore())
+	handler := limiter.LimitIP(NewAuthenticator(keys, nil).Require(receiptScope, limiter.LimitPrincipal(ok)))
+
+	// guessing keys uses up the ip's bucket like any other request
+	expected := []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusTooManyRequests}
+	for i, status := range expected {
+		req := httptest.NewRequest(http.MethodPost, "/receipts/process", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		req.Header.Set(apiKeyHeader, "rpk_guess.guess")
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		if resp.Code != status {
+			t.Fatalf("Request %d: expected %d but got %d", i+1, status, resp.Code)
+		}
+	}
+}
+
+func TestRateLimiter_SpoofedForwardedFor(t *testing.T) {
+	limits := func(trustedProxies int) http.Handler {
+		cfg := config.RateLimitsConfig{
+			Enabled:           true,
+			TrustProxyHeaders: true,
+			TrustedProxies:    trustedProxies,
+			Routes: []config.RouteLimitConfig{{
+				Name:  "process",
+				Path:  "/receipts/process",
+				PerIP: &config.LimitConfig{Requests: 1, Per: config.Duration{Duration: time.Minute}},
+			}},
+		}
+		ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})
+		return NewRateLimiter(cfg, service.NewMemoryRateLimitStore()).LimitIP(ok)
+	}
+	send := func(handler http.Handler, forwardedFor string) int {
+		req := httptest.NewRequest(http.MethodPost, "/receipts/process", nil)
+		req.RemoteAddr = "10.0.0.100:1234" // the proxy
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		return resp.Code
+	}
+
+	// the proxy appends the client's real address after whatever the client sent
+	handler := limits(1)
+	if code := send(handler, "1.1.1.1, 203.0.113.7"); code != http.StatusOK {
+		fatalErr(t, "first request", code, http.StatusOK)
+	}
+	if code := send(handler, "2.2.2.2, 203.0.113.7"); code != http.StatusTooManyRequests {
+		fatalErr(t, "request with a new spoofed entry", code, http.StatusTooManyRequests)
+	}
+
+	// behind two proxies the second entry from the right is the client
+	handler = limits(2)
+	if code := send(handler, "1.1.1.1, 203.0.113.7, 10.0.0.50"); code != http.StatusOK {
+		fatalErr(t, "first request behind two proxies", code, http.StatusOK)
+	}
+	if code := send(handler, "2.2.2.2, 203.0.113.7, 10.0.0.50"); code != http.StatusTooManyRequests {
+		fatalErr(t, "spoofed request behind two proxies", code, http.StatusTooManyRequests)
+	}
+}
@@ -1,8 +1,11 @@
 package api
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/RA341/receipt-processor-challenge/config"
 	"github.com/RA341/receipt-processor-challenge/models"
 	"github.com/RA341/receipt-processor-challenge/service"
 	u "github.com/RA341/receipt-processor-challenge/utils"
@@ -11,91 +14,254 @@ import (
 	"net/http"
 	"regexp"
 	"strings"
-	"time"
 )
 
 var (
-	retailerPattern = regexp.MustCompile(`^[\w\s\-&]+$`)
-	totalPattern    = regexp.MustCompile(`^\d+\.\d{2}$`)
-	idRegex         = regexp.MustCompile(`^\S+$`)
+	totalPattern = regexp.MustCompile(`^\d+\.\d{2}$`)
+	idRegex      = regexp.MustCompile(`^\S+$`)
 )
 
 var (
 	BadRequestErr = "The receipt is invalid."
 	NotFoundErr   = "No receipt found for that ID."
 	InternalErr   = "Internal server error."
+	TooLargeErr   = "The receipt is too large."
+	QueueFullErr  = "Too many receipts are waiting to be processed, try again later."
+)
+
+var (
+	errBodyTooLarge = errors.New("request body too large")
+	errTooManyItems = errors.New("too many items")
 )
 
 type ReceiptHandler struct {
-	srv *service.ReceiptService
+	srv    *service.ReceiptService
+	limits config.ReceiptsConfig
 }
 
 func NewReceiptHandler(srv *service.ReceiptService) (string, *ReceiptHandler) {
-	return "/receipts/", &ReceiptHandler{srv: srv}
+	return "/receipts/", &ReceiptHandler{srv: srv, limits: config.Get().Receipts}
 }
 
 // ReceiptsHandler is the main handler for the /receipts path.
 func (rh *ReceiptHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	switch r.Method {
-	case http.MethodPost:
+	// The segments should look like: "", "receipts", "process", "import" or "export", or "", "receipts", "{id}", "{action}"
+	pathSegments := strings.Split(r.URL.Path, "/")
+
+	switch {
+	case r.Method == http.MethodGet && (r.URL.Path == "/receipts" || r.URL.Path == "/receipts/"):
+		rh.GetReceipts(w, r)
+	case r.Method == http.MethodGet && r.URL.Path == "/receipts/export":
+		rh.ExportReceipts(w, r)
+	case r.Method == http.MethodPost && len(pathSegments) == 3 && pathSegments[2] == "process":
 		rh.PostProcessReceipt(w, r)
-	case http.MethodGet:
+	case r.Method == http.MethodPost && len(pathSegments) == 3 && pathSegments[2] == "import":
+		rh.ImportReceipts(w, r)
+	case r.Method == http.MethodPost && len(pathSegments) == 4 && pathSegments[3] == "void":
+		rh.PostVoidReceipt(w, r)
+	case r.Method == http.MethodGet && len(pathSegments) == 3:
+		rh.GetReceipt(w, r)
+	case r.Method == http.MethodGet && len(pathSegments) == 4 && pathSegments[3] == "points":
 		rh.GetReceiptPoints(w, r)
+	case r.Method == http.MethodGet && len(pathSegments) == 4 && pathSegments[3] == "status":
+		rh.GetReceiptStatus(w, r)
+	case r.Method == http.MethodGet && len(pathSegments) == 4 && pathSegments[3] == "breakdown":
+		rh.GetReceiptBreakdown(w, r)
 	default:
 		w.WriteHeader(http.StatusNotFound)
-		slog.Warn(fmt.Sprintf("Method %s not supported", r.Method))
+		slog.Warn(fmt.Sprintf("Method %s not supported", r.Method), slog.String("path", r.URL.Path))
 	}
 }
 
 func (rh *ReceiptHandler) GetReceiptPoints(w http.ResponseWriter, r *http.Request) {
-	pathSegments := strings.Split(r.URL.Path, "/")
-	// The segments should look like: "", "receipts", "{id}", "points"
-	if !(len(pathSegments) == 4 && pathSegments[3] == "points") {
-		http.NotFound(w, r)
+	record, ok := rh.lookupReceipt(w, r)
+	if !ok {
 		return
 	}
 
-	pathId := pathSegments[2]
-	if !idRegex.MatchString(pathId) {
-		http.Error(w, NotFoundErr, http.StatusNotFound)
+	if !sendPointsAvailable(w, record) {
 		return
 	}
 
-	points, err := rh.srv.GetPointsById(pathId)
+	response := models.PointsResponse{Points: record.Points}
+	sendJsonResponse(w, response)
+}
+
+func (rh *ReceiptHandler) GetReceiptBreakdown(w http.ResponseWriter, r *http.Request) {
+	record, ok := rh.lookupReceipt(w, r)
+	if !ok {
+		return
+	}
+	if !service.IsScored(record.Status) {
+		sendJsonResponseWithStatus(w, http.StatusAccepted, models.StatusResponse{Id: record.Id, Status: record.Status})
+		return
+	}
+
+	response := models.BreakdownResponse{Points: record.Points, Breakdown: record.Breakdown}
+	sendJsonResponse(w, response)
+}
+
+func (rh *ReceiptHandler) GetReceipt(w http.ResponseWriter, r *http.Request) {
+	record, ok := rh.lookupReceipt(w, r)
+	if !ok {
+		return
+	}
+
+	response := models.ReceiptStatusResponse{
+		Id:            record.Id,
+		Status:        record.Status,
+		StatusHistory: record.StatusHistory,
+		Receipt:       record.Receipt,
+		CreatedAt:     record.CreatedAt,
+	}
+	if service.IsScored(record.Status) {
+		response.Points = &record.Points
+	}
+	sendJsonResponse(w, response)
+}
+
+// GetReceiptStatus returns the lifecycle status of the receipt and the state of its processing job
+func (rh *ReceiptHandler) GetReceiptStatus(w http.ResponseWriter, r *http.Request) {
+	record, ok := rh.lookupReceipt(w, r)
+	if !ok {
+		return
+	}
+
+	job, err := rh.srv.GetJobStatus(record.Id)
 	if err != nil {
 		http.Error(w, NotFoundErr, http.StatusNotFound)
 		return
 	}
+	sendJsonResponse(w, job)
+}
 
-	response := models.PointsResponse{Points: points}
-	sendJsonResponse(w, response)
+// VoidRequest is the body of POST /receipts/{id}/void, Items are the indexes of returned items
+type VoidRequest struct {
+	Reason string `json:"reason"`
+	Items  []int  `json:"items,omitempty"`
+}
+
+// PostVoidReceipt takes back the points of the whole receipt or of the returned items
+func (rh *ReceiptHandler) PostVoidReceipt(w http.ResponseWriter, r *http.Request) {
+	record, ok := rh.lookupReceipt(w, r)
+	if !ok {
+		return
+	}
+
+	var request VoidRequest
+	if err := readJsonBody(w, r, &request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	voided, err := rh.srv.VoidReceipt(record.Id, actorFrom(r), request.Reason, request.Items)
+	if errors.Is(err, service.ErrInvalidTransition) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	sendJsonResponse(w, voided)
+}
+
+// sendPointsAvailable writes the status instead of the points for receipts that have none to give,
+// 202 while they are still being processed or reviewed and 409 once they were rejected or voided
+func sendPointsAvailable(w http.ResponseWriter, record models.ReceiptRecord) bool {
+	status := models.StatusResponse{Id: record.Id, Status: record.Status}
+	switch record.Status {
+	case models.StatusCredited:
+		return true
+	case models.StatusRejected, models.StatusVoided:
+		sendJsonResponseWithStatus(w, http.StatusConflict, status)
+	default:
+		sendJsonResponseWithStatus(w, http.StatusAccepted, status)
+	}
+	return false
+}
+
+// lookupReceipt loads the receipt in the path, writing a 404 if it doesn't exist
+// or belongs to another member than the caller
+func (rh *ReceiptHandler) lookupReceipt(w http.ResponseWriter, r *http.Request) (models.ReceiptRecord, bool) {
+	pathId, ok := receiptIdFromPath(w, r)
+	if !ok {
+		return models.ReceiptRecord{}, false
+	}
+
+	record, err := rh.srv.GetReceiptById(pathId)
+	if err != nil {
+		http.Error(w, NotFoundErr, http.StatusNotFound)
+		return models.ReceiptRecord{}, false
+	}
+
+	if principal, ok := principalFrom(r.Context()); ok && !principal.canAccessMember(record.Receipt.MemberId) {
+		http.Error(w, NotFoundErr, http.StatusNotFound)
+		return models.ReceiptRecord{}, false
+	}
+
+	return record, true
+}
+
+// receiptIdFromPath extracts the id from /receipts/{id}/..., writing a 404 if it is invalid
+func receiptIdFromPath(w http.ResponseWriter, r *http.Request) (string, bool) {
+	pathSegments := strings.Split(r.URL.Path, "/")
+	if len(pathSegments) < 3 {
+		http.NotFound(w, r)
+		return "", false
+	}
+
+	pathId := pathSegments[2]
+	if !idRegex.MatchString(pathId) {
+		http.Error(w, NotFoundErr, http.StatusNotFound)
+		return "", false
+	}
+
+	return pathId, true
 }
 
 func (rh *ReceiptHandler) PostProcessReceipt(w http.ResponseWriter, r *http.Request) {
-	body, err := io.ReadAll(r.Body)
+	body := newReceiptBody(rh.limits)
+	err := decodeJsonBody(w, r, &body, rh.limits.MaxBodyBytes, rh.limits.DisallowUnknownFields)
+	receipt := body.receipt()
+	if errors.Is(err, errBodyTooLarge) || errors.Is(err, errTooManyItems) {
+		http.Error(w, TooLargeErr, http.StatusRequestEntityTooLarge)
+		return
+	}
 	if err != nil {
 		http.Error(w, BadRequestErr, http.StatusBadRequest)
 		return
 	}
-	defer func(Body io.ReadCloser) {
-		err := Body.Close()
-		if err != nil {
-			slog.Warn("error occurred while closing request body", u.ErrLog(err))
-		}
-	}(r.Body)
 
-	var receipt models.Receipt
-	if err := json.Unmarshal(body, &receipt); err != nil {
+	if err := service.ValidateReceipt(receipt); err != nil {
 		http.Error(w, BadRequestErr, http.StatusBadRequest)
 		return
 	}
 
-	if err := validateReceipt(receipt); err != nil {
-		http.Error(w, BadRequestErr, http.StatusBadRequest)
+	var submitter service.Submitter
+	if principal, ok := principalFrom(r.Context()); ok {
+		submitter.ClientId = principal.ClientId
+		// members can only submit receipts for themselves
+		if principal.MemberId != "" {
+			if receipt.MemberId != "" && receipt.MemberId != principal.MemberId {
+				http.Error(w, ForbiddenErr, http.StatusForbidden)
+				return
+			}
+			receipt.MemberId = principal.MemberId
+		}
+	}
+
+	if rh.srv.IsAsync() {
+		rh.enqueueReceipt(w, submitter, receipt)
 		return
 	}
 
-	receiptId, err := rh.srv.NewReceipt(receipt)
+	receiptId, err := rh.srv.SubmitReceipt(submitter, receipt)
+	if errors.Is(err, service.ErrReceiptRejected) {
+		http.Error(w, BadRequestErr, http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		slog.Error("Unable to store receipt", u.ErrLog(err))
 		http.Error(w, InternalErr, http.StatusInternalServerError)
@@ -106,7 +272,66 @@ func (rh *ReceiptHandler) PostProcessReceipt(w http.ResponseWriter, r *http.Requ
 	sendJsonResponse(w, resp)
 }
 
+// enqueueReceipt queues a validated receipt for scoring and answers with 202 and its id
+func (rh *ReceiptHandler) enqueueReceipt(w http.ResponseWriter, submitter service.Submitter, receipt models.Receipt) {
+	receiptId, err := rh.srv.EnqueueReceipt(submitter, receipt)
+	if errors.Is(err, service.ErrQueueFull) {
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, QueueFullErr, http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		slog.Error("Unable to queue receipt", u.ErrLog(err))
+		http.Error(w, InternalErr, http.StatusInternalServerError)
+		return
+	}
+
+	sendJsonResponseWithStatus(w, http.StatusAccepted, models.IdResponse{Id: receiptId})
+}
+
+// decodeJsonBody streams a single json value from the body into v, reading at most maxBytes.
+// Oversized bodies return errBodyTooLarge, and anything after the value is rejected
+func decodeJsonBody(w http.ResponseWriter, r *http.Request, v any, maxBytes int64, disallowUnknownFields bool) error {
+	body := http.MaxBytesReader(w, r.Body, maxBytes)
+	defer func(Body io.ReadCloser) {
+		err := Body.Close()
+		if err != nil {
+			slog.Warn("error occurred while closing request body", u.ErrLog(err))
+		}
+	}(body)
+
+	decoder := json.NewDecoder(body)
+	if disallowUnknownFields {
+		decoder.DisallowUnknownFields()
+	}
+
+	var maxBytesErr *http.MaxBytesError
+	if err := decoder.Decode(v); err != nil {
+		if errors.As(err, &maxBytesErr) {
+			return errBodyTooLarge
+		}
+		if errors.Is(err, errTooManyItems) {
+			return err
+		}
+		return fmt.Errorf("invalid json body: %v", err)
+	}
+
+	var trailing json.RawMessage
+	switch err := decoder.Decode(&trailing); {
+	case errors.Is(err, io.EOF):
+		return nil
+	case errors.As(err, &maxBytesErr):
+		return errBodyTooLarge
+	default:
+		return fmt.Errorf("invalid json body: unexpected data after the json value")
+	}
+}
+
 func sendJsonResponse(w http.ResponseWriter, jsonPayload any) {
+	sendJsonResponseWithStatus(w, http.StatusOK, jsonPayload)
+}
+
+func sendJsonResponseWithStatus(w http.ResponseWriter, status int, jsonPayload any) {
 	marshal, err := json.Marshal(jsonPayload)
 	if err != nil {
 		slog.Error("Unable to marshal response to client", u.ErrLog(err))
@@ -115,41 +340,65 @@ func sendJsonResponse(w http.ResponseWriter, jsonPayload any) {
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
 	_, err = w.Write(marshal)
 	if err != nil {
 		slog.Warn("Unable to write response to client", u.ErrLog(err))
 	}
 }
 
-func validateReceipt(receipt models.Receipt) error {
-	if !retailerPattern.MatchString(receipt.Retailer) {
-		return fmt.Errorf("invalid retailer name: must contain only alphanumeric characters, spaces, hyphens, and ampersands")
-	}
+// receiptBody decodes a submitted receipt, its items are decoded one at a time
+// so a receipt with too many of them fails before they are all allocated
+type receiptBody struct {
+	models.Receipt
+	Items boundedItems `json:"items"`
+}
 
-	if _, err := time.Parse("2006-01-02", receipt.PurchaseDate); err != nil {
-		return fmt.Errorf("invalid purchaseDate format: must be YYYY-MM-DD")
-	}
+func newReceiptBody(limits config.ReceiptsConfig) receiptBody {
+	return receiptBody{Items: boundedItems{max: limits.MaxItems, disallowUnknownFields: limits.DisallowUnknownFields}}
+}
+
+func (b receiptBody) receipt() models.Receipt {
+	receipt := b.Receipt
+	receipt.Items = b.Items.items
+	return receipt
+}
 
-	if _, err := time.Parse("15:04", receipt.PurchaseTime); err != nil {
-		return fmt.Errorf("invalid purchaseTime format: must be in 24-hour format (HH:MM)")
+// boundedItems is a json array of items that fails with errTooManyItems after max of them
+type boundedItems struct {
+	items                 []models.Item
+	max                   int
+	disallowUnknownFields bool
+}
+
+func (b *boundedItems) UnmarshalJSON(data []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if b.disallowUnknownFields {
+		decoder.DisallowUnknownFields()
 	}
 
-	if len(receipt.Items) < 1 {
-		return fmt.Errorf("at least one item is required")
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	if token == nil {
+		b.items = nil
+		return nil
+	}
+	if token != json.Delim('[') {
+		return fmt.Errorf("items must be an array")
 	}
 
-	for i, item := range receipt.Items {
-		if item.ShortDescription == "" {
-			return fmt.Errorf("item %d is missing a short description", i+1)
+	b.items = nil
+	for decoder.More() {
+		if len(b.items) == b.max {
+			return errTooManyItems
 		}
-		if !totalPattern.MatchString(item.Price) {
-			return fmt.Errorf("item %d has an invalid price format: must be in format 0.00", i+1)
+		var item models.Item
+		if err := decoder.Decode(&item); err != nil {
+			return err
 		}
+		b.items = append(b.items, item)
 	}
-
-	if !totalPattern.MatchString(receipt.Total) {
-		return fmt.Errorf("invalid total format: must be in format 0.00")
-	}
-
 	return nil
 }
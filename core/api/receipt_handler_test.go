@@ -4,12 +4,15 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"github.com/RA341/receipt-processor-challenge/config"
 	"github.com/RA341/receipt-processor-challenge/models"
+	"github.com/RA341/receipt-processor-challenge/service"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"strings"
 	"testing"
+	"time"
 )
 
 var (
@@ -315,3 +318,293 @@ func runTestGetPoints(t *testing.T, expected int64, payload string) {
 func fatalErr(t *testing.T, message string, got any, want any) {
 	t.Fatalf("%s: \ngot: %v\nwant: %v", message, got, want)
 }
+
+func TestReceiptHandler_PostProcessReceipt_BodyLimits(t *testing.T) {
+	bodyBytes, err := os.ReadFile("../../examples/morning-receipt.json")
+	if err != nil {
+		t.Fatalf("Failed to load request body: %v", err)
+	}
+
+	receiptSrv, err := initServices()
+	if err != nil {
+		t.Fatalf("Failed to init services: %v", err)
+	}
+	_, handler := NewReceiptHandler(receiptSrv)
+	handler.limits.MaxItems = 1
+	handler.limits.DisallowUnknownFields = true
+
+	simple, err := os.ReadFile("../../examples/simple-receipt.json")
+	if err != nil {
+		t.Fatalf("Failed to load request body: %v", err)
+	}
+	unknownField := strings.Replace(string(simple), `"retailer"`, `"cashier": "Bob", "retailer"`, 1)
+	unknownItemField := strings.Replace(string(simple), `"shortDescription"`, `"sku": "123", "shortDescription"`, 1)
+
+	cases := map[string]struct {
+		body           string
+		expectedStatus int
+	}{
+		"too many items": {body: string(bodyBytes), expectedStatus: http.StatusRequestEntityTooLarge},
+		"oversized body": {body: string(simple) + strings.Repeat(" ", int(handler.limits.MaxBodyBytes)), expectedStatus: http.StatusRequestEntityTooLarge},
+		"trailing data":  {body: string(simple) + `{"retailer": "Target"}`, expectedStatus: http.StatusBadRequest},
+		"unknown field":  {body: unknownField, expectedStatus: http.StatusBadRequest},
+		"unknown item":   {body: unknownItemField, expectedStatus: http.StatusBadRequest},
+		"valid":          {body: string(simple) + "\n", expectedStatus: http.StatusOK},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/receipts/process", strings.NewReader(c.body))
+			resp := httptest.NewRecorder()
+
+			handler.ServeHTTP(resp, req)
+
+			if status := resp.Code; status != c.expectedStatus {
+				t.Logf("Response body: %s", resp.Body.String())
+				fatalErr(t, "handler returned wrong status code", status, c.expectedStatus)
+			}
+		})
+	}
+}
+
+func TestReceiptHandler_GetReceipt_Lifecycle(t *testing.T) {
+	fraud := config.Default().Fraud
+	db, _ := service.NewDB()
+	receiptSrv := service.NewReceiptService(db, service.WithFraudChecker(service.NewFraudChecker(fraud)))
+	_, handler := NewReceiptHandler(receiptSrv)
+
+	// receipts purchased in the future are held for review
+	var receipt models.Receipt
+	bodyBytes, err := os.ReadFile("../../examples/simple-receipt.json")
+	if err != nil {
+		t.Fatalf("Failed to load request body: %v", err)
+	}
+	if err := json.Unmarshal(bodyBytes, &receipt); err != nil {
+		t.Fatalf("Failed to unmarshal request body: %v", err)
+	}
+	receipt.PurchaseDate = time.Now().AddDate(0, 0, 7).Format("2006-01-02")
+	receiptId, err := receiptSrv.NewReceipt(receipt)
+	if err != nil {
+		t.Fatalf("Failed to create receipt: %v", err)
+	}
+
+	get := func(target string) *httptest.ResponseRecorder {
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, target, nil))
+		return resp
+	}
+
+	resp := get("/receipts/" + receiptId)
+	var status models.ReceiptStatusResponse
+	if err := json.Unmarshal(resp.Body.Bytes(), &status); err != nil || status.Status != models.StatusPendingReview {
+		fatalErr(t, "handler returned wrong receipt status", resp.Body.String(), models.StatusPendingReview)
+	}
+
+	if resp := get("/receipts/" + receiptId + "/points"); resp.Code != http.StatusAccepted {
+		fatalErr(t, "handler returned wrong status code for a held receipt", resp.Code, http.StatusAccepted)
+	}
+
+	if _, err := receiptSrv.RejectReview(receiptId, "reviewer", "purchased in the future"); err != nil {
+		t.Fatalf("Failed to reject receipt: %v", err)
+	}
+	if resp := get("/receipts/" + receiptId + "/points"); resp.Code != http.StatusConflict {
+		fatalErr(t, "handler returned wrong status code for a rejected receipt", resp.Code, http.StatusConflict)
+	}
+}
+
+func TestReceiptHandler_PostProcessReceipt_Async(t *testing.T) {
+	db, _ := service.NewDB()
+	receiptSrv := service.NewReceiptService(db, service.WithAsyncProcessing(config.Default().Processing))
+	defer receiptSrv.Close()
+	_, handler := NewReceiptHandler(receiptSrv)
+
+	bodyBytes, err := os.ReadFile("../../examples/simple-receipt.json")
+	if err != nil {
+		t.Fatalf("Failed to load request body: %v", err)
+	}
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/receipts/process", bytes.NewReader(bodyBytes)))
+	if resp.Code != http.StatusAccepted {
+		fatalErr(t, "handler returned wrong status code", resp.Code, http.StatusAccepted)
+	}
+
+	var id models.IdResponse
+	if err := json.Unmarshal(resp.Body.Bytes(), &id); err != nil {
+		fatalErr(t, "Could not unmarshal response body", err, resp.Body.String())
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/receipts/"+id.Id+"/status", nil))
+
+		var job models.JobStatus
+		if err := json.Unmarshal(resp.Body.Bytes(), &job); err != nil {
+			fatalErr(t, "Could not unmarshal response body", err, resp.Body.String())
+		}
+		if job.Status == models.StatusCredited {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for the receipt to be credited, last status %+v", job)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestReceiptHandler_GetReceipts(t *testing.T) {
+	db, _ := service.NewDB()
+	receiptSrv := service.NewReceiptService(db)
+	_, handler := NewReceiptHandler(receiptSrv)
+
+	bodyBytes, err := os.ReadFile("../../examples/simple-receipt.json")
+	if err != nil {
+		t.Fatalf("Failed to load request body: %v", err)
+	}
+	var receipt models.Receipt
+	if err := json.Unmarshal(bodyBytes, &receipt); err != nil {
+		t.Fatalf("Failed to unmarshal request body: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		receipt.MemberId = fmt.Sprintf("member-%d", i%2)
+		if _, err := receiptSrv.NewReceipt(receipt); err != nil {
+			t.Fatalf("Failed to create receipt: %v", err)
+		}
+	}
+
+	list := func(target string, principal *Principal) (*httptest.ResponseRecorder, models.ReceiptListResponse) {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		if principal != nil {
+			req = req.WithContext(withPrincipal(req.Context(), *principal))
+		}
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+
+		var list models.ReceiptListResponse
+		if resp.Code == http.StatusOK {
+			if err := json.Unmarshal(resp.Body.Bytes(), &list); err != nil {
+				fatalErr(t, "Could not unmarshal response body", err, resp.Body.String())
+			}
+		}
+		return resp, list
+	}
+
+	resp, page := list("/receipts?limit=2&sort=-createdAt&minTotal=1.00", nil)
+	if resp.Code != http.StatusOK || len(page.Receipts) != 2 || page.NextCursor == "" {
+		fatalErr(t, "handler returned wrong first page", resp.Body.String(), "2 receipts and a cursor")
+	}
+	if page.Receipts[0].ItemCount != 1 || page.Receipts[0].Total != "1.25" {
+		fatalErr(t, "handler returned wrong summary", page.Receipts[0], receipt)
+	}
+
+	resp, page = list("/receipts/?limit=2&sort=-createdAt&minTotal=1.00&cursor="+page.NextCursor, nil)
+	if resp.Code != http.StatusOK || len(page.Receipts) != 1 || page.NextCursor != "" {
+		fatalErr(t, "handler returned wrong last page", resp.Body.String(), "1 receipt without a cursor")
+	}
+
+	// members only see their own receipts, whatever they ask for
+	_, page = list("/receipts?member=member-0", &Principal{MemberId: "member-1"})
+	if len(page.Receipts) != 1 || page.Receipts[0].MemberId != "member-1" {
+		fatalErr(t, "handler listed receipts of another member", page.Receipts, "member-1")
+	}
+
+	for _, target := range []string{"/receipts?minTotal=1", "/receipts?limit=0", "/receipts?sort=retailer", "/receipts?minPoints=many",
+		"/receipts?minTotal=99999999999999999999.00", "/receipts?maxTotal=92233720368547758.00"} {
+		if resp, _ := list(target, nil); resp.Code != http.StatusBadRequest {
+			fatalErr(t, "handler returned wrong status code for "+target, resp.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestReceiptHandler_ExportReceipts(t *testing.T) {
+	db, _ := service.NewDB()
+	receiptSrv := service.NewReceiptService(db)
+	_, handler := NewReceiptHandler(receiptSrv)
+
+	bodyBytes, err := os.ReadFile("../../examples/morning-receipt.json")
+	if err != nil {
+		t.Fatalf("Failed to load request body: %v", err)
+	}
+	var receipt models.Receipt
+	if err := json.Unmarshal(bodyBytes, &receipt); err != nil {
+		t.Fatalf("Failed to unmarshal request body: %v", err)
+	}
+	id, err := receiptSrv.NewReceipt(receipt)
+	if err != nil {
+		t.Fatalf("Failed to create receipt: %v", err)
+	}
+
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/receipts/export?format=ndjson&retailer=walgreens", nil))
+	if resp.Code != http.StatusOK || resp.Header().Get("Content-Type") != "application/x-ndjson" {
+		fatalErr(t, "handler returned wrong response", resp.Code, http.StatusOK)
+	}
+	var record models.ReceiptRecord
+	if err := json.Unmarshal(resp.Body.Bytes(), &record); err != nil || record.Id != id {
+		fatalErr(t, "handler exported wrong receipt", resp.Body.String(), id)
+	}
+
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/receipts/export", nil))
+	if lines := strings.Split(strings.TrimSpace(resp.Body.String()), "\n"); resp.Code != http.StatusOK || len(lines) != 2 {
+		fatalErr(t, "handler returned wrong csv export", resp.Body.String(), "a header and a row")
+	}
+
+	for _, target := range []string{"/receipts/export?format=xml", "/receipts/export?sort=retailer", "/receipts/export?minTotal=5"} {
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, target, nil))
+		if resp.Code != http.StatusBadRequest {
+			fatalErr(t, "handler returned wrong status code for "+target, resp.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestReceiptHandler_ImportReceipts(t *testing.T) {
+	db, _ := service.NewDB()
+	receiptSrv := service.NewReceiptService(db)
+	_, handler := NewReceiptHandler(receiptSrv)
+
+	var lines []string
+	for _, name := range []string{"morning-receipt.json", "simple-receipt.json"} {
+		bodyBytes, err := os.ReadFile("../../examples/" + name)
+		if err != nil {
+			t.Fatalf("Failed to load request body: %v", err)
+		}
+		var compact bytes.Buffer
+		if err := json.Compact(&compact, bodyBytes); err != nil {
+			t.Fatalf("Failed to compact request body: %v", err)
+		}
+		lines = append(lines, compact.String())
+	}
+	lines = append(lines, "{not json")
+	file := strings.Join(lines, "\n")
+
+	send := func(target string) (*httptest.ResponseRecorder, ImportResponse) {
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, target, strings.NewReader(file)))
+		var response ImportResponse
+		_ = json.Unmarshal(resp.Body.Bytes(), &response)
+		return resp, response
+	}
+
+	resp, response := send("/receipts/import?format=ndjson&source=receipts.ndjson")
+	if resp.Code != http.StatusOK || response.Summary.Imported != 2 || len(response.Rejects) != 1 {
+		fatalErr(t, "handler returned wrong import", resp.Body.String(), "2 imported and 1 reject")
+	}
+
+	// sending the file again finds the receipts it already imported
+	resp, response = send("/receipts/import?format=ndjson&source=receipts.ndjson")
+	if resp.Code != http.StatusOK || response.Summary.Imported != 2 {
+		fatalErr(t, "handler returned wrong repeated import", resp.Body.String(), "2 imported")
+	}
+	if page, _ := db.ListReceipts(service.ReceiptQuery{}); len(page.Receipts) != 2 {
+		fatalErr(t, "import stored wrong receipts", len(page.Receipts), 2)
+	}
+
+	for _, target := range []string{"/receipts/import", "/receipts/import?format=ndjson&rulesVersion=3", "/receipts/import?format=csv&mapping={"} {
+		resp, _ := send(target)
+		if resp.Code != http.StatusBadRequest {
+			fatalErr(t, "handler returned wrong status code for "+target, resp.Code, http.StatusBadRequest)
+		}
+	}
+}
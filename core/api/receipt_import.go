@@ -0,0 +1,82 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/RA341/receipt-processor-challenge/service"
+	u "github.com/RA341/receipt-processor-challenge/utils"
+	"log/slog"
+	"net/http"
+	"strconv"
+)
+
+// ImportResponse is the result of POST /receipts/import, Rejects has a line for every receipt that wasn't
+// imported and Error is why the import stopped early, the receipts before it are stored
+type ImportResponse struct {
+	Summary service.ImportSummary `json:"summary"`
+	Rejects []json.RawMessage     `json:"rejects"`
+	Error   string                `json:"error,omitempty"`
+}
+
+// ImportReceipts scores and stores the receipts of the file in the body, see service.ImportOptions.
+// Receipts get their ids from the source and their position in the file, so sending the same file
+// with the same source again only imports what the previous attempt didn't get to
+func (rh *ReceiptHandler) ImportReceipts(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	opts := service.ImportOptions{
+		Format:  params.Get("format"),
+		Mapping: service.DefaultCSVMapping(),
+		Source:  params.Get("source"),
+	}
+
+	if mapping := params.Get("mapping"); mapping != "" {
+		if err := json.Unmarshal([]byte(mapping), &opts.Mapping); err != nil {
+			http.Error(w, fmt.Sprintf("invalid mapping: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if version := params.Get("rulesVersion"); version != "" {
+		parsed, err := strconv.ParseInt(version, 10, 64)
+		if err != nil {
+			http.Error(w, "rulesVersion must be a number", http.StatusBadRequest)
+			return
+		}
+		if opts.Rules, err = rh.srv.RuleVersion(parsed); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	var rejects bytes.Buffer
+	opts.Rejects = &rejects
+	body := http.MaxBytesReader(w, r.Body, rh.limits.MaxImportBytes)
+	summary, err := rh.srv.Import(r.Context(), body, opts)
+
+	status := http.StatusOK
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case err == nil:
+	case errors.Is(err, service.ErrInvalidImport):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.As(err, &maxBytesErr):
+		status = http.StatusRequestEntityTooLarge
+	default:
+		slog.Warn("Receipt import stopped", slog.String("source", opts.Source), u.ErrLog(err))
+		status = http.StatusInternalServerError
+	}
+
+	response := ImportResponse{Summary: summary, Rejects: []json.RawMessage{}}
+	if err != nil {
+		response.Error = err.Error()
+	}
+	for _, line := range bytes.Split(bytes.TrimSpace(rejects.Bytes()), []byte("\n")) {
+		if len(line) > 0 {
+			response.Rejects = append(response.Rejects, line)
+		}
+	}
+	sendJsonResponseWithStatus(w, status, response)
+}
@@ -0,0 +1,185 @@
+package api
+
+import (
+	"fmt"
+	"github.com/RA341/receipt-processor-challenge/models"
+	"github.com/RA341/receipt-processor-challenge/service"
+	u "github.com/RA341/receipt-processor-challenge/utils"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// GetReceipts lists receipts a page at a time, see parseReceiptQuery for the parameters
+func (rh *ReceiptHandler) GetReceipts(w http.ResponseWriter, r *http.Request) {
+	query, err := parseReceiptQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	page, err := rh.srv.ListReceipts(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response := models.ReceiptListResponse{
+		Receipts:   make([]models.ReceiptSummary, 0, len(page.Receipts)),
+		NextCursor: page.NextCursor,
+	}
+	for _, record := range page.Receipts {
+		response.Receipts = append(response.Receipts, receiptSummary(record))
+	}
+	sendJsonResponse(w, response)
+}
+
+// parseReceiptQuery reads the listing filters from the query string: retailer, member, status,
+// purchasedFrom and purchasedTo (YYYY-MM-DD), minTotal and maxTotal (0.00), minPoints and maxPoints,
+// sort (createdAt, purchasedAt, total or points, prefixed with - for descending), limit and cursor.
+// Members can only list their own receipts
+func parseReceiptQuery(r *http.Request) (service.ReceiptQuery, error) {
+	return receiptQueryFrom(r, r.URL.Query())
+}
+
+// receiptQueryFrom reads the listing filters from params, restricted to what the caller of r may see
+func receiptQueryFrom(r *http.Request, params url.Values) (service.ReceiptQuery, error) {
+	query := service.ReceiptQuery{
+		Retailer:      params.Get("retailer"),
+		MemberId:      params.Get("member"),
+		Status:        params.Get("status"),
+		PurchasedFrom: params.Get("purchasedFrom"),
+		PurchasedTo:   params.Get("purchasedTo"),
+		Cursor:        params.Get("cursor"),
+	}
+
+	if principal, ok := principalFrom(r.Context()); ok && !principal.canAccessMember(query.MemberId) {
+		query.MemberId = principal.MemberId
+	}
+
+	sortBy := params.Get("sort")
+	query.Descending = strings.HasPrefix(sortBy, "-")
+	query.Sort = strings.TrimPrefix(sortBy, "-")
+
+	var err error
+	if query.MinTotal, err = amountParam(params, "minTotal"); err != nil {
+		return query, err
+	}
+	if query.MaxTotal, err = amountParam(params, "maxTotal"); err != nil {
+		return query, err
+	}
+	if query.MinPoints, err = intParam(params, "minPoints"); err != nil {
+		return query, err
+	}
+	if query.MaxPoints, err = intParam(params, "maxPoints"); err != nil {
+		return query, err
+	}
+	if limit, err := intParam(params, "limit"); err != nil {
+		return query, err
+	} else if limit != nil {
+		if *limit <= 0 {
+			return query, fmt.Errorf("limit must be positive")
+		}
+		query.Limit = int(*limit)
+	}
+
+	return query, nil
+}
+
+// amountParam parses a dollar amount such as 10.00 into cents, nil if the parameter is missing
+func amountParam(params url.Values, name string) (*int64, error) {
+	value := params.Get(name)
+	if value == "" {
+		return nil, nil
+	}
+	if !totalPattern.MatchString(value) {
+		return nil, fmt.Errorf("%s must be in format 0.00", name)
+	}
+
+	dollars, cents, _ := strings.Cut(value, ".")
+	d, err := strconv.ParseInt(dollars, 10, 64)
+	if err != nil || d > (math.MaxInt64-99)/100 {
+		return nil, fmt.Errorf("%s is too large", name)
+	}
+	c, err := strconv.ParseInt(cents, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be in format 0.00", name)
+	}
+	amount := d*100 + c
+	return &amount, nil
+}
+
+func intParam(params url.Values, name string) (*int64, error) {
+	value := params.Get(name)
+	if value == "" {
+		return nil, nil
+	}
+	parsed, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be an integer", name)
+	}
+	return &parsed, nil
+}
+
+func receiptSummary(record models.ReceiptRecord) models.ReceiptSummary {
+	return models.ReceiptSummary{
+		Id:           record.Id,
+		Retailer:     record.Receipt.Retailer,
+		PurchaseDate: record.Receipt.PurchaseDate,
+		PurchaseTime: record.Receipt.PurchaseTime,
+		Total:        record.Receipt.Total,
+		ItemCount:    len(record.Receipt.Items),
+		MemberId:     record.Receipt.MemberId,
+		Points:       record.Points,
+		Status:       record.Status,
+		Variant:      record.Variant,
+		CreatedAt:    record.CreatedAt,
+	}
+}
+
+// ExportReceipts streams the receipts matching the listing filters as ?format=csv or ?format=ndjson
+func (rh *ReceiptHandler) ExportReceipts(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = service.FormatCSV
+	}
+
+	query, err := parseReceiptQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	out := &trackingWriter{w: w}
+	writer, err := service.NewReceiptWriter(format, out)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", service.ExportContentType(format))
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="receipts.%s"`, format))
+	if err := rh.srv.ExportReceipts(query, writer); err != nil {
+		if !out.wrote {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		// the status was already sent, all we can do is cut the export short
+		slog.Warn("Receipt export failed midway", u.ErrLog(err))
+	}
+}
+
+// trackingWriter remembers whether anything was written, after which errors can't change the status anymore
+type trackingWriter struct {
+	w     io.Writer
+	wrote bool
+}
+
+func (t *trackingWriter) Write(p []byte) (int, error) {
+	t.wrote = true
+	return t.w.Write(p)
+}
@@ -0,0 +1,64 @@
+package api
+
+import (
+	"fmt"
+	"github.com/RA341/receipt-processor-challenge/models"
+	"github.com/RA341/receipt-processor-challenge/service"
+	"log/slog"
+	"net/http"
+)
+
+type ReportsHandler struct {
+	srv *service.ReceiptService
+}
+
+func NewReportsHandler(srv *service.ReceiptService) (string, *ReportsHandler) {
+	return "/reports/", &ReportsHandler{srv: srv}
+}
+
+// ServeHTTP handles the /reports path.
+func (rh *ReportsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// reports cover every member, so members with a JWT can't see them
+	if principal, ok := principalFrom(r.Context()); ok && !principal.canAccessMember("") {
+		http.Error(w, ForbiddenErr, http.StatusForbidden)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/reports/summary":
+		rh.GetReport(w, r, "")
+	case r.Method == http.MethodGet && r.URL.Path == "/reports/timeseries":
+		interval := r.URL.Query().Get("interval")
+		if interval == "" {
+			interval = service.IntervalDay
+		}
+		rh.GetReport(w, r, interval)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		slog.Warn(fmt.Sprintf("Method %s not supported", r.Method), slog.String("path", r.URL.Path))
+	}
+}
+
+// GetReport aggregates the credited receipts purchased between ?from= and ?to=, grouped by ?groupBy=
+func (rh *ReportsHandler) GetReport(w http.ResponseWriter, r *http.Request, interval string) {
+	query := service.ReportQuery{
+		From:     r.URL.Query().Get("from"),
+		To:       r.URL.Query().Get("to"),
+		Interval: interval,
+		GroupBy:  r.URL.Query().Get("groupBy"),
+	}
+
+	buckets, err := rh.srv.Report(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	sendJsonResponse(w, models.ReportResponse{
+		Interval: query.Interval,
+		GroupBy:  query.GroupBy,
+		From:     query.From,
+		To:       query.To,
+		Buckets:  buckets,
+	})
+}
@@ -0,0 +1,59 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/RA341/receipt-processor-challenge/models"
+	"github.com/RA341/receipt-processor-challenge/service"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestReportsHandler_GetReport(t *testing.T) {
+	db, _ := service.NewDB()
+	receiptSrv := service.NewReceiptService(db)
+	_, handler := NewReportsHandler(receiptSrv)
+
+	bodyBytes, err := os.ReadFile("../../examples/morning-receipt.json")
+	if err != nil {
+		t.Fatalf("Failed to load request body: %v", err)
+	}
+	var receipt models.Receipt
+	if err := json.Unmarshal(bodyBytes, &receipt); err != nil {
+		t.Fatalf("Failed to unmarshal request body: %v", err)
+	}
+	if _, err := receiptSrv.NewReceipt(receipt); err != nil {
+		t.Fatalf("Failed to create receipt: %v", err)
+	}
+
+	get := func(target string, principal *Principal) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		if principal != nil {
+			req = req.WithContext(withPrincipal(req.Context(), *principal))
+		}
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		return resp
+	}
+
+	resp := get("/reports/timeseries?groupBy=retailer&from=2022-01-01&to=2022-01-31", nil)
+	var report models.ReportResponse
+	if err := json.Unmarshal(resp.Body.Bytes(), &report); err != nil {
+		fatalErr(t, "Could not unmarshal response body", err, resp.Body.String())
+	}
+	if report.Interval != service.IntervalDay || len(report.Buckets) != 1 ||
+		report.Buckets[0].Period != receipt.PurchaseDate || report.Buckets[0].Revenue != receipt.Total {
+		fatalErr(t, "handler returned wrong report", resp.Body.String(), receipt)
+	}
+
+	if resp := get("/reports/summary?groupBy=member", nil); resp.Code != http.StatusBadRequest {
+		fatalErr(t, "handler returned wrong status code for an invalid group", resp.Code, http.StatusBadRequest)
+	}
+	if resp := get("/reports/summary", &Principal{MemberId: "member-1"}); resp.Code != http.StatusForbidden {
+		fatalErr(t, "handler returned wrong status code for a member", resp.Code, http.StatusForbidden)
+	}
+	if resp := get("/reports/summary", &Principal{ClientId: "finance"}); resp.Code != http.StatusOK {
+		fatalErr(t, "handler returned wrong status code for a client", resp.Code, http.StatusOK)
+	}
+}
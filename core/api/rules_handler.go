@@ -0,0 +1,65 @@
+package api
+
+import (
+	"fmt"
+	"github.com/RA341/receipt-processor-challenge/models"
+	"github.com/RA341/receipt-processor-challenge/service"
+	"log/slog"
+	"net/http"
+	"net/url"
+)
+
+type RulesHandler struct {
+	srv *service.ReceiptService
+}
+
+func NewRulesHandler(srv *service.ReceiptService) (string, *RulesHandler) {
+	return "/rules/", &RulesHandler{srv: srv}
+}
+
+// ServeHTTP handles the /rules path.
+func (rh *RulesHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/rules/simulate":
+		rh.PostSimulate(w, r)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		slog.Warn(fmt.Sprintf("Method %s not supported", r.Method), slog.String("path", r.URL.Path))
+	}
+}
+
+// PostSimulate compares the current rules with a candidate rule set on the stored receipts
+// matching the filter, credited ones unless the filter names a status, or on a sample of receipts
+func (rh *RulesHandler) PostSimulate(w http.ResponseWriter, r *http.Request) {
+	var request models.SimulationRequest
+	if err := readJsonBody(w, r, &request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	candidate, err := service.CompileRules(request.Rules)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	params := url.Values{}
+	for name, value := range request.Filter {
+		params.Set(name, value)
+	}
+	query, err := receiptQueryFrom(r, params)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if query.Status == "" {
+		query.Status = models.StatusCredited
+	}
+
+	result, err := rh.srv.SimulateRules(candidate, query, request.Receipts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sendJsonResponse(w, result)
+}
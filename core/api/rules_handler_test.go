@@ -0,0 +1,79 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/RA341/receipt-processor-challenge/models"
+	"github.com/RA341/receipt-processor-challenge/service"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestRulesHandler_PostSimulate(t *testing.T) {
+	db, _ := service.NewDB()
+	receiptSrv := service.NewReceiptService(db)
+	_, handler := NewRulesHandler(receiptSrv)
+
+	bodyBytes, err := os.ReadFile("../../examples/simple-receipt.json")
+	if err != nil {
+		t.Fatalf("Failed to load request body: %v", err)
+	}
+	var receipt models.Receipt
+	if err := json.Unmarshal(bodyBytes, &receipt); err != nil {
+		t.Fatalf("Failed to unmarshal request body: %v", err)
+	}
+	if _, err := receiptSrv.NewReceipt(receipt); err != nil {
+		t.Fatalf("Failed to create receipt: %v", err)
+	}
+
+	simulate := func(request models.SimulationRequest) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(request)
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/rules/simulate", bytes.NewReader(body)))
+		return resp
+	}
+
+	// the simple receipt earns 6 for Target and 25 for its 1.25 total, one point per character doubles the first
+	candidate := models.RuleSet{Rules: []models.Rule{
+		{Name: "retailerName", Type: service.RuleRetailerName, Points: 2},
+	}}
+	resp := simulate(models.SimulationRequest{Rules: candidate, Filter: map[string]string{"retailer": "target"}})
+	var result models.SimulationResponse
+	if err := json.Unmarshal(resp.Body.Bytes(), &result); err != nil {
+		fatalErr(t, "Could not unmarshal response body", err, resp.Body.String())
+	}
+	if result.Receipts != 1 || result.CurrentPoints != 31 || result.CandidatePoints != 12 || result.Delta != -19 {
+		fatalErr(t, "handler returned wrong simulation", resp.Body.String(), "31 points down to 12")
+	}
+
+	resp = simulate(models.SimulationRequest{Rules: candidate, Filter: map[string]string{"retailer": "walgreens"}})
+	if err := json.Unmarshal(resp.Body.Bytes(), &result); err != nil || result.Receipts != 0 {
+		fatalErr(t, "handler simulated receipts outside the filter", resp.Body.String(), 0)
+	}
+
+	invalid := []models.SimulationRequest{
+		{Rules: models.RuleSet{Rules: []models.Rule{{Type: "weather"}}}},
+		{Rules: candidate, Filter: map[string]string{"sort": "retailer"}},
+		{Rules: candidate, Receipts: []models.Receipt{{Retailer: "Target"}}},
+	}
+	for _, request := range invalid {
+		if resp := simulate(request); resp.Code != http.StatusBadRequest {
+			fatalErr(t, "handler returned wrong status code", resp.Code, http.StatusBadRequest)
+		}
+	}
+
+	// wasm rules can only name a registered plugin, never a module file
+	for _, rule := range []string{
+		`{"type": "wasm", "module": "/etc/passwd"}`,
+		`{"type": "wasm", "plugin": "/etc/passwd"}`,
+	} {
+		body := `{"rules": {"rules": [` + rule + `]}}`
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/rules/simulate", bytes.NewReader([]byte(body))))
+		if resp.Code != http.StatusBadRequest {
+			fatalErr(t, "handler returned wrong status code for "+rule, resp.Code, http.StatusBadRequest)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/RA341/receipt-processor-challenge/service"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// runExport downloads receipts from a running server's export endpoint, streaming them to a file or stdout
+func runExport(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	server := flags.String("server", "http://localhost:9992", "url of the receipt processor")
+	key := flags.String("key", os.Getenv("RECEIPT_API_KEY"), "api key with the read scope, defaults to RECEIPT_API_KEY")
+	format := flags.String("format", service.FormatCSV, "csv or ndjson")
+	out := flags.String("out", "", "file to write, stdout if empty")
+
+	filters := map[string]*string{}
+	for _, name := range []string{"retailer", "member", "status", "purchasedFrom", "purchasedTo",
+		"minTotal", "maxTotal", "minPoints", "maxPoints", "sort"} {
+		filters[name] = flags.String(name, "", "only export receipts matching "+name+", as in GET /receipts")
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	params := url.Values{"format": {*format}}
+	for name, value := range filters {
+		if *value != "" {
+			params.Set(name, *value)
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(*server, "/")+"/receipts/export?"+params.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	if *key != "" {
+		req.Header.Set("X-API-Key", *key)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("export failed with %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var dst io.Writer = os.Stdout
+	if *out != "" {
+		file, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		dst = file
+	}
+
+	written, err := io.Copy(dst, resp.Body)
+	if err != nil {
+		return err
+	}
+	if *out != "" {
+		fmt.Fprintf(os.Stderr, "wrote %d bytes to %s\n", written, *out)
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/RA341/receipt-processor-challenge/service"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// importResponse mirrors api.ImportResponse
+type importResponse struct {
+	Summary service.ImportSummary `json:"summary"`
+	Rejects []json.RawMessage     `json:"rejects"`
+	Error   string                `json:"error"`
+}
+
+// runImport sends a file of historical receipts to a running server's import endpoint. Receipts keep
+// their ids across attempts, so an import that stopped early is finished by running it again
+func runImport(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	server := flags.String("server", "http://localhost:9992", "url of the receipt processor")
+	key := flags.String("key", os.Getenv("RECEIPT_API_KEY"), "api key with the admin scope, defaults to RECEIPT_API_KEY")
+	format := flags.String("format", "", "csv or ndjson, guessed from the file extension if empty")
+	mappingFile := flags.String("mapping", "", "json file naming the csv columns, see service.CSVMapping")
+	rulesVersion := flags.String("rules-version", "", "version of the rules to score with, see GET /admin/rules, the live rules if empty")
+	source := flags.String("source", "", "name the receipt ids are derived from, defaults to a hash of the file")
+	rejects := flags.String("rejects", "", "file listing the rejected receipts, defaults to <file>.rejects.ndjson")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: import [flags] <file>")
+	}
+	path := flags.Arg(0)
+
+	if *format == "" {
+		*format = strings.TrimPrefix(filepath.Ext(path), ".")
+		if *format == "jsonl" {
+			*format = service.FormatNDJSON
+		}
+	}
+	if *rejects == "" {
+		*rejects = path + ".rejects.ndjson"
+	}
+
+	params := url.Values{"format": {*format}}
+	if *mappingFile != "" {
+		mapping, err := service.LoadCSVMapping(*mappingFile)
+		if err != nil {
+			return err
+		}
+		encoded, err := json.Marshal(mapping)
+		if err != nil {
+			return err
+		}
+		params.Set("mapping", string(encoded))
+	}
+	if *rulesVersion != "" {
+		params.Set("rulesVersion", *rulesVersion)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// the same contents always get the same ids, wherever the file is imported from
+	if *source == "" {
+		hash := sha256.New()
+		if _, err := io.Copy(hash, file); err != nil {
+			return err
+		}
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		*source = "sha256:" + hex.EncodeToString(hash.Sum(nil))
+	}
+	params.Set("source", *source)
+
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(*server, "/")+"/receipts/import?"+params.Encode(), file)
+	if err != nil {
+		return err
+	}
+	if *key != "" {
+		req.Header.Set("X-API-Key", *key)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var response importResponse
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return fmt.Errorf("import failed with %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	if err := writeRejects(*rejects, response.Rejects); err != nil {
+		return err
+	}
+	summary := response.Summary
+	fmt.Printf("processed: %d\n", summary.Processed)
+	fmt.Printf("imported:  %d (%d held for review)\n", summary.Imported, summary.Held)
+	fmt.Printf("rejected:  %d, see %s\n", summary.Rejected, *rejects)
+	fmt.Printf("points:    %d\n", summary.Points)
+	if response.Error != "" {
+		return fmt.Errorf("import stopped with %s, run it again to import the rest: %s", resp.Status, response.Error)
+	}
+	return nil
+}
+
+func writeRejects(path string, rejects []json.RawMessage) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	for _, reject := range rejects {
+		if _, err := file.Write(append(reject, '\n')); err != nil {
+			return err
+		}
+	}
+	return file.Close()
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/RA341/receipt-processor-challenge/config"
+	"github.com/RA341/receipt-processor-challenge/service"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+func runKeys(args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("keys needs a subcommand: create, list, rotate or revoke")
+	}
+
+	flags := flag.NewFlagSet("keys "+args[0], flag.ExitOnError)
+	file := flags.String("file", config.Get().Auth.KeysFile, "api keys file")
+	client := flags.String("client", "", "client the key belongs to (create)")
+	scopes := flags.String("scopes", service.ScopeSubmit+","+service.ScopeRead, "comma separated scopes: submit, read, admin (create)")
+	overlap := flags.Duration("overlap", 24*time.Hour, "how long the old key stays valid (rotate)")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	store, err := service.NewAPIKeyStore(*file)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "create":
+		plaintext, key, err := store.Create(*client, strings.Split(*scopes, ","))
+		if err != nil {
+			return err
+		}
+		printNewKey(key.Id, plaintext)
+	case "list":
+		keys, err := store.List()
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tCLIENT\tSCOPES\tCREATED\tSTATUS")
+		for _, key := range keys {
+			status := "active"
+			if key.RevokedAt != nil {
+				status = "revoked"
+			} else if key.ExpiresAt != nil {
+				status = "expires " + key.ExpiresAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n",
+				key.Id, key.ClientId, strings.Join(key.Scopes, ","), key.CreatedAt.Format(time.RFC3339), status)
+		}
+		return tw.Flush()
+	case "rotate":
+		if flags.NArg() != 1 {
+			return fmt.Errorf("usage: keys rotate [-overlap 24h] <key id>")
+		}
+		plaintext, key, err := store.Rotate(flags.Arg(0), *overlap)
+		if err != nil {
+			return err
+		}
+		printNewKey(key.Id, plaintext)
+		fmt.Printf("the old key stays valid for %s\n", *overlap)
+	case "revoke":
+		if flags.NArg() != 1 {
+			return fmt.Errorf("usage: keys revoke <key id>")
+		}
+		if err := store.Revoke(flags.Arg(0)); err != nil {
+			return err
+		}
+		fmt.Printf("revoked %s\n", flags.Arg(0))
+	default:
+		return fmt.Errorf("unknown keys subcommand: %s", args[0])
+	}
+
+	return nil
+}
+
+func printNewKey(id, plaintext string) {
+	fmt.Printf("id:  %s\n", id)
+	fmt.Printf("key: %s\n", plaintext)
+	fmt.Println("store the key now, it can't be shown again")
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/RA341/receipt-processor-challenge/config"
+	"os"
+)
+
+const usage = `usage: receipt-cli <command> [flags]
+
+commands:
+  keys create   create an api key
+  keys list     list api keys
+  keys rotate   replace an api key, keeping the old one valid for an overlap
+  keys revoke   revoke an api key
+  export        download receipts from a running server as csv or ndjson
+  import        send historical receipts from a csv or ndjson file to a running server
+  score         print the points of receipt files, or stdin, without a server
+
+Settings are read from the file in RECEIPT_CONFIG, the same as the server.
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	if _, err := config.Load(""); err != nil {
+		fatal(err)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "keys":
+		err = runKeys(os.Args[2:])
+	case "export":
+		err = runExport(os.Args[2:])
+	case "import":
+		err = runImport(os.Args[2:])
+	case "score":
+		err = runScore(os.Args[2:])
+	case "-h", "--help", "help":
+		fmt.Print(usage)
+		return
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(exitCode(err))
+	}
+}
+
+// exitCode is 3 when some receipts were invalid and 1 for any other error
+func exitCode(err error) int {
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, errInvalidReceipts):
+		return exitInvalid
+	default:
+		return 1
+	}
+}
+
+func fatal(err error) {
+	fmt.Fprintf(os.Stderr, "error: %v\n", err)
+	os.Exit(1)
+}
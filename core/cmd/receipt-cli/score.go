@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/RA341/receipt-processor-challenge/config"
+	"github.com/RA341/receipt-processor-challenge/models"
+	"github.com/RA341/receipt-processor-challenge/service"
+	"io"
+	"os"
+	"text/tabwriter"
+)
+
+// errInvalidReceipts makes the command exit with exitInvalid once every receipt is printed
+var errInvalidReceipts = errors.New("some receipts are invalid")
+
+const exitInvalid = 3
+
+// scoreResult is a scored receipt as printed with -output json
+type scoreResult struct {
+	Source    string                 `json:"source"`
+	Index     int                    `json:"index"`
+	Retailer  string                 `json:"retailer,omitempty"`
+	Points    int64                  `json:"points"`
+	Breakdown []models.BreakdownLine `json:"breakdown,omitempty"`
+	Error     string                 `json:"error,omitempty"`
+}
+
+// runScore scores receipt files without a server, with the default rules or a rule file
+func runScore(args []string) error {
+	flags := flag.NewFlagSet("score", flag.ExitOnError)
+	rulesFile := flags.String("rules", "", "rule file to score with, the default rules if empty")
+	output := flags.String("output", "table", "table or json")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *output != "table" && *output != "json" {
+		return fmt.Errorf("output must be table or json")
+	}
+
+	// wasm rules can run the plugins of the config
+	service.RegisterPlugins(config.Get().Plugins)
+	rules := service.DefaultRules()
+	if *rulesFile != "" {
+		var err error
+		if rules, err = service.LoadRulesFile(*rulesFile); err != nil {
+			return err
+		}
+	}
+
+	sources := flags.Args()
+	if len(sources) == 0 {
+		sources = []string{"-"}
+	}
+
+	results, err := scoreSources(rules, sources)
+	if err != nil {
+		return err
+	}
+
+	if *output == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(results); err != nil {
+			return err
+		}
+	} else if err := printScores(results); err != nil {
+		return err
+	}
+
+	for _, result := range results {
+		if result.Error != "" {
+			return errInvalidReceipts
+		}
+	}
+	return nil
+}
+
+// scoreSources validates and scores every receipt in the sources, in order
+func scoreSources(rules *service.Rules, sources []string) ([]scoreResult, error) {
+	var results []scoreResult
+	for _, source := range sources {
+		receipts, err := readReceipts(source)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", source, err)
+		}
+		for i, receipt := range receipts {
+			result := scoreResult{Source: source, Index: i, Retailer: receipt.Retailer}
+			if err := service.ValidateReceipt(receipt); err != nil {
+				result.Error = err.Error()
+			} else {
+				// the total is summed from the breakdown, so the rules only run once and always agree with it
+				result.Breakdown = rules.Breakdown(receipt)
+				for _, line := range result.Breakdown {
+					result.Points += line.Points
+				}
+			}
+			results = append(results, result)
+		}
+	}
+	return results, nil
+}
+
+// readReceipts reads a single receipt, an array of them or one per line from the file, - is stdin
+func readReceipts(source string) ([]models.Receipt, error) {
+	var r io.Reader = os.Stdin
+	if source != "-" {
+		file, err := os.Open(source)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		r = file
+	}
+
+	reader := bufio.NewReader(r)
+	// an array starts with [, anything else is read as a stream of receipts
+	first, err := peekNonSpace(reader)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(reader)
+	if first == '[' {
+		var receipts []models.Receipt
+		if err := dec.Decode(&receipts); err != nil {
+			return nil, err
+		}
+		return receipts, nil
+	}
+
+	var receipts []models.Receipt
+	for {
+		var receipt models.Receipt
+		err := dec.Decode(&receipt)
+		if errors.Is(err, io.EOF) {
+			return receipts, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("receipt %d: %v", len(receipts)+1, err)
+		}
+		receipts = append(receipts, receipt)
+	}
+}
+
+func peekNonSpace(r *bufio.Reader) (byte, error) {
+	for i := 1; ; i++ {
+		peeked, err := r.Peek(i)
+		if len(peeked) < i {
+			if errors.Is(err, io.EOF) {
+				return 0, fmt.Errorf("no receipts found")
+			}
+			return 0, err
+		}
+		if b := peeked[i-1]; len(bytes.TrimSpace([]byte{b})) > 0 {
+			return b, nil
+		}
+	}
+}
+
+func printScores(results []scoreResult) error {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, result := range results {
+		fmt.Fprintf(tw, "%s #%d\t%s\n", result.Source, result.Index, result.Retailer)
+		if result.Error != "" {
+			fmt.Fprintf(tw, "  invalid\t%s\n", result.Error)
+			continue
+		}
+		for _, line := range result.Breakdown {
+			fmt.Fprintf(tw, "  %s\t%d\n", line.Rule, line.Points)
+		}
+		fmt.Fprintf(tw, "  total\t%d\n", result.Points)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"github.com/RA341/receipt-processor-challenge/service"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestScoreSources_Examples(t *testing.T) {
+	sources, _ := filepath.Glob("../../../examples/*.json")
+	if len(sources) == 0 {
+		t.Fatalf("Found no example receipts")
+	}
+
+	results, err := scoreSources(service.DefaultRules(), sources)
+	if err != nil {
+		t.Fatalf("Failed to score the examples: %v", err)
+	}
+
+	expected := map[string]int64{"morning-receipt.json": 15, "simple-receipt.json": 31}
+	for _, result := range results {
+		want, ok := expected[filepath.Base(result.Source)]
+		if !ok {
+			continue
+		}
+		if result.Error != "" || result.Points != want {
+			t.Fatalf("%s: expected %d points but got %d (%s)", result.Source, want, result.Points, result.Error)
+		}
+		var sum int64
+		for _, line := range result.Breakdown {
+			sum += line.Points
+		}
+		if sum != result.Points {
+			t.Fatalf("%s: breakdown adds up to %d but the total is %d", result.Source, sum, result.Points)
+		}
+	}
+}
+
+func TestRunScore_InvalidReceipts(t *testing.T) {
+	dir := t.TempDir()
+	valid, err := os.ReadFile("../../../examples/simple-receipt.json")
+	if err != nil {
+		t.Fatalf("Failed to load example receipt: %v", err)
+	}
+	path := filepath.Join(dir, "receipts.json")
+	contents := "[" + string(valid) + `, {"retailer": "Target", "total": "1"}]`
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatalf("Failed to write receipts: %v", err)
+	}
+
+	// every receipt is still printed, then the command exits with 3
+	results, err := scoreSources(service.DefaultRules(), []string{path})
+	if err != nil || len(results) != 2 || results[0].Points != 31 || results[1].Error == "" {
+		t.Fatalf("Expected a scored and an invalid receipt, got %+v (%v)", results, err)
+	}
+	if code := exitCode(runScore([]string{"-output", "json", path})); code != exitInvalid {
+		t.Fatalf("Expected exit code %d but got %d", exitInvalid, code)
+	}
+
+	if code := exitCode(runScore([]string{filepath.Join(dir, "missing.json")})); code != 1 {
+		t.Fatalf("Expected exit code 1 for a missing file but got %d", code)
+	}
+}
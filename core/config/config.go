@@ -0,0 +1,562 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EnvConfigPath is the env var pointing to an optional JSON config file
+const EnvConfigPath = "RECEIPT_CONFIG"
+
+type Config struct {
+	Receipts   ReceiptsConfig   `json:"receipts"`
+	Tiers      []TierConfig     `json:"tiers"`
+	Retailers  RetailersConfig  `json:"retailers"`
+	Categories CategoriesConfig `json:"categories"`
+	Auth       AuthConfig       `json:"auth"`
+	RateLimits RateLimitsConfig `json:"rateLimits"`
+	Fraud      FraudConfig      `json:"fraud"`
+	Processing ProcessingConfig `json:"processing"`
+	Webhooks   WebhooksConfig   `json:"webhooks"`
+	Events     EventsConfig     `json:"events"`
+	Experiment ExperimentConfig `json:"experiment"`
+	Plugins    PluginsConfig    `json:"plugins"`
+	Rules      RulesConfig      `json:"rules"`
+}
+
+type RulesConfig struct {
+	// HistoryFile stores the rule changes made through the admin api, they are lost on restart if it is empty
+	HistoryFile string `json:"historyFile"`
+}
+
+// PluginsConfig limits the WebAssembly rule plugins, and registers plugins to score alongside the default rules
+type PluginsConfig struct {
+	// MemoryLimitPages caps the memory of a plugin, in 64 KiB WebAssembly pages
+	MemoryLimitPages int `json:"memoryLimitPages"`
+	// Timeout bounds how long a plugin can take to score one receipt
+	Timeout Duration `json:"timeout"`
+	// Rules are added after the default rules, and are the only plugins rule changes can refer to
+	Rules []PluginRuleConfig `json:"rules"`
+}
+
+type PluginRuleConfig struct {
+	Name string `json:"name"`
+	// Module is the path of the plugin's .wasm file
+	Module string `json:"module"`
+}
+
+// ExperimentConfig splits submissions between rule set variants for A/B testing
+type ExperimentConfig struct {
+	// Name identifies the experiment, it is stored with every score and seeds the assignment hash.
+	// There is no experiment without a name
+	Name string `json:"name"`
+	// HashBy is member (the default) to keep all receipts of a member in one variant, or receipt.
+	// Receipts without a member are assigned by receipt either way
+	HashBy   string                    `json:"hashBy"`
+	Variants []ExperimentVariantConfig `json:"variants"`
+}
+
+type ExperimentVariantConfig struct {
+	Name string `json:"name"`
+	// Weight is the variant's share of the submissions, relative to the other variants
+	Weight int `json:"weight"`
+	// RulesFile is the rule file the variant scores with, the live rules if empty
+	RulesFile string `json:"rulesFile"`
+}
+
+// EventsConfig controls the live receipt event stream
+type EventsConfig struct {
+	// ReplaySize is how many recent events are kept for clients resuming with Last-Event-ID
+	ReplaySize int `json:"replaySize"`
+	// StreamBuffer is how many events a slow client can fall behind before it is disconnected
+	StreamBuffer int      `json:"streamBuffer"`
+	Heartbeat    Duration `json:"heartbeat"`
+}
+
+// WebhooksConfig controls delivery of receipt events to webhook subscriptions
+type WebhooksConfig struct {
+	Workers   int      `json:"workers"`
+	QueueSize int      `json:"queueSize"`
+	Timeout   Duration `json:"timeout"`
+	// MaxAttempts is how often a delivery is tried, the wait between attempts
+	// starts at RetryBackoff and doubles up to MaxBackoff
+	MaxAttempts  int      `json:"maxAttempts"`
+	RetryBackoff Duration `json:"retryBackoff"`
+	MaxBackoff   Duration `json:"maxBackoff"`
+	// LogSize is how many deliveries are kept per subscription
+	LogSize int `json:"logSize"`
+}
+
+// ProcessingConfig controls how submitted receipts are scored
+type ProcessingConfig struct {
+	// Async makes POST /receipts/process return 202 right after validation, a worker pool scores the receipt
+	Async     bool `json:"async"`
+	Workers   int  `json:"workers"`
+	QueueSize int  `json:"queueSize"`
+	// MaxAttempts is how often a receipt is tried before it goes to the dead-letter store,
+	// the wait between attempts starts at RetryBackoff and doubles each time
+	MaxAttempts  int      `json:"maxAttempts"`
+	RetryBackoff Duration `json:"retryBackoff"`
+	// JobRetention is how long the job of a processed or dead-lettered receipt is kept for its status,
+	// after that the status only reports the receipt's lifecycle
+	JobRetention Duration `json:"jobRetention"`
+}
+
+// FraudConfig scores each submission, every failed check adds its weight (0-1) to the risk
+// score and receipts scoring at least HoldThreshold are held for review instead of credited
+type FraudConfig struct {
+	Enabled       bool                 `json:"enabled"`
+	HoldThreshold float64              `json:"holdThreshold"`
+	Velocity      []VelocityRuleConfig `json:"velocity"`
+	// MaxTotal flags receipts with a total above it
+	MaxTotal       string  `json:"maxTotal"`
+	MaxTotalWeight float64 `json:"maxTotalWeight"`
+	// ItemMismatchRatio flags receipts where the items add up to more than the total,
+	// or the total is more than this ratio above the items (tax and fees)
+	ItemMismatchRatio  float64 `json:"itemMismatchRatio"`
+	ItemMismatchWeight float64 `json:"itemMismatchWeight"`
+	// MaxPurchaseAge flags receipts purchased longer ago than this
+	MaxPurchaseAge Duration `json:"maxPurchaseAge"`
+	StaleWeight    float64  `json:"staleWeight"`
+	// FutureLeeway allows purchase times slightly in the future, the receipt has no time zone
+	FutureLeeway Duration `json:"futureLeeway"`
+	FutureWeight float64  `json:"futureWeight"`
+}
+
+// VelocityRuleConfig flags a submission when more than Max receipts were submitted for the
+// same key within Window, Key is one of member, memberRetailer or client
+type VelocityRuleConfig struct {
+	Key    string   `json:"key"`
+	Max    int      `json:"max"`
+	Window Duration `json:"window"`
+	Weight float64  `json:"weight"`
+}
+
+type RateLimitsConfig struct {
+	Enabled bool `json:"enabled"`
+	// TrustProxyHeaders takes the source ip from X-Forwarded-For, only enable it behind a proxy that sets it
+	TrustProxyHeaders bool `json:"trustProxyHeaders"`
+	// TrustedProxies is how many proxies in front of the server append to X-Forwarded-For, the source ip is the
+	// entry that many from the right. Entries further left are sent by the client and can't be trusted
+	TrustedProxies int `json:"trustedProxies"`
+	// Routes are checked in order, the first one matching the request applies
+	Routes []RouteLimitConfig `json:"routes"`
+}
+
+// RouteLimitConfig limits requests to paths starting with Path, an empty Method matches every method.
+// Each limit is a separate token bucket per api key client, member and source ip, nil limits are not enforced
+type RouteLimitConfig struct {
+	Name      string       `json:"name"`
+	Method    string       `json:"method,omitempty"`
+	Path      string       `json:"path"`
+	PerClient *LimitConfig `json:"perClient,omitempty"`
+	PerMember *LimitConfig `json:"perMember,omitempty"`
+	PerIP     *LimitConfig `json:"perIP,omitempty"`
+}
+
+// LimitConfig allows Requests every Per, with bursts of up to Burst requests (defaults to Requests)
+type LimitConfig struct {
+	Requests int64    `json:"requests"`
+	Per      Duration `json:"per"`
+	Burst    int64    `json:"burst,omitempty"`
+}
+
+type AuthConfig struct {
+	// Enabled requires every request to carry a valid API key, off by default so the
+	// service keeps working with clients that only know the spec
+	Enabled bool `json:"enabled"`
+	// KeysFile stores the hashed API keys, shared with the receipt-cli keys commands
+	KeysFile string    `json:"keysFile"`
+	JWT      JWTConfig `json:"jwt"`
+}
+
+// JWTConfig enables bearer JWTs from the identity provider next to API keys when JWKS is set
+type JWTConfig struct {
+	// JWKS is a file path or an http(s) URL serving the key set
+	JWKS string `json:"jwks"`
+	// Issuer and Audience are checked against the iss and aud claims when set
+	Issuer   string `json:"issuer"`
+	Audience string `json:"audience"`
+	// MemberClaim holds the member id, ScopeClaim the space separated scopes
+	MemberClaim string `json:"memberClaim"`
+	ScopeClaim  string `json:"scopeClaim"`
+	// CacheTTL is how long a fetched key set is used before it is fetched again
+	CacheTTL Duration `json:"cacheTTL"`
+	// Leeway allows for clock skew when checking exp and nbf
+	Leeway Duration `json:"leeway"`
+}
+
+// Duration reads durations such as "10m" from the config file
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var raw string
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("duration must be a string such as \"10m\": %v", err)
+	}
+	parsed, err := time.ParseDuration(raw)
+	if err != nil {
+		return err
+	}
+	d.Duration = parsed
+	return nil
+}
+
+// ReceiptsConfig bounds what a single submitted receipt may contain
+type ReceiptsConfig struct {
+	// MaxBodyBytes is the largest request body accepted, larger bodies get a 413
+	MaxBodyBytes int64 `json:"maxBodyBytes"`
+	// MaxItems is checked while the items are decoded, the body is still read up to MaxBodyBytes first
+	MaxItems int `json:"maxItems"`
+	// DisallowUnknownFields rejects receipts with fields that are not in the spec
+	DisallowUnknownFields bool `json:"disallowUnknownFields"`
+	// MaxImportBytes is the largest file accepted by POST /receipts/import
+	MaxImportBytes int64 `json:"maxImportBytes"`
+}
+
+type CategoriesConfig struct {
+	// Taxonomy is checked in order, the first category with a matching keyword or pattern wins
+	Taxonomy []CategoryConfig     `json:"taxonomy"`
+	Rules    []CategoryRuleConfig `json:"rules"`
+}
+
+// CategoryConfig maps item descriptions to a category, Parent places it in the taxonomy
+// so a rule on "beverages" also applies to "alcohol" if that is its parent
+type CategoryConfig struct {
+	Name     string   `json:"name"`
+	Parent   string   `json:"parent,omitempty"`
+	Keywords []string `json:"keywords,omitempty"`
+	Patterns []string `json:"patterns,omitempty"`
+}
+
+// CategoryRuleConfig awards PointsPerItem for items in the category (or its children),
+// Exclude stops those items from earning any item based points
+type CategoryRuleConfig struct {
+	Category      string `json:"category"`
+	PointsPerItem int64  `json:"pointsPerItem,omitempty"`
+	Exclude       bool   `json:"exclude,omitempty"`
+}
+
+type RetailersConfig struct {
+	// ScoreCanonicalName scores the retailer name rule on the catalog name instead of the raw
+	// name the client sent, off by default to stay compatible with the spec
+	ScoreCanonicalName bool `json:"scoreCanonicalName"`
+	// MatchThreshold is the minimum similarity (0-1) for a fuzzy match against the catalog
+	MatchThreshold float64 `json:"matchThreshold"`
+}
+
+// TierConfig defines a membership tier, a member reaches the tier once
+// their rolling 12-month points are >= MinPoints
+type TierConfig struct {
+	Name       string  `json:"name"`
+	MinPoints  int64   `json:"minPoints"`
+	Multiplier float64 `json:"multiplier"`
+}
+
+var (
+	mu      sync.RWMutex
+	current = Default()
+)
+
+func Default() *Config {
+	return &Config{
+		Receipts: ReceiptsConfig{
+			MaxBodyBytes:   1 << 20, // 1 MiB
+			MaxItems:       500,
+			MaxImportBytes: 256 << 20, // 256 MiB
+		},
+		Experiment: ExperimentConfig{
+			HashBy: "member",
+		},
+		Plugins: PluginsConfig{
+			MemoryLimitPages: 256, // 16 MiB
+			Timeout:          Duration{100 * time.Millisecond},
+		},
+		Events: EventsConfig{
+			ReplaySize:   1_000,
+			StreamBuffer: 100,
+			Heartbeat:    Duration{15 * time.Second},
+		},
+		Webhooks: WebhooksConfig{
+			Workers:      2,
+			QueueSize:    1_000,
+			Timeout:      Duration{10 * time.Second},
+			MaxAttempts:  6,
+			RetryBackoff: Duration{time.Second},
+			MaxBackoff:   Duration{5 * time.Minute},
+			LogSize:      100,
+		},
+		Processing: ProcessingConfig{
+			Workers:      4,
+			QueueSize:    1_000,
+			MaxAttempts:  3,
+			RetryBackoff: Duration{100 * time.Millisecond},
+			JobRetention: Duration{time.Hour},
+		},
+		Tiers: []TierConfig{
+			{Name: "Silver", MinPoints: 1_000, Multiplier: 1.1},
+			{Name: "Gold", MinPoints: 5_000, Multiplier: 1.25},
+			{Name: "Platinum", MinPoints: 10_000, Multiplier: 1.5},
+		},
+		Retailers: RetailersConfig{
+			MatchThreshold: 0.85,
+		},
+		Categories: CategoriesConfig{
+			Taxonomy: []CategoryConfig{
+				{Name: "alcohol", Parent: "beverages", Keywords: []string{"beer", "wine", "vodka", "whiskey", "rum", "tequila", "gin", "ipa", "lager", "seltzer"}},
+				{Name: "tobacco", Keywords: []string{"cigarette", "cigarettes", "cigar", "cigars", "tobacco", "vape", "marlboro", "newport"}},
+				{Name: "beverages", Keywords: []string{"soda", "water", "juice", "pepsi", "coke", "dasani", "gatorade", "dew", "tea", "coffee"}},
+				{Name: "produce", Keywords: []string{"apple", "apples", "banana", "bananas", "lettuce", "tomato", "tomatoes", "onion", "onions", "avocado", "berries", "spinach"}},
+				{Name: "snacks", Keywords: []string{"chips", "doritos", "cookies", "candy", "pretzels", "popcorn"}},
+				{Name: "frozen", Keywords: []string{"pizza", "frozen", "ice cream"}},
+			},
+		},
+		Rules: RulesConfig{
+			HistoryFile: "rule-history.json",
+		},
+		Auth: AuthConfig{
+			KeysFile: "api-keys.json",
+			JWT: JWTConfig{
+				MemberClaim: "sub",
+				ScopeClaim:  "scope",
+				CacheTTL:    Duration{10 * time.Minute},
+				Leeway:      Duration{time.Minute},
+			},
+		},
+		Fraud: FraudConfig{
+			HoldThreshold: 0.7,
+			Velocity: []VelocityRuleConfig{
+				{Key: "member", Max: 20, Window: Duration{time.Hour}, Weight: 0.5},
+				{Key: "memberRetailer", Max: 5, Window: Duration{time.Hour}, Weight: 0.8},
+				{Key: "client", Max: 10_000, Window: Duration{time.Hour}, Weight: 0.3},
+			},
+			MaxTotal:           "2000.00",
+			MaxTotalWeight:     0.6,
+			ItemMismatchRatio:  0.3,
+			ItemMismatchWeight: 0.4,
+			MaxPurchaseAge:     Duration{90 * 24 * time.Hour},
+			StaleWeight:        0.7,
+			FutureLeeway:       Duration{24 * time.Hour},
+			FutureWeight:       1,
+		},
+		RateLimits: RateLimitsConfig{
+			TrustedProxies: 1,
+			Routes: []RouteLimitConfig{
+				{
+					Name:      "process",
+					Method:    "POST",
+					Path:      "/receipts/process",
+					PerClient: &LimitConfig{Requests: 600, Per: Duration{time.Minute}, Burst: 100},
+					PerMember: &LimitConfig{Requests: 30, Per: Duration{time.Hour}, Burst: 10},
+					PerIP:     &LimitConfig{Requests: 120, Per: Duration{time.Minute}, Burst: 30},
+				},
+				{
+					Name:      "default",
+					Path:      "/",
+					PerClient: &LimitConfig{Requests: 1200, Per: Duration{time.Minute}, Burst: 200},
+					PerMember: &LimitConfig{Requests: 120, Per: Duration{time.Minute}, Burst: 30},
+					PerIP:     &LimitConfig{Requests: 300, Per: Duration{time.Minute}, Burst: 60},
+				},
+			},
+		},
+	}
+}
+
+// Get returns the active config, defaults are used until Load is called
+func Get() *Config {
+	mu.RLock()
+	defer mu.RUnlock()
+	return current
+}
+
+// Load reads the config file at path over the defaults and makes it the active config,
+// an empty path falls back to EnvConfigPath, and if that is unset the defaults are kept
+func Load(path string) (*Config, error) {
+	if path == "" {
+		path = os.Getenv(EnvConfigPath)
+	}
+
+	cfg := Default()
+	if path != "" {
+		contents, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read config file %s: %v", path, err)
+		}
+		if err := json.Unmarshal(contents, cfg); err != nil {
+			return nil, fmt.Errorf("unable to parse config file %s: %v", path, err)
+		}
+	}
+
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
+	mu.Lock()
+	current = cfg
+	mu.Unlock()
+	return cfg, nil
+}
+
+func (c *Config) validate() error {
+	if c.Receipts.MaxBodyBytes <= 0 || c.Receipts.MaxItems <= 0 || c.Receipts.MaxImportBytes <= 0 {
+		return fmt.Errorf("receipts.maxBodyBytes, receipts.maxItems and receipts.maxImportBytes must be positive")
+	}
+
+	if e := c.Events; e.ReplaySize < 0 || e.StreamBuffer <= 0 || e.Heartbeat.Duration <= 0 {
+		return fmt.Errorf("events.streamBuffer and events.heartbeat must be positive")
+	}
+
+	if w := c.Webhooks; w.Workers <= 0 || w.QueueSize <= 0 || w.MaxAttempts <= 0 || w.LogSize <= 0 ||
+		w.Timeout.Duration <= 0 || w.RetryBackoff.Duration <= 0 || w.MaxBackoff.Duration < w.RetryBackoff.Duration {
+		return fmt.Errorf("webhooks settings must be positive and maxBackoff at least retryBackoff")
+	}
+
+	if e := c.Experiment; e.Name != "" {
+		if e.HashBy != "member" && e.HashBy != "receipt" {
+			return fmt.Errorf("experiment.hashBy must be member or receipt")
+		}
+		if len(e.Variants) == 0 {
+			return fmt.Errorf("experiment %s has no variants", e.Name)
+		}
+		variants := map[string]bool{}
+		for _, variant := range e.Variants {
+			if variant.Name == "" || variants[variant.Name] {
+				return fmt.Errorf("experiment %s: variants need unique names", e.Name)
+			}
+			variants[variant.Name] = true
+			if variant.Weight <= 0 {
+				return fmt.Errorf("experiment %s: variant %s needs a positive weight", e.Name, variant.Name)
+			}
+		}
+	}
+
+	if p := c.Plugins; p.MemoryLimitPages <= 0 || p.MemoryLimitPages > 65536 || p.Timeout.Duration <= 0 {
+		return fmt.Errorf("plugins.memoryLimitPages must be between 1 and 65536 and plugins.timeout positive")
+	}
+	plugins := map[string]bool{}
+	for _, rule := range c.Plugins.Rules {
+		if rule.Name == "" || rule.Module == "" || plugins[rule.Name] {
+			return fmt.Errorf("plugin rules need a unique name and a module")
+		}
+		plugins[rule.Name] = true
+	}
+
+	if p := c.Processing; p.Workers <= 0 || p.QueueSize <= 0 || p.MaxAttempts <= 0 || p.RetryBackoff.Duration < 0 || p.JobRetention.Duration < 0 {
+		return fmt.Errorf("processing.workers, processing.queueSize and processing.maxAttempts must be positive")
+	}
+
+	seen := map[string]bool{}
+	for _, tier := range c.Tiers {
+		if tier.Name == "" {
+			return fmt.Errorf("tier is missing a name")
+		}
+		if seen[tier.Name] {
+			return fmt.Errorf("duplicate tier name: %s", tier.Name)
+		}
+		seen[tier.Name] = true
+
+		if tier.MinPoints < 0 {
+			return fmt.Errorf("tier %s: minPoints must not be negative", tier.Name)
+		}
+		if tier.Multiplier < 1 {
+			return fmt.Errorf("tier %s: multiplier must be at least 1", tier.Name)
+		}
+	}
+
+	if c.Retailers.MatchThreshold <= 0 || c.Retailers.MatchThreshold > 1 {
+		return fmt.Errorf("retailers.matchThreshold must be between 0 and 1")
+	}
+
+	categories := map[string]bool{}
+	for _, category := range c.Categories.Taxonomy {
+		if category.Name == "" {
+			return fmt.Errorf("category is missing a name")
+		}
+		if categories[category.Name] {
+			return fmt.Errorf("duplicate category name: %s", category.Name)
+		}
+		categories[category.Name] = true
+
+		for _, pattern := range category.Patterns {
+			if _, err := regexp.Compile(pattern); err != nil {
+				return fmt.Errorf("category %s: invalid pattern %q: %v", category.Name, pattern, err)
+			}
+		}
+	}
+	for _, category := range c.Categories.Taxonomy {
+		if category.Parent != "" && !categories[category.Parent] {
+			return fmt.Errorf("category %s: unknown parent %s", category.Name, category.Parent)
+		}
+	}
+	for _, rule := range c.Categories.Rules {
+		if !categories[rule.Category] {
+			return fmt.Errorf("category rule: unknown category %s", rule.Category)
+		}
+		if rule.PointsPerItem < 0 {
+			return fmt.Errorf("category rule %s: pointsPerItem must not be negative", rule.Category)
+		}
+	}
+
+	if c.Auth.Enabled && c.Auth.KeysFile == "" {
+		return fmt.Errorf("auth.keysFile is required when auth is enabled")
+	}
+	if c.RateLimits.TrustedProxies < 1 {
+		return fmt.Errorf("rateLimits.trustedProxies must be at least 1")
+	}
+	for _, route := range c.RateLimits.Routes {
+		if route.Name == "" || !strings.HasPrefix(route.Path, "/") {
+			return fmt.Errorf("rate limit routes need a name and a path starting with /")
+		}
+		for _, limit := range []*LimitConfig{route.PerClient, route.PerMember, route.PerIP} {
+			if limit != nil && (limit.Requests <= 0 || limit.Per.Duration <= 0 || limit.Burst < 0) {
+				return fmt.Errorf("rate limit route %s: requests and per must be positive", route.Name)
+			}
+		}
+	}
+
+	if err := c.Fraud.validate(); err != nil {
+		return err
+	}
+
+	if c.Auth.JWT.JWKS != "" && (c.Auth.JWT.MemberClaim == "" || c.Auth.JWT.ScopeClaim == "") {
+		return fmt.Errorf("auth.jwt.memberClaim and auth.jwt.scopeClaim are required when auth.jwt.jwks is set")
+	}
+
+	return nil
+}
+
+func (f *FraudConfig) validate() error {
+	if f.HoldThreshold <= 0 || f.HoldThreshold > 1 {
+		return fmt.Errorf("fraud.holdThreshold must be between 0 and 1")
+	}
+	weights := []float64{f.MaxTotalWeight, f.ItemMismatchWeight, f.StaleWeight, f.FutureWeight}
+	for _, rule := range f.Velocity {
+		if rule.Key != "member" && rule.Key != "memberRetailer" && rule.Key != "client" {
+			return fmt.Errorf("fraud velocity key %q must be member, memberRetailer or client", rule.Key)
+		}
+		if rule.Max <= 0 || rule.Window.Duration <= 0 {
+			return fmt.Errorf("fraud velocity %s: max and window must be positive", rule.Key)
+		}
+		weights = append(weights, rule.Weight)
+	}
+	for _, weight := range weights {
+		if weight < 0 || weight > 1 {
+			return fmt.Errorf("fraud weights must be between 0 and 1")
+		}
+	}
+	if f.MaxTotal != "" && !regexp.MustCompile(`^\d+\.\d{2}$`).MatchString(f.MaxTotal) {
+		return fmt.Errorf("fraud.maxTotal must be in format 0.00")
+	}
+	return nil
+}
@@ -2,4 +2,9 @@ module github.com/RA341/receipt-processor-challenge
 
 go 1.24.2
 
-require github.com/google/uuid v1.6.0
+require (
+	github.com/google/uuid v1.6.0
+	github.com/tetratelabs/wazero v1.11.0
+)
+
+require golang.org/x/sys v0.38.0 // indirect
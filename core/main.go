@@ -1,7 +1,18 @@
 package main
 
-import "github.com/RA341/receipt-processor-challenge/api"
+import (
+	"github.com/RA341/receipt-processor-challenge/api"
+	"github.com/RA341/receipt-processor-challenge/config"
+	u "github.com/RA341/receipt-processor-challenge/utils"
+	"log/slog"
+	"os"
+)
 
 func main() {
+	if _, err := config.Load(""); err != nil {
+		slog.Error("Unable to load config", u.ErrLog(err))
+		os.Exit(1)
+	}
+
 	api.StartServer(":9992")
 }
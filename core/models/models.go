@@ -1,5 +1,7 @@
 package models
 
+import "time"
+
 type PointsResponse struct {
 	Points int64 `json:"points"`
 }
@@ -8,15 +10,443 @@ type IdResponse struct {
 	Id string `json:"id"`
 }
 
+// StatusResponse is returned instead of points while a receipt has none to give
+type StatusResponse struct {
+	Id     string `json:"id"`
+	Status string `json:"status"`
+}
+
 type Receipt struct {
 	Retailer     string `json:"retailer"`
 	PurchaseDate string `json:"purchaseDate"`
 	PurchaseTime string `json:"purchaseTime"`
 	Items        []Item `json:"items"`
 	Total        string `json:"total"`
+	// MemberId is optional, anonymous receipts are scored without a tier multiplier
+	MemberId string `json:"memberId,omitempty"`
 }
 
 type Item struct {
 	ShortDescription string `json:"shortDescription"`
 	Price            string `json:"price"`
+	// Category is assigned by the categorizer on submission
+	Category string `json:"category,omitempty"`
+}
+
+// BreakdownLine is the contribution of a single rule to a receipt's points
+type BreakdownLine struct {
+	Rule   string `json:"rule"`
+	Points int64  `json:"points"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// ReceiptRecord is a scored receipt as stored in the database
+type ReceiptRecord struct {
+	Id string `json:"id"`
+	// Receipt.Retailer holds the canonical catalog name when the retailer was matched
+	Receipt     Receipt `json:"receipt"`
+	RawRetailer string  `json:"rawRetailer"`
+	RetailerId  string  `json:"retailerId,omitempty"`
+	// ClientId is the API key client that submitted the receipt
+	ClientId  string          `json:"clientId,omitempty"`
+	Points    int64           `json:"points"`
+	Breakdown []BreakdownLine `json:"breakdown"`
+	Tier      string          `json:"tier,omitempty"`
+	// Experiment and Variant name the rule set variant the receipt was scored with, when an experiment ran
+	Experiment string `json:"experiment,omitempty"`
+	Variant    string `json:"variant,omitempty"`
+	// RulesVersion is the version of the live rules the receipt was scored with, see GET /admin/rules
+	RulesVersion *int64 `json:"rulesVersion,omitempty"`
+	// Imported receipts came from an import of historical receipts rather than a submission
+	Imported bool `json:"imported,omitempty"`
+	// Status is where the receipt is in its lifecycle, see StatusReceived
+	Status        string          `json:"status"`
+	StatusHistory []StatusChange  `json:"statusHistory"`
+	Risk          *RiskAssessment `json:"risk,omitempty"`
+	// Review is the reviewer's decision on a receipt that was held for review
+	Review *ReviewAuditEntry `json:"review,omitempty"`
+	// Voids are the full and partial voids of the receipt, each adds a negative void line to the breakdown
+	Voids     []Void    `json:"voids,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Receipt lifecycle, a receipt moves received -> validated -> scored -> credited,
+// or to pending_review when held, rejected when it fails validation or review,
+// and voided when a credited receipt is taken back
+const (
+	StatusReceived      = "received"
+	StatusValidated     = "validated"
+	StatusScored        = "scored"
+	StatusCredited      = "credited"
+	StatusPendingReview = "pending_review"
+	StatusRejected      = "rejected"
+	StatusVoided        = "voided"
+)
+
+type StatusChange struct {
+	From string    `json:"from,omitempty"`
+	To   string    `json:"to"`
+	At   time.Time `json:"at"`
+}
+
+// ReceiptStatusResponse is the receipt as returned by GET /receipts/{id}
+type ReceiptStatusResponse struct {
+	Id            string         `json:"id"`
+	Status        string         `json:"status"`
+	StatusHistory []StatusChange `json:"statusHistory"`
+	Receipt       Receipt        `json:"receipt"`
+	// Points is only set once the receipt is scored
+	Points    *int64    `json:"points,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Void takes back points of a credited receipt, for all of it or for the returned items
+type Void struct {
+	Reason string `json:"reason"`
+	Actor  string `json:"actor"`
+	// Items are the indexes of the returned items, empty for a full void
+	Items  []int     `json:"items,omitempty"`
+	Points int64     `json:"points"`
+	At     time.Time `json:"at"`
+}
+
+const (
+	JobQueued       = "queued"
+	JobProcessing   = "processing"
+	JobRetrying     = "retrying"
+	JobDone         = "done"
+	JobDeadLettered = "dead_lettered"
+)
+
+// JobStatus is the processing state of a submitted receipt, returned by GET /receipts/{id}/status
+type JobStatus struct {
+	Id string `json:"id"`
+	// Status is the receipt's lifecycle status and Job the state of its processing job
+	Status    string    `json:"status"`
+	Job       string    `json:"job"`
+	Attempts  int       `json:"attempts"`
+	LastError string    `json:"lastError,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// DeadLetter is a receipt that failed processing on every attempt
+type DeadLetter struct {
+	ReceiptId string    `json:"receiptId"`
+	Attempts  int       `json:"attempts"`
+	Error     string    `json:"error"`
+	FailedAt  time.Time `json:"failedAt"`
+}
+
+const (
+	EventReceiptScored   = "receipt.scored"
+	EventReceiptFlagged  = "receipt.flagged"
+	EventReceiptRejected = "receipt.rejected"
+	EventReceiptVoided   = "receipt.voided"
+)
+
+// ReceiptEvent is published when a receipt is scored, flagged for review, rejected or voided
+type ReceiptEvent struct {
+	Id        string    `json:"id"`
+	Type      string    `json:"type"`
+	ReceiptId string    `json:"receiptId"`
+	Retailer  string    `json:"retailer"`
+	MemberId  string    `json:"memberId,omitempty"`
+	Status    string    `json:"status"`
+	Points    int64     `json:"points"`
+	At        time.Time `json:"at"`
+}
+
+// WebhookSubscription receives the events of the listed types, or every event if Events is empty
+type WebhookSubscription struct {
+	Id     string   `json:"id"`
+	URL    string   `json:"url"`
+	Events []string `json:"events,omitempty"`
+	// Secret signs the payloads, it is only returned when the subscription is created
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// WebhookDelivery is one attempt to deliver an event to a subscription
+type WebhookDelivery struct {
+	Id             string    `json:"id"`
+	SubscriptionId string    `json:"subscriptionId"`
+	EventId        string    `json:"eventId"`
+	EventType      string    `json:"eventType"`
+	Attempt        int       `json:"attempt"`
+	StatusCode     int       `json:"statusCode,omitempty"`
+	Error          string    `json:"error,omitempty"`
+	Success        bool      `json:"success"`
+	At             time.Time `json:"at"`
+}
+
+// ReceiptSummary is a receipt as listed by GET /receipts
+type ReceiptSummary struct {
+	Id           string    `json:"id"`
+	Retailer     string    `json:"retailer"`
+	PurchaseDate string    `json:"purchaseDate"`
+	PurchaseTime string    `json:"purchaseTime"`
+	Total        string    `json:"total"`
+	ItemCount    int       `json:"itemCount"`
+	MemberId     string    `json:"memberId,omitempty"`
+	Points       int64     `json:"points"`
+	Status       string    `json:"status"`
+	Variant      string    `json:"variant,omitempty"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+type ReceiptListResponse struct {
+	Receipts []ReceiptSummary `json:"receipts"`
+	// NextCursor fetches the next page with ?cursor=, it is empty on the last page
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+// ReviewItem is a receipt waiting in the review queue
+type ReviewItem struct {
+	ReceiptId string          `json:"receiptId"`
+	Retailer  string          `json:"retailer"`
+	MemberId  string          `json:"memberId,omitempty"`
+	Points    int64           `json:"points"`
+	Risk      *RiskAssessment `json:"risk,omitempty"`
+	QueuedAt  time.Time       `json:"queuedAt"`
+	ClaimedBy string          `json:"claimedBy,omitempty"`
+	ClaimedAt *time.Time      `json:"claimedAt,omitempty"`
+}
+
+const (
+	ReviewClaim   = "claim"
+	ReviewRelease = "release"
+	ReviewApprove = "approve"
+	ReviewReject  = "reject"
+)
+
+// ReviewAuditEntry records an action a reviewer took on a held receipt
+type ReviewAuditEntry struct {
+	ReceiptId string    `json:"receiptId"`
+	Action    string    `json:"action"`
+	Actor     string    `json:"actor"`
+	Reason    string    `json:"reason,omitempty"`
+	At        time.Time `json:"at"`
+}
+
+// RiskAssessment is the fraud score of a receipt, between 0 and 1, and the checks that contributed to it
+type RiskAssessment struct {
+	Score   float64      `json:"score"`
+	Reasons []RiskReason `json:"reasons,omitempty"`
+}
+
+type RiskReason struct {
+	Check  string  `json:"check"`
+	Weight float64 `json:"weight"`
+	Detail string  `json:"detail"`
+}
+
+type BreakdownResponse struct {
+	Points    int64           `json:"points"`
+	Breakdown []BreakdownLine `json:"breakdown"`
+}
+
+type TierChange struct {
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+	Points    int64     `json:"rollingPoints"`
+	ChangedAt time.Time `json:"changedAt"`
+}
+
+type MemberTierResponse struct {
+	MemberId      string       `json:"memberId"`
+	Tier          string       `json:"tier"`
+	Multiplier    float64      `json:"multiplier"`
+	RollingPoints int64        `json:"rollingPoints"`
+	History       []TierChange `json:"history"`
+}
+
+// RuleSet is a named list of point rules, as written in a rule file
+type RuleSet struct {
+	Name  string `json:"name"`
+	Rules []Rule `json:"rules"`
+}
+
+// Rule configures one of the rule types, the fields its type doesn't use are ignored
+type Rule struct {
+	// Name labels the rule's line in the breakdown, it defaults to the type
+	Name     string `json:"name,omitempty"`
+	Type     string `json:"type"`
+	Disabled bool   `json:"disabled,omitempty"`
+	Points   int64  `json:"points,omitempty"`
+	// Every is the item count of itemPairs and the description length multiple of itemDescriptionLength
+	Every int `json:"every,omitempty"`
+	// Multiple is the amount totalMultiple checks the total against, such as 0.25
+	Multiple string `json:"multiple,omitempty"`
+	// Multiplier is the share of the item price itemDescriptionLength awards
+	Multiplier float64 `json:"multiplier,omitempty"`
+	// From and To bound the purchase time of purchaseTime as HH:MM, both exclusive
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+	// When is the condition of an expression rule, it awards points to every receipt without one
+	When string `json:"when,omitempty"`
+	// Expression computes the points of an expression rule, which awards Points without one
+	Expression string `json:"expression,omitempty"`
+	// Plugin names the plugin a wasm rule runs, one registered in the plugins config
+	Plugin string `json:"plugin,omitempty"`
+}
+
+// SimulationRequest rescores receipts under a candidate rule set
+type SimulationRequest struct {
+	Rules RuleSet `json:"rules"`
+	// Filter selects the stored receipts to rescore, with the parameters of GET /receipts
+	Filter map[string]string `json:"filter,omitempty"`
+	// Receipts are rescored instead of the stored receipts when given
+	Receipts []Receipt `json:"receipts,omitempty"`
+}
+
+// SimulationResponse compares the points of the rescored receipts under the current and candidate rules
+type SimulationResponse struct {
+	Receipts        int64             `json:"receipts"`
+	CurrentPoints   int64             `json:"currentPoints"`
+	CandidatePoints int64             `json:"candidatePoints"`
+	Delta           int64             `json:"delta"`
+	Increased       int64             `json:"increased"`
+	Decreased       int64             `json:"decreased"`
+	Rules           []RuleDelta       `json:"rules"`
+	Histogram       []HistogramBucket `json:"histogram"`
+	Retailers       []RetailerDelta   `json:"retailers"`
+}
+
+// RuleDelta is what a rule contributed under each rule set, a rule missing from one of them contributed 0 there
+type RuleDelta struct {
+	Rule      string `json:"rule"`
+	Current   int64  `json:"current"`
+	Candidate int64  `json:"candidate"`
+	Delta     int64  `json:"delta"`
+}
+
+// HistogramBucket counts the receipts scoring between From and To points, both inclusive, under each rule set
+type HistogramBucket struct {
+	From      int64 `json:"from"`
+	To        int64 `json:"to"`
+	Current   int64 `json:"current"`
+	Candidate int64 `json:"candidate"`
+}
+
+type RetailerDelta struct {
+	Retailer  string `json:"retailer"`
+	Receipts  int64  `json:"receipts"`
+	Current   int64  `json:"current"`
+	Candidate int64  `json:"candidate"`
+	Delta     int64  `json:"delta"`
+}
+
+const (
+	RuleCreate  = "create"
+	RuleUpdate  = "update"
+	RuleEnable  = "enable"
+	RuleDisable = "disable"
+	RuleDelete  = "delete"
+)
+
+// ActiveRules is the rule set receipts are scored with, Version counts the changes made to it
+type ActiveRules struct {
+	Version int64  `json:"version"`
+	Name    string `json:"name"`
+	Rules   []Rule `json:"rules"`
+}
+
+// RuleChangeRequest is the body of the rule admin endpoints that take one
+type RuleChangeRequest struct {
+	Rule Rule `json:"rule"`
+	// Receipts validate the change instead of the most recent credited receipts when given
+	Receipts []Receipt `json:"receipts,omitempty"`
+}
+
+// RuleChange records a change an admin made to the active rules, Before is nil for a
+// created rule and After is nil for a deleted one
+type RuleChange struct {
+	Version int64     `json:"version"`
+	Action  string    `json:"action"`
+	Rule    string    `json:"rule"`
+	Actor   string    `json:"actor"`
+	At      time.Time `json:"at"`
+	Before  *Rule     `json:"before,omitempty"`
+	After   *Rule     `json:"after,omitempty"`
+}
+
+// RuleChangeResponse is the outcome of a rule change. Validation compares the rules before and after it
+// on the sample receipts, a dry run isn't applied and its change has no version
+type RuleChangeResponse struct {
+	Applied    bool               `json:"applied"`
+	Change     RuleChange         `json:"change"`
+	Rules      ActiveRules        `json:"rules"`
+	Validation SimulationResponse `json:"validation"`
+}
+
+// Campaign is a time-boxed promotion evaluated alongside the default point rules
+type Campaign struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+	// Start and End bound the purchase date/time of receipts the campaign applies to
+	Start  time.Time      `json:"start"`
+	End    time.Time      `json:"end"`
+	Target CampaignTarget `json:"target"`
+	// Bonus is a flat amount of points added to matching receipts
+	Bonus int64 `json:"bonus,omitempty"`
+	// Multiplier is applied to the points from the default rules, 2 doubles them
+	Multiplier float64 `json:"multiplier,omitempty"`
+	// Exclusive campaigns don't stack, only the highest priority one applies
+	Exclusive bool `json:"exclusive,omitempty"`
+	Priority  int  `json:"priority,omitempty"`
+	// MemberCap limits the total points a single member can earn from the campaign
+	MemberCap int64     `json:"memberCap,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// CampaignTarget narrows which receipts a campaign applies to, empty fields match everything
+type CampaignTarget struct {
+	Retailer     string `json:"retailer,omitempty"`
+	ItemContains string `json:"itemContains,omitempty"`
+	MinTotal     string `json:"minTotal,omitempty"`
+	MaxTotal     string `json:"maxTotal,omitempty"`
+	// TimeFrom and TimeTo bound the time of day as HH:MM, TimeTo is exclusive
+	TimeFrom string   `json:"timeFrom,omitempty"`
+	TimeTo   string   `json:"timeTo,omitempty"`
+	Weekdays []string `json:"weekdays,omitempty"`
+}
+
+// Retailer is a catalog entry, receipts whose retailer matches the
+// canonical name or one of the aliases are normalized to the canonical name
+type Retailer struct {
+	Id            string   `json:"id"`
+	CanonicalName string   `json:"canonicalName"`
+	Aliases       []string `json:"aliases"`
+}
+
+// APIKey is a stored API key, only the hash of the secret is kept
+type APIKey struct {
+	Id        string    `json:"id"`
+	ClientId  string    `json:"clientId"`
+	Hash      string    `json:"hash"`
+	Scopes    []string  `json:"scopes"`
+	CreatedAt time.Time `json:"createdAt"`
+	// ExpiresAt is set on the old key when it is rotated, so both keys work during the overlap
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	RevokedAt *time.Time `json:"revokedAt,omitempty"`
+}
+
+// ReportBucket aggregates the credited receipts purchased in one period, for one group if the report is grouped
+type ReportBucket struct {
+	// Period is the first day of the bucket, or empty for a report over the whole range
+	Period       string  `json:"period,omitempty"`
+	Group        string  `json:"group,omitempty"`
+	Receipts     int64   `json:"receipts"`
+	PointsIssued int64   `json:"pointsIssued"`
+	Revenue      string  `json:"revenue"`
+	Items        int64   `json:"items"`
+	AverageItems float64 `json:"averageItems"`
+}
+
+type ReportResponse struct {
+	Interval string         `json:"interval,omitempty"`
+	GroupBy  string         `json:"groupBy,omitempty"`
+	From     string         `json:"from,omitempty"`
+	To       string         `json:"to,omitempty"`
+	Buckets  []ReportBucket `json:"buckets"`
 }
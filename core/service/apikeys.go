@@ -0,0 +1,357 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/RA341/receipt-processor-challenge/models"
+	"github.com/google/uuid"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ScopeSubmit = "submit"
+	ScopeRead   = "read"
+	ScopeAdmin  = "admin"
+
+	// apiKeyPrefix makes keys easy to recognize, the format is rpk_<key id>.<secret>
+	apiKeyPrefix = "rpk_"
+	// apiKeyReloadInterval is how often authenticating checks the keys file for changes
+	apiKeyReloadInterval = time.Second
+)
+
+var (
+	ErrInvalidAPIKey = errors.New("invalid api key")
+	validScopes      = []string{ScopeSubmit, ScopeRead, ScopeAdmin}
+)
+
+// APIKeyStore keeps hashed API keys in a JSON file, the file is re-read when it
+// changes on disk so keys created or revoked with the cli apply to a running server
+// within apiKeyReloadInterval
+type APIKeyStore struct {
+	mu   sync.RWMutex
+	path string
+	keys map[string]models.APIKey
+	// loaded is the keys file as of the last read or write
+	loaded os.FileInfo
+	// checkedAt is when the keys file was last checked for changes
+	checkedAt time.Time
+	now       func() time.Time
+}
+
+// NewAPIKeyStore loads the keys at path, a missing file is treated as an empty store
+// and an empty path keeps the keys in memory only
+func NewAPIKeyStore(path string) (*APIKeyStore, error) {
+	ks := &APIKeyStore{
+		path: path,
+		keys: map[string]models.APIKey{},
+		now:  time.Now,
+	}
+	if err := ks.reloadIfChanged(); err != nil {
+		return nil, err
+	}
+	return ks, nil
+}
+
+// Create makes a new key for the client, the returned plaintext key is not stored and can't be recovered
+func (ks *APIKeyStore) Create(clientId string, scopes []string) (plaintext string, key models.APIKey, err error) {
+	if strings.TrimSpace(clientId) == "" {
+		return "", models.APIKey{}, fmt.Errorf("client id is required")
+	}
+	if err := validateScopes(scopes); err != nil {
+		return "", models.APIKey{}, err
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	if err := ks.reloadIfChanged(); err != nil {
+		return "", models.APIKey{}, err
+	}
+
+	plaintext, key, err = ks.newKey(clientId, scopes)
+	if err != nil {
+		return "", models.APIKey{}, err
+	}
+	ks.keys[key.Id] = key
+
+	if err := ks.save(); err != nil {
+		delete(ks.keys, key.Id)
+		return "", models.APIKey{}, err
+	}
+	return plaintext, key, nil
+}
+
+// Rotate creates a replacement for the key with the same client and scopes,
+// the old key keeps working for the overlap and then expires
+func (ks *APIKeyStore) Rotate(id string, overlap time.Duration) (plaintext string, key models.APIKey, err error) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	if err := ks.reloadIfChanged(); err != nil {
+		return "", models.APIKey{}, err
+	}
+
+	old, ok := ks.keys[id]
+	if !ok || !ks.active(old) {
+		return "", models.APIKey{}, fmt.Errorf("unable to find active api key: %s", id)
+	}
+
+	plaintext, key, err = ks.newKey(old.ClientId, old.Scopes)
+	if err != nil {
+		return "", models.APIKey{}, err
+	}
+
+	expiring := old
+	expiresAt := ks.now().Add(overlap)
+	if old.ExpiresAt == nil || expiresAt.Before(*old.ExpiresAt) {
+		expiring.ExpiresAt = &expiresAt
+	}
+	ks.keys[old.Id] = expiring
+	ks.keys[key.Id] = key
+
+	if err := ks.save(); err != nil {
+		ks.keys[old.Id] = old
+		delete(ks.keys, key.Id)
+		return "", models.APIKey{}, err
+	}
+	return plaintext, key, nil
+}
+
+func (ks *APIKeyStore) Revoke(id string) error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	if err := ks.reloadIfChanged(); err != nil {
+		return err
+	}
+
+	key, ok := ks.keys[id]
+	if !ok {
+		return fmt.Errorf("unable to find api key: %s", id)
+	}
+	if key.RevokedAt != nil {
+		return nil
+	}
+	revoked := key
+	now := ks.now()
+	revoked.RevokedAt = &now
+	ks.keys[id] = revoked
+
+	if err := ks.save(); err != nil {
+		ks.keys[id] = key
+		return err
+	}
+	return nil
+}
+
+// List returns all keys, including expired and revoked ones, oldest first
+func (ks *APIKeyStore) List() ([]models.APIKey, error) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	if err := ks.reloadIfChanged(); err != nil {
+		return nil, err
+	}
+
+	keys := make([]models.APIKey, 0, len(ks.keys))
+	for _, key := range ks.keys {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+	})
+	return keys, nil
+}
+
+// Authenticate returns the key matching the plaintext if it is neither expired nor revoked
+func (ks *APIKeyStore) Authenticate(plaintext string) (models.APIKey, error) {
+	id, secret, ok := strings.Cut(strings.TrimPrefix(plaintext, apiKeyPrefix), ".")
+	if !ok || !strings.HasPrefix(plaintext, apiKeyPrefix) {
+		return models.APIKey{}, ErrInvalidAPIKey
+	}
+
+	if err := ks.reloadIfStale(); err != nil {
+		return models.APIKey{}, err
+	}
+
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	key, found := ks.keys[id]
+	if !found || !ks.active(key) {
+		return models.APIKey{}, ErrInvalidAPIKey
+	}
+	if subtle.ConstantTimeCompare([]byte(hashSecret(secret)), []byte(key.Hash)) != 1 {
+		return models.APIKey{}, ErrInvalidAPIKey
+	}
+	return key, nil
+}
+
+// HasScope reports whether the scopes grant scope, admin grants everything
+func HasScope(scopes []string, scope string) bool {
+	return slices.Contains(scopes, scope) || slices.Contains(scopes, ScopeAdmin)
+}
+
+func (ks *APIKeyStore) active(key models.APIKey) bool {
+	if key.RevokedAt != nil {
+		return false
+	}
+	return key.ExpiresAt == nil || ks.now().Before(*key.ExpiresAt)
+}
+
+func (ks *APIKeyStore) newKey(clientId string, scopes []string) (string, models.APIKey, error) {
+	newUUID, err := uuid.NewRandom()
+	if err != nil {
+		return "", models.APIKey{}, err
+	}
+
+	secretBytes := make([]byte, 32)
+	if _, err := rand.Read(secretBytes); err != nil {
+		return "", models.APIKey{}, err
+	}
+	secret := base64.RawURLEncoding.EncodeToString(secretBytes)
+
+	key := models.APIKey{
+		Id:        newUUID.String(),
+		ClientId:  clientId,
+		Hash:      hashSecret(secret),
+		Scopes:    slices.Clone(scopes),
+		CreatedAt: ks.now(),
+	}
+	return apiKeyPrefix + key.Id + "." + secret, key, nil
+}
+
+// reloadIfStale checks the keys file for changes at most once per apiKeyReloadInterval,
+// so authenticating a request doesn't stat the file every time
+func (ks *APIKeyStore) reloadIfStale() error {
+	ks.mu.RLock()
+	stale := ks.now().Sub(ks.checkedAt) >= apiKeyReloadInterval
+	ks.mu.RUnlock()
+	if !stale {
+		return nil
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	// another request may have reloaded while this one waited for the lock
+	if ks.now().Sub(ks.checkedAt) < apiKeyReloadInterval {
+		return nil
+	}
+	return ks.reloadIfChanged()
+}
+
+// reloadIfChanged re-reads the keys file if it was modified since it was last read
+func (ks *APIKeyStore) reloadIfChanged() error {
+	if ks.path == "" {
+		return nil
+	}
+	ks.checkedAt = ks.now()
+
+	info, err := os.Stat(ks.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to stat api keys file: %v", err)
+	}
+	// every save renames a new file into place, so os.SameFile also catches
+	// writes that land within the file system's timestamp granularity
+	if ks.loaded != nil && os.SameFile(info, ks.loaded) && info.ModTime().Equal(ks.loaded.ModTime()) {
+		return nil
+	}
+
+	contents, err := os.ReadFile(ks.path)
+	if err != nil {
+		return fmt.Errorf("unable to read api keys file: %v", err)
+	}
+	var keys []models.APIKey
+	if err := json.Unmarshal(contents, &keys); err != nil {
+		return fmt.Errorf("unable to parse api keys file: %v", err)
+	}
+
+	ks.keys = map[string]models.APIKey{}
+	for _, key := range keys {
+		ks.keys[key.Id] = key
+	}
+	ks.loaded = info
+	return nil
+}
+
+// save writes the keys to a temp file and renames it over the keys file so readers never see a partial file
+func (ks *APIKeyStore) save() error {
+	if ks.path == "" {
+		return nil
+	}
+
+	keys := make([]models.APIKey, 0, len(ks.keys))
+	for _, key := range ks.keys {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+	})
+
+	contents, err := json.MarshalIndent(keys, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(ks.path), ".api-keys-*")
+	if err != nil {
+		return fmt.Errorf("unable to write api keys file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(contents); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("unable to write api keys file: %v", err)
+	}
+	if err := tmp.Chmod(0o600); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("unable to write api keys file: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("unable to write api keys file: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), ks.path); err != nil {
+		return fmt.Errorf("unable to write api keys file: %v", err)
+	}
+
+	info, err := os.Stat(ks.path)
+	if err != nil {
+		return fmt.Errorf("unable to stat api keys file: %v", err)
+	}
+	ks.loaded = info
+	return nil
+}
+
+func validateScopes(scopes []string) error {
+	if len(scopes) == 0 {
+		return fmt.Errorf("at least one scope is required")
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(validScopes, scope) {
+			return fmt.Errorf("invalid scope %q: must be one of %s", scope, strings.Join(validScopes, ", "))
+		}
+	}
+	return nil
+}
+
+// hashSecret hashes the random part of a key, the secrets have 256 bits of entropy
+// so a plain SHA-256 is enough and keeps authentication fast
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
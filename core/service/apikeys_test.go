@@ -0,0 +1,139 @@
+package service
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAPIKeyStore_Authenticate(t *testing.T) {
+	ks, err := NewAPIKeyStore("")
+	if err != nil {
+		t.Fatalf("Failed to create key store: %v", err)
+	}
+
+	plaintext, key, err := ks.Create("pos-1", []string{ScopeSubmit})
+	if err != nil {
+		t.Fatalf("Failed to create key: %v", err)
+	}
+	if key.Hash == "" || key.Hash == plaintext {
+		t.Fatalf("Expected the key to be stored hashed but got %q", key.Hash)
+	}
+
+	got, err := ks.Authenticate(plaintext)
+	if err != nil || got.ClientId != "pos-1" {
+		t.Fatalf("Expected the key to authenticate as pos-1 but got %+v, %v", got, err)
+	}
+
+	invalid := []string{"", "rpk_nope", plaintext + "x", key.Id, "rpk_" + key.Id + "." + key.Hash}
+	for _, candidate := range invalid {
+		if _, err := ks.Authenticate(candidate); err == nil {
+			t.Fatalf("Expected %q to be rejected", candidate)
+		}
+	}
+
+	if _, _, err := ks.Create("pos-1", []string{"superuser"}); err == nil {
+		t.Fatalf("Expected an unknown scope to be rejected")
+	}
+}
+
+func TestAPIKeyStore_RotateAndRevoke(t *testing.T) {
+	ks, _ := NewAPIKeyStore("")
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	ks.now = func() time.Time { return now }
+
+	oldKey, old, _ := ks.Create("pos-1", []string{ScopeRead})
+	newKey, _, err := ks.Rotate(old.Id, time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to rotate key: %v", err)
+	}
+
+	if _, err := ks.Authenticate(oldKey); err != nil {
+		t.Fatalf("Expected the old key to work during the overlap: %v", err)
+	}
+
+	now = now.Add(2 * time.Hour)
+	if _, err := ks.Authenticate(oldKey); err == nil {
+		t.Fatalf("Expected the old key to expire after the overlap")
+	}
+
+	replacement, err := ks.Authenticate(newKey)
+	if err != nil {
+		t.Fatalf("Expected the new key to work: %v", err)
+	}
+
+	if err := ks.Revoke(replacement.Id); err != nil {
+		t.Fatalf("Failed to revoke key: %v", err)
+	}
+	if _, err := ks.Authenticate(newKey); err == nil {
+		t.Fatalf("Expected the revoked key to be rejected")
+	}
+}
+
+func TestAPIKeyStore_FileReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	server, err := NewAPIKeyStore(path)
+	if err != nil {
+		t.Fatalf("Failed to create key store: %v", err)
+	}
+	now := time.Now()
+	server.now = func() time.Time { return now }
+
+	// a second store on the same file plays the part of the cli
+	cli, _ := NewAPIKeyStore(path)
+	plaintext, key, err := cli.Create("pos-1", []string{ScopeSubmit})
+	if err != nil {
+		t.Fatalf("Failed to create key: %v", err)
+	}
+
+	// the file is checked at most once per interval
+	if _, err := server.Authenticate(plaintext); !errors.Is(err, ErrInvalidAPIKey) {
+		t.Fatalf("Expected the server to check the file only after the interval, got %v", err)
+	}
+	now = now.Add(apiKeyReloadInterval)
+	if _, err := server.Authenticate(plaintext); err != nil {
+		t.Fatalf("Expected the server to pick up the new key: %v", err)
+	}
+
+	if err := cli.Revoke(key.Id); err != nil {
+		t.Fatalf("Failed to revoke key: %v", err)
+	}
+	now = now.Add(apiKeyReloadInterval)
+	if _, err := server.Authenticate(plaintext); err == nil {
+		t.Fatalf("Expected the server to pick up the revocation")
+	}
+}
+
+func TestAPIKeyStore_SaveFailureRollsBack(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "keys")
+	if err := os.Mkdir(dir, 0o700); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	ks, _ := NewAPIKeyStore(filepath.Join(dir, "keys.json"))
+	plaintext, key, err := ks.Create("pos-1", []string{ScopeRead})
+	if err != nil {
+		t.Fatalf("Failed to create key: %v", err)
+	}
+
+	// without its directory the keys file can't be written
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatalf("Failed to remove directory: %v", err)
+	}
+
+	if _, _, err := ks.Rotate(key.Id, 0); err == nil {
+		t.Fatalf("Expected the rotation to fail")
+	}
+	if err := ks.Revoke(key.Id); err == nil {
+		t.Fatalf("Expected the revocation to fail")
+	}
+
+	keys, _ := ks.List()
+	if len(keys) != 1 || keys[0].ExpiresAt != nil || keys[0].RevokedAt != nil {
+		t.Fatalf("Expected the failed changes to be rolled back, got %+v", keys)
+	}
+	if _, err := ks.Authenticate(plaintext); err != nil {
+		t.Fatalf("Expected the key to keep working: %v", err)
+	}
+}
@@ -0,0 +1,318 @@
+package service
+
+import (
+	"fmt"
+	"github.com/RA341/receipt-processor-challenge/models"
+	"github.com/google/uuid"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CampaignService stores promotional campaigns and tracks the points
+// each member earned from them, to enforce per-member caps
+type CampaignService struct {
+	mu        sync.Mutex
+	campaigns []models.Campaign
+	// campaign id -> member id -> points awarded, counting the ones reserved by receipts not yet credited
+	awarded map[string]map[string]int64
+	// receipt id -> points scored but not credited yet, held receipts keep theirs until they are reviewed
+	reserved map[string][]campaignAward
+	// receipt id -> points of credited receipts, kept so a void can take them back
+	credited map[string][]campaignAward
+	now      func() time.Time
+}
+
+// campaignAward is what a campaign awarded a member for a receipt
+type campaignAward struct {
+	campaignId string
+	memberId   string
+	points     int64
+}
+
+func NewCampaignService() *CampaignService {
+	return &CampaignService{
+		awarded:  map[string]map[string]int64{},
+		reserved: map[string][]campaignAward{},
+		credited: map[string][]campaignAward{},
+		now:      time.Now,
+	}
+}
+
+func (cs *CampaignService) Create(campaign models.Campaign) (models.Campaign, error) {
+	if err := validateCampaign(campaign); err != nil {
+		return models.Campaign{}, err
+	}
+
+	newUUID, err := uuid.NewUUID()
+	if err != nil {
+		return models.Campaign{}, err
+	}
+	campaign.Id = newUUID.String()
+	campaign.CreatedAt = cs.now()
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.campaigns = append(cs.campaigns, campaign)
+
+	return campaign, nil
+}
+
+// List returns all campaigns, activeAt filters to campaigns whose window contains it if non-zero
+func (cs *CampaignService) List(activeAt time.Time) []models.Campaign {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	result := make([]models.Campaign, 0, len(cs.campaigns))
+	for _, c := range cs.campaigns {
+		if !activeAt.IsZero() && !inWindow(c, activeAt) {
+			continue
+		}
+		result = append(result, c)
+	}
+	return result
+}
+
+// Apply evaluates all campaigns against the receipt and returns a breakdown line per
+// campaign that awarded points, basePoints are the points from the default rules.
+// Awarded points are reserved against the member caps until the receipt is credited or
+// released, applying the same receipt again replaces its reservation
+func (cs *CampaignService) Apply(receiptId string, receipt *models.Receipt, basePoints int64) []models.BreakdownLine {
+	purchasedAt, err := purchaseTimestamp(receipt)
+	if err != nil {
+		return nil
+	}
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.release(receiptId)
+
+	var matched []models.Campaign
+	for _, c := range cs.campaigns {
+		if inWindow(c, purchasedAt) && matchesTarget(c.Target, receipt, purchasedAt) {
+			matched = append(matched, c)
+		}
+	}
+	matched = resolveStacking(matched)
+
+	var lines []models.BreakdownLine
+	var awards []campaignAward
+	for _, c := range matched {
+		points := campaignPoints(c, basePoints)
+		if c.MemberCap > 0 {
+			points = cs.capForMember(c, receipt.MemberId, points)
+		}
+		if points <= 0 {
+			continue
+		}
+
+		if receipt.MemberId != "" {
+			award := campaignAward{campaignId: c.Id, memberId: receipt.MemberId, points: points}
+			cs.add(award, 1)
+			awards = append(awards, award)
+		}
+
+		lines = append(lines, models.BreakdownLine{
+			Rule:   "campaign",
+			Points: points,
+			Detail: c.Name,
+		})
+	}
+	if len(awards) > 0 {
+		cs.reserved[receiptId] = awards
+	}
+
+	return lines
+}
+
+// Credit keeps the points the receipt reserved, it is called once the receipt is credited
+func (cs *CampaignService) Credit(receiptId string) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	if awards, ok := cs.reserved[receiptId]; ok {
+		cs.credited[receiptId] = awards
+		delete(cs.reserved, receiptId)
+	}
+}
+
+// Release frees the points the receipt reserved, it is called when the receipt is
+// rejected or can't be stored so they don't count against the member caps
+func (cs *CampaignService) Release(receiptId string) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.release(receiptId)
+}
+
+// Reverse takes back the points a credited receipt earned, it is called when the receipt is voided
+func (cs *CampaignService) Reverse(receiptId string) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	for _, award := range cs.credited[receiptId] {
+		cs.add(award, -1)
+	}
+	delete(cs.credited, receiptId)
+}
+
+func (cs *CampaignService) release(receiptId string) {
+	for _, award := range cs.reserved[receiptId] {
+		cs.add(award, -1)
+	}
+	delete(cs.reserved, receiptId)
+}
+
+// add counts the award towards the member's total, sign -1 takes it back
+func (cs *CampaignService) add(award campaignAward, sign int64) {
+	if cs.awarded[award.campaignId] == nil {
+		cs.awarded[award.campaignId] = map[string]int64{}
+	}
+	cs.awarded[award.campaignId][award.memberId] += sign * award.points
+}
+
+// capForMember limits points to what is left of the member's cap,
+// anonymous receipts can't be tracked so they never earn from capped campaigns
+func (cs *CampaignService) capForMember(c models.Campaign, memberId string, points int64) int64 {
+	if memberId == "" {
+		return 0
+	}
+	remaining := c.MemberCap - cs.awarded[c.Id][memberId]
+	return min(points, remaining)
+}
+
+// resolveStacking keeps only the highest priority exclusive campaign if one matched,
+// otherwise all matched campaigns stack
+func resolveStacking(matched []models.Campaign) []models.Campaign {
+	sort.SliceStable(matched, func(i, j int) bool {
+		return matched[i].Priority > matched[j].Priority
+	})
+
+	for _, c := range matched {
+		if c.Exclusive {
+			return []models.Campaign{c}
+		}
+	}
+	return matched
+}
+
+func campaignPoints(c models.Campaign, basePoints int64) int64 {
+	points := c.Bonus
+	if c.Multiplier > 1 {
+		points += int64(math.Round(float64(basePoints) * (c.Multiplier - 1)))
+	}
+	return points
+}
+
+func inWindow(c models.Campaign, at time.Time) bool {
+	return !at.Before(c.Start) && at.Before(c.End)
+}
+
+func matchesTarget(target models.CampaignTarget, receipt *models.Receipt, purchasedAt time.Time) bool {
+	if target.Retailer != "" && !strings.EqualFold(strings.TrimSpace(receipt.Retailer), target.Retailer) {
+		return false
+	}
+
+	if target.ItemContains != "" {
+		found := false
+		needle := strings.ToLower(target.ItemContains)
+		for _, item := range receipt.Items {
+			if strings.Contains(strings.ToLower(item.ShortDescription), needle) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if target.MinTotal != "" || target.MaxTotal != "" {
+		total, err := parseCents(receipt.Total)
+		if err != nil {
+			return false
+		}
+		if minTotal, err := parseCents(target.MinTotal); err == nil && total < minTotal {
+			return false
+		}
+		if maxTotal, err := parseCents(target.MaxTotal); err == nil && total > maxTotal {
+			return false
+		}
+	}
+
+	timeOfDay := purchasedAt.Format(timeLayout)
+	// HH:MM strings compare correctly lexically
+	if target.TimeFrom != "" && timeOfDay < target.TimeFrom {
+		return false
+	}
+	if target.TimeTo != "" && timeOfDay >= target.TimeTo {
+		return false
+	}
+
+	if len(target.Weekdays) > 0 {
+		found := false
+		for _, day := range target.Weekdays {
+			if strings.EqualFold(day, purchasedAt.Weekday().String()) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+func validateCampaign(c models.Campaign) error {
+	if strings.TrimSpace(c.Name) == "" {
+		return fmt.Errorf("campaign name is required")
+	}
+	if c.Start.IsZero() || c.End.IsZero() || !c.End.After(c.Start) {
+		return fmt.Errorf("campaign needs a start and an end after the start")
+	}
+	if c.Bonus < 0 || c.MemberCap < 0 {
+		return fmt.Errorf("bonus and memberCap must not be negative")
+	}
+	if c.Multiplier != 0 && c.Multiplier < 1 {
+		return fmt.Errorf("multiplier must be at least 1")
+	}
+	if c.Bonus == 0 && c.Multiplier <= 1 {
+		return fmt.Errorf("campaign must award a bonus or a multiplier above 1")
+	}
+
+	for _, amount := range []string{c.Target.MinTotal, c.Target.MaxTotal} {
+		if amount == "" {
+			continue
+		}
+		if _, err := parseCents(amount); err != nil {
+			return err
+		}
+	}
+	for _, t := range []string{c.Target.TimeFrom, c.Target.TimeTo} {
+		if t == "" {
+			continue
+		}
+		if _, err := time.Parse(timeLayout, t); err != nil {
+			return fmt.Errorf("invalid time %q: must be in 24-hour format (HH:MM)", t)
+		}
+	}
+	for _, day := range c.Target.Weekdays {
+		if !isWeekday(day) {
+			return fmt.Errorf("invalid weekday: %s", day)
+		}
+	}
+
+	return nil
+}
+
+func isWeekday(day string) bool {
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if strings.EqualFold(day, d.String()) {
+			return true
+		}
+	}
+	return false
+}
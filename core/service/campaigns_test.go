@@ -0,0 +1,125 @@
+package service
+
+import (
+	"fmt"
+	"github.com/RA341/receipt-processor-challenge/models"
+	"testing"
+	"time"
+)
+
+var (
+	march2022 = models.Campaign{
+		Name:   "Gatorade March",
+		Start:  time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC),
+		End:    time.Date(2022, 4, 1, 0, 0, 0, 0, time.UTC),
+		Target: models.CampaignTarget{ItemContains: "gatorade"},
+		Bonus:  100,
+	}
+	doubleMarket = models.Campaign{
+		Name:       "Double points at M&M",
+		Start:      time.Date(2022, 3, 19, 0, 0, 0, 0, time.UTC),
+		End:        time.Date(2022, 3, 21, 0, 0, 0, 0, time.UTC),
+		Target:     models.CampaignTarget{Retailer: "m&m corner market", TimeFrom: "14:00", TimeTo: "15:00"},
+		Multiplier: 2,
+	}
+)
+
+func TestCampaignService_Stacking(t *testing.T) {
+	cs := NewCampaignService()
+	mustCreateCampaign(t, cs, march2022)
+	mustCreateCampaign(t, cs, doubleMarket)
+
+	receipt := testMap["test 2"].receipt
+	lines := cs.Apply("receipt-1", &receipt, 109)
+	if got := sumBreakdown(lines); got != 209 {
+		t.Fatalf("Expected 209 campaign points but got %d: %+v", got, lines)
+	}
+}
+
+func TestCampaignService_Exclusive(t *testing.T) {
+	cs := NewCampaignService()
+	mustCreateCampaign(t, cs, march2022)
+	exclusive := doubleMarket
+	exclusive.Exclusive = true
+	mustCreateCampaign(t, cs, exclusive)
+
+	receipt := testMap["test 2"].receipt
+	lines := cs.Apply("receipt-1", &receipt, 109)
+	if len(lines) != 1 || lines[0].Detail != exclusive.Name {
+		t.Fatalf("Expected only the exclusive campaign to apply but got %+v", lines)
+	}
+}
+
+func TestCampaignService_MemberCap(t *testing.T) {
+	cs := NewCampaignService()
+	capped := march2022
+	capped.MemberCap = 150
+	mustCreateCampaign(t, cs, capped)
+
+	receipt := testMap["test 2"].receipt
+	if lines := cs.Apply("anonymous", &receipt, 0); len(lines) != 0 {
+		t.Fatalf("Expected anonymous receipt to earn nothing from a capped campaign but got %+v", lines)
+	}
+
+	receipt.MemberId = "member-1"
+	expected := []int64{100, 50, 0}
+	for i, want := range expected {
+		receiptId := fmt.Sprintf("receipt-%d", i+1)
+		if got := sumBreakdown(cs.Apply(receiptId, &receipt, 0)); got != want {
+			t.Fatalf("Receipt %d: expected %d points but got %d", i+1, want, got)
+		}
+		cs.Credit(receiptId)
+	}
+
+	// voiding the first receipt gives its points back to the member
+	cs.Reverse("receipt-1")
+	if got := sumBreakdown(cs.Apply("receipt-4", &receipt, 0)); got != 100 {
+		t.Fatalf("Expected the voided points to be available again but got %d", got)
+	}
+}
+
+func TestCampaignService_MemberCapReservation(t *testing.T) {
+	cs := NewCampaignService()
+	capped := march2022
+	capped.MemberCap = 150
+	mustCreateCampaign(t, cs, capped)
+
+	receipt := testMap["test 2"].receipt
+	receipt.MemberId = "member-1"
+
+	// a held receipt reserves its points, so a second one can't exceed the cap meanwhile
+	if got := sumBreakdown(cs.Apply("held", &receipt, 0)); got != 100 {
+		t.Fatalf("Expected 100 points but got %d", got)
+	}
+	if got := sumBreakdown(cs.Apply("other", &receipt, 0)); got != 50 {
+		t.Fatalf("Expected the reservation to count against the cap but got %d", got)
+	}
+
+	// scoring the same receipt again replaces its reservation instead of adding to it
+	if got := sumBreakdown(cs.Apply("other", &receipt, 0)); got != 50 {
+		t.Fatalf("Expected a replayed receipt to get the same 50 points but got %d", got)
+	}
+
+	// rejecting the held receipt frees what it reserved
+	cs.Release("held")
+	cs.Release("other")
+	if got := sumBreakdown(cs.Apply("next", &receipt, 0)); got != 100 {
+		t.Fatalf("Expected the released points to be available again but got %d", got)
+	}
+}
+
+func TestCampaignService_OutsideWindow(t *testing.T) {
+	cs := NewCampaignService()
+	mustCreateCampaign(t, cs, march2022)
+
+	receipt := testMap["test 1"].receipt // January, no Gatorade
+	if lines := cs.Apply("receipt-1", &receipt, 28); len(lines) != 0 {
+		t.Fatalf("Expected no campaign points but got %+v", lines)
+	}
+}
+
+func mustCreateCampaign(t *testing.T, cs *CampaignService, campaign models.Campaign) {
+	if _, err := cs.Create(campaign); err != nil {
+		t.Fatalf("Failed to create campaign: %v", err)
+	}
+}
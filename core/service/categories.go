@@ -0,0 +1,145 @@
+package service
+
+import (
+	"fmt"
+	"github.com/RA341/receipt-processor-challenge/config"
+	"github.com/RA341/receipt-processor-challenge/models"
+	"regexp"
+	"strings"
+)
+
+// UncategorizedCategory is assigned to items that match nothing in the taxonomy
+const UncategorizedCategory = "uncategorized"
+
+type compiledCategory struct {
+	name     string
+	keywords []*regexp.Regexp
+	patterns []*regexp.Regexp
+}
+
+// Categorizer maps item descriptions to categories and applies the category rules
+type Categorizer struct {
+	categories []compiledCategory
+	parents    map[string]string
+	rules      []config.CategoryRuleConfig
+}
+
+func NewCategorizer(cfg config.CategoriesConfig) (*Categorizer, error) {
+	c := &Categorizer{
+		parents: map[string]string{},
+		rules:   cfg.Rules,
+	}
+
+	for _, category := range cfg.Taxonomy {
+		compiled := compiledCategory{name: category.Name}
+		for _, keyword := range category.Keywords {
+			// keywords match whole words, case-insensitive
+			re, err := regexp.Compile(`(?i)\b` + regexp.QuoteMeta(keyword) + `\b`)
+			if err != nil {
+				return nil, fmt.Errorf("category %s: invalid keyword %q: %v", category.Name, keyword, err)
+			}
+			compiled.keywords = append(compiled.keywords, re)
+		}
+		for _, pattern := range category.Patterns {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("category %s: invalid pattern %q: %v", category.Name, pattern, err)
+			}
+			compiled.patterns = append(compiled.patterns, re)
+		}
+
+		c.categories = append(c.categories, compiled)
+		if category.Parent != "" {
+			c.parents[category.Name] = category.Parent
+		}
+	}
+
+	return c, nil
+}
+
+// Categorize returns the first category in the taxonomy matching the description
+func (c *Categorizer) Categorize(description string) string {
+	description = strings.TrimSpace(description)
+	for _, category := range c.categories {
+		for _, re := range category.keywords {
+			if re.MatchString(description) {
+				return category.name
+			}
+		}
+		for _, re := range category.patterns {
+			if re.MatchString(description) {
+				return category.name
+			}
+		}
+	}
+	return UncategorizedCategory
+}
+
+// CategorizeItems sets the category on every item of the receipt
+func (c *Categorizer) CategorizeItems(receipt *models.Receipt) {
+	for i := range receipt.Items {
+		receipt.Items[i].Category = c.Categorize(receipt.Items[i].ShortDescription)
+	}
+}
+
+// isA reports whether category is target or one of its descendants
+func (c *Categorizer) isA(category, target string) bool {
+	seen := map[string]bool{}
+	for category != "" && !seen[category] {
+		if category == target {
+			return true
+		}
+		seen[category] = true
+		category = c.parents[category]
+	}
+	return false
+}
+
+func (c *Categorizer) excluded(item models.Item) bool {
+	for _, rule := range c.rules {
+		if rule.Exclude && c.isA(item.Category, rule.Category) {
+			return true
+		}
+	}
+	return false
+}
+
+// WithoutExcluded returns a copy of the receipt without items from excluded categories,
+// so they don't earn item based points
+func (c *Categorizer) WithoutExcluded(receipt models.Receipt) models.Receipt {
+	items := make([]models.Item, 0, len(receipt.Items))
+	for _, item := range receipt.Items {
+		if !c.excluded(item) {
+			items = append(items, item)
+		}
+	}
+	receipt.Items = items
+	return receipt
+}
+
+// categoryPoints awards each category rule's points per matching item, as a breakdown line per rule
+func (c *Categorizer) categoryPoints(receipt *models.Receipt) []models.BreakdownLine {
+	var lines []models.BreakdownLine
+	for _, rule := range c.rules {
+		if rule.PointsPerItem == 0 || rule.Exclude {
+			continue
+		}
+
+		var count int64 = 0
+		for _, item := range receipt.Items {
+			if c.isA(item.Category, rule.Category) {
+				count++
+			}
+		}
+		if count == 0 {
+			continue
+		}
+
+		lines = append(lines, models.BreakdownLine{
+			Rule:   "category",
+			Points: count * rule.PointsPerItem,
+			Detail: fmt.Sprintf("%d %s item(s) @ %d points", count, rule.Category, rule.PointsPerItem),
+		})
+	}
+	return lines
+}
@@ -0,0 +1,78 @@
+package service
+
+import (
+	"github.com/RA341/receipt-processor-challenge/config"
+	"github.com/RA341/receipt-processor-challenge/models"
+	"testing"
+)
+
+func TestCategorizer_Categorize(t *testing.T) {
+	categorizer, err := NewCategorizer(config.Default().Categories)
+	if err != nil {
+		t.Fatalf("Failed to build categorizer: %v", err)
+	}
+
+	cases := map[string]string{
+		"Mountain Dew 12PK":        "beverages",
+		"Emils Cheese Pizza":       "frozen",
+		"Doritos Nacho Cheese":     "snacks",
+		"Bud Light Beer 12pk":      "alcohol",
+		"   Klarbrunn 12-PK 12 FL": UncategorizedCategory,
+		"Pineapple":                UncategorizedCategory, // keywords match whole words only
+	}
+
+	for description, expected := range cases {
+		if got := categorizer.Categorize(description); got != expected {
+			t.Fatalf("%q: expected %s but got %s", description, expected, got)
+		}
+	}
+}
+
+func TestCategorizer_Rules(t *testing.T) {
+	cfg := config.CategoriesConfig{
+		Taxonomy: []config.CategoryConfig{
+			{Name: "beverages", Keywords: []string{"gatorade"}},
+			{Name: "alcohol", Parent: "beverages", Patterns: []string{`(?i)\bbeer\b`}},
+			{Name: "produce", Keywords: []string{"banana"}},
+		},
+		Rules: []config.CategoryRuleConfig{
+			{Category: "produce", PointsPerItem: 5},
+			{Category: "alcohol", Exclude: true},
+		},
+	}
+	categorizer, err := NewCategorizer(cfg)
+	if err != nil {
+		t.Fatalf("Failed to build categorizer: %v", err)
+	}
+
+	db, _ := NewDB()
+	srv := NewReceiptService(db, WithCategorizer(categorizer))
+
+	receipt := models.Receipt{
+		Retailer:     "Corner",
+		PurchaseDate: "2022-01-02",
+		PurchaseTime: "08:00",
+		Items: []models.Item{
+			{ShortDescription: "Banana", Price: "1.00"},
+			{ShortDescription: "Bananas", Price: "1.00"}, // not a whole word match
+			{ShortDescription: "Dark Beer", Price: "9.00"},
+			{ShortDescription: "Lager Beer", Price: "9.00"},
+		},
+		Total: "20.01",
+	}
+
+	id, err := srv.NewReceipt(receipt)
+	if err != nil {
+		t.Fatalf("Failed to create receipt: %v", err)
+	}
+	record, _ := srv.GetReceiptById(id)
+
+	// 6 retailer name + 5 for the two non-alcohol items + 1 for the "Banana" description + 5 produce,
+	// "Dark Beer" is excluded so its 9 character description earns nothing
+	if record.Points != 17 {
+		t.Fatalf("Expected 17 points but got %d: %+v", record.Points, record.Breakdown)
+	}
+	if record.Receipt.Items[2].Category != "alcohol" || receipt.Items[2].Category != "" {
+		t.Fatalf("Expected only the stored copy to be categorized: %+v", record.Receipt.Items)
+	}
+}
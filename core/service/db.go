@@ -2,40 +2,261 @@ package service
 
 import (
 	"fmt"
+	"github.com/RA341/receipt-processor-challenge/models"
 	"github.com/google/uuid"
+	"math"
+	"sort"
+	"strings"
 	"sync"
 )
 
 type Database interface {
-	CreatePoint(totalPoints int64) (transactionId string, err error)
-	GetPointById(transactionId string) (totalPoints int64, err error)
+	// CreateReceipt stores a new receipt under its id, or a new one if it has none
+	CreateReceipt(record models.ReceiptRecord) (transactionId string, err error)
+	GetReceiptById(transactionId string) (record models.ReceiptRecord, err error)
+	// UpdateReceipt replaces a stored receipt, it fails if the receipt doesn't exist
+	UpdateReceipt(record models.ReceiptRecord) error
+	// DeleteReceipt removes a stored receipt, deleting a missing receipt is not an error
+	DeleteReceipt(transactionId string) error
+	// ListReceipts returns a page of the receipts matching the query
+	ListReceipts(query ReceiptQuery) (ReceiptPage, error)
 }
 
+type storedReceipt struct {
+	record models.ReceiptRecord
+	// seq is the insertion order, it breaks ties in the sorted indexes
+	seq int64
+}
+
+// FranklyWeHaveNoIdeaWhereYourDataIsDB keeps receipts in memory, with secondary indexes
+// on the filterable and sortable fields so listing doesn't scan every receipt
 type FranklyWeHaveNoIdeaWhereYourDataIsDB struct {
-	pointsTable *sync.Map
+	mu           sync.RWMutex
+	receiptTable map[string]*storedReceipt
+	seq          int64
+
+	byRetailer map[string]map[string]struct{}
+	byMember   map[string]map[string]struct{}
+	byStatus   map[string]map[string]struct{}
+	sorted     map[string]*sortedIndex
 }
 
 func NewDB() (*FranklyWeHaveNoIdeaWhereYourDataIsDB, error) {
-	return &FranklyWeHaveNoIdeaWhereYourDataIsDB{pointsTable: &sync.Map{}}, nil
+	db := &FranklyWeHaveNoIdeaWhereYourDataIsDB{
+		receiptTable: map[string]*storedReceipt{},
+		byRetailer:   map[string]map[string]struct{}{},
+		byMember:     map[string]map[string]struct{}{},
+		byStatus:     map[string]map[string]struct{}{},
+		sorted:       map[string]*sortedIndex{},
+	}
+	for _, field := range receiptSortFields {
+		db.sorted[field] = &sortedIndex{}
+	}
+	return db, nil
+}
+
+func (f *FranklyWeHaveNoIdeaWhereYourDataIsDB) CreateReceipt(record models.ReceiptRecord) (transactionId string, err error) {
+	if record.Id == "" {
+		newUUID, err := uuid.NewUUID()
+		if err != nil {
+			return "", err
+		}
+		record.Id = newUUID.String()
+	}
+	transactionId = record.Id
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.receiptTable[transactionId]; ok {
+		return "", fmt.Errorf("receipt %s already exists", transactionId)
+	}
+
+	f.seq++
+	stored := &storedReceipt{record: record, seq: f.seq}
+	f.receiptTable[transactionId] = stored
+	f.index(stored)
+
+	return transactionId, nil
+}
+
+func (f *FranklyWeHaveNoIdeaWhereYourDataIsDB) GetReceiptById(transactionId string) (record models.ReceiptRecord, err error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	stored, ok := f.receiptTable[transactionId]
+	if !ok {
+		return models.ReceiptRecord{}, fmt.Errorf("unable to find receipt for: %s", transactionId)
+	}
+
+	return stored.record, nil
+}
+
+func (f *FranklyWeHaveNoIdeaWhereYourDataIsDB) UpdateReceipt(record models.ReceiptRecord) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	stored, ok := f.receiptTable[record.Id]
+	if !ok {
+		return fmt.Errorf("unable to find receipt for: %s", record.Id)
+	}
+
+	f.unindex(stored)
+	stored.record = record
+	f.index(stored)
+	return nil
+}
+
+func (f *FranklyWeHaveNoIdeaWhereYourDataIsDB) DeleteReceipt(transactionId string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	stored, ok := f.receiptTable[transactionId]
+	if !ok {
+		return nil
+	}
+	f.unindex(stored)
+	delete(f.receiptTable, transactionId)
+	return nil
 }
 
-func (f *FranklyWeHaveNoIdeaWhereYourDataIsDB) CreatePoint(totalPoints int64) (transactionId string, err error) {
-	newUUID, err := uuid.NewUUID()
+func (f *FranklyWeHaveNoIdeaWhereYourDataIsDB) ListReceipts(query ReceiptQuery) (ReceiptPage, error) {
+	query, err := query.normalized()
+	if err != nil {
+		return ReceiptPage{}, err
+	}
+	after, err := query.decodeCursor()
 	if err != nil {
-		return "", err
+		return ReceiptPage{}, err
+	}
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	var matched []*storedReceipt
+	if candidates, ok := f.smallestSet(query); ok {
+		matched = f.listFromSet(query, candidates, after)
+	} else {
+		matched = f.listFromIndex(query, after)
+	}
+
+	page := ReceiptPage{Receipts: make([]models.ReceiptRecord, 0, min(len(matched), query.Limit))}
+	for i, stored := range matched {
+		if i == query.Limit {
+			last := matched[i-1]
+			page.NextCursor = query.encodeCursor(indexEntry{key: sortKey(query.Sort, last), seq: last.seq})
+			break
+		}
+		page.Receipts = append(page.Receipts, stored.record)
+	}
+	return page, nil
+}
+
+// smallestSet returns the ids of the most selective equality filter, ok is false without one
+func (f *FranklyWeHaveNoIdeaWhereYourDataIsDB) smallestSet(query ReceiptQuery) (ids map[string]struct{}, ok bool) {
+	consider := func(index map[string]map[string]struct{}, value string) {
+		if value == "" {
+			return
+		}
+		set := index[value]
+		if !ok || len(set) < len(ids) {
+			ids, ok = set, true
+		}
+	}
+	consider(f.byRetailer, strings.ToLower(query.Retailer))
+	consider(f.byMember, query.MemberId)
+	consider(f.byStatus, query.Status)
+	return ids, ok
+}
+
+// listFromSet sorts the matching candidates, returning up to one more than the limit after the cursor
+func (f *FranklyWeHaveNoIdeaWhereYourDataIsDB) listFromSet(query ReceiptQuery, ids map[string]struct{}, after *indexEntry) []*storedReceipt {
+	var matched []*storedReceipt
+	for id := range ids {
+		stored := f.receiptTable[id]
+		entry := indexEntry{key: sortKey(query.Sort, stored), seq: stored.seq}
+		if after != nil && !entry.follows(*after, query.Descending) {
+			continue
+		}
+		if query.matches(stored.record) {
+			matched = append(matched, stored)
+		}
 	}
 
-	transactionId = newUUID.String()
-	f.pointsTable.Store(transactionId, totalPoints)
+	sort.Slice(matched, func(i, j int) bool {
+		a := indexEntry{key: sortKey(query.Sort, matched[i]), seq: matched[i].seq}
+		b := indexEntry{key: sortKey(query.Sort, matched[j]), seq: matched[j].seq}
+		return b.follows(a, query.Descending)
+	})
+	return matched[:min(len(matched), query.Limit+1)]
+}
+
+// listFromIndex walks the sorted index of the sort field from the cursor,
+// returning up to one more than the limit
+func (f *FranklyWeHaveNoIdeaWhereYourDataIsDB) listFromIndex(query ReceiptQuery, after *indexEntry) []*storedReceipt {
+	var matched []*storedReceipt
+	low, high, bounded := query.keyRange(query.Sort)
+
+	// without a cursor, start at the edge of the range instead of the edge of the index
+	start := after
+	if start == nil && bounded {
+		if query.Descending {
+			start = &indexEntry{key: high, seq: math.MaxInt64}
+		} else {
+			start = &indexEntry{key: low, seq: 0}
+		}
+	}
+
+	f.sorted[query.Sort].walk(start, query.Descending, func(entry indexEntry) bool {
+		if bounded && ((query.Descending && entry.key < low) || (!query.Descending && entry.key > high)) {
+			return false // past the end of the range
+		}
+		if stored := f.receiptTable[entry.id]; query.matches(stored.record) {
+			matched = append(matched, stored)
+		}
+		return len(matched) <= query.Limit
+	})
+	return matched
+}
+
+// index adds the receipt to the secondary indexes, callers hold the write lock
+func (f *FranklyWeHaveNoIdeaWhereYourDataIsDB) index(stored *storedReceipt) {
+	id := stored.record.Id
+	addToSet(f.byRetailer, strings.ToLower(stored.record.Receipt.Retailer), id)
+	addToSet(f.byMember, stored.record.Receipt.MemberId, id)
+	addToSet(f.byStatus, stored.record.Status, id)
+	for field, index := range f.sorted {
+		index.insert(indexEntry{key: sortKey(field, stored), seq: stored.seq, id: id})
+	}
+}
 
-	return transactionId, err
+// unindex removes the receipt from the secondary indexes, callers hold the write lock
+func (f *FranklyWeHaveNoIdeaWhereYourDataIsDB) unindex(stored *storedReceipt) {
+	id := stored.record.Id
+	removeFromSet(f.byRetailer, strings.ToLower(stored.record.Receipt.Retailer), id)
+	removeFromSet(f.byMember, stored.record.Receipt.MemberId, id)
+	removeFromSet(f.byStatus, stored.record.Status, id)
+	for field, index := range f.sorted {
+		index.remove(indexEntry{key: sortKey(field, stored), seq: stored.seq, id: id})
+	}
 }
 
-func (f *FranklyWeHaveNoIdeaWhereYourDataIsDB) GetPointById(transactionId string) (totalPoints int64, err error) {
-	tmpPoint, ok := f.pointsTable.Load(transactionId)
+func addToSet(index map[string]map[string]struct{}, value, id string) {
+	if value == "" {
+		return
+	}
+	set, ok := index[value]
 	if !ok {
-		return 0, fmt.Errorf("unable to find points for: %s", transactionId)
+		set = map[string]struct{}{}
+		index[value] = set
 	}
+	set[id] = struct{}{}
+}
 
-	return tmpPoint.(int64), nil
+func removeFromSet(index map[string]map[string]struct{}, value, id string) {
+	set := index[value]
+	delete(set, id)
+	if len(set) == 0 {
+		delete(index, value)
+	}
 }
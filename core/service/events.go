@@ -0,0 +1,154 @@
+package service
+
+import (
+	"github.com/RA341/receipt-processor-challenge/models"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// EventBus fans receipt events out to in-process subscribers and keeps
+// the most recent ones so streams can resume where they left off
+type EventBus struct {
+	mu          sync.Mutex
+	seq         int64
+	nextSub     int
+	subscribers map[int]func(event models.ReceiptEvent)
+	// replay holds the last replaySize events, oldest first
+	replay     []models.ReceiptEvent
+	replaySize int
+}
+
+func NewEventBus(replaySize int) *EventBus {
+	return &EventBus{
+		subscribers: map[int]func(event models.ReceiptEvent){},
+		replaySize:  replaySize,
+	}
+}
+
+// Subscribe calls handler for every published event, handlers run while the bus is locked
+// so they must hand slow work off instead of blocking, and must not publish themselves
+func (b *EventBus) Subscribe(handler func(event models.ReceiptEvent)) (unsubscribe func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	id := b.subscribe(handler)
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.subscribers, id)
+	}
+}
+
+func (b *EventBus) subscribe(handler func(event models.ReceiptEvent)) int {
+	id := b.nextSub
+	b.nextSub++
+	b.subscribers[id] = handler
+	return id
+}
+
+// Publish numbers the event and delivers it to every subscriber, in order of the numbers
+func (b *EventBus) Publish(event models.ReceiptEvent) models.ReceiptEvent {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.seq++
+	event.Id = strconv.FormatInt(b.seq, 10)
+	event.At = time.Now()
+
+	if b.replaySize > 0 {
+		if len(b.replay) == b.replaySize {
+			b.replay = b.replay[1:]
+		}
+		b.replay = append(b.replay, event)
+	}
+
+	for _, handler := range b.subscribers {
+		handler(event)
+	}
+	return event
+}
+
+// EventStream receives the events matching its filter on C, C is closed when
+// the stream is closed or the reader fell more than the buffer behind
+type EventStream struct {
+	C          <-chan models.ReceiptEvent
+	overflowed atomic.Bool
+	close      func()
+}
+
+// Overflowed reports whether the stream was dropped for falling behind
+func (s *EventStream) Overflowed() bool {
+	return s.overflowed.Load()
+}
+
+func (s *EventStream) Close() {
+	s.close()
+}
+
+// Stream subscribes to the events after lastEventId, replaying the ones still buffered,
+// an empty lastEventId only streams new events. filter may be nil to receive everything
+func (b *EventBus) Stream(lastEventId string, buffer int, filter func(event models.ReceiptEvent) bool) *EventStream {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var replay []models.ReceiptEvent
+	if after, err := strconv.ParseInt(lastEventId, 10, 64); err == nil {
+		for _, event := range b.replay {
+			seq, _ := strconv.ParseInt(event.Id, 10, 64)
+			if seq > after && (filter == nil || filter(event)) {
+				replay = append(replay, event)
+			}
+		}
+	}
+
+	ch := make(chan models.ReceiptEvent, buffer+len(replay))
+	for _, event := range replay {
+		ch <- event
+	}
+
+	stream := &EventStream{C: ch}
+	closed := false
+	// closeLocked must be called with the bus locked
+	closeLocked := func(id int) {
+		if !closed {
+			closed = true
+			delete(b.subscribers, id)
+			close(ch)
+		}
+	}
+
+	var id int
+	id = b.subscribe(func(event models.ReceiptEvent) {
+		if filter != nil && !filter(event) {
+			return
+		}
+		select {
+		case ch <- event:
+		default:
+			// don't let a slow reader hold up publishing, it can reconnect with Last-Event-ID
+			stream.overflowed.Store(true)
+			closeLocked(id)
+		}
+	})
+	stream.close = func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		closeLocked(id)
+	}
+
+	return stream
+}
+
+// receiptEvent describes the record's current state as an event of the type
+func receiptEvent(eventType string, record models.ReceiptRecord) models.ReceiptEvent {
+	return models.ReceiptEvent{
+		Type:      eventType,
+		ReceiptId: record.Id,
+		Retailer:  record.Receipt.Retailer,
+		MemberId:  record.Receipt.MemberId,
+		Status:    record.Status,
+		Points:    record.Points,
+	}
+}
@@ -0,0 +1,54 @@
+package service
+
+import (
+	"github.com/RA341/receipt-processor-challenge/models"
+	"testing"
+)
+
+func TestEventBus_StreamReplay(t *testing.T) {
+	bus := NewEventBus(3)
+	for _, retailer := range []string{"Target", "Walmart", "Target", "Target"} {
+		bus.Publish(models.ReceiptEvent{Type: models.EventReceiptScored, Retailer: retailer})
+	}
+
+	// event 1 fell out of the replay buffer, 2 doesn't match the filter
+	stream := bus.Stream("0", 10, func(event models.ReceiptEvent) bool { return event.Retailer == "Target" })
+	defer stream.Close()
+	bus.Publish(models.ReceiptEvent{Type: models.EventReceiptScored, Retailer: "Target"})
+
+	var ids []string
+	for len(ids) < 3 {
+		ids = append(ids, (<-stream.C).Id)
+	}
+	if ids[0] != "3" || ids[1] != "4" || ids[2] != "5" {
+		t.Fatalf("Expected events 3, 4 and 5 but got %v", ids)
+	}
+
+	live := bus.Stream("", 10, nil)
+	defer live.Close()
+	bus.Publish(models.ReceiptEvent{Type: models.EventReceiptVoided})
+	if event := <-live.C; event.Id != "6" {
+		t.Fatalf("Expected a new stream to only get new events, got %+v", event)
+	}
+}
+
+func TestEventBus_DropsSlowStreams(t *testing.T) {
+	bus := NewEventBus(10)
+	stream := bus.Stream("", 2, nil)
+
+	for i := 0; i < 3; i++ {
+		bus.Publish(models.ReceiptEvent{Type: models.EventReceiptScored})
+	}
+
+	received := 0
+	for range stream.C {
+		received++
+	}
+	if received != 2 || !stream.Overflowed() {
+		t.Fatalf("Expected the stream to be dropped after 2 events, got %d", received)
+	}
+
+	// closing a dropped stream is fine, and publishing goes on
+	stream.Close()
+	bus.Publish(models.ReceiptEvent{Type: models.EventReceiptScored})
+}
@@ -0,0 +1,104 @@
+package service
+
+import (
+	"fmt"
+	"github.com/RA341/receipt-processor-challenge/config"
+	"github.com/RA341/receipt-processor-challenge/models"
+	"hash/fnv"
+)
+
+// Experiment assigns every submission to one of its rule set variants, by a hash of the member or receipt id
+// so the assignment is deterministic and the same everywhere
+type Experiment struct {
+	name        string
+	hashBy      string
+	variants    []experimentVariant
+	totalWeight uint64
+}
+
+type experimentVariant struct {
+	name   string
+	weight uint64
+	// rules is nil for a variant scoring with the live rules
+	rules *Rules
+}
+
+// NewExperiment loads the rule files of the variants, it returns nil without an experiment name
+func NewExperiment(cfg config.ExperimentConfig) (*Experiment, error) {
+	if cfg.Name == "" {
+		return nil, nil
+	}
+
+	experiment := &Experiment{name: cfg.Name, hashBy: cfg.HashBy}
+	for _, variant := range cfg.Variants {
+		var rules *Rules
+		if variant.RulesFile != "" {
+			var err error
+			if rules, err = LoadRulesFile(variant.RulesFile); err != nil {
+				return nil, fmt.Errorf("experiment %s, variant %s: %v", cfg.Name, variant.Name, err)
+			}
+		}
+		experiment.variants = append(experiment.variants, experimentVariant{
+			name:   variant.Name,
+			weight: uint64(variant.Weight),
+			rules:  rules,
+		})
+		experiment.totalWeight += uint64(variant.Weight)
+	}
+	return experiment, nil
+}
+
+func (e *Experiment) Name() string {
+	return e.name
+}
+
+// assign picks the record's variant, records without a member are hashed by receipt id
+func (e *Experiment) assign(record models.ReceiptRecord) experimentVariant {
+	key := "receipt:" + record.Id
+	if e.hashBy == "member" && record.Receipt.MemberId != "" {
+		key = "member:" + record.Receipt.MemberId
+	}
+
+	// the experiment name salts the hash, so each experiment splits the members differently
+	hash := fnv.New64a()
+	_, _ = hash.Write([]byte(e.name + "/" + key))
+	slot := hash.Sum64() % e.totalWeight
+
+	for _, variant := range e.variants {
+		if slot < variant.weight {
+			return variant
+		}
+		slot -= variant.weight
+	}
+	return e.variants[len(e.variants)-1]
+}
+
+// variantRules returns the rules of the variant, ok is false if the experiment has no such variant
+// or the variant scores with the live rules
+func (e *Experiment) variantRules(experiment, variant string) (rules *Rules, ok bool) {
+	if experiment != e.name {
+		return nil, false
+	}
+	for _, v := range e.variants {
+		if v.name == variant && v.rules != nil {
+			return v.rules, true
+		}
+	}
+	return nil, false
+}
+
+// rulesFor returns the rules the record was scored with: its experiment variant's, or the version of the
+// live rules it was scored with. Records that don't know their rules fall back to the current ones
+func (s *ReceiptService) rulesFor(record models.ReceiptRecord) *Rules {
+	if s.experiment != nil && record.Variant != "" {
+		if rules, ok := s.experiment.variantRules(record.Experiment, record.Variant); ok {
+			return rules
+		}
+	}
+	if record.RulesVersion != nil {
+		if rules, err := s.rules.Version(*record.RulesVersion); err == nil {
+			return rules
+		}
+	}
+	return s.rules.Current()
+}
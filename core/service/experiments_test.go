@@ -0,0 +1,86 @@
+package service
+
+import (
+	"fmt"
+	"github.com/RA341/receipt-processor-challenge/config"
+	"github.com/RA341/receipt-processor-challenge/models"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExperiment_Assign(t *testing.T) {
+	experiment, err := NewExperiment(config.ExperimentConfig{
+		Name:   "quarter-bonus",
+		HashBy: "member",
+		Variants: []config.ExperimentVariantConfig{
+			{Name: "control", Weight: 90},
+			{Name: "bonus", Weight: 10},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create experiment: %v", err)
+	}
+
+	counts := map[string]int{}
+	for i := 0; i < 10000; i++ {
+		record := models.ReceiptRecord{Id: fmt.Sprintf("receipt-%d", i), Receipt: models.Receipt{MemberId: fmt.Sprintf("member-%d", i)}}
+		variant := experiment.assign(record)
+		counts[variant.name]++
+
+		// a member keeps the variant on every receipt
+		record.Id = "another-receipt"
+		if again := experiment.assign(record); again.name != variant.name {
+			t.Fatalf("Expected member-%d to stay in %s, got %s", i, variant.name, again.name)
+		}
+	}
+	if counts["bonus"] < 900 || counts["bonus"] > 1100 {
+		t.Fatalf("Expected about 10%% of members in bonus, got %d of 10000", counts["bonus"])
+	}
+
+	if none, err := NewExperiment(config.ExperimentConfig{}); none != nil || err != nil {
+		t.Fatalf("Expected no experiment without a name, got %v, %v", none, err)
+	}
+}
+
+func TestReceiptService_Experiment(t *testing.T) {
+	// the bonus variant only awards 1000 points for a round total
+	path := filepath.Join(t.TempDir(), "bonus.json")
+	if err := os.WriteFile(path, []byte(`{"name": "bonus", "rules": [{"type": "roundTotal", "points": 1000}]}`), 0o600); err != nil {
+		t.Fatalf("Failed to write rule file: %v", err)
+	}
+	experiment, err := NewExperiment(config.ExperimentConfig{
+		Name:     "round-bonus",
+		HashBy:   "receipt",
+		Variants: []config.ExperimentVariantConfig{{Name: "bonus", Weight: 1, RulesFile: path}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create experiment: %v", err)
+	}
+
+	db, _ := NewDB()
+	srv := NewReceiptService(db, WithExperiment(experiment))
+
+	id, err := srv.NewReceipt(testMap["test 2"].receipt) // M&M Corner Market, 9.00
+	if err != nil {
+		t.Fatalf("Failed to submit receipt: %v", err)
+	}
+	record, err := srv.GetReceiptById(id)
+	if err != nil {
+		t.Fatalf("Failed to get receipt: %v", err)
+	}
+	if record.Experiment != "round-bonus" || record.Variant != "bonus" {
+		t.Fatalf("Expected the receipt in round-bonus/bonus, got %s/%s", record.Experiment, record.Variant)
+	}
+	if record.Points != 1000 {
+		t.Fatalf("Expected the variant's 1000 points, got %d: %+v", record.Points, record.Breakdown)
+	}
+
+	buckets, err := srv.Report(ReportQuery{GroupBy: GroupByVariant})
+	if err != nil {
+		t.Fatalf("Failed to build report: %v", err)
+	}
+	if len(buckets) != 1 || buckets[0].Group != "round-bonus/bonus" || buckets[0].PointsIssued != 1000 {
+		t.Fatalf("Expected one round-bonus/bonus bucket with 1000 points, got %+v", buckets)
+	}
+}
@@ -0,0 +1,146 @@
+package service
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/RA341/receipt-processor-challenge/models"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	FormatCSV    = "csv"
+	FormatNDJSON = "ndjson"
+)
+
+// ReceiptWriter writes receipts in an export format one at a time
+type ReceiptWriter interface {
+	Write(record models.ReceiptRecord) error
+	// Flush writes anything buffered to the underlying writer
+	Flush() error
+}
+
+// NewReceiptWriter returns a writer for the format, FormatCSV or FormatNDJSON
+func NewReceiptWriter(format string, w io.Writer) (ReceiptWriter, error) {
+	switch format {
+	case FormatCSV:
+		return &csvReceiptWriter{w: csv.NewWriter(w)}, nil
+	case FormatNDJSON:
+		return &ndjsonReceiptWriter{enc: json.NewEncoder(w)}, nil
+	default:
+		return nil, fmt.Errorf("format must be %s or %s", FormatCSV, FormatNDJSON)
+	}
+}
+
+// ExportContentType is the media type of the export format
+func ExportContentType(format string) string {
+	if format == FormatCSV {
+		return "text/csv"
+	}
+	return "application/x-ndjson"
+}
+
+// ExportReceipts writes every receipt matching the query, a page at a time so
+// the whole export is never held in memory. The query's Limit and Cursor are ignored
+func (s *ReceiptService) ExportReceipts(query ReceiptQuery, w ReceiptWriter) error {
+	query.Limit = MaxListLimit
+	query.Cursor = ""
+
+	for {
+		page, err := s.db.ListReceipts(query)
+		if err != nil {
+			return err
+		}
+		for _, record := range page.Receipts {
+			if err := w.Write(record); err != nil {
+				return err
+			}
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+
+		if page.NextCursor == "" {
+			return nil
+		}
+		query.Cursor = page.NextCursor
+	}
+}
+
+var csvExportHeader = []string{
+	"id", "retailer", "rawRetailer", "purchaseDate", "purchaseTime", "total", "items",
+	"memberId", "status", "points", "breakdown", "createdAt", "variant",
+}
+
+// csvReceiptWriter writes a row per receipt, the breakdown is folded into one rule=points;... column
+type csvReceiptWriter struct {
+	w             *csv.Writer
+	headerWritten bool
+}
+
+func (c *csvReceiptWriter) Write(record models.ReceiptRecord) error {
+	if !c.headerWritten {
+		c.headerWritten = true
+		if err := c.w.Write(csvExportHeader); err != nil {
+			return err
+		}
+	}
+
+	breakdown := make([]string, 0, len(record.Breakdown))
+	for _, line := range record.Breakdown {
+		breakdown = append(breakdown, fmt.Sprintf("%s=%d", line.Rule, line.Points))
+	}
+
+	return c.w.Write([]string{
+		csvText(record.Id),
+		csvText(record.Receipt.Retailer),
+		csvText(record.RawRetailer),
+		csvText(record.Receipt.PurchaseDate),
+		csvText(record.Receipt.PurchaseTime),
+		csvText(record.Receipt.Total),
+		strconv.Itoa(len(record.Receipt.Items)),
+		csvText(record.Receipt.MemberId),
+		record.Status,
+		strconv.FormatInt(record.Points, 10),
+		csvText(strings.Join(breakdown, ";")),
+		record.CreatedAt.Format(time.RFC3339),
+		csvText(record.Variant),
+	})
+}
+
+// csvText keeps spreadsheets from running submitted text as a formula, cells starting with
+// a character that starts a formula get a leading quote
+func csvText(cell string) string {
+	if cell != "" && strings.ContainsRune("=+-@\t\r", rune(cell[0])) {
+		return "'" + cell
+	}
+	return cell
+}
+
+func (c *csvReceiptWriter) Flush() error {
+	// an empty export still gets its header
+	if !c.headerWritten {
+		c.headerWritten = true
+		if err := c.w.Write(csvExportHeader); err != nil {
+			return err
+		}
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// ndjsonReceiptWriter writes every stored receipt as a JSON object on its own line
+type ndjsonReceiptWriter struct {
+	enc *json.Encoder
+}
+
+func (n *ndjsonReceiptWriter) Write(record models.ReceiptRecord) error {
+	return n.enc.Encode(record)
+}
+
+func (n *ndjsonReceiptWriter) Flush() error {
+	return nil
+}
@@ -0,0 +1,98 @@
+package service
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"github.com/RA341/receipt-processor-challenge/models"
+	"strings"
+	"testing"
+)
+
+func TestReceiptService_ExportReceipts(t *testing.T) {
+	db, _ := NewDB()
+	srv := NewReceiptService(db)
+
+	// enough receipts to span several pages
+	for i := 0; i < MaxListLimit+10; i++ {
+		receipt := testMap["test 2"].receipt
+		if i%2 == 0 {
+			receipt = testMap["test 1"].receipt
+		}
+		if _, err := srv.NewReceipt(receipt); err != nil {
+			t.Fatalf("Failed to submit receipt: %v", err)
+		}
+	}
+
+	var out bytes.Buffer
+	writer, _ := NewReceiptWriter(FormatCSV, &out)
+	if err := srv.ExportReceipts(ReceiptQuery{Retailer: "target", Limit: 5}, writer); err != nil {
+		t.Fatalf("Failed to export receipts: %v", err)
+	}
+	rows, err := csv.NewReader(&out).ReadAll()
+	if err != nil {
+		t.Fatalf("Export is not valid csv: %v", err)
+	}
+	if len(rows) != 1+(MaxListLimit+10)/2 {
+		t.Fatalf("Expected a header and %d receipts, got %d rows", (MaxListLimit+10)/2, len(rows))
+	}
+	if strings.Join(rows[0], ",") != strings.Join(csvExportHeader, ",") {
+		t.Fatalf("Expected the header first, got %v", rows[0])
+	}
+	if row := rows[1]; row[1] != "Target" || row[9] != "28" || !strings.Contains(row[10], "retailerName=6") {
+		t.Fatalf("Unexpected row %v", row)
+	}
+
+	out.Reset()
+	writer, _ = NewReceiptWriter(FormatNDJSON, &out)
+	minPoints := int64(100)
+	if err := srv.ExportReceipts(ReceiptQuery{MinPoints: &minPoints}, writer); err != nil {
+		t.Fatalf("Failed to export receipts: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != (MaxListLimit+10)/2 {
+		t.Fatalf("Expected %d lines, got %d", (MaxListLimit+10)/2, len(lines))
+	}
+	var record models.ReceiptRecord
+	if err := json.Unmarshal([]byte(lines[0]), &record); err != nil || record.Points != 109 || len(record.Breakdown) == 0 {
+		t.Fatalf("Unexpected line %s: %v", lines[0], err)
+	}
+
+	out.Reset()
+	writer, _ = NewReceiptWriter(FormatCSV, &out)
+	if err := srv.ExportReceipts(ReceiptQuery{Retailer: "Costco"}, writer); err != nil || strings.Count(out.String(), "\n") != 1 {
+		t.Fatalf("Expected an empty export to only have the header, got %q (%v)", out.String(), err)
+	}
+
+	if _, err := NewReceiptWriter("xlsx", &out); err == nil {
+		t.Fatalf("Expected an unknown format to fail")
+	}
+}
+
+func TestCsvReceiptWriter_EscapesFormulas(t *testing.T) {
+	record := models.ReceiptRecord{
+		Id:          "receipt-1",
+		Receipt:     models.Receipt{Retailer: "@SUM(A1:A9)", MemberId: `=HYPERLINK("https://example.com")`, Total: "-1.00"},
+		RawRetailer: "+Target",
+		Points:      -5,
+	}
+
+	var out bytes.Buffer
+	writer, _ := NewReceiptWriter(FormatCSV, &out)
+	if err := writer.Write(record); err != nil {
+		t.Fatalf("Failed to write receipt: %v", err)
+	}
+	_ = writer.Flush()
+
+	rows, err := csv.NewReader(&out).ReadAll()
+	if err != nil {
+		t.Fatalf("Export is not valid csv: %v", err)
+	}
+	row := rows[1]
+	if row[1] != "'@SUM(A1:A9)" || row[2] != "'+Target" || row[5] != "'-1.00" || row[7] != `'=HYPERLINK("https://example.com")` {
+		t.Fatalf("Expected the formulas to be quoted, got %v", row)
+	}
+	if row[0] != "receipt-1" || row[9] != "-5" {
+		t.Fatalf("Expected other cells to be left alone, got %v", row)
+	}
+}
@@ -0,0 +1,847 @@
+package service
+
+import (
+	"fmt"
+	"github.com/RA341/receipt-processor-challenge/models"
+	u "github.com/RA341/receipt-processor-challenge/utils"
+	"log/slog"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Expressions are compiled once when the rules are, and can't loop, so these limits only
+// guard against very large expressions and receipts with very many items
+const (
+	exprMaxLength = 2000
+	exprMaxDepth  = 50
+	// exprMaxSteps bounds the operations, item visits included, of one evaluation. It is the only
+	// limit on evaluation, a clock would give the same receipt different points under load
+	exprMaxSteps = 10000
+)
+
+var errExprSteps = fmt.Errorf("expression took more than %d steps", exprMaxSteps)
+
+type exprType int
+
+const (
+	exprNumber exprType = iota
+	exprString
+	exprBool
+)
+
+func (t exprType) String() string {
+	switch t {
+	case exprNumber:
+		return "number"
+	case exprString:
+		return "string"
+	default:
+		return "boolean"
+	}
+}
+
+type exprValue struct {
+	num  float64
+	str  string
+	bool bool
+}
+
+// exprEnv is the receipt an expression is evaluated on and the budget it has left
+type exprEnv struct {
+	receipt     *models.Receipt
+	total       float64
+	purchasedAt time.Time
+	steps       int
+}
+
+func newExprEnv(receipt *models.Receipt) (*exprEnv, error) {
+	total, err := parseCents(receipt.Total)
+	if err != nil {
+		return nil, err
+	}
+	purchasedAt, err := purchaseTimestamp(receipt)
+	if err != nil {
+		return nil, fmt.Errorf("invalid purchase date or time: %v", err)
+	}
+	return &exprEnv{
+		receipt:     receipt,
+		total:       float64(total) / 100,
+		purchasedAt: purchasedAt,
+	}, nil
+}
+
+// step charges an operation to the budget
+func (env *exprEnv) step() error {
+	env.steps++
+	if env.steps > exprMaxSteps {
+		return errExprSteps
+	}
+	return nil
+}
+
+// expr is a type checked expression, compiled to a closure
+type expr struct {
+	typ  exprType
+	eval func(env *exprEnv) (exprValue, error)
+}
+
+// exprVariables are the receipt fields expressions can read
+var exprVariables = map[string]expr{
+	"retailer": {typ: exprString, eval: func(env *exprEnv) (exprValue, error) {
+		return exprValue{str: env.receipt.Retailer}, nil
+	}},
+	"total": {typ: exprNumber, eval: func(env *exprEnv) (exprValue, error) {
+		return exprValue{num: env.total}, nil
+	}},
+	"itemCount": {typ: exprNumber, eval: func(env *exprEnv) (exprValue, error) {
+		return exprValue{num: float64(len(env.receipt.Items))}, nil
+	}},
+	"date": {typ: exprString, eval: func(env *exprEnv) (exprValue, error) {
+		return exprValue{str: env.receipt.PurchaseDate}, nil
+	}},
+	"time": {typ: exprString, eval: func(env *exprEnv) (exprValue, error) {
+		return exprValue{str: env.receipt.PurchaseTime}, nil
+	}},
+	"year": {typ: exprNumber, eval: func(env *exprEnv) (exprValue, error) {
+		return exprValue{num: float64(env.purchasedAt.Year())}, nil
+	}},
+	"month": {typ: exprNumber, eval: func(env *exprEnv) (exprValue, error) {
+		return exprValue{num: float64(env.purchasedAt.Month())}, nil
+	}},
+	"day": {typ: exprNumber, eval: func(env *exprEnv) (exprValue, error) {
+		return exprValue{num: float64(env.purchasedAt.Day())}, nil
+	}},
+	"weekday": {typ: exprString, eval: func(env *exprEnv) (exprValue, error) {
+		return exprValue{str: env.purchasedAt.Weekday().String()}, nil
+	}},
+	"hour": {typ: exprNumber, eval: func(env *exprEnv) (exprValue, error) {
+		return exprValue{num: float64(env.purchasedAt.Hour())}, nil
+	}},
+	"minute": {typ: exprNumber, eval: func(env *exprEnv) (exprValue, error) {
+		return exprValue{num: float64(env.purchasedAt.Minute())}, nil
+	}},
+}
+
+// exprFunction is a helper expressions can call, variadic functions take any number of their last parameter
+type exprFunction struct {
+	params   []exprType
+	variadic bool
+	result   exprType
+	call     func(env *exprEnv, args []exprValue) (exprValue, error)
+}
+
+func numberFunc(f func(float64) float64) exprFunction {
+	return exprFunction{params: []exprType{exprNumber}, result: exprNumber, call: func(_ *exprEnv, args []exprValue) (exprValue, error) {
+		return exprValue{num: f(args[0].num)}, nil
+	}}
+}
+
+func stringFunc(f func(string) string) exprFunction {
+	return exprFunction{params: []exprType{exprString}, result: exprString, call: func(_ *exprEnv, args []exprValue) (exprValue, error) {
+		return exprValue{str: f(args[0].str)}, nil
+	}}
+}
+
+func textTest(f func(s, substr string) bool) exprFunction {
+	return exprFunction{params: []exprType{exprString, exprString}, result: exprBool, call: func(_ *exprEnv, args []exprValue) (exprValue, error) {
+		return exprValue{bool: f(args[0].str, args[1].str)}, nil
+	}}
+}
+
+func extremeFunc(pick func(a, b float64) float64) exprFunction {
+	return exprFunction{params: []exprType{exprNumber}, variadic: true, result: exprNumber, call: func(_ *exprEnv, args []exprValue) (exprValue, error) {
+		result := args[0].num
+		for _, arg := range args[1:] {
+			result = pick(result, arg.num)
+		}
+		return exprValue{num: result}, nil
+	}}
+}
+
+// itemFunc visits the items whose description contains the text, ignoring case
+func itemFunc(result exprType, visit func(value *exprValue, price float64)) exprFunction {
+	return exprFunction{params: []exprType{exprString}, result: result, call: func(env *exprEnv, args []exprValue) (exprValue, error) {
+		text := strings.ToLower(args[0].str)
+		var value exprValue
+		for _, item := range env.receipt.Items {
+			if err := env.step(); err != nil {
+				return exprValue{}, err
+			}
+			if !strings.Contains(strings.ToLower(item.ShortDescription), text) {
+				continue
+			}
+			price, err := parseCents(item.Price)
+			if err != nil {
+				return exprValue{}, err
+			}
+			visit(&value, float64(price)/100)
+		}
+		return value, nil
+	}}
+}
+
+var exprFunctions = map[string]exprFunction{
+	"floor":      numberFunc(math.Floor),
+	"ceil":       numberFunc(math.Ceil),
+	"round":      numberFunc(math.Round),
+	"abs":        numberFunc(math.Abs),
+	"min":        extremeFunc(math.Min),
+	"max":        extremeFunc(math.Max),
+	"lower":      stringFunc(strings.ToLower),
+	"upper":      stringFunc(strings.ToUpper),
+	"trim":       stringFunc(strings.TrimSpace),
+	"contains":   textTest(strings.Contains),
+	"startsWith": textTest(strings.HasPrefix),
+	"endsWith":   textTest(strings.HasSuffix),
+	"len": {params: []exprType{exprString}, result: exprNumber, call: func(_ *exprEnv, args []exprValue) (exprValue, error) {
+		return exprValue{num: float64(len([]rune(args[0].str)))}, nil
+	}},
+	"countItems": itemFunc(exprNumber, func(value *exprValue, _ float64) {
+		value.num++
+	}),
+	"itemTotal": itemFunc(exprNumber, func(value *exprValue, price float64) {
+		value.num += price
+	}),
+	"anyItem": itemFunc(exprBool, func(value *exprValue, _ float64) {
+		value.bool = true
+	}),
+}
+
+// ExprError is an error in an expression, at the 1-based column it was found
+type ExprError struct {
+	Column  int
+	Message string
+}
+
+func (e *ExprError) Error() string {
+	return fmt.Sprintf("column %d: %s", e.Column, e.Message)
+}
+
+// compileExpr parses and type checks the expression, it must evaluate to the type
+func compileExpr(source string, want exprType) (expr, error) {
+	if len(source) > exprMaxLength {
+		return expr{}, fmt.Errorf("expression is longer than %d characters", exprMaxLength)
+	}
+	tokens, err := tokenizeExpr(source)
+	if err != nil {
+		return expr{}, err
+	}
+
+	p := &exprParser{tokens: tokens}
+	compiled, err := p.parseOr()
+	if err != nil {
+		return expr{}, err
+	}
+	if next := p.peek(); next.kind != tokenEOF {
+		return expr{}, p.errorAt(next, "unexpected %s", next)
+	}
+	if compiled.typ != want {
+		return expr{}, &ExprError{Column: 1, Message: fmt.Sprintf("expression is a %s, it must be a %s", compiled.typ, want)}
+	}
+	return compiled, nil
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenNumber
+	tokenString
+	tokenIdent
+	tokenOperator
+)
+
+type exprToken struct {
+	kind   tokenKind
+	text   string
+	num    float64
+	column int
+}
+
+func (t exprToken) String() string {
+	switch t.kind {
+	case tokenEOF:
+		return "end of expression"
+	case tokenString:
+		return strconv.Quote(t.text)
+	default:
+		return "'" + t.text + "'"
+	}
+}
+
+// exprOperators are matched longest first
+var exprOperators = []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "!", "+", "-", "*", "/", "%", "(", ")", ","}
+
+func tokenizeExpr(source string) ([]exprToken, error) {
+	var tokens []exprToken
+	runes := []rune(source)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		column := i + 1
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case unicode.IsDigit(r) || (r == '.' && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			start := i
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.') {
+				i++
+			}
+			num, err := strconv.ParseFloat(string(runes[start:i]), 64)
+			if err != nil {
+				return nil, &ExprError{Column: column, Message: fmt.Sprintf("invalid number %s", string(runes[start:i]))}
+			}
+			tokens = append(tokens, exprToken{kind: tokenNumber, text: string(runes[start:i]), num: num, column: column})
+		case r == '\'' || r == '"':
+			var text strings.Builder
+			i++
+			for i < len(runes) && runes[i] != r {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+				}
+				text.WriteRune(runes[i])
+				i++
+			}
+			if i == len(runes) {
+				return nil, &ExprError{Column: column, Message: "string is missing its closing quote"}
+			}
+			i++
+			tokens = append(tokens, exprToken{kind: tokenString, text: text.String(), column: column})
+		case unicode.IsLetter(r) || r == '_':
+			start := i
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_') {
+				i++
+			}
+			tokens = append(tokens, exprToken{kind: tokenIdent, text: string(runes[start:i]), column: column})
+		default:
+			matched := ""
+			for _, op := range exprOperators {
+				if strings.HasPrefix(string(runes[i:]), op) {
+					matched = op
+					break
+				}
+			}
+			if matched == "" {
+				return nil, &ExprError{Column: column, Message: fmt.Sprintf("unexpected character %q", r)}
+			}
+			i += len([]rune(matched))
+			tokens = append(tokens, exprToken{kind: tokenOperator, text: matched, column: column})
+		}
+	}
+	return append(tokens, exprToken{kind: tokenEOF, column: len(runes) + 1}), nil
+}
+
+// exprParser is a recursive descent parser, from the lowest precedence:
+//
+//	or:         and (("or" | "||") and)*
+//	and:        not (("and" | "&&") not)*
+//	not:        ("not" | "!") not | comparison
+//	comparison: sum (("==" | "!=" | "<" | "<=" | ">" | ">=" | "contains") sum)?
+//	sum:        product (("+" | "-") product)*
+//	product:    unary (("*" | "/" | "%") unary)*
+//	unary:      "-" unary | primary
+//	primary:    number | string | "true" | "false" | variable | function "(" arguments ")" | "(" or ")"
+type exprParser struct {
+	tokens []exprToken
+	pos    int
+	depth  int
+}
+
+func (p *exprParser) peek() exprToken {
+	return p.tokens[p.pos]
+}
+
+func (p *exprParser) next() exprToken {
+	token := p.tokens[p.pos]
+	if token.kind != tokenEOF {
+		p.pos++
+	}
+	return token
+}
+
+// accept consumes the next token if it is one of the operators or keywords
+func (p *exprParser) accept(texts ...string) (exprToken, bool) {
+	token := p.peek()
+	if token.kind != tokenOperator && token.kind != tokenIdent {
+		return token, false
+	}
+	for _, text := range texts {
+		if token.text == text {
+			return p.next(), true
+		}
+	}
+	return token, false
+}
+
+func (p *exprParser) errorAt(token exprToken, format string, args ...any) error {
+	return &ExprError{Column: token.column, Message: fmt.Sprintf(format, args...)}
+}
+
+// operand checks the type of an operator's operand
+func (p *exprParser) operand(op exprToken, operand expr, want exprType) error {
+	if operand.typ != want {
+		return p.errorAt(op, "%s needs %s operands, got a %s", op, want, operand.typ)
+	}
+	return nil
+}
+
+func (p *exprParser) operands(op exprToken, want exprType, left, right expr) error {
+	if err := p.operand(op, left, want); err != nil {
+		return err
+	}
+	return p.operand(op, right, want)
+}
+
+func (p *exprParser) parseOr() (expr, error) {
+	p.depth++
+	defer func() { p.depth-- }()
+	if p.depth > exprMaxDepth {
+		return expr{}, p.errorAt(p.peek(), "expression is nested more than %d levels deep", exprMaxDepth)
+	}
+
+	left, err := p.parseAnd()
+	if err != nil {
+		return expr{}, err
+	}
+	for {
+		op, ok := p.accept("or", "||")
+		if !ok {
+			return left, nil
+		}
+		right, err := p.parseAnd()
+		if err != nil {
+			return expr{}, err
+		}
+		if err := p.operands(op, exprBool, left, right); err != nil {
+			return expr{}, err
+		}
+		left = logical(left, right, true)
+	}
+}
+
+func (p *exprParser) parseAnd() (expr, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return expr{}, err
+	}
+	for {
+		op, ok := p.accept("and", "&&")
+		if !ok {
+			return left, nil
+		}
+		right, err := p.parseNot()
+		if err != nil {
+			return expr{}, err
+		}
+		if err := p.operands(op, exprBool, left, right); err != nil {
+			return expr{}, err
+		}
+		left = logical(left, right, false)
+	}
+}
+
+// logical short circuits, or stops at the first true operand and and at the first false one
+func logical(left, right expr, or bool) expr {
+	return expr{typ: exprBool, eval: func(env *exprEnv) (exprValue, error) {
+		if err := env.step(); err != nil {
+			return exprValue{}, err
+		}
+		l, err := left.eval(env)
+		if err != nil || l.bool == or {
+			return l, err
+		}
+		return right.eval(env)
+	}}
+}
+
+func (p *exprParser) parseNot() (expr, error) {
+	op, ok := p.accept("not", "!")
+	if !ok {
+		return p.parseComparison()
+	}
+	operand, err := p.parseNot()
+	if err != nil {
+		return expr{}, err
+	}
+	if err := p.operand(op, operand, exprBool); err != nil {
+		return expr{}, err
+	}
+	return expr{typ: exprBool, eval: func(env *exprEnv) (exprValue, error) {
+		if err := env.step(); err != nil {
+			return exprValue{}, err
+		}
+		v, err := operand.eval(env)
+		return exprValue{bool: !v.bool}, err
+	}}, nil
+}
+
+func (p *exprParser) parseComparison() (expr, error) {
+	left, err := p.parseSum()
+	if err != nil {
+		return expr{}, err
+	}
+	op, ok := p.accept("==", "!=", "<", "<=", ">", ">=", "contains")
+	if !ok {
+		return left, nil
+	}
+	right, err := p.parseSum()
+	if err != nil {
+		return expr{}, err
+	}
+
+	var compare func(l, r exprValue) bool
+	switch op.text {
+	case "==", "!=":
+		if left.typ != right.typ {
+			return expr{}, p.errorAt(op, "%s compares a %s with a %s", op, left.typ, right.typ)
+		}
+		compare = func(l, r exprValue) bool {
+			return (l == r) == (op.text == "==")
+		}
+	case "contains":
+		if err := p.operands(op, exprString, left, right); err != nil {
+			return expr{}, err
+		}
+		compare = func(l, r exprValue) bool {
+			return strings.Contains(l.str, r.str)
+		}
+	default:
+		if left.typ == exprBool || left.typ != right.typ {
+			return expr{}, p.errorAt(op, "%s needs two numbers or two strings, got a %s and a %s", op, left.typ, right.typ)
+		}
+		compare = func(l, r exprValue) bool {
+			order := strings.Compare(l.str, r.str)
+			if left.typ == exprNumber {
+				order = compareNumbers(l.num, r.num)
+			}
+			switch op.text {
+			case "<":
+				return order < 0
+			case "<=":
+				return order <= 0
+			case ">":
+				return order > 0
+			default:
+				return order >= 0
+			}
+		}
+	}
+
+	return expr{typ: exprBool, eval: func(env *exprEnv) (exprValue, error) {
+		l, r, err := evalBoth(env, left, right)
+		if err != nil {
+			return exprValue{}, err
+		}
+		return exprValue{bool: compare(l, r)}, nil
+	}}, nil
+}
+
+func compareNumbers(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func evalBoth(env *exprEnv, left, right expr) (exprValue, exprValue, error) {
+	if err := env.step(); err != nil {
+		return exprValue{}, exprValue{}, err
+	}
+	l, err := left.eval(env)
+	if err != nil {
+		return exprValue{}, exprValue{}, err
+	}
+	r, err := right.eval(env)
+	return l, r, err
+}
+
+func (p *exprParser) parseSum() (expr, error) {
+	left, err := p.parseProduct()
+	if err != nil {
+		return expr{}, err
+	}
+	for {
+		op, ok := p.accept("+", "-")
+		if !ok {
+			return left, nil
+		}
+		right, err := p.parseProduct()
+		if err != nil {
+			return expr{}, err
+		}
+		// + joins strings as well
+		if op.text == "+" && left.typ == exprString && right.typ == exprString {
+			l, r := left, right
+			left = expr{typ: exprString, eval: func(env *exprEnv) (exprValue, error) {
+				lv, rv, err := evalBoth(env, l, r)
+				return exprValue{str: lv.str + rv.str}, err
+			}}
+			continue
+		}
+		if left, err = p.arithmetic(op, left, right); err != nil {
+			return expr{}, err
+		}
+	}
+}
+
+func (p *exprParser) parseProduct() (expr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return expr{}, err
+	}
+	for {
+		op, ok := p.accept("*", "/", "%")
+		if !ok {
+			return left, nil
+		}
+		right, err := p.parseUnary()
+		if err != nil {
+			return expr{}, err
+		}
+		if left, err = p.arithmetic(op, left, right); err != nil {
+			return expr{}, err
+		}
+	}
+}
+
+func (p *exprParser) arithmetic(op exprToken, left, right expr) (expr, error) {
+	if err := p.operands(op, exprNumber, left, right); err != nil {
+		return expr{}, err
+	}
+	return expr{typ: exprNumber, eval: func(env *exprEnv) (exprValue, error) {
+		l, r, err := evalBoth(env, left, right)
+		if err != nil {
+			return exprValue{}, err
+		}
+		switch op.text {
+		case "+":
+			return exprValue{num: l.num + r.num}, nil
+		case "-":
+			return exprValue{num: l.num - r.num}, nil
+		case "*":
+			return exprValue{num: l.num * r.num}, nil
+		}
+		if r.num == 0 {
+			return exprValue{}, fmt.Errorf("column %d: division by zero", op.column)
+		}
+		if op.text == "/" {
+			return exprValue{num: l.num / r.num}, nil
+		}
+		return exprValue{num: math.Mod(l.num, r.num)}, nil
+	}}, nil
+}
+
+func (p *exprParser) parseUnary() (expr, error) {
+	op, ok := p.accept("-")
+	if !ok {
+		return p.parsePrimary()
+	}
+	operand, err := p.parseUnary()
+	if err != nil {
+		return expr{}, err
+	}
+	if err := p.operand(op, operand, exprNumber); err != nil {
+		return expr{}, err
+	}
+	return expr{typ: exprNumber, eval: func(env *exprEnv) (exprValue, error) {
+		if err := env.step(); err != nil {
+			return exprValue{}, err
+		}
+		v, err := operand.eval(env)
+		return exprValue{num: -v.num}, err
+	}}, nil
+}
+
+func (p *exprParser) parsePrimary() (expr, error) {
+	token := p.next()
+	switch {
+	case token.kind == tokenNumber:
+		return constant(exprNumber, exprValue{num: token.num}), nil
+	case token.kind == tokenString:
+		return constant(exprString, exprValue{str: token.text}), nil
+	case token.kind == tokenIdent && (token.text == "true" || token.text == "false"):
+		return constant(exprBool, exprValue{bool: token.text == "true"}), nil
+	case token.kind == tokenOperator && token.text == "(":
+		inner, err := p.parseOr()
+		if err != nil {
+			return expr{}, err
+		}
+		if _, ok := p.accept(")"); !ok {
+			return expr{}, p.errorAt(p.peek(), "expected ')' to close the '(' at column %d, got %s", token.column, p.peek())
+		}
+		return inner, nil
+	case token.kind == tokenIdent:
+		if _, ok := p.accept("("); ok {
+			return p.parseCall(token)
+		}
+		variable, ok := exprVariables[token.text]
+		if !ok {
+			return expr{}, p.errorAt(token, "unknown variable %s", token.text)
+		}
+		return variable, nil
+	default:
+		return expr{}, p.errorAt(token, "unexpected %s", token)
+	}
+}
+
+func constant(typ exprType, value exprValue) expr {
+	return expr{typ: typ, eval: func(*exprEnv) (exprValue, error) {
+		return value, nil
+	}}
+}
+
+// parseCall parses the arguments of a function call, the opening parenthesis is already consumed
+func (p *exprParser) parseCall(name exprToken) (expr, error) {
+	var args []expr
+	if _, ok := p.accept(")"); !ok {
+		for {
+			arg, err := p.parseOr()
+			if err != nil {
+				return expr{}, err
+			}
+			args = append(args, arg)
+			if _, ok := p.accept(","); ok {
+				continue
+			}
+			if _, ok := p.accept(")"); ok {
+				break
+			}
+			return expr{}, p.errorAt(p.peek(), "expected ',' or ')' in the arguments of %s, got %s", name.text, p.peek())
+		}
+	}
+
+	if name.text == "if" {
+		return p.conditional(name, args)
+	}
+	function, ok := exprFunctions[name.text]
+	if !ok {
+		return expr{}, p.errorAt(name, "unknown function %s", name.text)
+	}
+
+	if len(args) < len(function.params) || (!function.variadic && len(args) > len(function.params)) {
+		return expr{}, p.errorAt(name, "%s takes %d arguments, got %d", name.text, len(function.params), len(args))
+	}
+	for i, arg := range args {
+		want := function.params[min(i, len(function.params)-1)]
+		if arg.typ != want {
+			return expr{}, p.errorAt(name, "argument %d of %s must be a %s, got a %s", i+1, name.text, want, arg.typ)
+		}
+	}
+
+	return expr{typ: function.result, eval: func(env *exprEnv) (exprValue, error) {
+		if err := env.step(); err != nil {
+			return exprValue{}, err
+		}
+		values := make([]exprValue, len(args))
+		for i, arg := range args {
+			value, err := arg.eval(env)
+			if err != nil {
+				return exprValue{}, err
+			}
+			values[i] = value
+		}
+		return function.call(env, values)
+	}}, nil
+}
+
+// conditional is if(condition, then, else), only the branch taken is evaluated
+func (p *exprParser) conditional(name exprToken, args []expr) (expr, error) {
+	if len(args) != 3 {
+		return expr{}, p.errorAt(name, "if takes 3 arguments, got %d", len(args))
+	}
+	condition, then, otherwise := args[0], args[1], args[2]
+	if condition.typ != exprBool {
+		return expr{}, p.errorAt(name, "the condition of if must be a boolean, got a %s", condition.typ)
+	}
+	if then.typ != otherwise.typ {
+		return expr{}, p.errorAt(name, "the branches of if must have the same type, got a %s and a %s", then.typ, otherwise.typ)
+	}
+
+	return expr{typ: then.typ, eval: func(env *exprEnv) (exprValue, error) {
+		if err := env.step(); err != nil {
+			return exprValue{}, err
+		}
+		c, err := condition.eval(env)
+		if err != nil {
+			return exprValue{}, err
+		}
+		if c.bool {
+			return then.eval(env)
+		}
+		return otherwise.eval(env)
+	}}, nil
+}
+
+// compileExpressionRule compiles the condition and points of an expression rule. A rule whose expression
+// fails on a receipt, by exceeding the limits or dividing by zero, awards it no points
+func compileExpressionRule(rule models.Rule) (pointRule, error) {
+	if rule.Points < 0 {
+		return pointRule{}, fmt.Errorf("points must not be negative")
+	}
+	if rule.Expression == "" && rule.Points == 0 {
+		return pointRule{}, fmt.Errorf("points or an expression are required")
+	}
+
+	var when, amount *expr
+	if rule.When != "" {
+		compiled, err := compileExpr(rule.When, exprBool)
+		if err != nil {
+			return pointRule{}, fmt.Errorf("when: %v", err)
+		}
+		when = &compiled
+	}
+	if rule.Expression != "" {
+		compiled, err := compileExpr(rule.Expression, exprNumber)
+		if err != nil {
+			return pointRule{}, fmt.Errorf("expression: %v", err)
+		}
+		amount = &compiled
+	}
+
+	points := func(receipt *models.Receipt) (int64, error) {
+		env, err := newExprEnv(receipt)
+		if err != nil {
+			return 0, err
+		}
+		if when != nil {
+			matched, err := when.eval(env)
+			if err != nil || !matched.bool {
+				return 0, err
+			}
+		}
+		if amount == nil {
+			return rule.Points, nil
+		}
+		value, err := amount.eval(env)
+		if err != nil {
+			return 0, err
+		}
+		// checked before converting, a float beyond the range of int64 converts to a negative number
+		if math.IsNaN(value.num) || value.num < 0 || value.num > maxRulePoints {
+			return 0, fmt.Errorf("expression evaluated to %v, points must be between 0 and %d", value.num, maxRulePoints)
+		}
+		return int64(math.Round(value.num)), nil
+	}
+
+	return pointRule{
+		name: rule.Name,
+		calc: func(receipt *models.Receipt) int64 {
+			awarded, err := points(receipt)
+			if err != nil {
+				slog.Warn("Expression rule failed, awarding no points", slog.String("rule", rule.Name), u.ErrLog(err))
+				return 0
+			}
+			return awarded
+		},
+		check: func(receipt *models.Receipt) error {
+			_, err := points(receipt)
+			return err
+		},
+	}, nil
+}
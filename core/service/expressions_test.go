@@ -0,0 +1,177 @@
+package service
+
+import (
+	"errors"
+	"github.com/RA341/receipt-processor-challenge/models"
+	"strings"
+	"testing"
+)
+
+// evalExpr evaluates a compiled expression on the receipt within the step limit, like a rule does
+func evalExpr(compiled expr, receipt *models.Receipt) (exprValue, error) {
+	env, err := newExprEnv(receipt)
+	if err != nil {
+		return exprValue{}, err
+	}
+	return compiled.eval(env)
+}
+
+func TestCompileExpr_Eval(t *testing.T) {
+	// M&M Corner Market, Sunday 2022-03-20 14:33, 4 Gatorades at 2.25 for 9.00
+	receipt := testMap["test 2"].receipt
+
+	numbers := map[string]float64{
+		"floor(total / 2)":                 4,
+		"itemCount * 2 + -1":               7,
+		"(1 + 2) * 3 % 4":                  1,
+		"day + month + year":               20 + 3 + 2022,
+		"hour * 60 + minute":               14*60 + 33,
+		"countItems('gatorade')":           4,
+		"itemTotal('ADE')":                 9,
+		"max(1, total, 3) - min(5, 2)":     7,
+		"len(retailer)":                    17,
+		"if(weekday == 'Sunday', 10, 1/0)": 10,
+	}
+	for source, expected := range numbers {
+		compiled, err := compileExpr(source, exprNumber)
+		if err != nil {
+			t.Fatalf("Failed to compile %s: %v", source, err)
+		}
+		value, err := evalExpr(compiled, &receipt)
+		if err != nil || value.num != expected {
+			t.Fatalf("Expected %s to be %v, got %v (%v)", source, expected, value.num, err)
+		}
+	}
+
+	conditions := map[string]bool{
+		"retailer contains 'Market' and weekday == 'Sunday'":  true,
+		"retailer contains 'market'":                          false,
+		"contains(lower(retailer), 'market')":                 true,
+		"not anyItem('water') && total >= 9":                  true,
+		"time > '14:00' and time < '16:00'":                   true,
+		"date == '2022-03-21' || startsWith(retailer, 'M&M')": true,
+		"!(itemCount != 4)":                                   true,
+		"true or 1 / 0 > 1":                                   true,
+		"false and 1 / 0 > 1":                                 false,
+	}
+	for source, expected := range conditions {
+		compiled, err := compileExpr(source, exprBool)
+		if err != nil {
+			t.Fatalf("Failed to compile %s: %v", source, err)
+		}
+		value, err := evalExpr(compiled, &receipt)
+		if err != nil || value.bool != expected {
+			t.Fatalf("Expected %s to be %v, got %v (%v)", source, expected, value.bool, err)
+		}
+	}
+
+	compiled, _ := compileExpr("total / (itemCount - 4)", exprNumber)
+	if _, err := evalExpr(compiled, &receipt); err == nil || !strings.Contains(err.Error(), "division by zero") {
+		t.Fatalf("Expected dividing by zero to fail, got %v", err)
+	}
+}
+
+func TestCompileExpr_Errors(t *testing.T) {
+	invalid := map[string]struct {
+		source  string
+		column  int
+		message string
+	}{
+		"unknown variable":   {source: "totl > 5", column: 1, message: "unknown variable totl"},
+		"unknown function":   {source: "total > sqrt(4)", column: 9, message: "unknown function sqrt"},
+		"type mismatch":      {source: "retailer > 5", column: 10, message: "'>' needs two numbers or two strings"},
+		"non-boolean and":    {source: "total and true", column: 7, message: "'and' needs boolean operands, got a number"},
+		"wrong result":       {source: "total + 1", column: 1, message: "expression is a number, it must be a boolean"},
+		"unclosed paren":     {source: "(total > 5", column: 11, message: "expected ')' to close the '(' at column 1"},
+		"unclosed string":    {source: "retailer == 'Target", column: 13, message: "missing its closing quote"},
+		"bad character":      {source: "total > 5 ; true", column: 11, message: "unexpected character ';'"},
+		"trailing tokens":    {source: "total > 5 5", column: 11, message: "unexpected '5'"},
+		"argument count":     {source: "floor(total, 2) > 1", column: 1, message: "floor takes 1 arguments, got 2"},
+		"argument type":      {source: "contains(retailer, 5)", column: 1, message: "argument 2 of contains must be a string"},
+		"mismatched if":      {source: "if(true, 1, 'a') > 0", column: 1, message: "branches of if must have the same type"},
+		"missing operand":    {source: "total >", column: 8, message: "unexpected end of expression"},
+		"missing separators": {source: "max(1 2) > 0", column: 7, message: "expected ',' or ')' in the arguments of max"},
+	}
+	for name, test := range invalid {
+		_, err := compileExpr(test.source, exprBool)
+		var exprErr *ExprError
+		if !errors.As(err, &exprErr) || exprErr.Column != test.column || !strings.Contains(exprErr.Message, test.message) {
+			t.Fatalf("Expected %s to fail at column %d with %q, got %v", name, test.column, test.message, err)
+		}
+	}
+
+	if _, err := compileExpr(strings.Repeat("(", 100)+"true"+strings.Repeat(")", 100), exprBool); err == nil {
+		t.Fatalf("Expected a deeply nested expression to fail")
+	}
+	if _, err := compileExpr(strings.Repeat("1 + ", exprMaxLength)+"1 > 0", exprBool); err == nil {
+		t.Fatalf("Expected a long expression to fail")
+	}
+}
+
+func TestCompileExpr_StepLimit(t *testing.T) {
+	receipt := testMap["test 2"].receipt
+	receipt.Items = make([]models.Item, exprMaxSteps)
+	for i := range receipt.Items {
+		receipt.Items[i] = models.Item{ShortDescription: "Gatorade", Price: "2.25"}
+	}
+
+	compiled, err := compileExpr("countItems('gatorade')", exprNumber)
+	if err != nil {
+		t.Fatalf("Failed to compile expression: %v", err)
+	}
+
+	env, err := newExprEnv(&receipt)
+	if err != nil {
+		t.Fatalf("Failed to build environment: %v", err)
+	}
+	if _, err := compiled.eval(env); !errors.Is(err, errExprSteps) {
+		t.Fatalf("Expected the step limit to stop the evaluation, got %v", err)
+	}
+}
+
+func TestCompileRules_Expression(t *testing.T) {
+	set := models.RuleSet{Rules: []models.Rule{
+		{Name: "sundayMarket", Type: RuleExpression, When: "retailer contains 'Market' and weekday == 'Sunday'", Expression: "floor(total / 2)"},
+		{Name: "gatoradeFan", Type: RuleExpression, When: "countItems('gatorade') >= 4", Points: 15},
+		{Name: "perItem", Type: RuleExpression, Expression: "itemCount * 0.6"},
+	}}
+	rules, err := CompileRules(set)
+	if err != nil {
+		t.Fatalf("Failed to compile rules: %v", err)
+	}
+
+	// 4 for half the 9.00 total, 15 for the four gatorades and 2 for 4 items at 0.6, rounded
+	receipt := testMap["test 2"].receipt
+	if points := rules.Points(receipt); points != 4+15+2 {
+		t.Fatalf("Expected 21 points, got %d: %+v", points, rules.Breakdown(receipt))
+	}
+	if points := rules.Points(testMap["test 1"].receipt); points != 3 { // Target, 5 items
+		t.Fatalf("Expected 3 points for Target, got %d: %+v", points, rules.Breakdown(testMap["test 1"].receipt))
+	}
+
+	invalid := map[string]models.Rule{
+		"no points":         {Type: RuleExpression, When: "total > 5"},
+		"negative points":   {Type: RuleExpression, Points: -5},
+		"boolean points":    {Type: RuleExpression, Expression: "total > 5"},
+		"numeric condition": {Type: RuleExpression, When: "total", Points: 5},
+	}
+	for name, rule := range invalid {
+		if _, err := CompileRules(models.RuleSet{Rules: []models.Rule{rule}}); err == nil {
+			t.Fatalf("Expected %s to fail", name)
+		}
+	}
+
+	// a negative or huge result fails the validation of rule changes and scores nothing
+	for _, expression := range []string{"5 - itemCount * 2", "total * 1" + strings.Repeat("0", 30), "total * 1000000000"} {
+		failing, err := CompileRules(models.RuleSet{Rules: []models.Rule{{Type: RuleExpression, Expression: expression}}})
+		if err != nil {
+			t.Fatalf("Failed to compile rules: %v", err)
+		}
+		if err := failing.check(receipt); err == nil {
+			t.Fatalf("Expected %s to fail the check", expression)
+		}
+		if points := failing.Points(receipt); points != 0 {
+			t.Fatalf("Expected %s to award nothing, got %d", expression, points)
+		}
+	}
+}
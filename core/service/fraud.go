@@ -0,0 +1,186 @@
+package service
+
+import (
+	"fmt"
+	"github.com/RA341/receipt-processor-challenge/config"
+	"github.com/RA341/receipt-processor-challenge/models"
+	"math"
+	"slices"
+	"sync"
+	"time"
+)
+
+// FraudChecker scores submissions for fraud, it remembers recent submissions for the velocity checks
+type FraudChecker struct {
+	mu            sync.Mutex
+	cfg           config.FraudConfig
+	maxTotalCents int64
+	// submissions holds the recent submissions per velocity key, oldest first
+	submissions map[string][]submission
+	now         func() time.Time
+}
+
+type submission struct {
+	receiptId string
+	at        time.Time
+}
+
+func NewFraudChecker(cfg config.FraudConfig) *FraudChecker {
+	maxTotal, err := parseCents(cfg.MaxTotal)
+	if err != nil {
+		maxTotal = 0 // the check is off without a valid max total
+	}
+
+	return &FraudChecker{
+		cfg:           cfg,
+		maxTotalCents: maxTotal,
+		submissions:   map[string][]submission{},
+		now:           time.Now,
+	}
+}
+
+// Assess records the submission and scores the receipt, retailerKey identifies
+// the retailer for the velocity checks and should be the same for every spelling.
+// Assessing the same receipt id again, when processing is retried, doesn't count it twice
+func (fc *FraudChecker) Assess(receiptId string, submitter Submitter, receipt *models.Receipt, retailerKey string) models.RiskAssessment {
+	now := fc.now()
+
+	var reasons []models.RiskReason
+	reasons = append(reasons, fc.checkVelocity(receiptId, submitter, receipt, retailerKey, now)...)
+	reasons = append(reasons, fc.checkTotal(receipt)...)
+	reasons = append(reasons, fc.checkPurchaseDate(receipt, now)...)
+
+	return models.RiskAssessment{Score: combineRisk(reasons), Reasons: reasons}
+}
+
+// Hold reports whether the assessment is risky enough to hold the receipt for review
+func (fc *FraudChecker) Hold(risk models.RiskAssessment) bool {
+	return risk.Score >= fc.cfg.HoldThreshold
+}
+
+func (fc *FraudChecker) checkVelocity(receiptId string, submitter Submitter, receipt *models.Receipt, retailerKey string, now time.Time) []models.RiskReason {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	var reasons []models.RiskReason
+	for _, rule := range fc.cfg.Velocity {
+		var key string
+		switch rule.Key {
+		case "member":
+			if receipt.MemberId != "" {
+				key = "member:" + receipt.MemberId
+			}
+		case "memberRetailer":
+			if receipt.MemberId != "" {
+				key = "memberRetailer:" + receipt.MemberId + "|" + retailerKey
+			}
+		case "client":
+			if submitter.ClientId != "" {
+				key = "client:" + submitter.ClientId
+			}
+		}
+		if key == "" {
+			continue
+		}
+
+		// the same key can appear in several rules, keep enough history for the longest window
+		count := fc.record(key+"|"+rule.Window.String(), receiptId, now, rule.Window.Duration)
+		if count > rule.Max {
+			reasons = append(reasons, models.RiskReason{
+				Check:  "velocity",
+				Weight: rule.Weight,
+				Detail: fmt.Sprintf("%d receipts per %s in %s, limit is %d", count, rule.Key, rule.Window.Duration, rule.Max),
+			})
+		}
+	}
+
+	return reasons
+}
+
+// record adds a submission to the key unless the receipt was already recorded,
+// and returns how many fall within the window
+func (fc *FraudChecker) record(key, receiptId string, now time.Time, window time.Duration) int {
+	cutoff := now.Add(-window)
+	recent := fc.submissions[key]
+	start := 0
+	for start < len(recent) && !recent[start].at.After(cutoff) {
+		start++
+	}
+	recent = recent[start:]
+	if !slices.ContainsFunc(recent, func(s submission) bool { return s.receiptId == receiptId }) {
+		recent = append(recent, submission{receiptId: receiptId, at: now})
+	}
+	fc.submissions[key] = recent
+	return len(recent)
+}
+
+func (fc *FraudChecker) checkTotal(receipt *models.Receipt) []models.RiskReason {
+	total, err := parseCents(receipt.Total)
+	if err != nil {
+		return nil
+	}
+
+	var reasons []models.RiskReason
+	if fc.maxTotalCents > 0 && total > fc.maxTotalCents {
+		reasons = append(reasons, models.RiskReason{
+			Check:  "total",
+			Weight: fc.cfg.MaxTotalWeight,
+			Detail: fmt.Sprintf("total %s is above %s", receipt.Total, fc.cfg.MaxTotal),
+		})
+	}
+
+	var items int64 = 0
+	for _, item := range receipt.Items {
+		price, err := parseCents(item.Price)
+		if err != nil {
+			return reasons
+		}
+		items += price
+	}
+	if items > total || float64(total-items) > float64(items)*fc.cfg.ItemMismatchRatio {
+		reasons = append(reasons, models.RiskReason{
+			Check:  "itemTotal",
+			Weight: fc.cfg.ItemMismatchWeight,
+			Detail: fmt.Sprintf("items add up to %s but the total is %s", formatCents(items), receipt.Total),
+		})
+	}
+
+	return reasons
+}
+
+func (fc *FraudChecker) checkPurchaseDate(receipt *models.Receipt, now time.Time) []models.RiskReason {
+	purchasedAt, err := purchaseTimestamp(receipt)
+	if err != nil {
+		return nil
+	}
+
+	// the receipt has no time zone, compare it as if it was in the server's
+	purchasedAt = time.Date(purchasedAt.Year(), purchasedAt.Month(), purchasedAt.Day(),
+		purchasedAt.Hour(), purchasedAt.Minute(), 0, 0, now.Location())
+
+	switch {
+	case purchasedAt.After(now.Add(fc.cfg.FutureLeeway.Duration)):
+		return []models.RiskReason{{
+			Check:  "futureDate",
+			Weight: fc.cfg.FutureWeight,
+			Detail: fmt.Sprintf("purchased in the future at %s", purchasedAt.Format(dateTimeLayout)),
+		}}
+	case fc.cfg.MaxPurchaseAge.Duration > 0 && purchasedAt.Before(now.Add(-fc.cfg.MaxPurchaseAge.Duration)):
+		return []models.RiskReason{{
+			Check:  "staleDate",
+			Weight: fc.cfg.StaleWeight,
+			Detail: fmt.Sprintf("purchased more than %s ago", fc.cfg.MaxPurchaseAge.Duration),
+		}}
+	}
+	return nil
+}
+
+// combineRisk treats the weights as independent probabilities of fraud, so several weak
+// signals add up to a strong one but the score never exceeds 1
+func combineRisk(reasons []models.RiskReason) float64 {
+	clean := 1.0
+	for _, reason := range reasons {
+		clean *= 1 - reason.Weight
+	}
+	return math.Round((1-clean)*1000) / 1000
+}
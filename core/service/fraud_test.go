@@ -0,0 +1,125 @@
+package service
+
+import (
+	"fmt"
+	"github.com/RA341/receipt-processor-challenge/config"
+	"github.com/RA341/receipt-processor-challenge/models"
+	"testing"
+	"time"
+)
+
+func newTestFraudChecker(now time.Time) *FraudChecker {
+	cfg := config.Default().Fraud
+	cfg.Enabled = true
+	fc := NewFraudChecker(cfg)
+	fc.now = func() time.Time { return now }
+	return fc
+}
+
+func fraudReceipt(date string, total string, prices ...string) models.Receipt {
+	receipt := models.Receipt{
+		Retailer:     "Target",
+		PurchaseDate: date,
+		PurchaseTime: "13:01",
+		Total:        total,
+		MemberId:     "member-1",
+	}
+	for _, price := range prices {
+		receipt.Items = append(receipt.Items, models.Item{ShortDescription: "Item", Price: price})
+	}
+	return receipt
+}
+
+func TestFraudChecker_Checks(t *testing.T) {
+	now := time.Date(2024, 6, 15, 12, 0, 0, 0, time.Local)
+
+	cases := map[string]struct {
+		receipt models.Receipt
+		checks  []string
+		hold    bool
+	}{
+		"clean":         {receipt: fraudReceipt("2024-06-14", "10.80", "10.00"), checks: nil},
+		"future date":   {receipt: fraudReceipt("2024-06-20", "10.00", "10.00"), checks: []string{"futureDate"}, hold: true},
+		"stale date":    {receipt: fraudReceipt("2023-01-01", "10.00", "10.00"), checks: []string{"staleDate"}, hold: true},
+		"huge total":    {receipt: fraudReceipt("2024-06-14", "5000.00", "5000.00"), checks: []string{"total"}},
+		"items over":    {receipt: fraudReceipt("2024-06-14", "1.00", "10.00"), checks: []string{"itemTotal"}},
+		"combined risk": {receipt: fraudReceipt("2024-06-14", "5000.00", "10.00"), checks: []string{"total", "itemTotal"}, hold: true},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			fc := newTestFraudChecker(now)
+			risk := fc.Assess(name, Submitter{}, &tc.receipt, "target")
+
+			if len(risk.Reasons) != len(tc.checks) {
+				t.Fatalf("Expected checks %v but got %+v", tc.checks, risk.Reasons)
+			}
+			for i, check := range tc.checks {
+				if risk.Reasons[i].Check != check {
+					t.Fatalf("Expected checks %v but got %+v", tc.checks, risk.Reasons)
+				}
+			}
+			if fc.Hold(risk) != tc.hold {
+				t.Fatalf("Expected hold %v with score %v", tc.hold, risk.Score)
+			}
+		})
+	}
+}
+
+func TestFraudChecker_Velocity(t *testing.T) {
+	now := time.Date(2024, 6, 15, 12, 0, 0, 0, time.Local)
+	fc := newTestFraudChecker(now)
+	receipt := fraudReceipt("2024-06-14", "10.00", "10.00")
+
+	// the default allows 5 receipts per member and retailer an hour
+	for i := 0; i < 5; i++ {
+		if risk := fc.Assess(fmt.Sprintf("receipt-%d", i+1), Submitter{}, &receipt, "target"); risk.Score != 0 {
+			t.Fatalf("Submission %d: unexpected risk %+v", i+1, risk)
+		}
+	}
+
+	// a retried receipt is the same submission
+	if risk := fc.Assess("receipt-5", Submitter{}, &receipt, "target"); risk.Score != 0 {
+		t.Fatalf("Expected a retried receipt not to count twice, got %+v", risk)
+	}
+
+	risk := fc.Assess("receipt-6", Submitter{}, &receipt, "target")
+	if len(risk.Reasons) != 1 || risk.Reasons[0].Check != "velocity" || !fc.Hold(risk) {
+		t.Fatalf("Expected the 6th receipt to be held for velocity, got %+v", risk)
+	}
+
+	// other retailers and later windows are unaffected
+	if risk := fc.Assess("receipt-7", Submitter{}, &receipt, "walmart"); risk.Score != 0 {
+		t.Fatalf("Unexpected risk at another retailer %+v", risk)
+	}
+	fc.now = func() time.Time { return now.Add(2 * time.Hour) }
+	if risk := fc.Assess("receipt-8", Submitter{}, &receipt, "target"); risk.Score != 0 {
+		t.Fatalf("Unexpected risk in a new window %+v", risk)
+	}
+}
+
+func TestReceiptService_HoldsRiskyReceipts(t *testing.T) {
+	tiers := NewTierService(config.Default().Tiers)
+	db, _ := NewDB()
+	srv := NewReceiptService(db,
+		WithTiers(tiers),
+		WithFraudChecker(newTestFraudChecker(time.Now())),
+	)
+
+	future := time.Now().AddDate(0, 0, 7).Format(dateLayout)
+	id, err := srv.NewReceipt(fraudReceipt(future, "10.00", "10.00"))
+	if err != nil {
+		t.Fatalf("Failed to submit receipt: %v", err)
+	}
+
+	record, err := srv.GetReceiptById(id)
+	if err != nil {
+		t.Fatalf("Failed to get receipt: %v", err)
+	}
+	if record.Status != models.StatusPendingReview || record.Risk == nil || len(record.Risk.Reasons) == 0 {
+		t.Fatalf("Expected the receipt to be held with reasons, got %+v", record)
+	}
+	if _, err := tiers.Member("member-1"); err == nil {
+		t.Fatalf("Expected the held receipt not to credit the member")
+	}
+}
@@ -0,0 +1,530 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/RA341/receipt-processor-challenge/models"
+	"github.com/google/uuid"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+const defaultCheckpointEvery = 100
+
+// ErrInvalidImport is returned when the import can't be read at all, before any receipt is
+var ErrInvalidImport = errors.New("invalid import")
+
+// CSVMapping names the CSV columns the receipt fields are read from. Every row is an item, consecutive rows
+// with the same ReceiptId make up one receipt. Without a ReceiptId column every row is a receipt of its own
+type CSVMapping struct {
+	ReceiptId       string `json:"receiptId"`
+	Retailer        string `json:"retailer"`
+	PurchaseDate    string `json:"purchaseDate"`
+	PurchaseTime    string `json:"purchaseTime"`
+	Total           string `json:"total"`
+	MemberId        string `json:"memberId"`
+	ItemDescription string `json:"itemDescription"`
+	ItemPrice       string `json:"itemPrice"`
+	// DateLayout and TimeLayout are the Go layouts of the legacy date and time,
+	// they are converted to YYYY-MM-DD and HH:MM. Empty means they are already in that format
+	DateLayout string `json:"dateLayout"`
+	TimeLayout string `json:"timeLayout"`
+}
+
+// DefaultCSVMapping reads every field from the column named like its JSON field
+func DefaultCSVMapping() CSVMapping {
+	return CSVMapping{
+		ReceiptId:       "id",
+		Retailer:        "retailer",
+		PurchaseDate:    "purchaseDate",
+		PurchaseTime:    "purchaseTime",
+		Total:           "total",
+		MemberId:        "memberId",
+		ItemDescription: "shortDescription",
+		ItemPrice:       "price",
+	}
+}
+
+// LoadCSVMapping reads a mapping from a JSON file, columns it leaves out keep their default names
+func LoadCSVMapping(path string) (CSVMapping, error) {
+	mapping := DefaultCSVMapping()
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return mapping, err
+	}
+	if err := json.Unmarshal(contents, &mapping); err != nil {
+		return mapping, fmt.Errorf("invalid csv mapping %s: %v", path, err)
+	}
+	return mapping, nil
+}
+
+type ImportOptions struct {
+	// Format is FormatCSV or FormatNDJSON
+	Format  string
+	Mapping CSVMapping
+	// Source names the input, a checkpoint can only resume the source it was made for. Each receipt's id
+	// is derived from the source and its position in it, so importing the same source again stores nothing
+	// twice. Without a source the receipts get random ids
+	Source string
+	// Checkpoint is the file progress is saved to and resumed from, the import can't be resumed without one
+	Checkpoint string
+	// CheckpointEvery is how many receipts are imported between checkpoints, receipts imported
+	// after the last checkpoint are read again when resuming and found already stored
+	CheckpointEvery int
+	// RejectsFile receives a JSON line for every receipt that wasn't imported, and is left out if empty
+	RejectsFile string
+	// Rejects receives the lines instead when there is no RejectsFile, it can't be truncated to a checkpoint
+	Rejects io.Writer
+	// Rules score the receipts, the live rules and the experiment's do if nil
+	Rules *Rules
+}
+
+// ImportSummary counts what happened to the receipts of an import, including the ones of resumed runs
+type ImportSummary struct {
+	// Processed is how many receipts were read, a resumed import skips them
+	Processed int64 `json:"processed"`
+	Imported  int64 `json:"imported"`
+	// Held is how many of the imported receipts were held for review
+	Held     int64 `json:"held"`
+	Rejected int64 `json:"rejected"`
+	Points   int64 `json:"points"`
+}
+
+type importCheckpoint struct {
+	Source  string        `json:"source"`
+	Summary ImportSummary `json:"summary"`
+	// RejectsOffset is the size of the rejects file at the checkpoint, lines after it are from
+	// receipts that will be read again
+	RejectsOffset int64     `json:"rejectsOffset"`
+	SavedAt       time.Time `json:"savedAt"`
+}
+
+type importReject struct {
+	Record  int64           `json:"record"`
+	Line    int             `json:"line"`
+	Id      string          `json:"id,omitempty"`
+	Error   string          `json:"error"`
+	Receipt *models.Receipt `json:"receipt,omitempty"`
+	Raw     string          `json:"raw,omitempty"`
+}
+
+// importRecord is a receipt read from an import, or the reason it couldn't be read
+type importRecord struct {
+	line    int
+	receipt models.Receipt
+	raw     string
+	err     error
+}
+
+type importSource interface {
+	// next returns io.EOF after the last receipt
+	next() (importRecord, error)
+}
+
+// Import validates, scores and stores every receipt read from r, resuming from the checkpoint if there is one.
+// Receipts that fail validation or scoring go to the rejects file, errors reading r or storing receipts stop
+// the import. When ctx is cancelled, progress is checkpointed and ctx's error is returned. Fraud checks are
+// skipped, old purchase dates would flag every receipt as stale
+func (s *ReceiptService) Import(ctx context.Context, r io.Reader, opts ImportOptions) (ImportSummary, error) {
+	if opts.CheckpointEvery <= 0 {
+		opts.CheckpointEvery = defaultCheckpointEvery
+	}
+
+	var source importSource
+	switch opts.Format {
+	case FormatNDJSON:
+		source = &ndjsonImportSource{r: bufio.NewReader(r)}
+	case FormatCSV:
+		csvSource, err := newCSVImportSource(r, opts.Mapping)
+		if err != nil {
+			return ImportSummary{}, err
+		}
+		source = csvSource
+	default:
+		return ImportSummary{}, fmt.Errorf("%w: format must be %s or %s", ErrInvalidImport, FormatCSV, FormatNDJSON)
+	}
+
+	checkpoint, err := loadImportCheckpoint(opts)
+	if err != nil {
+		return ImportSummary{}, err
+	}
+	rejects, err := openImportRejects(opts, checkpoint.RejectsOffset)
+	if err != nil {
+		return checkpoint.Summary, err
+	}
+	defer rejects.Close()
+
+	summary := checkpoint.Summary
+	save := func() error {
+		offset, err := rejects.sync()
+		if err != nil {
+			return err
+		}
+		return saveImportCheckpoint(opts.Checkpoint, importCheckpoint{
+			Source:        opts.Source,
+			Summary:       summary,
+			RejectsOffset: offset,
+			SavedAt:       time.Now(),
+		})
+	}
+
+	var read int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return summary, errors.Join(err, save())
+		}
+
+		record, err := source.next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return summary, errors.Join(err, save())
+		}
+		read++
+		if read <= summary.Processed {
+			continue // imported before the import was interrupted
+		}
+
+		reject := importReject{Record: read, Line: record.line, Raw: record.raw}
+		if record.err == nil {
+			reject.Receipt = &record.receipt
+			record.err = ValidateReceipt(record.receipt)
+		}
+		if record.err == nil {
+			var stored models.ReceiptRecord
+			stored, record.err = s.importReceipt(importId(opts.Source, read), record.receipt, opts.Rules)
+			reject.Id = stored.Id
+			if record.err != nil && !errors.Is(record.err, ErrReceiptRejected) {
+				return summary, errors.Join(fmt.Errorf("unable to store receipt %d: %v", read, record.err), save())
+			}
+			if record.err == nil {
+				summary.Imported++
+				summary.Points += stored.Points
+				if stored.Status == models.StatusPendingReview {
+					summary.Held++
+				}
+			}
+		}
+		if record.err != nil {
+			summary.Rejected++
+			reject.Error = record.err.Error()
+			if err := rejects.write(reject); err != nil {
+				return summary, err
+			}
+		}
+
+		summary.Processed = read
+		if summary.Processed%int64(opts.CheckpointEvery) == 0 {
+			if err := save(); err != nil {
+				return summary, err
+			}
+		}
+	}
+
+	return summary, save()
+}
+
+// importNamespace seeds the ids of imported receipts
+var importNamespace = uuid.NewSHA1(uuid.NameSpaceURL, []byte("receipt-processor/import"))
+
+// importId is the id of the receipt at the record number of the source, empty for a random one
+func importId(source string, record int64) string {
+	if source == "" {
+		return ""
+	}
+	return uuid.NewSHA1(importNamespace, []byte(fmt.Sprintf("%s#%d", source, record))).String()
+}
+
+// importReceipt scores and stores the receipt the same way a submission is. A receipt already
+// stored under the id was imported by an earlier run and is returned as it was stored
+func (s *ReceiptService) importReceipt(id string, receipt models.Receipt, rules *Rules) (models.ReceiptRecord, error) {
+	if id != "" {
+		if stored, err := s.db.GetReceiptById(id); err == nil {
+			if stored.Status == models.StatusRejected {
+				return stored, &RejectedError{Id: id, Err: fmt.Errorf("%w: %v", ErrReceiptRejected, checkScorable(&receipt))}
+			}
+			return stored, nil
+		}
+	}
+
+	record := newReceiptRecord(Submitter{}, receipt)
+	if id != "" {
+		record.Id = id
+	}
+	return s.submitRecord(record, processOpts{rules: rules, imported: true})
+}
+
+func loadImportCheckpoint(opts ImportOptions) (importCheckpoint, error) {
+	if opts.Checkpoint == "" {
+		return importCheckpoint{}, nil
+	}
+
+	contents, err := os.ReadFile(opts.Checkpoint)
+	if errors.Is(err, os.ErrNotExist) {
+		return importCheckpoint{}, nil
+	}
+	if err != nil {
+		return importCheckpoint{}, err
+	}
+
+	var checkpoint importCheckpoint
+	if err := json.Unmarshal(contents, &checkpoint); err != nil {
+		return importCheckpoint{}, fmt.Errorf("invalid checkpoint %s: %v", opts.Checkpoint, err)
+	}
+	if checkpoint.Source != opts.Source {
+		return importCheckpoint{}, fmt.Errorf("checkpoint %s belongs to the import of %s", opts.Checkpoint, checkpoint.Source)
+	}
+	return checkpoint, nil
+}
+
+// saveImportCheckpoint replaces the checkpoint file atomically, so an interruption never leaves half of one
+func saveImportCheckpoint(path string, checkpoint importCheckpoint) error {
+	if path == "" {
+		return nil
+	}
+	contents, err := json.MarshalIndent(checkpoint, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path+".tmp", contents, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// importRejects appends to the rejects file or writer, buffered until the next checkpoint
+type importRejects struct {
+	file *os.File
+	w    *bufio.Writer
+}
+
+// openImportRejects opens the rejects file, dropping what was written after the checkpoint
+func openImportRejects(opts ImportOptions, offset int64) (*importRejects, error) {
+	path := opts.RejectsFile
+	if path == "" {
+		if opts.Rejects == nil {
+			return &importRejects{}, nil
+		}
+		return &importRejects{w: bufio.NewWriter(opts.Rejects)}, nil
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	if err := file.Truncate(offset); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &importRejects{file: file, w: bufio.NewWriter(file)}, nil
+}
+
+func (r *importRejects) write(reject importReject) error {
+	if r.w == nil {
+		return nil
+	}
+	line, err := json.Marshal(reject)
+	if err != nil {
+		return err
+	}
+	_, err = r.w.Write(append(line, '\n'))
+	return err
+}
+
+// sync writes out the buffered rejects and returns the file size
+func (r *importRejects) sync() (int64, error) {
+	if r.w == nil {
+		return 0, nil
+	}
+	if err := r.w.Flush(); err != nil {
+		return 0, err
+	}
+	if r.file == nil {
+		return 0, nil
+	}
+	if err := r.file.Sync(); err != nil {
+		return 0, err
+	}
+	return r.file.Seek(0, io.SeekCurrent)
+}
+
+func (r *importRejects) Close() error {
+	if r.file == nil {
+		return nil
+	}
+	return r.file.Close()
+}
+
+// ndjsonImportSource reads a models.Receipt per line, blank lines are skipped
+type ndjsonImportSource struct {
+	r    *bufio.Reader
+	line int
+}
+
+func (n *ndjsonImportSource) next() (importRecord, error) {
+	for {
+		line, err := n.r.ReadBytes('\n')
+		if len(line) == 0 && err != nil {
+			return importRecord{}, err
+		}
+		if err != nil && !errors.Is(err, io.EOF) {
+			return importRecord{}, err
+		}
+		n.line++
+
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		record := importRecord{line: n.line}
+		if err := json.Unmarshal(line, &record.receipt); err != nil {
+			record.raw = string(line)
+			record.err = fmt.Errorf("invalid receipt json: %v", err)
+		}
+		return record, nil
+	}
+}
+
+// csvImportSource groups the item rows of a CSV into receipts
+type csvImportSource struct {
+	r       *csv.Reader
+	mapping CSVMapping
+	columns map[string]int
+	// pending is the first row of the next receipt or the error reading it,
+	// found while looking for the end of the previous receipt
+	pending     []string
+	pendingLine int
+	pendingErr  error
+}
+
+func newCSVImportSource(r io.Reader, mapping CSVMapping) (*csvImportSource, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("%w: unable to read the csv header: %v", ErrInvalidImport, err)
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.TrimSpace(name)] = i
+	}
+
+	required := map[string]string{
+		"retailer":        mapping.Retailer,
+		"purchaseDate":    mapping.PurchaseDate,
+		"purchaseTime":    mapping.PurchaseTime,
+		"total":           mapping.Total,
+		"itemDescription": mapping.ItemDescription,
+		"itemPrice":       mapping.ItemPrice,
+	}
+	for field, column := range required {
+		if _, ok := columns[column]; !ok {
+			return nil, fmt.Errorf("%w: csv has no column %q for %s", ErrInvalidImport, column, field)
+		}
+	}
+	// the optional columns are ignored when they're missing
+	for _, column := range []*string{&mapping.ReceiptId, &mapping.MemberId} {
+		if _, ok := columns[*column]; !ok {
+			*column = ""
+		}
+	}
+
+	return &csvImportSource{r: reader, mapping: mapping, columns: columns}, nil
+}
+
+// read returns the next row and the line it starts on
+func (c *csvImportSource) read() ([]string, int, error) {
+	if c.pending != nil || c.pendingErr != nil {
+		row, line, err := c.pending, c.pendingLine, c.pendingErr
+		c.pending, c.pendingErr = nil, nil
+		return row, line, err
+	}
+	row, err := c.r.Read()
+	if err != nil {
+		return nil, 0, err
+	}
+	line, _ := c.r.FieldPos(0)
+	return row, line, nil
+}
+
+func (c *csvImportSource) field(row []string, column string) string {
+	i, ok := c.columns[column]
+	if column == "" || !ok || i >= len(row) {
+		return ""
+	}
+	return strings.TrimSpace(row[i])
+}
+
+func (c *csvImportSource) next() (importRecord, error) {
+	first, line, err := c.read()
+	if err != nil {
+		// a malformed row only rejects its receipt, the reader carries on after it
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			return importRecord{line: parseErr.StartLine, err: err}, nil
+		}
+		return importRecord{}, err
+	}
+
+	record := importRecord{line: line}
+	receipt := &record.receipt
+	receipt.Retailer = c.field(first, c.mapping.Retailer)
+	receipt.PurchaseDate = c.field(first, c.mapping.PurchaseDate)
+	receipt.PurchaseTime = c.field(first, c.mapping.PurchaseTime)
+	receipt.Total = c.field(first, c.mapping.Total)
+	receipt.MemberId = c.field(first, c.mapping.MemberId)
+
+	id := c.field(first, c.mapping.ReceiptId)
+	for row := first; row != nil; {
+		receipt.Items = append(receipt.Items, models.Item{
+			ShortDescription: c.field(row, c.mapping.ItemDescription),
+			Price:            c.field(row, c.mapping.ItemPrice),
+		})
+		if id == "" {
+			break
+		}
+
+		row, line, err = c.read()
+		if err != nil || c.field(row, c.mapping.ReceiptId) != id {
+			// the row belongs to the next receipt, io.EOF is found again on the next read
+			if !errors.Is(err, io.EOF) {
+				c.pending, c.pendingLine, c.pendingErr = row, line, err
+			}
+			break
+		}
+	}
+
+	record.err = c.convertLayouts(receipt)
+	return record, nil
+}
+
+// convertLayouts rewrites the legacy date and time in the layouts the API expects
+func (c *csvImportSource) convertLayouts(receipt *models.Receipt) error {
+	if c.mapping.DateLayout != "" {
+		date, err := time.Parse(c.mapping.DateLayout, receipt.PurchaseDate)
+		if err != nil {
+			return fmt.Errorf("purchase date %q doesn't match %q", receipt.PurchaseDate, c.mapping.DateLayout)
+		}
+		receipt.PurchaseDate = date.Format(dateLayout)
+	}
+	if c.mapping.TimeLayout != "" {
+		purchaseTime, err := time.Parse(c.mapping.TimeLayout, receipt.PurchaseTime)
+		if err != nil {
+			return fmt.Errorf("purchase time %q doesn't match %q", receipt.PurchaseTime, c.mapping.TimeLayout)
+		}
+		receipt.PurchaseTime = purchaseTime.Format(timeLayout)
+	}
+	return nil
+}
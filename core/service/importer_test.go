@@ -0,0 +1,238 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/RA341/receipt-processor-challenge/models"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReceiptService_Import_NDJSON(t *testing.T) {
+	db, _ := NewDB()
+	srv := NewReceiptService(db)
+	dir := t.TempDir()
+
+	var lines []string
+	for _, name := range []string{"test 1", "test 2"} {
+		line, _ := json.Marshal(testMap[name].receipt)
+		lines = append(lines, string(line), "")
+	}
+	invalid := testMap["test 2"].receipt
+	invalid.Total = "9"
+	line, _ := json.Marshal(invalid)
+	lines = append(lines, "{not json", string(line))
+
+	opts := ImportOptions{
+		Format:      FormatNDJSON,
+		Source:      "receipts.ndjson",
+		Checkpoint:  filepath.Join(dir, "checkpoint"),
+		RejectsFile: filepath.Join(dir, "rejects"),
+	}
+	summary, err := srv.Import(context.Background(), strings.NewReader(strings.Join(lines, "\n")), opts)
+	if err != nil {
+		t.Fatalf("Failed to import receipts: %v", err)
+	}
+	expected := ImportSummary{Processed: 4, Imported: 2, Rejected: 2, Points: 28 + 109}
+	if summary != expected {
+		t.Fatalf("Expected summary %+v, got %+v", expected, summary)
+	}
+
+	rejects, _ := os.ReadFile(opts.RejectsFile)
+	rejectLines := strings.Split(strings.TrimSpace(string(rejects)), "\n")
+	var reject importReject
+	if err := json.Unmarshal([]byte(rejectLines[0]), &reject); err != nil || len(rejectLines) != 2 ||
+		reject.Line != 5 || reject.Raw != "{not json" {
+		t.Fatalf("Unexpected rejects %s", rejects)
+	}
+
+	// the checkpoint marks everything as done, so importing again is a no-op
+	again, err := srv.Import(context.Background(), strings.NewReader(strings.Join(lines, "\n")), opts)
+	if err != nil || again != expected {
+		t.Fatalf("Expected the finished import to be skipped, got %+v (%v)", again, err)
+	}
+	if page, _ := db.ListReceipts(ReceiptQuery{}); len(page.Receipts) != 2 {
+		t.Fatalf("Expected 2 stored receipts, got %d", len(page.Receipts))
+	}
+
+	opts.Source = "other.ndjson"
+	if _, err := srv.Import(context.Background(), strings.NewReader(""), opts); err == nil {
+		t.Fatalf("Expected resuming another file's checkpoint to fail")
+	}
+}
+
+func TestReceiptService_Import_CSV(t *testing.T) {
+	db, _ := NewDB()
+	srv := NewReceiptService(db)
+
+	mapping := DefaultCSVMapping()
+	mapping.ReceiptId = "txn"
+	mapping.Retailer = "store"
+	mapping.PurchaseDate = "date"
+	mapping.DateLayout = "01/02/2006"
+	mapping.MemberId = "loyalty"
+
+	csvFile := `txn,store,date,purchaseTime,total,shortDescription,price
+1,Target,01/01/2022,13:01,35.35,Mountain Dew 12PK,6.49
+1,Target,01/01/2022,13:01,35.35,Emils Cheese Pizza,12.25
+1,Target,01/01/2022,13:01,35.35,Knorr Creamy Chicken,1.26
+1,Target,01/01/2022,13:01,35.35,Doritos Nacho Cheese,3.35
+1,Target,01/01/2022,13:01,35.35,   Klarbrunn 12-PK 12 FL OZ  ,12.00
+2,M&M Corner Market,03/20/2022,14:33,9.00,Gatorade,2.25
+2,M&M Corner Market,03/20/2022,14:33,9.00,Gatorade,2.25
+2,M&M Corner Market,03/20/2022,14:33,9.00,Gatorade,2.25
+2,M&M Corner Market,03/20/2022,14:33,9.00,Gatorade,2.25
+3,Walgreens,2022-01-02,08:13,2.65,Pepsi,2.65
+`
+	summary, err := srv.Import(context.Background(), strings.NewReader(csvFile), ImportOptions{Format: FormatCSV, Mapping: mapping})
+	if err != nil {
+		t.Fatalf("Failed to import receipts: %v", err)
+	}
+	// the Walgreens receipt is dated in the wrong layout
+	expected := ImportSummary{Processed: 3, Imported: 2, Rejected: 1, Points: 28 + 109}
+	if summary != expected {
+		t.Fatalf("Expected summary %+v, got %+v", expected, summary)
+	}
+
+	mapping.Total = "amount"
+	if _, err := srv.Import(context.Background(), strings.NewReader(csvFile), ImportOptions{Format: FormatCSV, Mapping: mapping}); err == nil {
+		t.Fatalf("Expected a missing column to fail the import")
+	}
+}
+
+// cancelAfterDB cancels the import once it stored enough receipts
+type cancelAfterDB struct {
+	*FranklyWeHaveNoIdeaWhereYourDataIsDB
+	after  int
+	cancel context.CancelFunc
+}
+
+func (c *cancelAfterDB) CreateReceipt(record models.ReceiptRecord) (string, error) {
+	c.after--
+	if c.after == 0 {
+		c.cancel()
+	}
+	return c.FranklyWeHaveNoIdeaWhereYourDataIsDB.CreateReceipt(record)
+}
+
+func TestReceiptService_Import_Resume(t *testing.T) {
+	inner, _ := NewDB()
+	ctx, cancel := context.WithCancel(context.Background())
+	db := &cancelAfterDB{FranklyWeHaveNoIdeaWhereYourDataIsDB: inner, after: 3, cancel: cancel}
+	srv := NewReceiptService(db)
+	dir := t.TempDir()
+
+	var lines []string
+	for i := 0; i < 10; i++ {
+		receipt := testMap["test 1"].receipt
+		if i%3 == 0 {
+			receipt.Total = "35"
+		}
+		line, _ := json.Marshal(receipt)
+		lines = append(lines, string(line))
+	}
+	input := strings.Join(lines, "\n")
+
+	opts := ImportOptions{
+		Format:          FormatNDJSON,
+		Source:          "receipts.ndjson",
+		Checkpoint:      filepath.Join(dir, "checkpoint"),
+		CheckpointEvery: 4,
+		RejectsFile:     filepath.Join(dir, "rejects"),
+	}
+	summary, err := srv.Import(ctx, strings.NewReader(input), opts)
+	if !errors.Is(err, context.Canceled) || summary.Processed != 5 {
+		t.Fatalf("Expected the import to stop after 5 receipts, got %+v (%v)", summary, err)
+	}
+
+	summary, err = srv.Import(context.Background(), strings.NewReader(input), opts)
+	if err != nil {
+		t.Fatalf("Failed to resume import: %v", err)
+	}
+	expected := ImportSummary{Processed: 10, Imported: 6, Rejected: 4, Points: 6 * 28}
+	if summary != expected {
+		t.Fatalf("Expected summary %+v, got %+v", expected, summary)
+	}
+	// the receipts read again after the checkpoint are found under their ids instead of stored twice
+	if page, _ := inner.ListReceipts(ReceiptQuery{Status: models.StatusCredited}); len(page.Receipts) != 6 {
+		t.Fatalf("Expected every receipt to be imported once, got %d", len(page.Receipts))
+	}
+	if rejects, _ := os.ReadFile(opts.RejectsFile); strings.Count(string(rejects), "\n") != 4 {
+		t.Fatalf("Expected 4 rejects, got %s", rejects)
+	}
+}
+
+func TestReceiptService_Import_RuleVersion(t *testing.T) {
+	db, _ := NewDB()
+	srv := NewReceiptService(db)
+	bonus := models.Rule{Name: "bigBasket", Type: RuleItemPairs, Points: 100, Every: 4}
+	if _, err := srv.EditRules(RuleEdit{Action: models.RuleCreate, Rule: bonus, Actor: "client:ops"}); err != nil {
+		t.Fatalf("Failed to create rule: %v", err)
+	}
+	line, _ := json.Marshal(testMap["test 2"].receipt) // M&M Corner Market, 109 points
+
+	original, err := srv.RuleVersion(0)
+	if err != nil {
+		t.Fatalf("Failed to get rule version 0: %v", err)
+	}
+	summary, err := srv.Import(context.Background(), strings.NewReader(string(line)), ImportOptions{Format: FormatNDJSON, Source: "v0", Rules: original})
+	if err != nil || summary.Points != 109 {
+		t.Fatalf("Expected the original rules to score 109 points, got %+v (%v)", summary, err)
+	}
+	summary, err = srv.Import(context.Background(), strings.NewReader(string(line)), ImportOptions{Format: FormatNDJSON, Source: "live"})
+	if err != nil || summary.Points != 209 {
+		t.Fatalf("Expected the live rules to score 209 points, got %+v (%v)", summary, err)
+	}
+
+	if _, err := srv.RuleVersion(5); !errors.Is(err, ErrRuleVersion) {
+		t.Fatalf("Expected an unknown version to fail with ErrRuleVersion, got %v", err)
+	}
+}
+
+func TestReceiptService_Import_Tiers(t *testing.T) {
+	db, _ := NewDB()
+	srv := NewReceiptService(db)
+	srv.tiers.Credit("member-1", 1000) // Silver
+
+	// 50 receipts of 109 points from 2022 would be enough for Gold if they counted as earned today
+	var lines []string
+	for i := 0; i < 50; i++ {
+		receipt := testMap["test 2"].receipt
+		receipt.MemberId = "member-1"
+		line, _ := json.Marshal(receipt)
+		lines = append(lines, string(line))
+	}
+	recent := testMap["test 2"].receipt
+	recent.MemberId = "member-1"
+	recent.PurchaseDate = time.Now().AddDate(0, -1, 0).Format("2006-01-02")
+	line, _ := json.Marshal(recent)
+	lines = append(lines, string(line))
+
+	opts := ImportOptions{Format: FormatNDJSON, Source: "history.ndjson"}
+	if _, err := srv.Import(context.Background(), strings.NewReader(strings.Join(lines, "\n")), opts); err != nil {
+		t.Fatalf("Failed to import receipts: %v", err)
+	}
+
+	member, err := srv.GetMemberTier("member-1")
+	if err != nil || member.Tier != "Silver" || len(member.History) != 1 {
+		t.Fatalf("Expected the member to stay Silver, got %+v (%v)", member, err)
+	}
+
+	// the recent receipt counts towards the tier, but isn't multiplied by it
+	record, err := db.GetReceiptById(importId(opts.Source, 51))
+	if err != nil {
+		t.Fatalf("Failed to get the recent receipt: %v", err)
+	}
+	for _, line := range record.Breakdown {
+		if line.Rule == "tierMultiplier" {
+			t.Fatalf("Expected no tier multiplier on an imported receipt, got %+v", record.Breakdown)
+		}
+	}
+	if !record.Imported || record.Tier != "" || member.RollingPoints != 1000+record.Points {
+		t.Fatalf("Expected only the recent receipt to count, got %d rolling points for %+v", member.RollingPoints, record)
+	}
+}
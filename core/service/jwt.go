@@ -0,0 +1,388 @@
+package service
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/RA341/receipt-processor-challenge/config"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrInvalidToken = errors.New("invalid token")
+
+// jwksMinRefresh stops tokens with unknown key ids from making us fetch the key set on every request
+const jwksMinRefresh = 30 * time.Second
+
+// JWTClaims is what the service needs from a verified token
+type JWTClaims struct {
+	MemberId string
+	Scopes   []string
+}
+
+// JWTVerifier verifies RS256, ES256 and EdDSA signed bearer tokens against a JWKS
+type JWTVerifier struct {
+	keys        *JWKSCache
+	issuer      string
+	audience    string
+	memberClaim string
+	scopeClaim  string
+	leeway      time.Duration
+	now         func() time.Time
+}
+
+func NewJWTVerifier(cfg config.JWTConfig) *JWTVerifier {
+	return &JWTVerifier{
+		keys:        NewJWKSCache(cfg.JWKS, cfg.CacheTTL.Duration),
+		issuer:      cfg.Issuer,
+		audience:    cfg.Audience,
+		memberClaim: cfg.MemberClaim,
+		scopeClaim:  cfg.ScopeClaim,
+		leeway:      cfg.Leeway.Duration,
+		now:         time.Now,
+	}
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// Verify checks the token signature and its exp, nbf, iss and aud claims
+func (v *JWTVerifier) Verify(token string) (JWTClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return JWTClaims{}, fmt.Errorf("%w: malformed token", ErrInvalidToken)
+	}
+
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return JWTClaims{}, fmt.Errorf("%w: bad header: %v", ErrInvalidToken, err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return JWTClaims{}, fmt.Errorf("%w: bad signature encoding", ErrInvalidToken)
+	}
+
+	key, err := v.keys.Key(header.Kid)
+	if err != nil {
+		return JWTClaims{}, err
+	}
+	if err := verifySignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return JWTClaims{}, err
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return JWTClaims{}, fmt.Errorf("%w: bad claims encoding", ErrInvalidToken)
+	}
+	var claims map[string]any
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	if err := decoder.Decode(&claims); err != nil {
+		return JWTClaims{}, fmt.Errorf("%w: bad claims: %v", ErrInvalidToken, err)
+	}
+
+	if err := v.checkRegisteredClaims(claims); err != nil {
+		return JWTClaims{}, err
+	}
+
+	memberId, _ := claims[v.memberClaim].(string)
+	if memberId == "" {
+		return JWTClaims{}, fmt.Errorf("%w: missing %s claim", ErrInvalidToken, v.memberClaim)
+	}
+
+	return JWTClaims{MemberId: memberId, Scopes: scopesFromClaim(claims[v.scopeClaim])}, nil
+}
+
+func (v *JWTVerifier) checkRegisteredClaims(claims map[string]any) error {
+	now := v.now()
+
+	exp, ok := numericClaim(claims, "exp")
+	if !ok {
+		return fmt.Errorf("%w: missing exp claim", ErrInvalidToken)
+	}
+	if !now.Before(exp.Add(v.leeway)) {
+		return fmt.Errorf("%w: token expired", ErrInvalidToken)
+	}
+	if nbf, ok := numericClaim(claims, "nbf"); ok && now.Add(v.leeway).Before(nbf) {
+		return fmt.Errorf("%w: token not valid yet", ErrInvalidToken)
+	}
+
+	if v.issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != v.issuer {
+			return fmt.Errorf("%w: unexpected issuer", ErrInvalidToken)
+		}
+	}
+
+	if v.audience != "" {
+		found := false
+		switch aud := claims["aud"].(type) {
+		case string:
+			found = aud == v.audience
+		case []any:
+			for _, a := range aud {
+				if a == v.audience {
+					found = true
+				}
+			}
+		}
+		if !found {
+			return fmt.Errorf("%w: unexpected audience", ErrInvalidToken)
+		}
+	}
+
+	return nil
+}
+
+func verifySignature(alg string, key crypto.PublicKey, signed, signature []byte) error {
+	switch alg {
+	case "RS256":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("%w: key does not match alg %s", ErrInvalidToken, alg)
+		}
+		digest := sha256.Sum256(signed)
+		if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], signature); err != nil {
+			return fmt.Errorf("%w: bad signature", ErrInvalidToken)
+		}
+	case "ES256":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok || pub.Curve != elliptic.P256() || len(signature) != 64 {
+			return fmt.Errorf("%w: key does not match alg %s", ErrInvalidToken, alg)
+		}
+		digest := sha256.Sum256(signed)
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(pub, digest[:], r, s) {
+			return fmt.Errorf("%w: bad signature", ErrInvalidToken)
+		}
+	case "EdDSA":
+		pub, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return fmt.Errorf("%w: key does not match alg %s", ErrInvalidToken, alg)
+		}
+		if !ed25519.Verify(pub, signed, signature) {
+			return fmt.Errorf("%w: bad signature", ErrInvalidToken)
+		}
+	default:
+		// this also rejects "none" and the HMAC algorithms
+		return fmt.Errorf("%w: unsupported alg %q", ErrInvalidToken, alg)
+	}
+	return nil
+}
+
+// JWKSCache loads a key set from a file or URL and keeps it for the ttl
+type JWKSCache struct {
+	mu        sync.Mutex
+	source    string
+	ttl       time.Duration
+	client    *http.Client
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+	// attemptedAt limits fetches to one per jwksMinRefresh, even if they fail
+	attemptedAt time.Time
+	now         func() time.Time
+}
+
+func NewJWKSCache(source string, ttl time.Duration) *JWKSCache {
+	return &JWKSCache{
+		source: source,
+		ttl:    ttl,
+		client: &http.Client{Timeout: 10 * time.Second},
+		now:    time.Now,
+	}
+}
+
+// Key returns the key with the kid, fetching the key set again if it is
+// stale or doesn't have the kid, in case the provider rotated its keys
+func (c *JWKSCache) Key(kid string) (crypto.PublicKey, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	stale := c.keys == nil || now.Sub(c.fetchedAt) > c.ttl
+	_, known := c.keys[kid]
+	if (stale || !known) && now.Sub(c.attemptedAt) > jwksMinRefresh {
+		c.attemptedAt = now
+		// on failure keep serving the old keys in case the provider is briefly unreachable
+		if err := c.refresh(); err != nil && c.keys == nil {
+			return nil, err
+		}
+	}
+	if c.keys == nil {
+		return nil, fmt.Errorf("jwks from %s is not available", c.source)
+	}
+
+	key, ok := c.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown key id %q", ErrInvalidToken, kid)
+	}
+	return key, nil
+}
+
+func (c *JWKSCache) refresh() error {
+	contents, err := c.fetch()
+	if err != nil {
+		return fmt.Errorf("unable to load jwks: %v", err)
+	}
+
+	keys, err := ParseJWKS(contents)
+	if err != nil {
+		return err
+	}
+	c.keys = keys
+	c.fetchedAt = c.now()
+	return nil
+}
+
+func (c *JWKSCache) fetch() ([]byte, error) {
+	if !strings.HasPrefix(c.source, "http://") && !strings.HasPrefix(c.source, "https://") {
+		return os.ReadFile(c.source)
+	}
+
+	resp, err := c.client.Get(c.source)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
+
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// ParseJWKS parses the RSA, P-256 and Ed25519 signing keys of a key set by kid,
+// keys of other types are skipped
+func ParseJWKS(contents []byte) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(contents, &set); err != nil {
+		return nil, fmt.Errorf("unable to parse jwks: %v", err)
+	}
+
+	keys := map[string]crypto.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		key, err := parseJWK(k)
+		if err != nil {
+			return nil, fmt.Errorf("jwks key %q: %v", k.Kid, err)
+		}
+		if key != nil {
+			keys[k.Kid] = key
+		}
+	}
+	return keys, nil
+}
+
+func parseJWK(k jwk) (crypto.PublicKey, error) {
+	switch {
+	case k.Kty == "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("bad modulus")
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil || len(e) > 4 {
+			return nil, fmt.Errorf("bad exponent")
+		}
+		modulus := new(big.Int).SetBytes(n)
+		if modulus.BitLen() < 2048 {
+			return nil, fmt.Errorf("rsa keys must be at least 2048 bits")
+		}
+		return &rsa.PublicKey{
+			N: modulus,
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+	case k.Kty == "EC" && k.Crv == "P-256":
+		x, errX := base64.RawURLEncoding.DecodeString(k.X)
+		y, errY := base64.RawURLEncoding.DecodeString(k.Y)
+		if errX != nil || errY != nil || len(x) != 32 || len(y) != 32 {
+			return nil, fmt.Errorf("bad coordinates")
+		}
+		// ecdh rejects points that are not on the curve
+		if _, err := ecdh.P256().NewPublicKey(append(append([]byte{4}, x...), y...)); err != nil {
+			return nil, fmt.Errorf("invalid P-256 point")
+		}
+		return &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}, nil
+	case k.Kty == "OKP" && k.Crv == "Ed25519":
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("bad public key")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, nil
+	}
+}
+
+func decodeSegment(segment string, v any) error {
+	contents, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(contents, v)
+}
+
+func numericClaim(claims map[string]any, name string) (time.Time, bool) {
+	number, ok := claims[name].(json.Number)
+	if !ok {
+		return time.Time{}, false
+	}
+	seconds, err := number.Float64()
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(seconds), 0), true
+}
+
+// scopesFromClaim accepts both a space separated string and an array of strings
+func scopesFromClaim(claim any) []string {
+	switch scopes := claim.(type) {
+	case string:
+		return strings.Fields(scopes)
+	case []any:
+		var result []string
+		for _, scope := range scopes {
+			if s, ok := scope.(string); ok {
+				result = append(result, s)
+			}
+		}
+		return result
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package service
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/RA341/receipt-processor-challenge/config"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testSigner struct {
+	kid string
+	alg string
+	key crypto.Signer
+}
+
+// newTestKeySet generates an RS256, ES256 and EdDSA key and returns their signers and JWKS
+func newTestKeySet(t *testing.T) (map[string]testSigner, []byte) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate rsa key: %v", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate ecdsa key: %v", err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate ed25519 key: %v", err)
+	}
+
+	b64 := base64.RawURLEncoding.EncodeToString
+	ecdhKey, err := ecKey.PublicKey.ECDH()
+	if err != nil {
+		t.Fatalf("Failed to convert ecdsa key: %v", err)
+	}
+	ecPub := ecdhKey.Bytes() // 0x04 || x || y
+	jwks, _ := json.Marshal(map[string]any{"keys": []map[string]string{
+		{"kid": "rsa", "kty": "RSA", "use": "sig", "n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+		{"kid": "ec", "kty": "EC", "crv": "P-256", "x": b64(ecPub[1:33]), "y": b64(ecPub[33:])},
+		{"kid": "ed", "kty": "OKP", "crv": "Ed25519", "x": b64(edKey.Public().(ed25519.PublicKey))},
+	}})
+
+	signers := map[string]testSigner{
+		"RS256": {kid: "rsa", alg: "RS256", key: rsaKey},
+		"ES256": {kid: "ec", alg: "ES256", key: ecKey},
+		"EdDSA": {kid: "ed", alg: "EdDSA", key: edKey},
+	}
+	return signers, jwks
+}
+
+func (s testSigner) sign(t *testing.T, claims map[string]any) string {
+	b64 := base64.RawURLEncoding.EncodeToString
+	header, _ := json.Marshal(map[string]string{"alg": s.alg, "kid": s.kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := b64(header) + "." + b64(payload)
+
+	var signature []byte
+	var err error
+	switch key := s.key.(type) {
+	case *rsa.PrivateKey:
+		digest := sha256.Sum256([]byte(signed))
+		signature, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	case *ecdsa.PrivateKey:
+		digest := sha256.Sum256([]byte(signed))
+		r, sigS, signErr := ecdsa.Sign(rand.Reader, key, digest[:])
+		err = signErr
+		signature = append(r.FillBytes(make([]byte, 32)), sigS.FillBytes(make([]byte, 32))...)
+	case ed25519.PrivateKey:
+		signature = ed25519.Sign(key, []byte(signed))
+	}
+	if err != nil {
+		t.Fatalf("Failed to sign token: %v", err)
+	}
+
+	return signed + "." + b64(signature)
+}
+
+func newTestVerifier(source string) *JWTVerifier {
+	cfg := config.Default().Auth.JWT
+	cfg.JWKS = source
+	cfg.Issuer = "https://id.example.com"
+	cfg.Audience = "receipts"
+	return NewJWTVerifier(cfg)
+}
+
+func validClaims() map[string]any {
+	return map[string]any{
+		"sub":   "member-1",
+		"iss":   "https://id.example.com",
+		"aud":   []string{"receipts", "other"},
+		"scope": "submit read",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+	}
+}
+
+func TestJWTVerifier_Algorithms(t *testing.T) {
+	signers, jwks := newTestKeySet(t)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, jwks, 0o600); err != nil {
+		t.Fatalf("Failed to write jwks: %v", err)
+	}
+	verifier := newTestVerifier(path)
+
+	for alg, signer := range signers {
+		t.Run(alg, func(t *testing.T) {
+			claims, err := verifier.Verify(signer.sign(t, validClaims()))
+			if err != nil {
+				t.Fatalf("Failed to verify token: %v", err)
+			}
+			if claims.MemberId != "member-1" || len(claims.Scopes) != 2 {
+				t.Fatalf("Unexpected claims: %+v", claims)
+			}
+		})
+	}
+}
+
+func TestJWTVerifier_Rejects(t *testing.T) {
+	signers, jwks := newTestKeySet(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(jwks)
+	}))
+	defer server.Close()
+	verifier := newTestVerifier(server.URL)
+
+	signer := signers["ES256"]
+	withClaim := func(name string, value any) map[string]any {
+		claims := validClaims()
+		if value == nil {
+			delete(claims, name)
+		} else {
+			claims[name] = value
+		}
+		return claims
+	}
+
+	wrongKid := signers["EdDSA"]
+	wrongKid.kid = "rsa"
+	tampered := signer.sign(t, validClaims())
+	tampered = tampered[:len(tampered)-4] + "AAAA"
+
+	cases := map[string]string{
+		"expired":        signer.sign(t, withClaim("exp", time.Now().Add(-time.Hour).Unix())),
+		"missing exp":    signer.sign(t, withClaim("exp", nil)),
+		"not yet valid":  signer.sign(t, withClaim("nbf", time.Now().Add(time.Hour).Unix())),
+		"wrong issuer":   signer.sign(t, withClaim("iss", "https://evil.example.com")),
+		"wrong audience": signer.sign(t, withClaim("aud", "other")),
+		"missing member": signer.sign(t, withClaim("sub", nil)),
+		"alg mismatch":   wrongKid.sign(t, validClaims()),
+		"bad signature":  tampered,
+		"unsigned":       "eyJhbGciOiJub25lIiwia2lkIjoiZWMifQ.eyJzdWIiOiJtZW1iZXItMSJ9.",
+		"garbage":        "not-a-token",
+	}
+
+	for name, token := range cases {
+		t.Run(name, func(t *testing.T) {
+			if _, err := verifier.Verify(token); err == nil {
+				t.Fatalf("Expected the token to be rejected")
+			}
+		})
+	}
+}
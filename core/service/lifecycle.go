@@ -0,0 +1,47 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"github.com/RA341/receipt-processor-challenge/models"
+	"slices"
+	"time"
+)
+
+var ErrInvalidTransition = errors.New("invalid receipt status transition")
+
+// statusTransitions lists the statuses a receipt can move to from each status,
+// rejected and voided are final
+var statusTransitions = map[string][]string{
+	models.StatusReceived:      {models.StatusValidated, models.StatusRejected},
+	models.StatusValidated:     {models.StatusScored, models.StatusRejected},
+	models.StatusScored:        {models.StatusCredited, models.StatusPendingReview, models.StatusRejected},
+	models.StatusPendingReview: {models.StatusCredited, models.StatusRejected},
+	models.StatusCredited:      {models.StatusVoided},
+}
+
+// newRecordStatus starts the lifecycle of a new record as received
+func newRecordStatus(record *models.ReceiptRecord, at time.Time) {
+	record.Status = models.StatusReceived
+	record.StatusHistory = []models.StatusChange{{To: models.StatusReceived, At: at}}
+}
+
+// transition moves the record to the status, failing if the lifecycle doesn't allow it
+func transition(record *models.ReceiptRecord, to string, at time.Time) error {
+	if !slices.Contains(statusTransitions[record.Status], to) {
+		return fmt.Errorf("%w: %s to %s", ErrInvalidTransition, record.Status, to)
+	}
+
+	record.StatusHistory = append(record.StatusHistory, models.StatusChange{From: record.Status, To: to, At: at})
+	record.Status = to
+	return nil
+}
+
+// IsScored reports whether the receipt has been scored, its points may still be held or voided
+func IsScored(status string) bool {
+	switch status {
+	case models.StatusScored, models.StatusCredited, models.StatusPendingReview, models.StatusVoided:
+		return true
+	}
+	return false
+}
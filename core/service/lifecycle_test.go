@@ -0,0 +1,65 @@
+package service
+
+import (
+	"errors"
+	"github.com/RA341/receipt-processor-challenge/models"
+	"testing"
+	"time"
+)
+
+func TestTransition(t *testing.T) {
+	now := time.Now()
+	record := models.ReceiptRecord{}
+	newRecordStatus(&record, now)
+
+	for _, to := range []string{models.StatusValidated, models.StatusScored, models.StatusCredited, models.StatusVoided} {
+		if err := transition(&record, to, now); err != nil {
+			t.Fatalf("Failed to move to %s: %v", to, err)
+		}
+	}
+	if len(record.StatusHistory) != 5 || record.StatusHistory[4].From != models.StatusCredited {
+		t.Fatalf("Unexpected status history %+v", record.StatusHistory)
+	}
+
+	// voided is final
+	if err := transition(&record, models.StatusCredited, now); !errors.Is(err, ErrInvalidTransition) {
+		t.Fatalf("Expected an invalid transition, got %v", err)
+	}
+
+	skipping := models.ReceiptRecord{}
+	newRecordStatus(&skipping, now)
+	if err := transition(&skipping, models.StatusCredited, now); !errors.Is(err, ErrInvalidTransition) {
+		t.Fatalf("Expected crediting an unscored receipt to fail, got %v", err)
+	}
+}
+
+func TestReceiptService_SubmitReceiptLifecycle(t *testing.T) {
+	db, _ := NewDB()
+	srv := NewReceiptService(db)
+
+	id, err := srv.NewReceipt(testMap["test 1"].receipt)
+	if err != nil {
+		t.Fatalf("Failed to submit receipt: %v", err)
+	}
+	record, _ := srv.GetReceiptById(id)
+
+	var statuses []string
+	for _, change := range record.StatusHistory {
+		statuses = append(statuses, change.To)
+	}
+	expected := []string{models.StatusReceived, models.StatusValidated, models.StatusScored, models.StatusCredited}
+	if record.Status != models.StatusCredited || len(statuses) != len(expected) {
+		t.Fatalf("Expected statuses %v but got %v", expected, statuses)
+	}
+
+	invalid := testMap["test 1"].receipt
+	invalid.Total = "35.5"
+	_, err = srv.NewReceipt(invalid)
+	var rejected *RejectedError
+	if !errors.Is(err, ErrReceiptRejected) || !errors.As(err, &rejected) {
+		t.Fatalf("Expected the receipt to be rejected, got %v", err)
+	}
+	if record, _ := srv.GetReceiptById(rejected.Id); record.Status != models.StatusRejected {
+		t.Fatalf("Expected the rejected receipt to be stored, got %+v", record)
+	}
+}
@@ -0,0 +1,64 @@
+package service
+
+import (
+	"fmt"
+	"github.com/RA341/receipt-processor-challenge/models"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	dateLayout     = "2006-01-02"       // YYYY-MM-DD
+	timeLayout     = "15:04"            // HH:MM (24-hour)
+	dateTimeLayout = "2006-01-02 15:04" // dateLayout + timeLayout
+)
+
+// parseCents converts a dollar amount such as "35.35" to cents without going through a float
+func parseCents(amount string) (int64, error) {
+	dollars, cents, found := strings.Cut(strings.TrimSpace(amount), ".")
+	if !found {
+		cents = "00"
+	}
+	if dollars == "" || len(cents) != 2 {
+		return 0, fmt.Errorf("invalid amount %q: must be in format 0.00", amount)
+	}
+
+	d, err := strconv.ParseInt(dollars, 10, 64)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid amount %q: must be in format 0.00", amount)
+	}
+	c, err := strconv.ParseInt(cents, 10, 64)
+	if err != nil || c < 0 {
+		return 0, fmt.Errorf("invalid amount %q: must be in format 0.00", amount)
+	}
+
+	return d*100 + c, nil
+}
+
+// formatCents is the inverse of parseCents
+func formatCents(cents int64) string {
+	return fmt.Sprintf("%d.%02d", cents/100, cents%100)
+}
+
+// purchaseTimestamp combines the purchase date and time of the receipt, in UTC
+func purchaseTimestamp(receipt *models.Receipt) (time.Time, error) {
+	return time.Parse(dateTimeLayout, receipt.PurchaseDate+" "+receipt.PurchaseTime)
+}
+
+// checkScorable makes sure the fields the rules parse are valid, the api validates
+// receipts before they get here but other callers may not
+func checkScorable(receipt *models.Receipt) error {
+	if _, err := purchaseTimestamp(receipt); err != nil {
+		return fmt.Errorf("invalid purchase date or time: %v", err)
+	}
+	if _, err := parseCents(receipt.Total); err != nil {
+		return err
+	}
+	for i, item := range receipt.Items {
+		if _, err := parseCents(item.Price); err != nil {
+			return fmt.Errorf("item %d: %v", i+1, err)
+		}
+	}
+	return nil
+}
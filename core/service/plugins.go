@@ -0,0 +1,251 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/RA341/receipt-processor-challenge/config"
+	"github.com/RA341/receipt-processor-challenge/models"
+	u "github.com/RA341/receipt-processor-challenge/utils"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"log/slog"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// A plugin is a WebAssembly module exporting its memory as "memory" and two functions:
+//
+//	alloc(size i32) i32           reserves size bytes for the receipt and returns their address
+//	score(address, size i32) i64  scores the receipt JSON written there
+//
+// score returns the address of its result in the upper 32 bits and its size in the lower ones,
+// the result being the JSON of a wasmResult. Modules built for WASI get no files, environment or
+// real clock. Every receipt is scored by a new instance, so plugins can't keep state between receipts
+var (
+	pluginRuntimeOnce sync.Once
+	pluginRuntime     wazero.Runtime
+	pluginRuntimeErr  error
+
+	pluginModulesMu sync.Mutex
+	// pluginModules are the compiled modules by the hash of their contents, so reloading the rules
+	// doesn't compile the same module again
+	pluginModules = map[[sha256.Size]byte]wazero.CompiledModule{}
+	// pluginPaths are the module files of the registered plugins by name, wasm rules can only run
+	// these so rules sent to the api can't load any other file
+	pluginPaths = map[string]string{}
+)
+
+// wasmResult is what a plugin returns for a receipt, Explanation is shown in the breakdown
+type wasmResult struct {
+	Points      int64  `json:"points"`
+	Explanation string `json:"explanation"`
+}
+
+// wasmRuntime is shared by every plugin, its memory limit is read from the config the first time it is used
+func wasmRuntime() (wazero.Runtime, error) {
+	pluginRuntimeOnce.Do(func() {
+		ctx := context.Background()
+		cfg := wazero.NewRuntimeConfig().
+			WithMemoryLimitPages(uint32(config.Get().Plugins.MemoryLimitPages)).
+			WithCloseOnContextDone(true)
+		pluginRuntime = wazero.NewRuntimeWithConfig(ctx, cfg)
+		if _, err := wasi_snapshot_preview1.Instantiate(ctx, pluginRuntime); err != nil {
+			pluginRuntimeErr = fmt.Errorf("unable to set up wasi: %v", err)
+		}
+	})
+	return pluginRuntime, pluginRuntimeErr
+}
+
+// RulesWithPlugins registers the plugins of the config and compiles the default rules followed by a wasm rule for each
+func RulesWithPlugins(cfg config.PluginsConfig) (*Rules, error) {
+	RegisterPlugins(cfg)
+	set := DefaultRuleSet()
+	for _, plugin := range cfg.Rules {
+		set.Rules = append(set.Rules, models.Rule{Name: plugin.Name, Type: RuleWasm, Plugin: plugin.Name})
+	}
+	return CompileRules(set)
+}
+
+// RegisterPlugins lets wasm rules run the plugins of the config by name,
+// registering a name again replaces its module
+func RegisterPlugins(cfg config.PluginsConfig) {
+	pluginModulesMu.Lock()
+	defer pluginModulesMu.Unlock()
+	for _, plugin := range cfg.Rules {
+		pluginPaths[plugin.Name] = plugin.Module
+	}
+}
+
+func registeredPlugin(name string) (string, bool) {
+	pluginModulesMu.Lock()
+	defer pluginModulesMu.Unlock()
+	path, ok := pluginPaths[name]
+	return path, ok
+}
+
+// compilePlugin compiles the module and checks it exports what a plugin needs
+func compilePlugin(contents []byte) (wazero.CompiledModule, error) {
+	runtime, err := wasmRuntime()
+	if err != nil {
+		return nil, err
+	}
+
+	hash := sha256.Sum256(contents)
+	pluginModulesMu.Lock()
+	defer pluginModulesMu.Unlock()
+	if compiled, ok := pluginModules[hash]; ok {
+		return compiled, nil
+	}
+
+	compiled, err := runtime.CompileModule(context.Background(), contents)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkPluginExports(compiled); err != nil {
+		_ = compiled.Close(context.Background())
+		return nil, err
+	}
+	pluginModules[hash] = compiled
+	return compiled, nil
+}
+
+func checkPluginExports(compiled wazero.CompiledModule) error {
+	if _, ok := compiled.ExportedMemories()["memory"]; !ok {
+		return fmt.Errorf("module must export its memory as memory")
+	}
+
+	i32, i64 := api.ValueTypeI32, api.ValueTypeI64
+	signatures := map[string][2][]api.ValueType{
+		"alloc": {{i32}, {i32}},
+		"score": {{i32, i32}, {i64}},
+	}
+	functions := compiled.ExportedFunctions()
+	for name, signature := range signatures {
+		function, ok := functions[name]
+		if !ok {
+			return fmt.Errorf("module must export a %s function", name)
+		}
+		if !slices.Equal(function.ParamTypes(), signature[0]) || !slices.Equal(function.ResultTypes(), signature[1]) {
+			return fmt.Errorf("%s must take %s and return %s", name, valueTypes(signature[0]), valueTypes(signature[1]))
+		}
+	}
+	return nil
+}
+
+func valueTypes(types []api.ValueType) string {
+	names := make([]string, len(types))
+	for i, t := range types {
+		names[i] = api.ValueTypeName(t)
+	}
+	return fmt.Sprint(names)
+}
+
+// compileWasmRule loads the rule's plugin, a plugin that fails on a receipt, by running out of time or
+// memory or returning an invalid result, awards it no points
+func compileWasmRule(rule models.Rule) (pointRule, error) {
+	if rule.Plugin == "" {
+		return pointRule{}, fmt.Errorf("plugin is required")
+	}
+	path, ok := registeredPlugin(rule.Plugin)
+	if !ok {
+		return pointRule{}, fmt.Errorf("no plugin named %s in the plugins config", rule.Plugin)
+	}
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return pointRule{}, err
+	}
+	compiled, err := compilePlugin(contents)
+	if err != nil {
+		return pointRule{}, fmt.Errorf("invalid plugin %s: %v", rule.Plugin, err)
+	}
+
+	timeout := config.Get().Plugins.Timeout.Duration
+	explain := func(receipt *models.Receipt) (int64, string) {
+		result, err := runPlugin(compiled, timeout, receipt)
+		if err != nil {
+			slog.Warn("Plugin rule failed, awarding no points", slog.String("rule", rule.Name), u.ErrLog(err))
+			return 0, ""
+		}
+		return result.Points, result.Explanation
+	}
+	return pointRule{
+		name: rule.Name,
+		calc: func(receipt *models.Receipt) int64 {
+			points, _ := explain(receipt)
+			return points
+		},
+		check: func(receipt *models.Receipt) error {
+			_, err := runPlugin(compiled, timeout, receipt)
+			return err
+		},
+		explain: explain,
+	}, nil
+}
+
+// runPlugin scores the receipt on a new instance of the plugin
+func runPlugin(compiled wazero.CompiledModule, timeout time.Duration, receipt *models.Receipt) (wasmResult, error) {
+	runtime, err := wasmRuntime()
+	if err != nil {
+		return wasmResult{}, err
+	}
+	input, err := json.Marshal(receipt)
+	if err != nil {
+		return wasmResult{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// reactors built with WASI are initialized by _initialize, an anonymous name allows concurrent instances
+	module, err := runtime.InstantiateModule(ctx, compiled, wazero.NewModuleConfig().WithName("").WithStartFunctions("_initialize"))
+	if err != nil {
+		return wasmResult{}, pluginErr(ctx, timeout, err)
+	}
+	defer func() {
+		_ = module.Close(context.Background())
+	}()
+
+	allocated, err := module.ExportedFunction("alloc").Call(ctx, uint64(len(input)))
+	if err != nil {
+		return wasmResult{}, pluginErr(ctx, timeout, err)
+	}
+	address := uint32(allocated[0])
+	if !module.Memory().Write(address, input) {
+		return wasmResult{}, fmt.Errorf("alloc returned %d, which doesn't fit %d bytes in memory", address, len(input))
+	}
+
+	scored, err := module.ExportedFunction("score").Call(ctx, uint64(address), uint64(len(input)))
+	if err != nil {
+		return wasmResult{}, pluginErr(ctx, timeout, err)
+	}
+	resultAddress, resultSize := uint32(scored[0]>>32), uint32(scored[0])
+	output, ok := module.Memory().Read(resultAddress, resultSize)
+	if !ok {
+		return wasmResult{}, fmt.Errorf("score returned %d bytes at %d, which is outside memory", resultSize, resultAddress)
+	}
+
+	var result wasmResult
+	if err := json.Unmarshal(output, &result); err != nil {
+		return wasmResult{}, fmt.Errorf("invalid result: %v", err)
+	}
+	if result.Points < 0 {
+		return wasmResult{}, fmt.Errorf("plugin returned %d points, points must not be negative", result.Points)
+	}
+	// a plugin can't overflow the receipt's total
+	result.Points = min(result.Points, maxRulePoints)
+	return result, nil
+}
+
+// pluginErr names the timeout when the plugin was stopped by it
+func pluginErr(ctx context.Context, timeout time.Duration, err error) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("plugin took longer than %s", timeout)
+	}
+	return err
+}
@@ -0,0 +1,204 @@
+package service
+
+import (
+	"github.com/RA341/receipt-processor-challenge/config"
+	"github.com/RA341/receipt-processor-challenge/models"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// The test plugins are assembled by hand, wasm is a section per kind of declaration, sizes and
+// indexes are LEB128 encoded
+const (
+	wasmI32 = 0x7f
+	wasmI64 = 0x7e
+
+	opEnd         = 0x0b
+	opI32Const    = 0x41
+	opI64Const    = 0x42
+	opLoop        = 0x03
+	opBr          = 0x0c
+	opUnreachable = 0x00
+
+	// pluginResultAddress is where the data segment puts the result of the test plugins
+	pluginResultAddress = 1024
+)
+
+func uleb(n uint64) []byte {
+	var out []byte
+	for {
+		b := byte(n & 0x7f)
+		n >>= 7
+		if n == 0 {
+			return append(out, b)
+		}
+		out = append(out, b|0x80)
+	}
+}
+
+func sleb(n int64) []byte {
+	var out []byte
+	for {
+		b := byte(n & 0x7f)
+		n >>= 7
+		if (n == 0 && b&0x40 == 0) || (n == -1 && b&0x40 != 0) {
+			return append(out, b)
+		}
+		out = append(out, b|0x80)
+	}
+}
+
+func wasmVector(items ...[]byte) []byte {
+	out := uleb(uint64(len(items)))
+	for _, item := range items {
+		out = append(out, item...)
+	}
+	return out
+}
+
+func wasmSection(id byte, contents []byte) []byte {
+	return append(append([]byte{id}, uleb(uint64(len(contents)))...), contents...)
+}
+
+func wasmName(name string) []byte {
+	return append(uleb(uint64(len(name))), name...)
+}
+
+// testPlugin assembles a plugin with memoryPages of memory whose score function runs scoreBody,
+// the result is stored at pluginResultAddress
+func testPlugin(memoryPages uint64, result string, scoreBody ...byte) []byte {
+	allocBody := append([]byte{0x00, opI32Const}, append(sleb(2048), opEnd)...)
+	scoreBody = append([]byte{0x00}, scoreBody...)
+
+	module := []byte{0x00, 'a', 's', 'm', 0x01, 0x00, 0x00, 0x00}
+	module = append(module, wasmSection(1, wasmVector( // types
+		[]byte{0x60, 1, wasmI32, 1, wasmI32},
+		[]byte{0x60, 2, wasmI32, wasmI32, 1, wasmI64},
+	))...)
+	module = append(module, wasmSection(3, wasmVector([]byte{0}, []byte{1}))...)                       // functions
+	module = append(module, wasmSection(5, wasmVector(append([]byte{0x00}, uleb(memoryPages)...)))...) // memory
+	module = append(module, wasmSection(7, wasmVector(                                                 // exports
+		append(wasmName("memory"), 0x02, 0),
+		append(wasmName("alloc"), 0x00, 0),
+		append(wasmName("score"), 0x00, 1),
+	))...)
+	module = append(module, wasmSection(10, wasmVector( // code
+		append(uleb(uint64(len(allocBody))), allocBody...),
+		append(uleb(uint64(len(scoreBody))), scoreBody...),
+	))...)
+	offset := append([]byte{opI32Const}, append(sleb(pluginResultAddress), opEnd)...)
+	segment := append(append([]byte{0x00}, offset...), wasmName(result)...)
+	return append(module, wasmSection(11, wasmVector(segment))...) // data
+}
+
+// returnResult is a score body returning the result at pluginResultAddress
+func returnResult(result string) []byte {
+	return append([]byte{opI64Const}, append(sleb(pluginResultAddress<<32|int64(len(result))), opEnd)...)
+}
+
+// registerPlugin writes the module and registers it under the name, which wasm rules refer to
+func registerPlugin(t *testing.T, name string, module []byte) string {
+	path := filepath.Join(t.TempDir(), name+".wasm")
+	if err := os.WriteFile(path, module, 0o600); err != nil {
+		t.Fatalf("Failed to write plugin: %v", err)
+	}
+	RegisterPlugins(config.PluginsConfig{Rules: []config.PluginRuleConfig{{Name: name, Module: path}}})
+	return name
+}
+
+func TestCompileRules_Wasm(t *testing.T) {
+	result := `{"points": 42, "explanation": "partner bonus"}`
+	bonus := registerPlugin(t, "bonus", testPlugin(1, result, returnResult(result)...))
+
+	rules, err := CompileRules(models.RuleSet{Rules: []models.Rule{{Name: "partner", Type: RuleWasm, Plugin: bonus}}})
+	if err != nil {
+		t.Fatalf("Failed to compile rules: %v", err)
+	}
+	breakdown := rules.Breakdown(testMap["test 2"].receipt)
+	expected := models.BreakdownLine{Rule: "partner", Points: 42, Detail: "partner bonus"}
+	if len(breakdown) != 1 || breakdown[0] != expected {
+		t.Fatalf("Expected %+v, got %+v", expected, breakdown)
+	}
+
+	// registered after the default rules, 109 + 42
+	path := filepath.Join(t.TempDir(), "partner.wasm")
+	if err := os.WriteFile(path, testPlugin(1, result, returnResult(result)...), 0o600); err != nil {
+		t.Fatalf("Failed to write plugin: %v", err)
+	}
+	withPlugins, err := RulesWithPlugins(config.PluginsConfig{Rules: []config.PluginRuleConfig{{Name: "partner", Module: path}}})
+	if err != nil {
+		t.Fatalf("Failed to compile rules: %v", err)
+	}
+	if points := withPlugins.Points(testMap["test 2"].receipt); points != 151 {
+		t.Fatalf("Expected 151 points with the plugin, got %d", points)
+	}
+
+	RegisterPlugins(config.PluginsConfig{Rules: []config.PluginRuleConfig{{Name: "missing", Module: filepath.Join(t.TempDir(), "missing.wasm")}}})
+	invalid := map[string]string{
+		"missing file":   "missing",
+		"not wasm":       registerPlugin(t, "text", []byte("not a module")),
+		"too big memory": registerPlugin(t, "big", testPlugin(1024, result, returnResult(result)...)),
+		// only registered plugins can run, rules can't name a module file
+		"unregistered": bonus + ".wasm",
+		"no plugin":    "",
+	}
+	for name, plugin := range invalid {
+		if _, err := CompileRules(models.RuleSet{Rules: []models.Rule{{Type: RuleWasm, Plugin: plugin}}}); err == nil {
+			t.Fatalf("Expected %s to fail", name)
+		}
+	}
+}
+
+func TestRunPlugin_Failures(t *testing.T) {
+	failing := map[string]struct {
+		module  []byte
+		message string
+	}{
+		// loops forever, until the timeout closes the instance
+		"timeout": {
+			module:  testPlugin(1, "", opLoop, 0x40, opBr, 0, opEnd, opUnreachable, opEnd),
+			message: "took longer than",
+		},
+		"outside memory": {
+			module:  testPlugin(1, "", append([]byte{opI64Const}, append(sleb(1<<20<<32|16), opEnd)...)...),
+			message: "outside memory",
+		},
+		"invalid json": {
+			module:  testPlugin(1, "points", returnResult("points")...),
+			message: "invalid result",
+		},
+		"negative points": {
+			module:  testPlugin(1, `{"points": -5}`, returnResult(`{"points": -5}`)...),
+			message: "must not be negative",
+		},
+	}
+	for name, test := range failing {
+		plugin := registerPlugin(t, strings.ReplaceAll(name, " ", "-"), test.module)
+		rules, err := CompileRules(models.RuleSet{Rules: []models.Rule{{Name: "partner", Type: RuleWasm, Plugin: plugin}}})
+		if err != nil {
+			t.Fatalf("Failed to compile %s plugin: %v", name, err)
+		}
+
+		receipt := testMap["test 2"].receipt
+		if err := rules.check(receipt); err == nil || !strings.Contains(err.Error(), test.message) {
+			t.Fatalf("Expected the %s plugin to fail with %q, got %v", name, test.message, err)
+		}
+		if points := rules.Points(receipt); points != 0 {
+			t.Fatalf("Expected the failing %s plugin to award nothing, got %d", name, points)
+		}
+	}
+}
+
+func TestRunPlugin_ClampsPoints(t *testing.T) {
+	result := `{"points": 9223372036854775807}`
+	plugin := registerPlugin(t, "greedy", testPlugin(1, result, returnResult(result)...))
+	rules, err := CompileRules(models.RuleSet{Rules: []models.Rule{{Name: "greedy", Type: RuleWasm, Plugin: plugin}}})
+	if err != nil {
+		t.Fatalf("Failed to compile rules: %v", err)
+	}
+	if points := rules.Points(testMap["test 2"].receipt); points != maxRulePoints {
+		t.Fatalf("Expected the points to be clamped to %d, got %d", maxRulePoints, points)
+	}
+}
@@ -12,35 +12,52 @@ import (
 )
 
 var (
-	defaultPointRules = []calculationOpts{
-		pointsForRetailerName(),
-		pointsForRoundTotal(),
-		pointsForTotalMultipleOfQuarter(),
-		pointsPerTwoItems(),
-		pointsForItemDescriptionLength(),
-		pointsForOddPurchaseDay(),
-		pointsForPurchaseTimeBetween2And4PM(),
-	}
+	// defaultPointRules are the rules of the spec, see DefaultRuleSet
+	defaultPointRules = DefaultRules().rules
 )
 
 type calculationOpts func(receipt *models.Receipt) int64
 
-func calculatePoints(receipt *models.Receipt, pointsCalculationsOpts ...calculationOpts) int64 {
-	var points int64 = 0
-	for _, opt := range pointsCalculationsOpts {
-		points += opt(receipt)
+// pointRule is a named calculationOpts, the name is used to label its line in the breakdown
+type pointRule struct {
+	name string
+	calc calculationOpts
+	// check returns the error calc logs and scores as 0, it is only set on rules that can fail
+	check func(receipt *models.Receipt) error
+	// explain is used instead of calc by rules that explain their points in the breakdown
+	explain func(receipt *models.Receipt) (points int64, detail string)
+}
+
+func calculatePoints(receipt *models.Receipt, rules ...pointRule) int64 {
+	return sumBreakdown(calculateBreakdown(receipt, rules...))
+}
+
+// calculateBreakdown runs each rule and records the ones that awarded points
+func calculateBreakdown(receipt *models.Receipt, rules ...pointRule) []models.BreakdownLine {
+	var breakdown []models.BreakdownLine
+	for _, rule := range rules {
+		line := models.BreakdownLine{Rule: rule.name}
+		if rule.explain != nil {
+			line.Points, line.Detail = rule.explain(receipt)
+		} else {
+			line.Points = rule.calc(receipt)
+		}
+		if line.Points == 0 {
+			continue
+		}
+		breakdown = append(breakdown, line)
 	}
 
-	return points
+	return breakdown
 }
 
 // Rule 1: One point for every alphanumeric character in the retailer name.
-func pointsForRetailerName() calculationOpts {
+func pointsForRetailerName(perCharacter int64) calculationOpts {
 	return func(receipt *models.Receipt) int64 {
 		var points int64 = 0
 		for _, r := range receipt.Retailer {
 			if unicode.IsLetter(r) || unicode.IsDigit(r) {
-				points++
+				points += perCharacter
 			}
 		}
 		return points
@@ -48,12 +65,12 @@ func pointsForRetailerName() calculationOpts {
 }
 
 // Rule 2: 50 points if the total is a round dollar amount with no cents.
-func pointsForRoundTotal() calculationOpts {
+func pointsForRoundTotal(points int64) calculationOpts {
 	return func(receipt *models.Receipt) int64 {
 		if strings.HasSuffix(receipt.Total, ".00") {
 			_, err := strconv.ParseFloat(receipt.Total, 64)
 			if err == nil { // if valid number
-				return int64(50)
+				return points
 			}
 		}
 		return 0
@@ -61,7 +78,7 @@ func pointsForRoundTotal() calculationOpts {
 }
 
 // Rule 3: 25 points if the total is a multiple of 0.25.
-func pointsForTotalMultipleOfQuarter() calculationOpts {
+func pointsForTotalMultiple(points, multipleInCents int64) calculationOpts {
 	return func(receipt *models.Receipt) int64 {
 		totalFloat, err := strconv.ParseFloat(receipt.Total, 64)
 		if err != nil {
@@ -69,29 +86,28 @@ func pointsForTotalMultipleOfQuarter() calculationOpts {
 		}
 		// Convert to cents for modulo check
 		totalInCents := int64(math.Round(totalFloat*100 + 0.00001)) // Add epsilon for precision
-		if totalInCents >= 0 && totalInCents%25 == 0 {
-			return int64(25)
+		if totalInCents >= 0 && totalInCents%multipleInCents == 0 {
+			return points
 		}
 		return 0
 	}
 }
 
 // Rule 4: 5 points for every two items on the receipt.
-func pointsPerTwoItems() calculationOpts {
+func pointsPerItems(points int64, every int) calculationOpts {
 	return func(receipt *models.Receipt) int64 {
 		if receipt.Items == nil {
 			return 0
 		}
 
-		numberOfPairs := int64(len(receipt.Items) / 2)
-		points := numberOfPairs * 5.0
-		return points
+		numberOfGroups := int64(len(receipt.Items) / every)
+		return numberOfGroups * points
 	}
 }
 
 // Rule 5: If the trimmed length of the item description is a multiple of 3,
 // multiply the price by 0.2 and round up.
-func pointsForItemDescriptionLength() calculationOpts {
+func pointsForItemDescriptionLength(lengthMultiple int, multiplier float64) calculationOpts {
 	return func(receipt *models.Receipt) int64 {
 		if receipt.Items == nil {
 			return 0
@@ -101,7 +117,7 @@ func pointsForItemDescriptionLength() calculationOpts {
 			trimmedDesc := strings.TrimSpace(item.ShortDescription)
 			descLen := len(trimmedDesc)
 
-			if descLen > 0 && descLen%3 == 0 {
+			if descLen > 0 && descLen%lengthMultiple == 0 {
 				priceFloat, err := strconv.ParseFloat(item.Price, 64)
 				if err != nil {
 					slog.Warn("Could not parse price for item. Skipping....",
@@ -111,7 +127,7 @@ func pointsForItemDescriptionLength() calculationOpts {
 					)
 					continue
 				}
-				itemPoints := int64(math.Ceil(priceFloat * 0.2))
+				itemPoints := int64(math.Ceil(priceFloat * multiplier))
 				rulePoints += itemPoints
 			}
 		}
@@ -120,7 +136,7 @@ func pointsForItemDescriptionLength() calculationOpts {
 }
 
 // Rule 6: 6 points if the day in the purchase date is odd.
-func pointsForOddPurchaseDay() calculationOpts {
+func pointsForOddPurchaseDay(points int64) calculationOpts {
 	return func(receipt *models.Receipt) int64 {
 		layout := "2006-01-02" // YYYY-MM-DD
 		purchaseDate, err := time.Parse(layout, receipt.PurchaseDate)
@@ -133,14 +149,14 @@ func pointsForOddPurchaseDay() calculationOpts {
 		}
 		day := purchaseDate.Day()
 		if day%2 != 0 {
-			return int64(6)
+			return points
 		}
 		return 0
 	}
 }
 
 // Rule 7: 10 points if the time of purchase is after 2:00pm (14:00) and before 4:00pm (16:00).
-func pointsForPurchaseTimeBetween2And4PM() calculationOpts {
+func pointsForPurchaseTimeBetween(points int64, from, to time.Time) calculationOpts {
 	return func(receipt *models.Receipt) int64 {
 		layout := "15:04" // HH:MM (24-hour)
 		purchaseTime, err := time.Parse(layout, receipt.PurchaseTime)
@@ -151,12 +167,8 @@ func pointsForPurchaseTimeBetween2And4PM() calculationOpts {
 			)
 			return 0
 		}
-		// Define boundary times
-		time1400, _ := time.Parse(layout, "14:00")
-		time1600, _ := time.Parse(layout, "16:00")
-
-		if purchaseTime.After(time1400) && purchaseTime.Before(time1600) {
-			return int64(10)
+		if purchaseTime.After(from) && purchaseTime.Before(to) {
+			return points
 		}
 		return 0
 	}
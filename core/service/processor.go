@@ -0,0 +1,288 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"github.com/RA341/receipt-processor-challenge/config"
+	"github.com/RA341/receipt-processor-challenge/models"
+	u "github.com/RA341/receipt-processor-challenge/utils"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	ErrQueueFull      = errors.New("processing queue is full")
+	ErrProcessorAsync = errors.New("async processing is disabled")
+)
+
+// AsyncProcessor scores stored receipts on a bounded pool of workers,
+// retrying failures with backoff before moving them to the dead-letter store
+type AsyncProcessor struct {
+	srv *ReceiptService
+	cfg config.ProcessingConfig
+	// slots bounds the receipts queued or in progress, a slot is held until the receipt is done
+	slots chan struct{}
+	queue chan string
+	stop  chan struct{}
+	wg    sync.WaitGroup
+
+	mu          sync.Mutex
+	closed      bool
+	jobs        map[string]*models.JobStatus
+	deadLetters map[string]models.DeadLetter
+	// done are the receipts whose job is done or dead-lettered in the order they finished,
+	// their jobs are dropped once they are older than cfg.JobRetention
+	done []string
+}
+
+func newAsyncProcessor(srv *ReceiptService, cfg config.ProcessingConfig) *AsyncProcessor {
+	return &AsyncProcessor{
+		srv:         srv,
+		cfg:         cfg,
+		slots:       make(chan struct{}, cfg.QueueSize),
+		queue:       make(chan string, cfg.QueueSize),
+		stop:        make(chan struct{}),
+		jobs:        map[string]*models.JobStatus{},
+		deadLetters: map[string]models.DeadLetter{},
+	}
+}
+
+func (p *AsyncProcessor) start() {
+	for i := 0; i < p.cfg.Workers; i++ {
+		p.wg.Add(1)
+		go p.work()
+	}
+}
+
+// Close stops the workers once every queued receipt had an attempt, receipts waiting to retry are dead-lettered
+func (p *AsyncProcessor) Close() {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.closed = true
+	close(p.stop)
+	close(p.queue)
+	p.mu.Unlock()
+
+	p.wg.Wait()
+}
+
+// Enqueue stores the receipt as received and queues it for scoring
+func (p *AsyncProcessor) Enqueue(submitter Submitter, receipt models.Receipt) (string, error) {
+	if !p.reserve() {
+		return "", ErrQueueFull
+	}
+
+	receiptId, err := p.srv.db.CreateReceipt(newReceiptRecord(submitter, receipt))
+	if err != nil {
+		<-p.slots
+		return "", err
+	}
+
+	if err := p.push(receiptId); err != nil {
+		<-p.slots
+		// nothing will ever score the receipt, don't leave it behind as received
+		if deleteErr := p.srv.db.DeleteReceipt(receiptId); deleteErr != nil {
+			slog.Warn("Unable to delete unqueued receipt", slog.String("id", receiptId), u.ErrLog(deleteErr))
+		}
+		return "", err
+	}
+	return receiptId, nil
+}
+
+// Status returns the job of the receipt, ok is false if it wasn't processed asynchronously
+func (p *AsyncProcessor) Status(receiptId string) (models.JobStatus, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	job, ok := p.jobs[receiptId]
+	if ok {
+		return *job, true
+	}
+	// the job of a dead letter may be dropped already, the letter still tells how it ended
+	if letter, ok := p.deadLetters[receiptId]; ok {
+		return models.JobStatus{
+			Id:        receiptId,
+			Job:       models.JobDeadLettered,
+			Attempts:  letter.Attempts,
+			LastError: letter.Error,
+			UpdatedAt: letter.FailedAt,
+		}, true
+	}
+	return models.JobStatus{}, false
+}
+
+// DeadLetters lists the receipts that failed every attempt, oldest first
+func (p *AsyncProcessor) DeadLetters() []models.DeadLetter {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	letters := make([]models.DeadLetter, 0, len(p.deadLetters))
+	for _, letter := range p.deadLetters {
+		letters = append(letters, letter)
+	}
+	sort.Slice(letters, func(i, j int) bool {
+		return letters[i].FailedAt.Before(letters[j].FailedAt)
+	})
+	return letters
+}
+
+// Retry moves a dead-lettered receipt back into the queue
+func (p *AsyncProcessor) Retry(receiptId string) error {
+	p.mu.Lock()
+	_, ok := p.deadLetters[receiptId]
+	p.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("no dead letter for receipt: %s", receiptId)
+	}
+
+	if !p.reserve() {
+		return ErrQueueFull
+	}
+	if err := p.push(receiptId); err != nil {
+		<-p.slots
+		return err
+	}
+
+	p.mu.Lock()
+	delete(p.deadLetters, receiptId)
+	p.mu.Unlock()
+	return nil
+}
+
+func (p *AsyncProcessor) reserve() bool {
+	select {
+	case p.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// push queues a receipt that holds a slot, which never blocks as the queue is as large as the slots
+func (p *AsyncProcessor) push(receiptId string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return fmt.Errorf("processor is closed")
+	}
+	p.jobs[receiptId] = &models.JobStatus{Id: receiptId, Job: models.JobQueued, UpdatedAt: time.Now()}
+	p.queue <- receiptId
+	return nil
+}
+
+func (p *AsyncProcessor) work() {
+	defer p.wg.Done()
+	for receiptId := range p.queue {
+		p.run(receiptId)
+		<-p.slots
+	}
+}
+
+func (p *AsyncProcessor) run(receiptId string) {
+	backoff := p.cfg.RetryBackoff.Duration
+	for attempt := 1; ; attempt++ {
+		p.update(receiptId, models.JobProcessing, attempt, nil)
+
+		err := p.attempt(receiptId)
+		if err == nil {
+			p.update(receiptId, models.JobDone, attempt, nil)
+			p.finish(receiptId)
+			return
+		}
+
+		slog.Warn("Unable to process receipt", slog.String("id", receiptId), slog.Int("attempt", attempt), u.ErrLog(err))
+		if attempt >= p.cfg.MaxAttempts {
+			p.deadLetter(receiptId, attempt, err)
+			return
+		}
+
+		p.update(receiptId, models.JobRetrying, attempt, err)
+		select {
+		case <-time.After(backoff):
+			backoff *= 2
+		case <-p.stop:
+			p.deadLetter(receiptId, attempt, fmt.Errorf("processor stopped while retrying: %v", err))
+			return
+		}
+	}
+}
+
+// attempt scores the receipt and stores the result, a rejected receipt is a final outcome and not a failure
+func (p *AsyncProcessor) attempt(receiptId string) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic while processing receipt: %v", r)
+		}
+	}()
+
+	record, err := p.srv.db.GetReceiptById(receiptId)
+	if err != nil {
+		return err
+	}
+	if record.Status != models.StatusReceived {
+		return nil // processed by an earlier attempt
+	}
+
+	if err := p.srv.process(&record, processOpts{}); err != nil && !errors.Is(err, ErrReceiptRejected) {
+		return err
+	}
+	if err := p.srv.db.UpdateReceipt(record); err != nil {
+		return err
+	}
+	p.srv.settle(record)
+	return nil
+}
+
+func (p *AsyncProcessor) update(receiptId, state string, attempts int, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	job := p.jobs[receiptId]
+	job.Job = state
+	job.Attempts = attempts
+	job.UpdatedAt = time.Now()
+	if err != nil {
+		job.LastError = err.Error()
+	}
+}
+
+// finish records that the receipt's job is over and drops the jobs that ended longer than the retention ago.
+// A job retried since it was dead-lettered is no longer over, its entry is skipped until it ends again
+func (p *AsyncProcessor) finish(receiptId string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.done = append(p.done, receiptId)
+	cutoff := time.Now().Add(-p.cfg.JobRetention.Duration)
+	for len(p.done) > 0 {
+		job, ok := p.jobs[p.done[0]]
+		if ok && (job.Job == models.JobDone || job.Job == models.JobDeadLettered) {
+			if job.UpdatedAt.After(cutoff) {
+				break
+			}
+			delete(p.jobs, p.done[0])
+		}
+		p.done = p.done[1:]
+	}
+}
+
+func (p *AsyncProcessor) deadLetter(receiptId string, attempts int, err error) {
+	p.update(receiptId, models.JobDeadLettered, attempts, err)
+
+	p.mu.Lock()
+	p.deadLetters[receiptId] = models.DeadLetter{
+		ReceiptId: receiptId,
+		Attempts:  attempts,
+		Error:     err.Error(),
+		FailedAt:  time.Now(),
+	}
+	p.mu.Unlock()
+
+	p.finish(receiptId)
+}
@@ -0,0 +1,205 @@
+package service
+
+import (
+	"errors"
+	"github.com/RA341/receipt-processor-challenge/config"
+	"github.com/RA341/receipt-processor-challenge/models"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flakyDB fails the first failures updates
+type flakyDB struct {
+	*FranklyWeHaveNoIdeaWhereYourDataIsDB
+	failures atomic.Int64
+}
+
+func (f *flakyDB) UpdateReceipt(record models.ReceiptRecord) error {
+	if f.failures.Add(-1) >= 0 {
+		return errors.New("database unavailable")
+	}
+	return f.FranklyWeHaveNoIdeaWhereYourDataIsDB.UpdateReceipt(record)
+}
+
+func newAsyncTestService(failures int64, backoff time.Duration, queueSize int) (*ReceiptService, *flakyDB) {
+	memory, _ := NewDB()
+	db := &flakyDB{FranklyWeHaveNoIdeaWhereYourDataIsDB: memory}
+	db.failures.Store(failures)
+
+	cfg := config.Default().Processing
+	cfg.Workers = 1
+	cfg.QueueSize = queueSize
+	cfg.RetryBackoff = config.Duration{Duration: backoff}
+	return NewReceiptService(db, WithAsyncProcessing(cfg)), db
+}
+
+func waitForJob(t *testing.T, srv *ReceiptService, id string, state string) models.JobStatus {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		job, err := srv.GetJobStatus(id)
+		if err != nil {
+			t.Fatalf("Failed to get job status: %v", err)
+		}
+		if job.Job == state {
+			return job
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("Timed out waiting for the job to be %s", state)
+	return models.JobStatus{}
+}
+
+func TestAsyncProcessor_RetriesThenScores(t *testing.T) {
+	srv, _ := newAsyncTestService(2, time.Millisecond, 10)
+	defer srv.Close()
+
+	id, err := srv.EnqueueReceipt(Submitter{}, testMap["test 1"].receipt)
+	if err != nil {
+		t.Fatalf("Failed to enqueue receipt: %v", err)
+	}
+
+	job := waitForJob(t, srv, id, models.JobDone)
+	if job.Attempts != 3 || job.Status != models.StatusCredited || job.LastError == "" {
+		t.Fatalf("Expected the receipt to be credited on the third attempt, got %+v", job)
+	}
+
+	points, err := srv.GetPointsById(id)
+	if err != nil || points != testMap["test 1"].expectedPoints {
+		t.Fatalf("Expected %d points but got %d (%v)", testMap["test 1"].expectedPoints, points, err)
+	}
+}
+
+func TestAsyncProcessor_DeadLetter(t *testing.T) {
+	srv, db := newAsyncTestService(3, time.Millisecond, 10)
+	defer srv.Close()
+
+	id, err := srv.EnqueueReceipt(Submitter{}, testMap["test 2"].receipt)
+	if err != nil {
+		t.Fatalf("Failed to enqueue receipt: %v", err)
+	}
+
+	job := waitForJob(t, srv, id, models.JobDeadLettered)
+	if job.Status != models.StatusReceived {
+		t.Fatalf("Expected the dead-lettered receipt to stay received, got %+v", job)
+	}
+	if letters := srv.ListDeadLetters(); len(letters) != 1 || letters[0].ReceiptId != id {
+		t.Fatalf("Expected the receipt in the dead-letter store, got %+v", letters)
+	}
+
+	db.failures.Store(0)
+	if err := srv.RetryDeadLetter(id); err != nil {
+		t.Fatalf("Failed to retry dead letter: %v", err)
+	}
+	if job := waitForJob(t, srv, id, models.JobDone); job.Status != models.StatusCredited {
+		t.Fatalf("Expected the retried receipt to be credited, got %+v", job)
+	}
+	if letters := srv.ListDeadLetters(); len(letters) != 0 {
+		t.Fatalf("Expected the dead-letter store to be empty, got %+v", letters)
+	}
+}
+
+func TestAsyncProcessor_QueueFull(t *testing.T) {
+	// the first receipt keeps its slot while waiting to retry
+	srv, _ := newAsyncTestService(1, time.Hour, 1)
+
+	id, err := srv.EnqueueReceipt(Submitter{}, testMap["test 1"].receipt)
+	if err != nil {
+		t.Fatalf("Failed to enqueue receipt: %v", err)
+	}
+	waitForJob(t, srv, id, models.JobRetrying)
+
+	if _, err := srv.EnqueueReceipt(Submitter{}, testMap["test 2"].receipt); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("Expected the queue to be full, got %v", err)
+	}
+
+	srv.Close()
+	if job, _ := srv.GetJobStatus(id); job.Job != models.JobDeadLettered {
+		t.Fatalf("Expected closing to dead-letter the waiting receipt, got %+v", job)
+	}
+}
+
+func TestAsyncProcessor_DeadLetterRetention(t *testing.T) {
+	memory, _ := NewDB()
+	db := &flakyDB{FranklyWeHaveNoIdeaWhereYourDataIsDB: memory}
+	db.failures.Store(3)
+
+	cfg := config.Default().Processing
+	cfg.Workers = 1
+	cfg.RetryBackoff = config.Duration{Duration: time.Millisecond}
+	cfg.JobRetention = config.Duration{}
+	srv := NewReceiptService(db, WithAsyncProcessing(cfg))
+	defer srv.Close()
+
+	id, err := srv.EnqueueReceipt(Submitter{}, testMap["test 2"].receipt)
+	if err != nil {
+		t.Fatalf("Failed to enqueue receipt: %v", err)
+	}
+
+	// the job is dropped once dead-lettered, the status comes from the dead letter
+	job := waitForJob(t, srv, id, models.JobDeadLettered)
+	if job.Attempts != cfg.MaxAttempts || job.LastError == "" {
+		t.Fatalf("Expected the status of the dead letter, got %+v", job)
+	}
+	srv.async.mu.Lock()
+	jobs := len(srv.async.jobs)
+	srv.async.mu.Unlock()
+	if jobs != 0 {
+		t.Fatalf("Expected the dead-lettered job to be pruned, %d jobs are kept", jobs)
+	}
+}
+
+func TestAsyncProcessor_EnqueueAfterClose(t *testing.T) {
+	srv, db := newAsyncTestService(0, time.Millisecond, 10)
+	srv.Close()
+
+	if _, err := srv.EnqueueReceipt(Submitter{}, testMap["test 1"].receipt); err == nil {
+		t.Fatalf("Expected enqueueing on a closed processor to fail")
+	}
+	if page, _ := db.ListReceipts(ReceiptQuery{}); len(page.Receipts) != 0 {
+		t.Fatalf("Expected the unqueued receipt to be deleted, got %+v", page.Receipts)
+	}
+}
+
+func TestAsyncProcessor_RetriesApplyOnce(t *testing.T) {
+	memory, _ := NewDB()
+	db := &flakyDB{FranklyWeHaveNoIdeaWhereYourDataIsDB: memory}
+	db.failures.Store(2)
+
+	campaigns := NewCampaignService()
+	capped := march2022
+	capped.MemberCap = 150
+	mustCreateCampaign(t, campaigns, capped)
+
+	// one receipt per member and retailer an hour, a retry counting again would hold the receipt
+	fraud := newTestFraudChecker(time.Date(2022, 3, 21, 12, 0, 0, 0, time.Local))
+	fraud.cfg.Velocity = []config.VelocityRuleConfig{{Key: "member", Max: 1, Window: config.Duration{Duration: time.Hour}, Weight: 1}}
+
+	cfg := config.Default().Processing
+	cfg.Workers = 1
+	cfg.RetryBackoff = config.Duration{Duration: time.Millisecond}
+	cfg.JobRetention = config.Duration{}
+	srv := NewReceiptService(db, WithAsyncProcessing(cfg), WithCampaigns(campaigns), WithFraudChecker(fraud))
+	defer srv.Close()
+
+	receipt := testMap["test 2"].receipt
+	receipt.MemberId = "member-1"
+	id, err := srv.EnqueueReceipt(Submitter{}, receipt)
+	if err != nil {
+		t.Fatalf("Failed to enqueue receipt: %v", err)
+	}
+
+	// the job is dropped as soon as it is done, the status falls back to the receipt's
+	job := waitForJob(t, srv, id, models.JobDone)
+	if job.Status != models.StatusCredited || job.Attempts != 1 || job.LastError != "" {
+		t.Fatalf("Expected the receipt to be credited and its job dropped, got %+v", job)
+	}
+
+	record, _ := srv.GetReceiptById(id)
+	if record.Points != testMap["test 2"].expectedPoints+100 {
+		t.Fatalf("Expected the campaign bonus once, got %d points", record.Points)
+	}
+	if got := sumBreakdown(campaigns.Apply("next", &receipt, 0)); got != 50 {
+		t.Fatalf("Expected the retries to use 100 of the cap, %d points are left", got)
+	}
+}
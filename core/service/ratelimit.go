@@ -0,0 +1,116 @@
+package service
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// RateLimit is a token bucket refilling Requests tokens every Per, holding at most Burst tokens
+type RateLimit struct {
+	Requests int64
+	Per      time.Duration
+	Burst    int64
+}
+
+func (l RateLimit) capacity() float64 {
+	if l.Burst > 0 {
+		return float64(l.Burst)
+	}
+	return float64(l.Requests)
+}
+
+// tokensPerSecond is the refill rate of the bucket
+func (l RateLimit) tokensPerSecond() float64 {
+	return float64(l.Requests) / l.Per.Seconds()
+}
+
+type RateLimitResult struct {
+	Allowed   bool
+	Limit     int64
+	Remaining int64
+	// RetryAfter is how long until the next token is available, zero if the request was allowed
+	RetryAfter time.Duration
+	// Reset is how long until the bucket is full again
+	Reset time.Duration
+}
+
+// RateLimitStore holds the token buckets, implement it on a shared store such as
+// redis to enforce limits across several instances
+type RateLimitStore interface {
+	// Take removes a token from the bucket for key, a missing bucket starts out full
+	Take(key string, limit RateLimit) (RateLimitResult, error)
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+	// fullAt is when the bucket will have refilled completely
+	fullAt time.Time
+}
+
+// MemoryRateLimitStore keeps the buckets in process
+type MemoryRateLimitStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// rateLimitSweepInterval is how often buckets that refilled completely are dropped
+const rateLimitSweepInterval = time.Minute
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{
+		buckets: map[string]*tokenBucket{},
+		now:     time.Now,
+	}
+}
+
+func (m *MemoryRateLimitStore) Take(key string, limit RateLimit) (RateLimitResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	capacity := limit.capacity()
+	rate := limit.tokensPerSecond()
+
+	bucket, ok := m.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: capacity, updated: now}
+		m.buckets[key] = bucket
+	}
+	bucket.tokens = math.Min(capacity, bucket.tokens+now.Sub(bucket.updated).Seconds()*rate)
+	bucket.updated = now
+
+	result := RateLimitResult{Limit: int64(capacity)}
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = secondsToDuration((1 - bucket.tokens) / rate)
+	}
+	result.Remaining = int64(bucket.tokens)
+	result.Reset = secondsToDuration((capacity - bucket.tokens) / rate)
+	bucket.fullAt = now.Add(result.Reset)
+
+	if now.Sub(m.lastSweep) > rateLimitSweepInterval {
+		m.sweep(now)
+	}
+
+	return result, nil
+}
+
+// sweep drops buckets that would be full by now, they behave the same as a missing bucket
+func (m *MemoryRateLimitStore) sweep(now time.Time) {
+	m.lastSweep = now
+	for key, bucket := range m.buckets {
+		if now.After(bucket.fullAt) {
+			delete(m.buckets, key)
+		}
+	}
+}
+
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(math.Ceil(seconds * float64(time.Second)))
+}
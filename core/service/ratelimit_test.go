@@ -0,0 +1,56 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryRateLimitStore_Take(t *testing.T) {
+	store := NewMemoryRateLimitStore()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	store.now = func() time.Time { return now }
+
+	limit := RateLimit{Requests: 60, Per: time.Minute, Burst: 3}
+	for i := 0; i < 3; i++ {
+		result, _ := store.Take("ip:1", limit)
+		if !result.Allowed || result.Remaining != int64(2-i) {
+			t.Fatalf("Request %d: expected to be allowed with %d remaining but got %+v", i+1, 2-i, result)
+		}
+	}
+
+	result, _ := store.Take("ip:1", limit)
+	if result.Allowed || result.RetryAfter != time.Second {
+		t.Fatalf("Expected the burst to be used up with a 1s retry but got %+v", result)
+	}
+
+	if other, _ := store.Take("ip:2", limit); !other.Allowed {
+		t.Fatalf("Expected buckets to be independent per key")
+	}
+
+	now = now.Add(time.Second)
+	if result, _ := store.Take("ip:1", limit); !result.Allowed {
+		t.Fatalf("Expected a token to be refilled after a second but got %+v", result)
+	}
+}
+
+func TestMemoryRateLimitStore_Sweep(t *testing.T) {
+	store := NewMemoryRateLimitStore()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	store.now = func() time.Time { return now }
+
+	daily := RateLimit{Requests: 1, Per: 24 * time.Hour}
+	store.Take("member:1", daily)
+
+	// the sweep must not reset a bucket that is still refilling
+	now = now.Add(2 * time.Hour)
+	store.Take("member:2", daily)
+	if result, _ := store.Take("member:1", daily); result.Allowed {
+		t.Fatalf("Expected the daily limit to still apply after a sweep")
+	}
+
+	now = now.Add(25 * time.Hour)
+	store.Take("member:3", daily)
+	if _, ok := store.buckets["member:2"]; ok {
+		t.Fatalf("Expected refilled buckets to be swept")
+	}
+}
@@ -0,0 +1,251 @@
+package service
+
+import (
+	"encoding/base64"
+	"fmt"
+	"github.com/RA341/receipt-processor-challenge/models"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	SortCreatedAt   = "createdAt"
+	SortPurchasedAt = "purchasedAt"
+	SortTotal       = "total"
+	SortPoints      = "points"
+)
+
+const (
+	DefaultListLimit = 50
+	MaxListLimit     = 500
+)
+
+var receiptSortFields = []string{SortCreatedAt, SortPurchasedAt, SortTotal, SortPoints}
+
+// ReceiptQuery filters and sorts stored receipts, empty fields match everything
+type ReceiptQuery struct {
+	// Retailer matches the canonical retailer name, ignoring case
+	Retailer string
+	MemberId string
+	Status   string
+	// PurchasedFrom and PurchasedTo bound the purchase date as YYYY-MM-DD, both inclusive
+	PurchasedFrom string
+	PurchasedTo   string
+	// MinTotal and MaxTotal are in cents
+	MinTotal  *int64
+	MaxTotal  *int64
+	MinPoints *int64
+	MaxPoints *int64
+
+	// Sort is one of SortCreatedAt (the default), SortPurchasedAt, SortTotal or SortPoints
+	Sort       string
+	Descending bool
+	Limit      int
+	// Cursor is the NextCursor of the previous page, it only works with the same sort
+	Cursor string
+}
+
+type ReceiptPage struct {
+	Receipts []models.ReceiptRecord
+	// NextCursor is empty on the last page
+	NextCursor string
+}
+
+// normalized fills in the defaults and checks the query
+func (q ReceiptQuery) normalized() (ReceiptQuery, error) {
+	if q.Sort == "" {
+		q.Sort = SortCreatedAt
+	}
+	if !slices.Contains(receiptSortFields, q.Sort) {
+		return q, fmt.Errorf("sort must be one of %s", strings.Join(receiptSortFields, ", "))
+	}
+
+	if q.Limit == 0 {
+		q.Limit = DefaultListLimit
+	}
+	if q.Limit < 0 || q.Limit > MaxListLimit {
+		return q, fmt.Errorf("limit must be between 1 and %d", MaxListLimit)
+	}
+
+	for _, date := range []string{q.PurchasedFrom, q.PurchasedTo} {
+		if _, err := time.Parse(dateLayout, date); date != "" && err != nil {
+			return q, fmt.Errorf("purchase dates must be YYYY-MM-DD")
+		}
+	}
+	return q, nil
+}
+
+// matches applies every filter to the record
+func (q ReceiptQuery) matches(record models.ReceiptRecord) bool {
+	receipt := record.Receipt
+	if q.Retailer != "" && !strings.EqualFold(receipt.Retailer, q.Retailer) ||
+		q.MemberId != "" && receipt.MemberId != q.MemberId ||
+		q.Status != "" && record.Status != q.Status ||
+		q.PurchasedFrom != "" && receipt.PurchaseDate < q.PurchasedFrom ||
+		q.PurchasedTo != "" && receipt.PurchaseDate > q.PurchasedTo {
+		return false
+	}
+
+	total, _ := parseCents(receipt.Total)
+	return inRange(total, q.MinTotal, q.MaxTotal) && inRange(record.Points, q.MinPoints, q.MaxPoints)
+}
+
+func inRange(value int64, low, high *int64) bool {
+	return (low == nil || value >= *low) && (high == nil || value <= *high)
+}
+
+// keyRange returns the bounds the query puts on the sort key of the field, ok is false if it has none
+func (q ReceiptQuery) keyRange(field string) (low, high int64, ok bool) {
+	low, high = math.MinInt64, math.MaxInt64
+	var lowBound, highBound *int64
+	switch field {
+	case SortTotal:
+		lowBound, highBound = q.MinTotal, q.MaxTotal
+	case SortPoints:
+		lowBound, highBound = q.MinPoints, q.MaxPoints
+	case SortPurchasedAt:
+		if from, err := time.Parse(dateLayout, q.PurchasedFrom); err == nil {
+			start := from.Unix()
+			lowBound = &start
+		}
+		if to, err := time.Parse(dateLayout, q.PurchasedTo); err == nil {
+			end := to.AddDate(0, 0, 1).Unix() - 1
+			highBound = &end
+		}
+	}
+
+	if lowBound != nil {
+		low = *lowBound
+	}
+	if highBound != nil {
+		high = *highBound
+	}
+	return low, high, lowBound != nil || highBound != nil
+}
+
+// encodeCursor makes an opaque cursor pointing after the entry
+func (q ReceiptQuery) encodeCursor(entry indexEntry) string {
+	raw := fmt.Sprintf("%s,%t,%d,%d", q.Sort, q.Descending, entry.key, entry.seq)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// decodeCursor returns the entry the cursor points after, nil without a cursor
+func (q ReceiptQuery) decodeCursor() (*indexEntry, error) {
+	if q.Cursor == "" {
+		return nil, nil
+	}
+
+	invalid := fmt.Errorf("invalid cursor")
+	raw, err := base64.RawURLEncoding.DecodeString(q.Cursor)
+	if err != nil {
+		return nil, invalid
+	}
+	parts := strings.Split(string(raw), ",")
+	if len(parts) != 4 {
+		return nil, invalid
+	}
+	if parts[0] != q.Sort || parts[1] != strconv.FormatBool(q.Descending) {
+		return nil, fmt.Errorf("cursor was made with another sort order")
+	}
+
+	key, keyErr := strconv.ParseInt(parts[2], 10, 64)
+	seq, seqErr := strconv.ParseInt(parts[3], 10, 64)
+	if keyErr != nil || seqErr != nil {
+		return nil, invalid
+	}
+	return &indexEntry{key: key, seq: seq}, nil
+}
+
+// sortKey is the value the receipt is sorted by for the field
+func sortKey(field string, stored *storedReceipt) int64 {
+	switch field {
+	case SortPurchasedAt:
+		purchasedAt, err := purchaseTimestamp(&stored.record.Receipt)
+		if err != nil {
+			return 0
+		}
+		return purchasedAt.Unix()
+	case SortTotal:
+		total, _ := parseCents(stored.record.Receipt.Total)
+		return total
+	case SortPoints:
+		return stored.record.Points
+	default:
+		return stored.seq
+	}
+}
+
+type indexEntry struct {
+	key int64
+	seq int64
+	id  string
+}
+
+func (e indexEntry) less(other indexEntry) bool {
+	return e.key < other.key || (e.key == other.key && e.seq < other.seq)
+}
+
+// follows reports whether e comes after other in the sort direction
+func (e indexEntry) follows(other indexEntry, descending bool) bool {
+	if descending {
+		return e.less(other)
+	}
+	return other.less(e)
+}
+
+// sortedIndex keeps entries ordered by key, then insertion order
+type sortedIndex struct {
+	entries []indexEntry
+}
+
+// search returns the position of the first entry not before target
+func (s *sortedIndex) search(target indexEntry) int {
+	return sort.Search(len(s.entries), func(i int) bool {
+		return !s.entries[i].less(target)
+	})
+}
+
+func (s *sortedIndex) insert(entry indexEntry) {
+	s.entries = slices.Insert(s.entries, s.search(entry), entry)
+}
+
+func (s *sortedIndex) remove(entry indexEntry) {
+	i := s.search(entry)
+	if i < len(s.entries) && s.entries[i].seq == entry.seq {
+		s.entries = slices.Delete(s.entries, i, i+1)
+	}
+}
+
+// walk calls visit on the entries after start in the direction until visit returns false,
+// a nil start walks from the beginning
+func (s *sortedIndex) walk(start *indexEntry, descending bool, visit func(entry indexEntry) bool) {
+	if descending {
+		i := len(s.entries) - 1
+		if start != nil {
+			i = s.search(*start) - 1
+		}
+		for ; i >= 0; i-- {
+			if !visit(s.entries[i]) {
+				return
+			}
+		}
+		return
+	}
+
+	i := 0
+	if start != nil {
+		i = s.search(*start)
+		if i < len(s.entries) && s.entries[i].key == start.key && s.entries[i].seq == start.seq {
+			i++
+		}
+	}
+	for ; i < len(s.entries); i++ {
+		if !visit(s.entries[i]) {
+			return
+		}
+	}
+}
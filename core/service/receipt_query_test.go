@@ -0,0 +1,162 @@
+package service
+
+import (
+	"fmt"
+	"github.com/RA341/receipt-processor-challenge/models"
+	"sort"
+	"testing"
+)
+
+func newListTestDB(t *testing.T) (*FranklyWeHaveNoIdeaWhereYourDataIsDB, []models.ReceiptRecord) {
+	db, _ := NewDB()
+	retailers := []string{"Target", "Walgreens", "M&M Corner Market"}
+
+	var records []models.ReceiptRecord
+	for i := 0; i < 60; i++ {
+		record := models.ReceiptRecord{
+			Receipt: models.Receipt{
+				Retailer:     retailers[i%len(retailers)],
+				PurchaseDate: fmt.Sprintf("2022-01-%02d", i%28+1),
+				PurchaseTime: "13:01",
+				// repeat totals and points so ties are broken by insertion order
+				Total:    fmt.Sprintf("%d.%02d", i%10, i%4*25),
+				MemberId: fmt.Sprintf("member-%d", i%4),
+			},
+			Points: int64(i % 7 * 10),
+			Status: models.StatusCredited,
+		}
+		if i%5 == 0 {
+			record.Status = models.StatusPendingReview
+		}
+
+		id, err := db.CreateReceipt(record)
+		if err != nil {
+			t.Fatalf("Failed to create receipt: %v", err)
+		}
+		record.Id = id
+		records = append(records, record)
+	}
+	return db, records
+}
+
+// listAll follows the cursors until the last page
+func listAll(t *testing.T, db Database, query ReceiptQuery) []string {
+	var ids []string
+	for pages := 0; ; pages++ {
+		page, err := db.ListReceipts(query)
+		if err != nil {
+			t.Fatalf("Failed to list receipts: %v", err)
+		}
+		if pages > 100 {
+			t.Fatalf("Cursor did not advance")
+		}
+		for _, record := range page.Receipts {
+			ids = append(ids, record.Id)
+		}
+		if page.NextCursor == "" {
+			return ids
+		}
+		query.Cursor = page.NextCursor
+	}
+}
+
+func TestFranklyWeHaveNoIdeaWhereYourDataIsDB_ListReceipts(t *testing.T) {
+	db, records := newListTestDB(t)
+
+	minTotal, maxTotal := int64(200), int64(650)
+	minPoints := int64(20)
+	queries := map[string]ReceiptQuery{
+		"everything":         {},
+		"by retailer":        {Retailer: "walgreens"},
+		"by member desc":     {MemberId: "member-1", Sort: SortPoints, Descending: true},
+		"by status":          {Status: models.StatusPendingReview, Sort: SortTotal},
+		"total range":        {MinTotal: &minTotal, MaxTotal: &maxTotal, Sort: SortTotal},
+		"total range desc":   {MinTotal: &minTotal, MaxTotal: &maxTotal, Sort: SortTotal, Descending: true},
+		"points over dates":  {MinPoints: &minPoints, PurchasedFrom: "2022-01-05", PurchasedTo: "2022-01-20", Sort: SortPurchasedAt},
+		"dates desc":         {PurchasedFrom: "2022-01-05", PurchasedTo: "2022-01-20", Sort: SortPurchasedAt, Descending: true},
+		"combined filters":   {Retailer: "Target", MemberId: "member-2", MinPoints: &minPoints},
+		"newest first":       {Descending: true},
+		"points unfiltered":  {Sort: SortPoints},
+		"nothing matches":    {Retailer: "Costco"},
+		"empty total range":  {MinTotal: &maxTotal, MaxTotal: &minTotal, Sort: SortTotal},
+		"date without match": {PurchasedFrom: "2023-01-01"},
+	}
+
+	for name, query := range queries {
+		t.Run(name, func(t *testing.T) {
+			normalized, _ := query.normalized()
+			var expected []*storedReceipt
+			for i, record := range records {
+				if normalized.matches(record) {
+					expected = append(expected, &storedReceipt{record: record, seq: int64(i + 1)})
+				}
+			}
+			sort.SliceStable(expected, func(i, j int) bool {
+				a := indexEntry{key: sortKey(normalized.Sort, expected[i]), seq: expected[i].seq}
+				b := indexEntry{key: sortKey(normalized.Sort, expected[j]), seq: expected[j].seq}
+				return b.follows(a, normalized.Descending)
+			})
+
+			query.Limit = 7
+			ids := listAll(t, db, query)
+			if len(ids) != len(expected) {
+				t.Fatalf("Expected %d receipts, got %d", len(expected), len(ids))
+			}
+			for i, stored := range expected {
+				if ids[i] != stored.record.Id {
+					t.Fatalf("Receipt %d out of order, expected %s got %s", i, stored.record.Id, ids[i])
+				}
+			}
+		})
+	}
+}
+
+func TestFranklyWeHaveNoIdeaWhereYourDataIsDB_ListReceipts_Errors(t *testing.T) {
+	db, _ := newListTestDB(t)
+
+	page, err := db.ListReceipts(ReceiptQuery{Limit: 5, Sort: SortTotal})
+	if err != nil {
+		t.Fatalf("Failed to list receipts: %v", err)
+	}
+
+	invalid := map[string]ReceiptQuery{
+		"unknown sort":      {Sort: "retailer"},
+		"limit too large":   {Limit: MaxListLimit + 1},
+		"bad date":          {PurchasedFrom: "01-05-2022"},
+		"garbage cursor":    {Cursor: "not a cursor"},
+		"cursor of a sort":  {Cursor: page.NextCursor, Sort: SortPoints},
+		"cursor of a order": {Cursor: page.NextCursor, Sort: SortTotal, Descending: true},
+	}
+	for name, query := range invalid {
+		if _, err := db.ListReceipts(query); err == nil {
+			t.Fatalf("Expected %s to fail", name)
+		}
+	}
+}
+
+func TestFranklyWeHaveNoIdeaWhereYourDataIsDB_ListReceipts_Reindexes(t *testing.T) {
+	db, records := newListTestDB(t)
+
+	record := records[3]
+	record.Status = models.StatusVoided
+	record.Points = 1000
+	if err := db.UpdateReceipt(record); err != nil {
+		t.Fatalf("Failed to update receipt: %v", err)
+	}
+
+	page, _ := db.ListReceipts(ReceiptQuery{Status: models.StatusVoided})
+	if len(page.Receipts) != 1 || page.Receipts[0].Id != record.Id {
+		t.Fatalf("Expected the updated receipt under its new status, got %+v", page.Receipts)
+	}
+
+	page, _ = db.ListReceipts(ReceiptQuery{Sort: SortPoints, Descending: true, Limit: 1})
+	if page.Receipts[0].Id != record.Id {
+		t.Fatalf("Expected the updated receipt to sort by its new points, got %s", page.Receipts[0].Id)
+	}
+
+	minPoints := int64(1000)
+	page, _ = db.ListReceipts(ReceiptQuery{Status: models.StatusCredited, MinPoints: &minPoints})
+	if len(page.Receipts) != 0 {
+		t.Fatalf("Expected the updated receipt to leave its old status, got %+v", page.Receipts)
+	}
+}
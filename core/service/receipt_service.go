@@ -1,31 +1,586 @@
 package service
 
-import "github.com/RA341/receipt-processor-challenge/models"
-
-var ()
+import (
+	"errors"
+	"fmt"
+	"github.com/RA341/receipt-processor-challenge/config"
+	"github.com/RA341/receipt-processor-challenge/models"
+	"github.com/google/uuid"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
 
 type ReceiptService struct {
 	db Database
+	// rules are the live rules, the rule admin api changes them while receipts are scored
+	rules *RuleRegistry
+	// experiment is nil unless an A/B experiment splits the submissions between rule sets
+	experiment  *Experiment
+	tiers       *TierService
+	campaigns   *CampaignService
+	retailers   *RetailerCatalog
+	categorizer *Categorizer
+	// fraud is nil when fraud checks are disabled
+	fraud    *FraudChecker
+	reviews  *ReviewQueue
+	events   *EventBus
+	webhooks *WebhookDispatcher
+	reports  *ReportStore
+	// async is nil unless receipts are processed asynchronously
+	async *AsyncProcessor
+	// voidMu serializes voids so concurrent voids can't take back the same points twice
+	voidMu sync.Mutex
+	// scoreCanonicalName runs the rules on the catalog name instead of the raw retailer name
+	scoreCanonicalName bool
+}
+
+type ServiceOpt func(s *ReceiptService)
+
+// WithTiers overrides the tier service built from the active config
+func WithTiers(tiers *TierService) ServiceOpt {
+	return func(s *ReceiptService) {
+		s.tiers = tiers
+	}
+}
+
+// WithRules scores receipts with the rules instead of the default ones
+func WithRules(rules *Rules) ServiceOpt {
+	return func(s *ReceiptService) {
+		s.rules = NewRuleRegistry(rules)
+	}
+}
+
+// WithRuleRegistry scores receipts with the registry's live rules, see LoadRuleRegistry
+func WithRuleRegistry(registry *RuleRegistry) ServiceOpt {
+	return func(s *ReceiptService) {
+		s.rules = registry
+	}
+}
+
+// WithExperiment scores submissions with the rules of their experiment variant
+func WithExperiment(experiment *Experiment) ServiceOpt {
+	return func(s *ReceiptService) {
+		s.experiment = experiment
+	}
+}
+
+// WithCampaigns overrides the default empty campaign service
+func WithCampaigns(campaigns *CampaignService) ServiceOpt {
+	return func(s *ReceiptService) {
+		s.campaigns = campaigns
+	}
+}
+
+// WithRetailerCatalog overrides the default empty retailer catalog
+func WithRetailerCatalog(retailers *RetailerCatalog) ServiceOpt {
+	return func(s *ReceiptService) {
+		s.retailers = retailers
+	}
+}
+
+// WithCategorizer sets the item categorizer, by default the taxonomy is empty
+// and every item is uncategorized
+func WithCategorizer(categorizer *Categorizer) ServiceOpt {
+	return func(s *ReceiptService) {
+		s.categorizer = categorizer
+	}
+}
+
+// WithFraudChecker overrides the fraud checker built from the active config, nil disables the checks
+func WithFraudChecker(fraud *FraudChecker) ServiceOpt {
+	return func(s *ReceiptService) {
+		s.fraud = fraud
+	}
 }
 
-func NewReceiptService(db Database) *ReceiptService {
-	return &ReceiptService{db: db}
+// WithWebhookDispatcher overrides the webhook dispatcher built from the active config
+func WithWebhookDispatcher(webhooks *WebhookDispatcher) ServiceOpt {
+	return func(s *ReceiptService) {
+		s.webhooks = webhooks
+	}
+}
+
+// WithAsyncProcessing scores submitted receipts on a worker pool, call Close to stop the workers
+func WithAsyncProcessing(cfg config.ProcessingConfig) ServiceOpt {
+	return func(s *ReceiptService) {
+		s.async = newAsyncProcessor(s, cfg)
+	}
+}
+
+func NewReceiptService(db Database, opts ...ServiceOpt) *ReceiptService {
+	cfg := config.Get()
+	srv := &ReceiptService{
+		db:                 db,
+		rules:              NewRuleRegistry(DefaultRules()),
+		tiers:              NewTierService(cfg.Tiers),
+		campaigns:          NewCampaignService(),
+		retailers:          NewRetailerCatalog(cfg.Retailers.MatchThreshold),
+		categorizer:        &Categorizer{},
+		reviews:            NewReviewQueue(),
+		events:             NewEventBus(cfg.Events.ReplaySize),
+		reports:            NewReportStore(),
+		scoreCanonicalName: cfg.Retailers.ScoreCanonicalName,
+	}
+	if cfg.Fraud.Enabled {
+		srv.fraud = NewFraudChecker(cfg.Fraud)
+	}
+	if cfg.Processing.Async {
+		srv.async = newAsyncProcessor(srv, cfg.Processing)
+	}
+	for _, opt := range opts {
+		opt(srv)
+	}
+	if srv.webhooks == nil {
+		srv.webhooks = NewWebhookDispatcher(cfg.Webhooks)
+	}
+	srv.events.Subscribe(srv.webhooks.Handle)
+	if srv.async != nil {
+		srv.async.start()
+	}
+	return srv
 }
 
 func (s *ReceiptService) GetPointsById(transactionId string) (totalPoints int64, err error) {
-	return s.db.GetPointById(transactionId)
+	record, err := s.db.GetReceiptById(transactionId)
+	if err != nil {
+		return 0, err
+	}
+	return record.Points, nil
+}
+
+func (s *ReceiptService) GetReceiptById(transactionId string) (models.ReceiptRecord, error) {
+	return s.db.GetReceiptById(transactionId)
+}
+
+func (s *ReceiptService) ListReceipts(query ReceiptQuery) (ReceiptPage, error) {
+	return s.db.ListReceipts(query)
+}
+
+func (s *ReceiptService) GetMemberTier(memberId string) (models.MemberTierResponse, error) {
+	return s.tiers.Member(memberId)
+}
+
+func (s *ReceiptService) CreateCampaign(campaign models.Campaign) (models.Campaign, error) {
+	return s.campaigns.Create(campaign)
+}
+
+func (s *ReceiptService) ListCampaigns(activeAt time.Time) []models.Campaign {
+	return s.campaigns.List(activeAt)
+}
+
+func (s *ReceiptService) CreateRetailer(retailer models.Retailer) (models.Retailer, error) {
+	return s.retailers.Create(retailer)
+}
+
+func (s *ReceiptService) UpdateRetailer(retailer models.Retailer) (models.Retailer, error) {
+	return s.retailers.Update(retailer)
+}
+
+func (s *ReceiptService) DeleteRetailer(id string) error {
+	return s.retailers.Delete(id)
+}
+
+func (s *ReceiptService) GetRetailer(id string) (models.Retailer, error) {
+	return s.retailers.Get(id)
+}
+
+func (s *ReceiptService) ListRetailers() []models.Retailer {
+	return s.retailers.List()
+}
+
+var ErrReceiptRejected = errors.New("receipt rejected")
+
+// RejectedError is returned for a receipt that was stored as rejected because it can't be scored,
+// it matches ErrReceiptRejected and Id is the stored receipt
+type RejectedError struct {
+	Id  string
+	Err error
+}
+
+func (e *RejectedError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RejectedError) Unwrap() error {
+	return e.Err
+}
+
+// Submitter identifies who submitted a receipt
+type Submitter struct {
+	ClientId string
 }
 
 func (s *ReceiptService) NewReceipt(receipt models.Receipt) (transactionId string, err error) {
-	finalPoints := calculatePoints(
-		&receipt,
-		defaultPointRules...,
-	)
+	return s.SubmitReceipt(Submitter{}, receipt)
+}
 
-	pointId, err := s.db.CreatePoint(finalPoints)
+// SubmitReceipt scores and stores the receipt, attributing it to the submitter. A receipt that can't
+// be scored is stored as rejected and a *RejectedError carrying its id is returned
+func (s *ReceiptService) SubmitReceipt(submitter Submitter, receipt models.Receipt) (transactionId string, err error) {
+	record, err := s.submitRecord(newReceiptRecord(submitter, receipt), processOpts{})
 	if err != nil {
 		return "", err
 	}
+	return record.Id, nil
+}
+
+// submitRecord processes and stores a received record, returning it as stored
+func (s *ReceiptService) submitRecord(record models.ReceiptRecord, opts processOpts) (models.ReceiptRecord, error) {
+	processErr := s.process(&record, opts)
+
+	pointId, err := s.db.CreateReceipt(record)
+	if err != nil {
+		s.campaigns.Release(record.Id)
+		return models.ReceiptRecord{}, err
+	}
+	record.Id = pointId
+	s.settle(record)
+
+	if errors.Is(processErr, ErrReceiptRejected) {
+		return record, &RejectedError{Id: pointId, Err: processErr}
+	}
+	if processErr != nil {
+		return models.ReceiptRecord{}, processErr
+	}
+	return record, nil
+}
+
+// IsAsync reports whether receipts should be submitted with EnqueueReceipt
+func (s *ReceiptService) IsAsync() bool {
+	return s.async != nil
+}
+
+// EnqueueReceipt stores the receipt as received and scores it in the background,
+// it returns ErrQueueFull when too many receipts are waiting
+func (s *ReceiptService) EnqueueReceipt(submitter Submitter, receipt models.Receipt) (transactionId string, err error) {
+	if s.async == nil {
+		return "", ErrProcessorAsync
+	}
+	return s.async.Enqueue(submitter, receipt)
+}
+
+// GetJobStatus returns the processing state of the receipt
+func (s *ReceiptService) GetJobStatus(transactionId string) (models.JobStatus, error) {
+	record, err := s.db.GetReceiptById(transactionId)
+	if err != nil {
+		return models.JobStatus{}, err
+	}
 
-	return pointId, nil
+	job := models.JobStatus{Id: record.Id, Job: models.JobDone, Attempts: 1, UpdatedAt: record.CreatedAt}
+	if s.async != nil {
+		if asyncJob, ok := s.async.Status(transactionId); ok {
+			job = asyncJob
+		}
+	}
+	job.Status = record.Status
+	return job, nil
+}
+
+func (s *ReceiptService) ListDeadLetters() []models.DeadLetter {
+	if s.async == nil {
+		return []models.DeadLetter{}
+	}
+	return s.async.DeadLetters()
+}
+
+func (s *ReceiptService) RetryDeadLetter(transactionId string) error {
+	if s.async == nil {
+		return ErrProcessorAsync
+	}
+	return s.async.Retry(transactionId)
+}
+
+// Close stops the background workers once the queued receipts and webhook deliveries are done
+func (s *ReceiptService) Close() {
+	if s.async != nil {
+		s.async.Close()
+	}
+	s.webhooks.Close()
+}
+
+// Events is the bus receipt events are published on
+func (s *ReceiptService) Events() *EventBus {
+	return s.events
+}
+
+// Report aggregates the credited receipts, see ReportQuery
+func (s *ReceiptService) Report(query ReportQuery) ([]models.ReportBucket, error) {
+	return s.reports.Report(query)
+}
+
+func (s *ReceiptService) CreateWebhook(subscription models.WebhookSubscription) (models.WebhookSubscription, error) {
+	return s.webhooks.Create(subscription)
+}
+
+func (s *ReceiptService) ListWebhooks() []models.WebhookSubscription {
+	return s.webhooks.List()
+}
+
+func (s *ReceiptService) GetWebhook(id string) (models.WebhookSubscription, error) {
+	return s.webhooks.Get(id)
+}
+
+func (s *ReceiptService) DeleteWebhook(id string) error {
+	return s.webhooks.Delete(id)
+}
+
+func (s *ReceiptService) ListWebhookDeliveries(id string) ([]models.WebhookDelivery, error) {
+	return s.webhooks.Deliveries(id)
+}
+
+func (s *ReceiptService) RedeliverWebhook(id, deliveryId string) error {
+	return s.webhooks.Redeliver(id, deliveryId)
+}
+
+// newReceiptRecord is a received receipt that is yet to be processed, its id is assigned
+// up front so experiments can assign it to a variant before it is stored
+func newReceiptRecord(submitter Submitter, receipt models.Receipt) models.ReceiptRecord {
+	now := time.Now()
+	record := models.ReceiptRecord{
+		Receipt:     receipt,
+		Id:          uuid.NewString(),
+		RawRetailer: receipt.Retailer,
+		ClientId:    submitter.ClientId,
+		CreatedAt:   now,
+	}
+	newRecordStatus(&record, now)
+	return record
+}
+
+// processOpts change how process scores a record, the zero value scores it like a submission
+type processOpts struct {
+	// rules replace the live rules and the experiment's
+	rules *Rules
+	// imported marks a historical receipt: it skips the fraud checks and the tier multiplier,
+	// and counts towards the member's tier from its purchase time
+	imported bool
+}
+
+// process validates and scores a received record, it returns ErrReceiptRejected
+// with the record moved to rejected if the receipt can't be scored
+func (s *ReceiptService) process(record *models.ReceiptRecord, opts processOpts) error {
+	now := time.Now()
+	receipt := record.Receipt
+	if err := checkScorable(&receipt); err != nil {
+		if transitionErr := transition(record, models.StatusRejected, now); transitionErr != nil {
+			return transitionErr
+		}
+		return fmt.Errorf("%w: %v", ErrReceiptRejected, err)
+	}
+	if err := transition(record, models.StatusValidated, now); err != nil {
+		return err
+	}
+
+	// copy the items so categorizing doesn't modify the caller's receipt
+	receipt.Items = slices.Clone(receipt.Items)
+	s.categorizer.CategorizeItems(&receipt)
+
+	// the rules score the raw name unless configured otherwise, to stay compatible with the spec
+	scored := receipt
+	if retailer, ok := s.retailers.Match(receipt.Retailer); ok {
+		receipt.Retailer = retailer.CanonicalName
+		record.RetailerId = retailer.Id
+		if s.scoreCanonicalName {
+			scored = receipt
+		}
+	}
+	record.Receipt = receipt
+
+	rules := opts.rules
+	if rules == nil {
+		var version int64
+		rules, version = s.rules.currentVersion()
+		record.RulesVersion = &version
+	} else if version, ok := s.rules.versionOf(rules); ok {
+		record.RulesVersion = &version
+	}
+	if opts.rules == nil && s.experiment != nil {
+		variant := s.experiment.assign(*record)
+		record.Experiment, record.Variant = s.experiment.Name(), variant.name
+		if variant.rules != nil {
+			rules = variant.rules
+		}
+	}
+
+	breakdown := s.baseBreakdown(rules, scored)
+	basePoints := sumBreakdown(breakdown)
+	finalPoints := basePoints
+
+	for _, line := range s.campaigns.Apply(record.Id, &receipt, basePoints) {
+		breakdown = append(breakdown, line)
+		finalPoints += line.Points
+	}
+
+	record.Imported = opts.imported
+	// an imported receipt was earned under whatever tier the member had back then, which isn't known
+	if receipt.MemberId != "" && !opts.imported {
+		tier := s.tiers.CurrentTier(receipt.MemberId)
+		record.Tier = tier.Name
+		if line, ok := tierMultiplierLine(tier, basePoints); ok {
+			breakdown = append(breakdown, line)
+			finalPoints += line.Points
+		}
+	}
+
+	record.Points = finalPoints
+	record.Breakdown = breakdown
+	_ = transition(record, models.StatusScored, now)
+
+	nextStatus := models.StatusCredited
+	if s.fraud != nil && !opts.imported {
+		retailerKey := record.RetailerId
+		if retailerKey == "" {
+			retailerKey = normalizeRetailerName(record.RawRetailer)
+		}
+		risk := s.fraud.Assess(record.Id, Submitter{ClientId: record.ClientId}, &receipt, retailerKey)
+		record.Risk = &risk
+		if s.fraud.Hold(risk) {
+			nextStatus = models.StatusPendingReview
+		}
+	}
+	_ = transition(record, nextStatus, now)
+
+	return nil
+}
+
+// settle runs once a processed record is stored, held receipts go to the
+// review queue and are only credited once they pass review
+func (s *ReceiptService) settle(record models.ReceiptRecord) {
+	switch record.Status {
+	case models.StatusPendingReview:
+		s.reviews.Enqueue(record)
+		s.events.Publish(receiptEvent(models.EventReceiptFlagged, record))
+	case models.StatusCredited:
+		s.campaigns.Credit(record.Id)
+		if record.Receipt.MemberId != "" {
+			s.tiers.CreditAt(record.Receipt.MemberId, record.Points, tierCreditTime(record))
+		}
+		s.reports.Add(record)
+		s.events.Publish(receiptEvent(models.EventReceiptScored, record))
+	case models.StatusRejected:
+		s.events.Publish(receiptEvent(models.EventReceiptRejected, record))
+	}
+}
+
+// tierCreditTime is when the receipt's points count towards the member's tier from: when it was credited,
+// or its purchase time if it was imported so history doesn't land in the current 12-month window
+func tierCreditTime(record models.ReceiptRecord) time.Time {
+	if record.Imported {
+		if purchasedAt, err := purchaseTimestamp(&record.Receipt); err == nil {
+			return purchasedAt
+		}
+	}
+	for i := len(record.StatusHistory) - 1; i >= 0; i-- {
+		if change := record.StatusHistory[i]; change.To == models.StatusCredited {
+			return change.At
+		}
+	}
+	return time.Now()
+}
+
+// ActiveRules returns the rule set receipts are currently scored with
+func (s *ReceiptService) ActiveRules() models.ActiveRules {
+	return s.rules.Active()
+}
+
+// RuleVersion returns the rules as they were at the version, version 0 being the rules the server started with
+func (s *ReceiptService) RuleVersion(version int64) (*Rules, error) {
+	return s.rules.Version(version)
+}
+
+// RuleHistory lists the changes made to the rules, only to the named rule if rule isn't empty
+func (s *ReceiptService) RuleHistory(rule string) []models.RuleChange {
+	return s.rules.History(rule)
+}
+
+func (s *ReceiptService) ListReviews() []models.ReviewItem {
+	return s.reviews.List()
+}
+
+func (s *ReceiptService) ClaimReview(receiptId, actor string) (models.ReviewItem, error) {
+	return s.reviews.Claim(receiptId, actor)
+}
+
+func (s *ReceiptService) ReleaseReview(receiptId, actor string) error {
+	return s.reviews.Release(receiptId, actor)
+}
+
+func (s *ReceiptService) ReviewAudit(receiptId string) []models.ReviewAuditEntry {
+	return s.reviews.Audit(receiptId)
+}
+
+// ApproveReview credits a held receipt
+func (s *ReceiptService) ApproveReview(receiptId, actor string) (models.ReceiptRecord, error) {
+	return s.decideReview(receiptId, actor, models.ReviewApprove, "")
+}
+
+// RejectReview rejects a held receipt, its points are never credited
+func (s *ReceiptService) RejectReview(receiptId, actor, reason string) (models.ReceiptRecord, error) {
+	if strings.TrimSpace(reason) == "" {
+		return models.ReceiptRecord{}, fmt.Errorf("a reason is required to reject a receipt")
+	}
+	return s.decideReview(receiptId, actor, models.ReviewReject, reason)
+}
+
+func (s *ReceiptService) decideReview(receiptId, actor, action, reason string) (models.ReceiptRecord, error) {
+	var record models.ReceiptRecord
+	err := s.reviews.Decide(receiptId, actor, action, reason, func(decision models.ReviewAuditEntry) error {
+		var err error
+		record, err = s.db.GetReceiptById(receiptId)
+		if err != nil {
+			return err
+		}
+
+		status := models.StatusRejected
+		if action == models.ReviewApprove {
+			status = models.StatusCredited
+		}
+		if err := transition(&record, status, decision.At); err != nil {
+			return err
+		}
+		record.Review = &decision
+		if err := s.db.UpdateReceipt(record); err != nil {
+			return err
+		}
+
+		if action == models.ReviewApprove {
+			s.campaigns.Credit(record.Id)
+			if record.Receipt.MemberId != "" {
+				s.tiers.CreditAt(record.Receipt.MemberId, record.Points, tierCreditTime(record))
+			}
+			s.reports.Add(record)
+		} else {
+			s.campaigns.Release(record.Id)
+		}
+		return nil
+	})
+	if err == nil {
+		eventType := models.EventReceiptRejected
+		if action == models.ReviewApprove {
+			eventType = models.EventReceiptScored
+		}
+		s.events.Publish(receiptEvent(eventType, record))
+	}
+	return record, err
+}
+
+// baseBreakdown scores the receipt with the default and category rules,
+// items in excluded categories don't earn item based points
+func (s *ReceiptService) baseBreakdown(rules *Rules, scored models.Receipt) []models.BreakdownLine {
+	scored = s.categorizer.WithoutExcluded(scored)
+	breakdown := calculateBreakdown(
+		&scored,
+		rules.rules...,
+	)
+	return append(breakdown, s.categorizer.categoryPoints(&scored)...)
+}
+
+func sumBreakdown(breakdown []models.BreakdownLine) int64 {
+	var total int64 = 0
+	for _, line := range breakdown {
+		total += line.Points
+	}
+	return total
 }
@@ -0,0 +1,257 @@
+package service
+
+import (
+	"fmt"
+	"github.com/RA341/receipt-processor-challenge/models"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	IntervalDay   = "day"
+	IntervalWeek  = "week"
+	IntervalMonth = "month"
+
+	GroupByRetailer = "retailer"
+	GroupByCategory = "category"
+	// GroupByVariant compares the experiment variants, as experiment/variant
+	GroupByVariant = "variant"
+)
+
+// ReportQuery selects the receipts purchased between From and To (YYYY-MM-DD, both inclusive and optional),
+// bucketed by Interval and grouped by GroupBy. Without an Interval the whole range is one bucket
+type ReportQuery struct {
+	From     string
+	To       string
+	Interval string
+	GroupBy  string
+}
+
+func (q ReportQuery) validate() error {
+	for _, date := range []string{q.From, q.To} {
+		if _, err := time.Parse(dateLayout, date); date != "" && err != nil {
+			return fmt.Errorf("report dates must be YYYY-MM-DD")
+		}
+	}
+	if q.Interval != "" && !slices.Contains([]string{IntervalDay, IntervalWeek, IntervalMonth}, q.Interval) {
+		return fmt.Errorf("interval must be one of %s, %s or %s", IntervalDay, IntervalWeek, IntervalMonth)
+	}
+	if q.GroupBy != "" && !slices.Contains([]string{GroupByRetailer, GroupByCategory, GroupByVariant}, q.GroupBy) {
+		return fmt.Errorf("groupBy must be %s, %s or %s", GroupByRetailer, GroupByCategory, GroupByVariant)
+	}
+	return nil
+}
+
+// reportGroup is a dimension and its value, the zero group holds the totals over every receipt
+type reportGroup struct {
+	dimension string
+	value     string
+}
+
+type reportCounters struct {
+	receipts int64
+	points   int64
+	revenue  int64 // cents
+	items    int64
+}
+
+func (c *reportCounters) add(other reportCounters, sign int64) {
+	c.receipts += sign * other.receipts
+	c.points += sign * other.points
+	c.revenue += sign * other.revenue
+	c.items += sign * other.items
+}
+
+func (c reportCounters) isZero() bool {
+	return c == reportCounters{}
+}
+
+// ReportStore keeps per day counters of credited receipts, updated as receipts are credited and voided,
+// so reports only sum the days they cover instead of scanning the receipts
+type ReportStore struct {
+	mu sync.RWMutex
+	// days maps the purchase date to the counters of every group that had receipts that day
+	days map[string]map[reportGroup]*reportCounters
+}
+
+func NewReportStore() *ReportStore {
+	return &ReportStore{days: map[string]map[reportGroup]*reportCounters{}}
+}
+
+// Add counts a receipt that was credited
+func (r *ReportStore) Add(record models.ReceiptRecord) {
+	r.Replace(models.ReceiptRecord{}, record)
+}
+
+// Replace swaps what the receipt counted for before a change for what it counts after it,
+// only credited receipts count and returned items are left out
+func (r *ReportStore) Replace(before, after models.ReceiptRecord) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.apply(before, -1)
+	r.apply(after, 1)
+}
+
+// apply adds the record's contribution with the sign, callers hold the write lock
+func (r *ReportStore) apply(record models.ReceiptRecord, sign int64) {
+	if record.Status != models.StatusCredited {
+		return
+	}
+	day := record.Receipt.PurchaseDate
+
+	groups, ok := r.days[day]
+	if !ok {
+		groups = map[reportGroup]*reportCounters{}
+		r.days[day] = groups
+	}
+	for group, contribution := range reportContribution(record) {
+		counters, ok := groups[group]
+		if !ok {
+			counters = &reportCounters{}
+			groups[group] = counters
+		}
+		counters.add(contribution, sign)
+		if counters.isZero() {
+			delete(groups, group)
+		}
+	}
+	if len(groups) == 0 {
+		delete(r.days, day)
+	}
+}
+
+// reportContribution is what a credited receipt counts for in each group. The retailer and the totals get
+// all of it, categories get the items in them and a share of the points proportional to their revenue
+func reportContribution(record models.ReceiptRecord) map[reportGroup]reportCounters {
+	returned := returnedItems(record)
+
+	byCategory := map[string]reportCounters{}
+	var itemsRevenue, itemCount, returnedRevenue int64
+	for i, item := range record.Receipt.Items {
+		price, _ := parseCents(item.Price)
+		if returned[i] {
+			returnedRevenue += price
+			continue
+		}
+		category := item.Category
+		if category == "" {
+			category = UncategorizedCategory
+		}
+		counters := byCategory[category]
+		counters.items++
+		counters.revenue += price
+		byCategory[category] = counters
+
+		itemsRevenue += price
+		itemCount++
+	}
+
+	total, _ := parseCents(record.Receipt.Total)
+	receipt := reportCounters{
+		receipts: 1,
+		points:   record.Points,
+		revenue:  max(total-returnedRevenue, 0),
+		items:    itemCount,
+	}
+	contribution := map[reportGroup]reportCounters{
+		{}: receipt,
+		{dimension: GroupByRetailer, value: record.Receipt.Retailer}: receipt,
+	}
+	if record.Variant != "" {
+		contribution[reportGroup{dimension: GroupByVariant, value: record.Experiment + "/" + record.Variant}] = receipt
+	}
+	for category, counters := range byCategory {
+		counters.receipts = 1
+		if itemsRevenue > 0 {
+			counters.points = int64(math.Round(float64(record.Points) * float64(counters.revenue) / float64(itemsRevenue)))
+		}
+		contribution[reportGroup{dimension: GroupByCategory, value: category}] = counters
+	}
+	return contribution
+}
+
+// Report sums the counters of the days in the query's range into its buckets, ordered by period then group
+func (r *ReportStore) Report(query ReportQuery) ([]models.ReportBucket, error) {
+	if err := query.validate(); err != nil {
+		return nil, err
+	}
+
+	type bucketKey struct {
+		period string
+		group  string
+	}
+	sums := map[bucketKey]*reportCounters{}
+
+	r.mu.RLock()
+	for day, groups := range r.days {
+		if (query.From != "" && day < query.From) || (query.To != "" && day > query.To) {
+			continue
+		}
+		period, err := bucketStart(day, query.Interval)
+		if err != nil {
+			continue // receipts are validated before they're credited, so this shouldn't happen
+		}
+		for group, counters := range groups {
+			if group.dimension != query.GroupBy {
+				continue
+			}
+			key := bucketKey{period: period, group: group.value}
+			sum, ok := sums[key]
+			if !ok {
+				sum = &reportCounters{}
+				sums[key] = sum
+			}
+			sum.add(*counters, 1)
+		}
+	}
+	r.mu.RUnlock()
+
+	buckets := make([]models.ReportBucket, 0, len(sums))
+	for key, sum := range sums {
+		bucket := models.ReportBucket{
+			Period:       key.period,
+			Group:        key.group,
+			Receipts:     sum.receipts,
+			PointsIssued: sum.points,
+			Revenue:      formatCents(sum.revenue),
+			Items:        sum.items,
+		}
+		if sum.receipts > 0 {
+			bucket.AverageItems = math.Round(float64(sum.items)/float64(sum.receipts)*100) / 100
+		}
+		buckets = append(buckets, bucket)
+	}
+	sort.Slice(buckets, func(i, j int) bool {
+		if buckets[i].Period != buckets[j].Period {
+			return buckets[i].Period < buckets[j].Period
+		}
+		return strings.ToLower(buckets[i].Group) < strings.ToLower(buckets[j].Group)
+	})
+	return buckets, nil
+}
+
+// bucketStart is the first day of the interval the day falls in, weeks start on Monday.
+// An empty interval puts every day in the same bucket
+func bucketStart(day, interval string) (string, error) {
+	if interval == "" {
+		return "", nil
+	}
+	date, err := time.Parse(dateLayout, day)
+	if err != nil {
+		return "", err
+	}
+
+	switch interval {
+	case IntervalWeek:
+		sinceMonday := (int(date.Weekday()) + 6) % 7
+		date = date.AddDate(0, 0, -sinceMonday)
+	case IntervalMonth:
+		date = time.Date(date.Year(), date.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+	return date.Format(dateLayout), nil
+}
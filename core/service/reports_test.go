@@ -0,0 +1,100 @@
+package service
+
+import (
+	"github.com/RA341/receipt-processor-challenge/models"
+	"reflect"
+	"testing"
+)
+
+func TestReportStore_Report(t *testing.T) {
+	db, _ := NewDB()
+	srv := NewReceiptService(db)
+
+	target, err := srv.NewReceipt(testMap["test 1"].receipt)
+	if err != nil {
+		t.Fatalf("Failed to submit receipt: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := srv.NewReceipt(testMap["test 2"].receipt); err != nil {
+			t.Fatalf("Failed to submit receipt: %v", err)
+		}
+	}
+
+	report := func(query ReportQuery) []models.ReportBucket {
+		buckets, err := srv.Report(query)
+		if err != nil {
+			t.Fatalf("Failed to build report: %v", err)
+		}
+		return buckets
+	}
+
+	summary := report(ReportQuery{})
+	expected := []models.ReportBucket{{Receipts: 3, PointsIssued: 28 + 2*109, Revenue: "53.35", Items: 13, AverageItems: 4.33}}
+	if !reflect.DeepEqual(summary, expected) {
+		t.Fatalf("Expected summary %+v, got %+v", expected, summary)
+	}
+
+	weekly := report(ReportQuery{Interval: IntervalWeek, GroupBy: GroupByRetailer})
+	expected = []models.ReportBucket{
+		{Period: "2021-12-27", Group: "Target", Receipts: 1, PointsIssued: 28, Revenue: "35.35", Items: 5, AverageItems: 5},
+		{Period: "2022-03-14", Group: "M&M Corner Market", Receipts: 2, PointsIssued: 218, Revenue: "18.00", Items: 8, AverageItems: 4},
+	}
+	if !reflect.DeepEqual(weekly, expected) {
+		t.Fatalf("Expected weekly report %+v, got %+v", expected, weekly)
+	}
+
+	if monthly := report(ReportQuery{Interval: IntervalMonth, From: "2022-02-01"}); len(monthly) != 1 || monthly[0].Period != "2022-03-01" {
+		t.Fatalf("Expected only March in the range, got %+v", monthly)
+	}
+
+	// returning the pizza takes its price, item and points out of the counters
+	if _, err := srv.VoidReceipt(target, "admin", "returned pizza", []int{1}); err != nil {
+		t.Fatalf("Failed to void item: %v", err)
+	}
+	january := report(ReportQuery{To: "2022-01-31"})
+	expected = []models.ReportBucket{{Receipts: 1, PointsIssued: 25, Revenue: "23.10", Items: 4, AverageItems: 4}}
+	if !reflect.DeepEqual(january, expected) {
+		t.Fatalf("Expected the partial void to be reflected, got %+v", january)
+	}
+
+	if _, err := srv.VoidReceipt(target, "admin", "chargeback", nil); err != nil {
+		t.Fatalf("Failed to void receipt: %v", err)
+	}
+	if january := report(ReportQuery{To: "2022-01-31"}); len(january) != 0 {
+		t.Fatalf("Expected the voided receipt to leave the report, got %+v", january)
+	}
+
+	invalid := []ReportQuery{{Interval: "year"}, {GroupBy: "member"}, {From: "01-01-2022"}}
+	for _, query := range invalid {
+		if _, err := srv.Report(query); err == nil {
+			t.Fatalf("Expected %+v to fail", query)
+		}
+	}
+}
+
+func TestReportContribution_Categories(t *testing.T) {
+	record := models.ReceiptRecord{
+		Receipt: models.Receipt{
+			Retailer: "Target",
+			Total:    "10.00",
+			Items: []models.Item{
+				{ShortDescription: "Milk", Price: "2.50", Category: "dairy"},
+				{ShortDescription: "Cheese", Price: "2.50", Category: "dairy"},
+				{ShortDescription: "Bread", Price: "5.00", Category: "bakery"},
+			},
+		},
+		Points: 30,
+		Status: models.StatusCredited,
+	}
+
+	contribution := reportContribution(record)
+	dairy := contribution[reportGroup{dimension: GroupByCategory, value: "dairy"}]
+	bakery := contribution[reportGroup{dimension: GroupByCategory, value: "bakery"}]
+	if dairy != (reportCounters{receipts: 1, points: 15, revenue: 500, items: 2}) ||
+		bakery != (reportCounters{receipts: 1, points: 15, revenue: 500, items: 1}) {
+		t.Fatalf("Expected the points to be split by revenue, got dairy %+v and bakery %+v", dairy, bakery)
+	}
+	if total := contribution[reportGroup{}]; total != (reportCounters{receipts: 1, points: 30, revenue: 1000, items: 3}) {
+		t.Fatalf("Expected the totals to count the whole receipt, got %+v", total)
+	}
+}
@@ -0,0 +1,245 @@
+package service
+
+import (
+	"fmt"
+	"github.com/RA341/receipt-processor-challenge/models"
+	"github.com/google/uuid"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// retailerStopWords are dropped when normalizing names, so "Target Store" matches "Target"
+var retailerStopWords = map[string]bool{
+	"the":         true,
+	"store":       true,
+	"stores":      true,
+	"inc":         true,
+	"llc":         true,
+	"co":          true,
+	"corp":        true,
+	"supercenter": true,
+}
+
+// RetailerCatalog maps the retailer names clients send to canonical catalog entries
+type RetailerCatalog struct {
+	mu        sync.RWMutex
+	retailers map[string]models.Retailer
+	// normalized name or alias -> retailer id
+	index     map[string]string
+	threshold float64
+}
+
+func NewRetailerCatalog(matchThreshold float64) *RetailerCatalog {
+	return &RetailerCatalog{
+		retailers: map[string]models.Retailer{},
+		index:     map[string]string{},
+		threshold: matchThreshold,
+	}
+}
+
+func (rc *RetailerCatalog) Create(retailer models.Retailer) (models.Retailer, error) {
+	newUUID, err := uuid.NewUUID()
+	if err != nil {
+		return models.Retailer{}, err
+	}
+	retailer.Id = newUUID.String()
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if err := rc.checkConflicts(retailer); err != nil {
+		return models.Retailer{}, err
+	}
+	rc.put(retailer)
+
+	return retailer, nil
+}
+
+func (rc *RetailerCatalog) Update(retailer models.Retailer) (models.Retailer, error) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	existing, ok := rc.retailers[retailer.Id]
+	if !ok {
+		return models.Retailer{}, fmt.Errorf("unable to find retailer: %s", retailer.Id)
+	}
+
+	rc.remove(existing)
+	if err := rc.checkConflicts(retailer); err != nil {
+		rc.put(existing)
+		return models.Retailer{}, err
+	}
+	rc.put(retailer)
+
+	return retailer, nil
+}
+
+func (rc *RetailerCatalog) Delete(id string) error {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	existing, ok := rc.retailers[id]
+	if !ok {
+		return fmt.Errorf("unable to find retailer: %s", id)
+	}
+	rc.remove(existing)
+	return nil
+}
+
+func (rc *RetailerCatalog) Get(id string) (models.Retailer, error) {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	retailer, ok := rc.retailers[id]
+	if !ok {
+		return models.Retailer{}, fmt.Errorf("unable to find retailer: %s", id)
+	}
+	return retailer, nil
+}
+
+// List returns the catalog sorted by canonical name
+func (rc *RetailerCatalog) List() []models.Retailer {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	result := make([]models.Retailer, 0, len(rc.retailers))
+	for _, r := range rc.retailers {
+		result = append(result, r)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CanonicalName < result[j].CanonicalName
+	})
+	return result
+}
+
+// Match finds the catalog entry for a raw retailer name, first by exact normalized
+// name or alias, then by the closest fuzzy match above the threshold
+func (rc *RetailerCatalog) Match(raw string) (models.Retailer, bool) {
+	key := normalizeRetailerName(raw)
+	if key == "" {
+		return models.Retailer{}, false
+	}
+
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	if id, ok := rc.index[key]; ok {
+		return rc.retailers[id], true
+	}
+
+	bestScore := 0.0
+	bestId := ""
+	for candidate, id := range rc.index {
+		score := similarity(key, candidate)
+		// ties go to the lexically smaller id so matching is deterministic
+		if score > bestScore || (score == bestScore && id < bestId) {
+			bestScore = score
+			bestId = id
+		}
+	}
+
+	if bestId == "" || bestScore < rc.threshold {
+		return models.Retailer{}, false
+	}
+	return rc.retailers[bestId], true
+}
+
+func (rc *RetailerCatalog) checkConflicts(retailer models.Retailer) error {
+	if normalizeRetailerName(retailer.CanonicalName) == "" {
+		return fmt.Errorf("canonicalName is required")
+	}
+
+	for _, name := range retailerNames(retailer) {
+		key := normalizeRetailerName(name)
+		if id, ok := rc.index[key]; ok && id != retailer.Id {
+			return fmt.Errorf("%q already belongs to retailer %s", name, rc.retailers[id].CanonicalName)
+		}
+	}
+	return nil
+}
+
+func (rc *RetailerCatalog) put(retailer models.Retailer) {
+	rc.retailers[retailer.Id] = retailer
+	for _, name := range retailerNames(retailer) {
+		if key := normalizeRetailerName(name); key != "" {
+			rc.index[key] = retailer.Id
+		}
+	}
+}
+
+func (rc *RetailerCatalog) remove(retailer models.Retailer) {
+	delete(rc.retailers, retailer.Id)
+	for _, name := range retailerNames(retailer) {
+		delete(rc.index, normalizeRetailerName(name))
+	}
+}
+
+func retailerNames(retailer models.Retailer) []string {
+	return append([]string{retailer.CanonicalName}, retailer.Aliases...)
+}
+
+// normalizeRetailerName lowercases the name, drops punctuation, store numbers
+// such as "#1234" and stop words, "TARGET #1234" and "Target Store" both become "target"
+func normalizeRetailerName(name string) string {
+	var tokens []string
+	for _, field := range strings.Fields(strings.ToLower(name)) {
+		if strings.HasPrefix(field, "#") {
+			continue
+		}
+
+		token := strings.Map(func(r rune) rune {
+			if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '&' {
+				return r
+			}
+			return -1
+		}, field)
+
+		if token == "" || retailerStopWords[token] || isDigits(token) {
+			continue
+		}
+		tokens = append(tokens, token)
+	}
+	return strings.Join(tokens, " ")
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if !unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// similarity is 1 - the levenshtein distance relative to the longer string
+func similarity(a, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	longest := max(len(ra), len(rb))
+	if longest == 0 {
+		return 1
+	}
+	return 1 - float64(levenshtein(ra, rb))/float64(longest)
+}
+
+func levenshtein(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,74 @@
+package service
+
+import (
+	"github.com/RA341/receipt-processor-challenge/models"
+	"testing"
+)
+
+func TestRetailerCatalog_Match(t *testing.T) {
+	rc := NewRetailerCatalog(0.8)
+	target, err := rc.Create(models.Retailer{CanonicalName: "Target", Aliases: []string{"Target.com"}})
+	if err != nil {
+		t.Fatalf("Failed to create retailer: %v", err)
+	}
+	if _, err := rc.Create(models.Retailer{CanonicalName: "Walgreens"}); err != nil {
+		t.Fatalf("Failed to create retailer: %v", err)
+	}
+
+	cases := map[string]string{
+		"Target":       target.Id,
+		"TARGET #1234": target.Id,
+		"Target Store": target.Id,
+		"target.com":   target.Id,
+		"Targett":      target.Id, // typo, fuzzy match
+		"Costco":       "",
+	}
+
+	for raw, expectedId := range cases {
+		t.Run(raw, func(t *testing.T) {
+			retailer, ok := rc.Match(raw)
+			if expectedId == "" {
+				if ok {
+					t.Fatalf("Expected no match but got %s", retailer.CanonicalName)
+				}
+				return
+			}
+			if !ok || retailer.Id != expectedId {
+				t.Fatalf("Expected a match on %s but got %+v", expectedId, retailer)
+			}
+		})
+	}
+}
+
+func TestRetailerCatalog_AliasConflict(t *testing.T) {
+	rc := NewRetailerCatalog(0.8)
+	if _, err := rc.Create(models.Retailer{CanonicalName: "Target"}); err != nil {
+		t.Fatalf("Failed to create retailer: %v", err)
+	}
+	if _, err := rc.Create(models.Retailer{CanonicalName: "Tgt", Aliases: []string{"TARGET"}}); err == nil {
+		t.Fatalf("Expected a conflict on the TARGET alias")
+	}
+}
+
+func TestReceiptService_NormalizesRetailer(t *testing.T) {
+	db, _ := NewDB()
+	catalog := NewRetailerCatalog(0.8)
+	target, _ := catalog.Create(models.Retailer{CanonicalName: "Target"})
+	srv := NewReceiptService(db, WithRetailerCatalog(catalog))
+
+	receipt := testMap["test 1"].receipt
+	receipt.Retailer = "TARGET 1234"
+	id, err := srv.NewReceipt(receipt)
+	if err != nil {
+		t.Fatalf("Failed to create receipt: %v", err)
+	}
+
+	record, _ := srv.GetReceiptById(id)
+	if record.Receipt.Retailer != "Target" || record.RawRetailer != "TARGET 1234" || record.RetailerId != target.Id {
+		t.Fatalf("Unexpected retailer normalization: %+v", record)
+	}
+	// the raw name has 10 alphanumeric characters against 6 for the canonical name
+	if record.Points != testMap["test 1"].expectedPoints+4 {
+		t.Fatalf("Expected points to be scored on the raw name but got %d", record.Points)
+	}
+}
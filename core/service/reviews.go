@@ -0,0 +1,159 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"github.com/RA341/receipt-processor-challenge/models"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	ErrReviewNotFound = errors.New("no receipt pending review for that id")
+	ErrReviewClaimed  = errors.New("receipt is claimed by another reviewer")
+)
+
+// reviewClaimTTL is how long a claim holds before another reviewer can take the receipt over
+const reviewClaimTTL = 30 * time.Minute
+
+// ReviewQueue holds the receipts waiting for a reviewer and the audit trail of every review action
+type ReviewQueue struct {
+	mu      sync.Mutex
+	pending map[string]*models.ReviewItem
+	audit   []models.ReviewAuditEntry
+	now     func() time.Time
+}
+
+func NewReviewQueue() *ReviewQueue {
+	return &ReviewQueue{
+		pending: map[string]*models.ReviewItem{},
+		now:     time.Now,
+	}
+}
+
+// Enqueue adds a held receipt to the queue
+func (rq *ReviewQueue) Enqueue(record models.ReceiptRecord) {
+	rq.mu.Lock()
+	defer rq.mu.Unlock()
+
+	rq.pending[record.Id] = &models.ReviewItem{
+		ReceiptId: record.Id,
+		Retailer:  record.Receipt.Retailer,
+		MemberId:  record.Receipt.MemberId,
+		Points:    record.Points,
+		Risk:      record.Risk,
+		QueuedAt:  rq.now(),
+	}
+}
+
+// List returns the pending receipts, oldest first
+func (rq *ReviewQueue) List() []models.ReviewItem {
+	rq.mu.Lock()
+	defer rq.mu.Unlock()
+
+	items := make([]models.ReviewItem, 0, len(rq.pending))
+	for _, item := range rq.pending {
+		items = append(items, *item)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].QueuedAt.Before(items[j].QueuedAt)
+	})
+	return items
+}
+
+// Claim assigns the receipt to the reviewer, claiming a receipt again refreshes the claim
+func (rq *ReviewQueue) Claim(receiptId, actor string) (models.ReviewItem, error) {
+	rq.mu.Lock()
+	defer rq.mu.Unlock()
+
+	item, err := rq.claimable(receiptId, actor)
+	if err != nil {
+		return models.ReviewItem{}, err
+	}
+
+	now := rq.now()
+	item.ClaimedBy = actor
+	item.ClaimedAt = &now
+	rq.record(receiptId, models.ReviewClaim, actor, "")
+	return *item, nil
+}
+
+// Release gives up the reviewer's claim so someone else can take the receipt
+func (rq *ReviewQueue) Release(receiptId, actor string) error {
+	rq.mu.Lock()
+	defer rq.mu.Unlock()
+
+	item, err := rq.claimable(receiptId, actor)
+	if err != nil {
+		return err
+	}
+
+	item.ClaimedBy = ""
+	item.ClaimedAt = nil
+	rq.record(receiptId, models.ReviewRelease, actor, "")
+	return nil
+}
+
+// Decide removes the receipt from the queue once apply succeeds, the queue stays locked
+// while apply runs so two reviewers can't both decide on the same receipt
+func (rq *ReviewQueue) Decide(receiptId, actor, action, reason string, apply func(decision models.ReviewAuditEntry) error) error {
+	rq.mu.Lock()
+	defer rq.mu.Unlock()
+
+	if _, err := rq.claimable(receiptId, actor); err != nil {
+		return err
+	}
+
+	decision := models.ReviewAuditEntry{
+		ReceiptId: receiptId,
+		Action:    action,
+		Actor:     actor,
+		Reason:    reason,
+		At:        rq.now(),
+	}
+	if err := apply(decision); err != nil {
+		return err
+	}
+
+	delete(rq.pending, receiptId)
+	rq.audit = append(rq.audit, decision)
+	return nil
+}
+
+// Audit returns the review actions on the receipt, or on every receipt if receiptId is empty
+func (rq *ReviewQueue) Audit(receiptId string) []models.ReviewAuditEntry {
+	rq.mu.Lock()
+	defer rq.mu.Unlock()
+
+	entries := []models.ReviewAuditEntry{}
+	for _, entry := range rq.audit {
+		if receiptId == "" || entry.ReceiptId == receiptId {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// claimable returns the pending item if it is unclaimed, claimed by the actor or the claim expired
+func (rq *ReviewQueue) claimable(receiptId, actor string) (*models.ReviewItem, error) {
+	item, ok := rq.pending[receiptId]
+	if !ok {
+		return nil, ErrReviewNotFound
+	}
+
+	if item.ClaimedBy != "" && item.ClaimedBy != actor && rq.now().Sub(*item.ClaimedAt) < reviewClaimTTL {
+		return nil, fmt.Errorf("%w: %s", ErrReviewClaimed, item.ClaimedBy)
+	}
+	return item, nil
+}
+
+func (rq *ReviewQueue) record(receiptId, action, actor, reason string) {
+	rq.audit = append(rq.audit, models.ReviewAuditEntry{
+		ReceiptId: receiptId,
+		Action:    action,
+		Actor:     actor,
+		Reason:    reason,
+		At:        rq.now(),
+	})
+}
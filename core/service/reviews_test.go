@@ -0,0 +1,105 @@
+package service
+
+import (
+	"errors"
+	"github.com/RA341/receipt-processor-challenge/config"
+	"github.com/RA341/receipt-processor-challenge/models"
+	"testing"
+	"time"
+)
+
+// newHeldReceipt submits a receipt purchased in the future, which the fraud checks always hold
+func newHeldReceipt(t *testing.T, srv *ReceiptService) string {
+	future := time.Now().AddDate(0, 0, 7).Format(dateLayout)
+	id, err := srv.NewReceipt(fraudReceipt(future, "10.00", "10.00"))
+	if err != nil {
+		t.Fatalf("Failed to submit receipt: %v", err)
+	}
+	return id
+}
+
+func newReviewTestService() (*ReceiptService, *TierService) {
+	tiers := NewTierService(config.Default().Tiers)
+	db, _ := NewDB()
+	return NewReceiptService(db, WithTiers(tiers), WithFraudChecker(newTestFraudChecker(time.Now()))), tiers
+}
+
+func TestReceiptService_ApproveReview(t *testing.T) {
+	srv, tiers := newReviewTestService()
+	id := newHeldReceipt(t, srv)
+
+	if queue := srv.ListReviews(); len(queue) != 1 || queue[0].ReceiptId != id {
+		t.Fatalf("Expected the receipt in the review queue, got %+v", queue)
+	}
+
+	if _, err := srv.ClaimReview(id, "alice"); err != nil {
+		t.Fatalf("Failed to claim review: %v", err)
+	}
+	if _, err := srv.ApproveReview(id, "bob"); !errors.Is(err, ErrReviewClaimed) {
+		t.Fatalf("Expected another reviewer to be blocked by the claim, got %v", err)
+	}
+
+	record, err := srv.ApproveReview(id, "alice")
+	if err != nil {
+		t.Fatalf("Failed to approve review: %v", err)
+	}
+	if record.Status != models.StatusCredited || record.Review.Actor != "alice" {
+		t.Fatalf("Expected the receipt to be credited by alice, got %+v", record)
+	}
+
+	member, err := tiers.Member("member-1")
+	if err != nil || member.RollingPoints != record.Points {
+		t.Fatalf("Expected the member to be credited %d points, got %+v (%v)", record.Points, member, err)
+	}
+
+	if len(srv.ListReviews()) != 0 {
+		t.Fatalf("Expected the review queue to be empty")
+	}
+	if _, err := srv.ApproveReview(id, "alice"); !errors.Is(err, ErrReviewNotFound) {
+		t.Fatalf("Expected a decided receipt to leave the queue, got %v", err)
+	}
+
+	audit := srv.ReviewAudit(id)
+	if len(audit) != 2 || audit[0].Action != models.ReviewClaim || audit[1].Action != models.ReviewApprove {
+		t.Fatalf("Unexpected audit trail %+v", audit)
+	}
+}
+
+func TestReceiptService_RejectReview(t *testing.T) {
+	srv, tiers := newReviewTestService()
+	id := newHeldReceipt(t, srv)
+
+	if _, err := srv.RejectReview(id, "alice", " "); err == nil {
+		t.Fatalf("Expected a rejection without reason to fail")
+	}
+
+	record, err := srv.RejectReview(id, "alice", "duplicate of an earlier receipt")
+	if err != nil {
+		t.Fatalf("Failed to reject review: %v", err)
+	}
+	if record.Status != models.StatusRejected || record.Review.Reason != "duplicate of an earlier receipt" {
+		t.Fatalf("Expected the receipt to be rejected with the reason, got %+v", record)
+	}
+	if _, err := tiers.Member("member-1"); err == nil {
+		t.Fatalf("Expected the rejected receipt not to credit the member")
+	}
+}
+
+func TestReviewQueue_ClaimExpires(t *testing.T) {
+	now := time.Now()
+	queue := NewReviewQueue()
+	queue.now = func() time.Time { return now }
+	queue.Enqueue(models.ReceiptRecord{Id: "receipt-1"})
+
+	if _, err := queue.Claim("receipt-1", "alice"); err != nil {
+		t.Fatalf("Failed to claim review: %v", err)
+	}
+	if _, err := queue.Claim("receipt-1", "bob"); !errors.Is(err, ErrReviewClaimed) {
+		t.Fatalf("Expected the claim to hold, got %v", err)
+	}
+
+	queue.now = func() time.Time { return now.Add(reviewClaimTTL) }
+	if item, err := queue.Claim("receipt-1", "bob"); err != nil || item.ClaimedBy != "bob" {
+		t.Fatalf("Expected an expired claim to be taken over, got %+v (%v)", item, err)
+	}
+}
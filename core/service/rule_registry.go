@@ -0,0 +1,369 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/RA341/receipt-processor-challenge/models"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ruleValidationSample is how many of the most recent credited receipts validate a rule change without a sample
+const ruleValidationSample = 100
+
+var (
+	ErrRuleNotFound  = errors.New("rule not found")
+	ErrRuleExists    = errors.New("a rule with that name already exists")
+	ErrRuleVersion   = errors.New("no such rule version")
+	ErrRulesNotSaved = errors.New("unable to save the rule history")
+)
+
+// RuleRegistry holds the live rules receipts are scored with and the history of every change made to them,
+// saved to its file if it has one
+type RuleRegistry struct {
+	path string
+
+	// changeMu serializes changes, so each one is validated against the rules it replaces
+	changeMu sync.Mutex
+
+	mu      sync.RWMutex
+	current *Rules
+	version int64
+	history []models.RuleChange
+	// versions are the rules at every version, the index being the version
+	versions []*Rules
+	now      func() time.Time
+}
+
+func NewRuleRegistry(rules *Rules) *RuleRegistry {
+	return &RuleRegistry{current: rules, versions: []*Rules{rules}, now: time.Now}
+}
+
+// ruleRegistryFile is the saved registry, Versions holds the rule set of every version
+type ruleRegistryFile struct {
+	Versions []models.RuleSet    `json:"versions"`
+	History  []models.RuleChange `json:"history"`
+}
+
+// LoadRuleRegistry restores the rules and history saved to path, and saves every change made from then on.
+// rules are the live rules until the first change is saved, the saved rules replace them once it is
+func LoadRuleRegistry(path string, rules *Rules) (*RuleRegistry, error) {
+	rr := NewRuleRegistry(rules)
+	rr.path = path
+	if path == "" {
+		return rr, nil
+	}
+
+	contents, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return rr, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read rule history file: %v", err)
+	}
+	var saved ruleRegistryFile
+	if err := json.Unmarshal(contents, &saved); err != nil {
+		return nil, fmt.Errorf("unable to parse rule history file: %v", err)
+	}
+	if len(saved.Versions) == 0 || len(saved.History) != len(saved.Versions)-1 {
+		return nil, fmt.Errorf("rule history file has %d versions for %d changes", len(saved.Versions), len(saved.History))
+	}
+
+	rr.versions = make([]*Rules, 0, len(saved.Versions))
+	for version, set := range saved.Versions {
+		compiled, err := CompileRules(set)
+		if err != nil {
+			return nil, fmt.Errorf("rule history file, version %d: %v", version, err)
+		}
+		rr.versions = append(rr.versions, compiled)
+	}
+	rr.history = saved.History
+	rr.version = int64(len(saved.History))
+	rr.current = rr.versions[rr.version]
+	return rr, nil
+}
+
+// Current returns the live rules, they are replaced as a whole on every change so callers can keep using them
+func (rr *RuleRegistry) Current() *Rules {
+	rr.mu.RLock()
+	defer rr.mu.RUnlock()
+	return rr.current
+}
+
+// currentVersion returns the live rules and their version
+func (rr *RuleRegistry) currentVersion() (*Rules, int64) {
+	rr.mu.RLock()
+	defer rr.mu.RUnlock()
+	return rr.current, rr.version
+}
+
+// versionOf returns the version of the rules, ok is false if they were never live
+func (rr *RuleRegistry) versionOf(rules *Rules) (version int64, ok bool) {
+	rr.mu.RLock()
+	defer rr.mu.RUnlock()
+	for i := len(rr.versions) - 1; i >= 0; i-- {
+		if rr.versions[i] == rules {
+			return int64(i), true
+		}
+	}
+	return 0, false
+}
+
+// Active returns the rule set of the live rules and how many changes were made to it
+func (rr *RuleRegistry) Active() models.ActiveRules {
+	rr.mu.RLock()
+	defer rr.mu.RUnlock()
+	return activeRules(rr.current, rr.version)
+}
+
+// Version returns the rules as they were at the version
+func (rr *RuleRegistry) Version(version int64) (*Rules, error) {
+	rr.mu.RLock()
+	defer rr.mu.RUnlock()
+
+	if version < 0 || version >= int64(len(rr.versions)) {
+		return nil, fmt.Errorf("%w: %d, the latest is %d", ErrRuleVersion, version, rr.version)
+	}
+	return rr.versions[version], nil
+}
+
+// History lists the changes oldest first, only the ones to the named rule if rule isn't empty
+func (rr *RuleRegistry) History(rule string) []models.RuleChange {
+	rr.mu.RLock()
+	defer rr.mu.RUnlock()
+
+	result := make([]models.RuleChange, 0, len(rr.history))
+	for _, change := range rr.history {
+		if rule == "" || change.Rule == rule {
+			result = append(result, change)
+		}
+	}
+	return result
+}
+
+// publish swaps in the rules and records the change, callers hold changeMu.
+// Nothing changes if the registry can't be saved
+func (rr *RuleRegistry) publish(rules *Rules, change models.RuleChange) (models.RuleChange, error) {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	change.Version = rr.version + 1
+	history := append(slices.Clip(rr.history), change)
+	versions := append(slices.Clip(rr.versions), rules)
+	if err := rr.save(versions, history); err != nil {
+		return models.RuleChange{}, fmt.Errorf("%w: %v", ErrRulesNotSaved, err)
+	}
+
+	rr.version = change.Version
+	rr.current = rules
+	rr.history = history
+	rr.versions = versions
+	return change, nil
+}
+
+// save writes the registry to a temp file and renames it over the registry file so a crash never leaves a partial one
+func (rr *RuleRegistry) save(versions []*Rules, history []models.RuleChange) error {
+	if rr.path == "" {
+		return nil
+	}
+
+	saved := ruleRegistryFile{Versions: make([]models.RuleSet, 0, len(versions)), History: history}
+	for _, rules := range versions {
+		saved.Versions = append(saved.Versions, rules.RuleSet())
+	}
+	contents, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(rr.path), ".rule-history-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(contents); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), rr.path)
+}
+
+func activeRules(rules *Rules, version int64) models.ActiveRules {
+	set := rules.RuleSet()
+	return models.ActiveRules{Version: version, Name: set.Name, Rules: set.Rules}
+}
+
+// RuleEdit is a change to one rule of the live rules. Rule is the new rule for
+// RuleCreate and RuleUpdate, Name is the rule the other actions apply to
+type RuleEdit struct {
+	Action string
+	Name   string
+	Rule   models.Rule
+	Actor  string
+	// Receipts validate the change, the most recent credited receipts do if it is empty
+	Receipts []models.Receipt
+	// DryRun validates the change without applying it
+	DryRun bool
+}
+
+// EditRules validates the change on the sample receipts and, unless it is a dry run, publishes
+// the changed rules so receipts scored from then on use them. The change is rejected if the
+// rules don't compile or one of them fails on a sample receipt
+func (s *ReceiptService) EditRules(edit RuleEdit) (models.RuleChangeResponse, error) {
+	s.rules.changeMu.Lock()
+	defer s.rules.changeMu.Unlock()
+
+	current := s.rules.Current()
+	set, change, err := applyRuleEdit(current.RuleSet(), edit)
+	if err != nil {
+		return models.RuleChangeResponse{}, err
+	}
+	change.Actor = edit.Actor
+	change.At = s.rules.now()
+
+	candidate, err := CompileRules(set)
+	if err != nil {
+		return models.RuleChangeResponse{}, err
+	}
+
+	sample, err := s.ruleValidationSample(edit.Receipts)
+	if err != nil {
+		return models.RuleChangeResponse{}, err
+	}
+	sim := newRuleSimulation(current, candidate)
+	for i, receipt := range sample {
+		if err := candidate.check(receipt); err != nil {
+			return models.RuleChangeResponse{}, fmt.Errorf("sample receipt %d: %v", i+1, err)
+		}
+		sim.add(receipt.Retailer, receipt)
+	}
+
+	response := models.RuleChangeResponse{Validation: sim.result()}
+	if edit.DryRun {
+		response.Change = change
+		response.Rules = activeRules(candidate, 0)
+		return response, nil
+	}
+
+	if response.Change, err = s.rules.publish(candidate, change); err != nil {
+		return models.RuleChangeResponse{}, err
+	}
+	response.Applied = true
+	response.Rules = activeRules(candidate, response.Change.Version)
+	return response, nil
+}
+
+// applyRuleEdit returns the rule set with the edit made and the change it records, without Actor and At
+func applyRuleEdit(set models.RuleSet, edit RuleEdit) (models.RuleSet, models.RuleChange, error) {
+	name := edit.Name
+	if edit.Action == models.RuleCreate {
+		name = edit.Rule.Name
+		if name == "" {
+			name = edit.Rule.Type
+		}
+	}
+	change := models.RuleChange{Action: edit.Action, Rule: name}
+
+	index := slices.IndexFunc(set.Rules, func(rule models.Rule) bool {
+		return rule.Name == name
+	})
+	if edit.Action == models.RuleCreate {
+		if index >= 0 {
+			return set, change, fmt.Errorf("%w: %s", ErrRuleExists, name)
+		}
+	} else if index < 0 {
+		return set, change, fmt.Errorf("%w: %s", ErrRuleNotFound, name)
+	}
+
+	var before *models.Rule
+	if index >= 0 {
+		rule := set.Rules[index]
+		before = &rule
+	}
+
+	after := edit.Rule
+	after.Name = name
+	switch edit.Action {
+	case models.RuleCreate:
+		set.Rules = append(set.Rules, after)
+	case models.RuleUpdate:
+		set.Rules[index] = after
+	case models.RuleEnable, models.RuleDisable:
+		after = *before
+		after.Disabled = edit.Action == models.RuleDisable
+		set.Rules[index] = after
+	case models.RuleDelete:
+		set.Rules = slices.Delete(set.Rules, index, index+1)
+	default:
+		return set, change, fmt.Errorf("unknown rule action %s", edit.Action)
+	}
+
+	change.Before = before
+	if edit.Action != models.RuleDelete {
+		change.After = &after
+	}
+	return set, change, nil
+}
+
+// ruleValidationSample prepares the receipts a rule change is validated on the way they are scored,
+// the given ones or the most recent credited receipts
+func (s *ReceiptService) ruleValidationSample(receipts []models.Receipt) ([]models.Receipt, error) {
+	var sample []models.Receipt
+	if len(receipts) > 0 {
+		for i, receipt := range receipts {
+			if err := ValidateReceipt(receipt); err != nil {
+				return nil, fmt.Errorf("sample receipt %d: %v", i+1, err)
+			}
+			s.categorizer.CategorizeItems(&receipt)
+			sample = append(sample, s.categorizer.WithoutExcluded(receipt))
+		}
+		return sample, nil
+	}
+
+	page, err := s.db.ListReceipts(ReceiptQuery{
+		Status:     models.StatusCredited,
+		Sort:       SortCreatedAt,
+		Descending: true,
+		Limit:      ruleValidationSample,
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, record := range page.Receipts {
+		sample = append(sample, s.categorizer.WithoutExcluded(s.scoringInput(record)))
+	}
+	return sample, nil
+}
+
+// check runs every rule on the receipt, failing if one of them fails or panics
+func (r *Rules) check(receipt models.Receipt) error {
+	for _, rule := range r.rules {
+		if err := checkRule(rule, receipt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkRule(rule pointRule, receipt models.Receipt) (err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("rule %s failed: %v", rule.name, recovered)
+		}
+	}()
+	if rule.check != nil {
+		if err := rule.check(&receipt); err != nil {
+			return fmt.Errorf("rule %s failed: %v", rule.name, err)
+		}
+	}
+	rule.calc(&receipt)
+	return nil
+}
@@ -0,0 +1,168 @@
+package service
+
+import (
+	"errors"
+	"github.com/RA341/receipt-processor-challenge/models"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReceiptService_EditRules(t *testing.T) {
+	db, _ := NewDB()
+	srv := NewReceiptService(db)
+
+	points := func() int64 {
+		id, err := srv.NewReceipt(testMap["test 2"].receipt) // M&M Corner Market, 109 points
+		if err != nil {
+			t.Fatalf("Failed to submit receipt: %v", err)
+		}
+		total, err := srv.GetPointsById(id)
+		if err != nil {
+			t.Fatalf("Failed to get points: %v", err)
+		}
+		return total
+	}
+	if got := points(); got != 109 {
+		t.Fatalf("Expected 109 points with the default rules, got %d", got)
+	}
+
+	bonus := models.Rule{Name: "bigBasket", Type: RuleItemPairs, Points: 100, Every: 4}
+	dryRun, err := srv.EditRules(RuleEdit{Action: models.RuleCreate, Rule: bonus, Actor: "client:ops", DryRun: true})
+	if err != nil {
+		t.Fatalf("Failed to validate rule: %v", err)
+	}
+	// validated on the receipt submitted above, which earns the bonus once
+	if dryRun.Applied || dryRun.Validation.Receipts != 1 || dryRun.Validation.Delta != 100 {
+		t.Fatalf("Expected an unapplied dry run adding 100 points, got %+v", dryRun)
+	}
+	if got := points(); got != 109 {
+		t.Fatalf("Expected the dry run not to change the points, got %d", got)
+	}
+
+	created, err := srv.EditRules(RuleEdit{Action: models.RuleCreate, Rule: bonus, Actor: "client:ops"})
+	if err != nil {
+		t.Fatalf("Failed to create rule: %v", err)
+	}
+	if !created.Applied || created.Change.Version != 1 || created.Rules.Version != 1 || len(created.Rules.Rules) != 8 {
+		t.Fatalf("Expected version 1 with 8 rules, got %+v", created)
+	}
+	if got := points(); got != 209 {
+		t.Fatalf("Expected the new rule to score live receipts, got %d", got)
+	}
+
+	if _, err := srv.EditRules(RuleEdit{Action: models.RuleDisable, Name: "bigBasket", Actor: "client:ops"}); err != nil {
+		t.Fatalf("Failed to disable rule: %v", err)
+	}
+	if got := points(); got != 109 {
+		t.Fatalf("Expected the disabled rule to stop scoring, got %d", got)
+	}
+
+	update := models.Rule{Type: RuleItemPairs, Points: 50, Every: 4}
+	if _, err := srv.EditRules(RuleEdit{Action: models.RuleUpdate, Name: "bigBasket", Rule: update, Actor: "client:admin"}); err != nil {
+		t.Fatalf("Failed to update rule: %v", err)
+	}
+	if got := points(); got != 159 {
+		t.Fatalf("Expected the updated rule to score 50, got %d", got)
+	}
+
+	if _, err := srv.EditRules(RuleEdit{Action: models.RuleDelete, Name: "bigBasket", Actor: "client:admin"}); err != nil {
+		t.Fatalf("Failed to delete rule: %v", err)
+	}
+	if active := srv.ActiveRules(); active.Version != 4 || len(active.Rules) != 7 {
+		t.Fatalf("Expected version 4 with the 7 default rules, got %+v", active)
+	}
+
+	history := srv.RuleHistory("bigBasket")
+	actions := []string{models.RuleCreate, models.RuleDisable, models.RuleUpdate, models.RuleDelete}
+	if len(history) != len(actions) {
+		t.Fatalf("Expected %d changes, got %+v", len(actions), history)
+	}
+	for i, change := range history {
+		if change.Action != actions[i] || change.Version != int64(i+1) || change.At.IsZero() {
+			t.Fatalf("Expected change %d to be a %s, got %+v", i+1, actions[i], change)
+		}
+	}
+	if history[0].Before != nil || history[0].Actor != "client:ops" || history[3].After != nil || history[3].Actor != "client:admin" {
+		t.Fatalf("Expected the history to record who changed what, got %+v", history)
+	}
+	if history[2].Before.Points != 100 || !history[2].Before.Disabled || history[2].After.Points != 50 {
+		t.Fatalf("Expected the update to record the rule before and after, got %+v", history[2])
+	}
+
+	invalid := map[string]struct {
+		edit RuleEdit
+		err  error
+	}{
+		"duplicate":      {edit: RuleEdit{Action: models.RuleCreate, Rule: models.Rule{Type: RuleRoundTotal, Points: 5}}, err: ErrRuleExists},
+		"unknown rule":   {edit: RuleEdit{Action: models.RuleEnable, Name: "weekendBonus"}, err: ErrRuleNotFound},
+		"invalid rule":   {edit: RuleEdit{Action: models.RuleUpdate, Name: "roundTotal", Rule: models.Rule{Type: RuleRoundTotal}}},
+		"invalid sample": {edit: RuleEdit{Action: models.RuleDisable, Name: "roundTotal", Receipts: []models.Receipt{{Retailer: "Target"}}}},
+	}
+	for name, test := range invalid {
+		_, err := srv.EditRules(test.edit)
+		if err == nil || (test.err != nil && !errors.Is(err, test.err)) {
+			t.Fatalf("Expected %s to fail with %v, got %v", name, test.err, err)
+		}
+	}
+	if active := srv.ActiveRules(); active.Version != 4 {
+		t.Fatalf("Expected failed changes not to be applied, got version %d", active.Version)
+	}
+}
+
+func TestRules_Check(t *testing.T) {
+	rules := &Rules{rules: []pointRule{{name: "broken", calc: func(receipt *models.Receipt) int64 {
+		return int64(len(receipt.Items[5].ShortDescription))
+	}}}}
+	if err := rules.check(testMap["test 2"].receipt); err == nil {
+		t.Fatalf("Expected a rule that panics to fail validation")
+	}
+	if err := DefaultRules().check(testMap["test 2"].receipt); err != nil {
+		t.Fatalf("Expected the default rules to pass validation, got %v", err)
+	}
+}
+
+func TestLoadRuleRegistry(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "rule-history.json")
+	registry, err := LoadRuleRegistry(path, DefaultRules())
+	if err != nil {
+		t.Fatalf("Failed to load rule registry: %v", err)
+	}
+	db, _ := NewDB()
+	srv := NewReceiptService(db, WithRuleRegistry(registry))
+
+	bonus := models.Rule{Name: "bigBasket", Type: RuleItemPairs, Points: 100, Every: 4}
+	if _, err := srv.EditRules(RuleEdit{Action: models.RuleCreate, Rule: bonus, Actor: "client:ops"}); err != nil {
+		t.Fatalf("Failed to create rule: %v", err)
+	}
+
+	// a restart picks up the changed rules and their history
+	restored, err := LoadRuleRegistry(path, DefaultRules())
+	if err != nil {
+		t.Fatalf("Failed to reload rule registry: %v", err)
+	}
+	receipt := testMap["test 2"].receipt // M&M Corner Market, 109 points
+	if active := restored.Active(); active.Version != 1 || restored.Current().Points(receipt) != 209 {
+		t.Fatalf("Expected the changed rules at version 1, got version %d", active.Version)
+	}
+	if history := restored.History(""); len(history) != 1 || history[0].Rule != "bigBasket" || history[0].Actor != "client:ops" {
+		t.Fatalf("Expected the change in the history, got %+v", history)
+	}
+	if original, err := restored.Version(0); err != nil || original.Points(receipt) != 109 {
+		t.Fatalf("Expected version 0 to be the original rules (%v)", err)
+	}
+
+	// a change that can't be saved isn't applied
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatalf("Failed to remove the history directory: %v", err)
+	}
+	srv = NewReceiptService(db, WithRuleRegistry(restored))
+	_, err = srv.EditRules(RuleEdit{Action: models.RuleDelete, Name: "bigBasket", Actor: "client:ops"})
+	if !errors.Is(err, ErrRulesNotSaved) {
+		t.Fatalf("Expected ErrRulesNotSaved, got %v", err)
+	}
+	if active := restored.Active(); active.Version != 1 || len(restored.History("")) != 1 || restored.Current().Points(receipt) != 209 {
+		t.Fatalf("Expected the rules to stay at version 1, got version %d", active.Version)
+	}
+}
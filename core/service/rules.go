@@ -0,0 +1,181 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/RA341/receipt-processor-challenge/models"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// The rule types a rule file can configure
+const (
+	RuleRetailerName          = "retailerName"
+	RuleRoundTotal            = "roundTotal"
+	RuleTotalMultiple         = "totalMultiple"
+	RuleItemPairs             = "itemPairs"
+	RuleItemDescriptionLength = "itemDescriptionLength"
+	RuleOddPurchaseDay        = "oddPurchaseDay"
+	RulePurchaseTime          = "purchaseTime"
+	RuleExpression            = "expression"
+	RuleWasm                  = "wasm"
+)
+
+// maxRulePoints is the most one rule can award a receipt, so no rule can overflow the receipt's total
+const maxRulePoints = 1_000_000_000
+
+var ruleTypes = []string{
+	RuleRetailerName, RuleRoundTotal, RuleTotalMultiple, RuleItemPairs,
+	RuleItemDescriptionLength, RuleOddPurchaseDay, RulePurchaseTime, RuleExpression, RuleWasm,
+}
+
+// DefaultRuleSet is the rules of the spec, written as a rule file
+func DefaultRuleSet() models.RuleSet {
+	return models.RuleSet{
+		Name: "default",
+		Rules: []models.Rule{
+			{Name: "retailerName", Type: RuleRetailerName, Points: 1},
+			{Name: "roundTotal", Type: RuleRoundTotal, Points: 50},
+			{Name: "totalMultipleOfQuarter", Type: RuleTotalMultiple, Points: 25, Multiple: "0.25"},
+			{Name: "itemPairs", Type: RuleItemPairs, Points: 5, Every: 2},
+			{Name: "itemDescriptionLength", Type: RuleItemDescriptionLength, Every: 3, Multiplier: 0.2},
+			{Name: "oddPurchaseDay", Type: RuleOddPurchaseDay, Points: 6},
+			{Name: "purchaseTime2To4PM", Type: RulePurchaseTime, Points: 10, From: "14:00", To: "16:00"},
+		},
+	}
+}
+
+// Rules is a compiled rule set, ready to score receipts
+type Rules struct {
+	name string
+	// set is the rule set the rules were compiled from, with the default names filled in
+	set   models.RuleSet
+	rules []pointRule
+}
+
+// DefaultRules compiles DefaultRuleSet
+func DefaultRules() *Rules {
+	rules, err := CompileRules(DefaultRuleSet())
+	if err != nil {
+		panic(fmt.Sprintf("default rules are invalid: %v", err))
+	}
+	return rules
+}
+
+// LoadRulesFile reads and compiles a rule file, a JSON models.RuleSet
+func LoadRulesFile(path string) (*Rules, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var set models.RuleSet
+	if err := json.Unmarshal(contents, &set); err != nil {
+		return nil, fmt.Errorf("invalid rule file %s: %v", path, err)
+	}
+	if set.Name == "" {
+		set.Name = path
+	}
+	return CompileRules(set)
+}
+
+// CompileRules checks every rule of the set and builds its calculation, disabled rules are left out
+func CompileRules(set models.RuleSet) (*Rules, error) {
+	compiled := &Rules{name: set.Name, set: models.RuleSet{Name: set.Name, Rules: make([]models.Rule, 0, len(set.Rules))}}
+	seen := map[string]bool{}
+	for i, rule := range set.Rules {
+		if rule.Name == "" {
+			rule.Name = rule.Type
+		}
+		compiled.set.Rules = append(compiled.set.Rules, rule)
+		if seen[rule.Name] {
+			return nil, fmt.Errorf("rule %d: the name %s is used twice", i+1, rule.Name)
+		}
+		seen[rule.Name] = true
+
+		compiledRule, err := compileRule(rule)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: %v", rule.Name, err)
+		}
+		if !rule.Disabled {
+			compiled.rules = append(compiled.rules, compiledRule)
+		}
+	}
+	return compiled, nil
+}
+
+func compileRule(rule models.Rule) (pointRule, error) {
+	switch rule.Type {
+	case RuleExpression:
+		return compileExpressionRule(rule)
+	case RuleWasm:
+		return compileWasmRule(rule)
+	}
+	calc, err := compileRuleCalc(rule)
+	return pointRule{name: rule.Name, calc: calc}, err
+}
+
+func compileRuleCalc(rule models.Rule) (calculationOpts, error) {
+	if !slices.Contains(ruleTypes, rule.Type) {
+		return nil, fmt.Errorf("type must be one of %s", strings.Join(ruleTypes, ", "))
+	}
+	if rule.Points < 0 {
+		return nil, fmt.Errorf("points must not be negative")
+	}
+	if rule.Type != RuleItemDescriptionLength && rule.Points == 0 {
+		return nil, fmt.Errorf("points are required")
+	}
+
+	switch rule.Type {
+	case RuleRetailerName:
+		return pointsForRetailerName(rule.Points), nil
+	case RuleRoundTotal:
+		return pointsForRoundTotal(rule.Points), nil
+	case RuleTotalMultiple:
+		multiple, err := parseCents(rule.Multiple)
+		if err != nil || multiple == 0 {
+			return nil, fmt.Errorf("multiple must be a positive amount such as 0.25")
+		}
+		return pointsForTotalMultiple(rule.Points, multiple), nil
+	case RuleItemPairs:
+		if rule.Every <= 0 {
+			return nil, fmt.Errorf("every must be a positive item count")
+		}
+		return pointsPerItems(rule.Points, rule.Every), nil
+	case RuleItemDescriptionLength:
+		if rule.Every <= 0 || rule.Multiplier <= 0 {
+			return nil, fmt.Errorf("every and multiplier must be positive")
+		}
+		return pointsForItemDescriptionLength(rule.Every, rule.Multiplier), nil
+	case RuleOddPurchaseDay:
+		return pointsForOddPurchaseDay(rule.Points), nil
+	default: // RulePurchaseTime
+		from, fromErr := time.Parse(timeLayout, rule.From)
+		to, toErr := time.Parse(timeLayout, rule.To)
+		if fromErr != nil || toErr != nil || !from.Before(to) {
+			return nil, fmt.Errorf("from and to must be HH:MM, from before to")
+		}
+		return pointsForPurchaseTimeBetween(rule.Points, from, to), nil
+	}
+}
+
+func (r *Rules) Name() string {
+	return r.name
+}
+
+// RuleSet is the rule set the rules were compiled from, disabled rules included
+func (r *Rules) RuleSet() models.RuleSet {
+	return models.RuleSet{Name: r.set.Name, Rules: slices.Clone(r.set.Rules)}
+}
+
+// Breakdown runs the rules on the receipt, listing the ones that awarded points
+func (r *Rules) Breakdown(receipt models.Receipt) []models.BreakdownLine {
+	return calculateBreakdown(&receipt, r.rules...)
+}
+
+// Points is the total the rules award the receipt
+func (r *Rules) Points(receipt models.Receipt) int64 {
+	return calculatePoints(&receipt, r.rules...)
+}
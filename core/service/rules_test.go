@@ -0,0 +1,78 @@
+package service
+
+import (
+	"github.com/RA341/receipt-processor-challenge/models"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCompileRules(t *testing.T) {
+	set := DefaultRuleSet()
+	set.Rules[1].Disabled = true                   // roundTotal
+	set.Rules[2].Multiple = "1.00"                 // totalMultipleOfQuarter
+	set.Rules[3].Points, set.Rules[3].Every = 2, 1 // itemPairs, now per item
+
+	rules, err := CompileRules(set)
+	if err != nil {
+		t.Fatalf("Failed to compile rules: %v", err)
+	}
+
+	// M&M Corner Market: 14 for the name, 25 for a whole dollar total, 8 for 4 items,
+	// 10 for the afternoon purchase and nothing for the disabled round total
+	receipt := testMap["test 2"].receipt
+	if points := rules.Points(receipt); points != 14+25+8+10 {
+		t.Fatalf("Expected 57 points, got %d: %+v", points, rules.Breakdown(receipt))
+	}
+	for _, line := range rules.Breakdown(receipt) {
+		if line.Rule == "roundTotal" {
+			t.Fatalf("Expected the disabled rule to be left out")
+		}
+	}
+
+	invalid := map[string]models.Rule{
+		"unknown type":     {Type: "weather", Points: 5},
+		"missing points":   {Type: RuleRoundTotal},
+		"negative points":  {Type: RuleRoundTotal, Points: -5},
+		"bad multiple":     {Type: RuleTotalMultiple, Points: 5, Multiple: "quarter"},
+		"zero multiple":    {Type: RuleTotalMultiple, Points: 5, Multiple: "0.00"},
+		"no item count":    {Type: RuleItemPairs, Points: 5},
+		"no multiplier":    {Type: RuleItemDescriptionLength, Every: 3},
+		"backwards window": {Type: RulePurchaseTime, Points: 5, From: "16:00", To: "14:00"},
+		"bad time":         {Type: RulePurchaseTime, Points: 5, From: "2pm", To: "16:00"},
+	}
+	for name, rule := range invalid {
+		if _, err := CompileRules(models.RuleSet{Rules: []models.Rule{rule}}); err == nil {
+			t.Fatalf("Expected %s to fail", name)
+		}
+	}
+
+	duplicate := models.RuleSet{Rules: []models.Rule{{Type: RuleRoundTotal, Points: 5}, {Type: RuleRoundTotal, Points: 10}}}
+	if _, err := CompileRules(duplicate); err == nil {
+		t.Fatalf("Expected rules sharing a name to fail")
+	}
+}
+
+func TestLoadRulesFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.json")
+	contents := `{"rules": [{"type": "retailerName", "points": 2}, {"name": "bigBasket", "type": "itemPairs", "points": 10, "every": 5}]}`
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatalf("Failed to write rule file: %v", err)
+	}
+
+	rules, err := LoadRulesFile(path)
+	if err != nil {
+		t.Fatalf("Failed to load rule file: %v", err)
+	}
+	// Target: 6 alphanumeric characters at 2 points each, and one group of 5 items
+	if points := rules.Points(testMap["test 1"].receipt); points != 12+10 || rules.Name() != path {
+		t.Fatalf("Expected 22 points from %s, got %d", rules.Name(), points)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"rules": [`), 0o600); err != nil {
+		t.Fatalf("Failed to write rule file: %v", err)
+	}
+	if _, err := LoadRulesFile(path); err == nil {
+		t.Fatalf("Expected an invalid rule file to fail")
+	}
+}
@@ -0,0 +1,185 @@
+package service
+
+import (
+	"fmt"
+	"github.com/RA341/receipt-processor-challenge/models"
+	"sort"
+	"strings"
+)
+
+const (
+	simulationHistogramBuckets = 10
+	simulationTopRetailers     = 10
+)
+
+// SimulateRules rescores receipts under the current and candidate rules without changing anything, and
+// compares them. The stored receipts matching the query are rescored, or the sample when it isn't empty.
+// Only the rules are compared, campaign, category and tier points don't depend on them
+func (s *ReceiptService) SimulateRules(candidate *Rules, query ReceiptQuery, sample []models.Receipt) (models.SimulationResponse, error) {
+	sim := newRuleSimulation(s.rules.Current(), candidate)
+
+	if len(sample) > 0 {
+		for i, receipt := range sample {
+			if err := ValidateReceipt(receipt); err != nil {
+				return models.SimulationResponse{}, fmt.Errorf("receipt %d: %v", i+1, err)
+			}
+			s.categorizer.CategorizeItems(&receipt)
+			sim.add(receipt.Retailer, s.categorizer.WithoutExcluded(receipt))
+		}
+		return sim.result(), nil
+	}
+
+	query.Limit = MaxListLimit
+	query.Cursor = ""
+	for {
+		page, err := s.db.ListReceipts(query)
+		if err != nil {
+			return models.SimulationResponse{}, err
+		}
+		for _, record := range page.Receipts {
+			sim.add(record.Receipt.Retailer, s.categorizer.WithoutExcluded(s.scoringInput(record)))
+		}
+		if page.NextCursor == "" {
+			return sim.result(), nil
+		}
+		query.Cursor = page.NextCursor
+	}
+}
+
+// scoringInput is the receipt the rules ran on when the record was scored
+func (s *ReceiptService) scoringInput(record models.ReceiptRecord) models.Receipt {
+	scored := record.Receipt
+	if !s.scoreCanonicalName {
+		scored.Retailer = record.RawRetailer
+	}
+	return scored
+}
+
+type simulatedScore struct {
+	current   int64
+	candidate int64
+}
+
+type ruleSimulation struct {
+	current   *Rules
+	candidate *Rules
+
+	scores    []simulatedScore
+	rules     map[string]*models.RuleDelta
+	retailers map[string]*models.RetailerDelta
+}
+
+func newRuleSimulation(current, candidate *Rules) *ruleSimulation {
+	return &ruleSimulation{
+		current:   current,
+		candidate: candidate,
+		rules:     map[string]*models.RuleDelta{},
+		retailers: map[string]*models.RetailerDelta{},
+	}
+}
+
+// add scores the receipt under both rule sets, retailer is the name it is reported under
+func (sim *ruleSimulation) add(retailer string, receipt models.Receipt) {
+	var score simulatedScore
+	for _, line := range sim.current.Breakdown(receipt) {
+		sim.rule(line.Rule).Current += line.Points
+		score.current += line.Points
+	}
+	for _, line := range sim.candidate.Breakdown(receipt) {
+		sim.rule(line.Rule).Candidate += line.Points
+		score.candidate += line.Points
+	}
+	sim.scores = append(sim.scores, score)
+
+	delta, ok := sim.retailers[retailer]
+	if !ok {
+		delta = &models.RetailerDelta{Retailer: retailer}
+		sim.retailers[retailer] = delta
+	}
+	delta.Receipts++
+	delta.Current += score.current
+	delta.Candidate += score.candidate
+}
+
+func (sim *ruleSimulation) rule(name string) *models.RuleDelta {
+	delta, ok := sim.rules[name]
+	if !ok {
+		delta = &models.RuleDelta{Rule: name}
+		sim.rules[name] = delta
+	}
+	return delta
+}
+
+func (sim *ruleSimulation) result() models.SimulationResponse {
+	result := models.SimulationResponse{
+		Receipts:  int64(len(sim.scores)),
+		Rules:     []models.RuleDelta{},
+		Histogram: sim.histogram(),
+		Retailers: []models.RetailerDelta{},
+	}
+	for _, score := range sim.scores {
+		result.CurrentPoints += score.current
+		result.CandidatePoints += score.candidate
+		if score.candidate > score.current {
+			result.Increased++
+		} else if score.candidate < score.current {
+			result.Decreased++
+		}
+	}
+	result.Delta = result.CandidatePoints - result.CurrentPoints
+
+	for _, delta := range sim.rules {
+		delta.Delta = delta.Candidate - delta.Current
+		result.Rules = append(result.Rules, *delta)
+	}
+	sort.Slice(result.Rules, func(i, j int) bool {
+		return result.Rules[i].Rule < result.Rules[j].Rule
+	})
+
+	for _, delta := range sim.retailers {
+		delta.Delta = delta.Candidate - delta.Current
+		result.Retailers = append(result.Retailers, *delta)
+	}
+	// most affected first, by the size of the change either way
+	sort.Slice(result.Retailers, func(i, j int) bool {
+		a, b := abs(result.Retailers[i].Delta), abs(result.Retailers[j].Delta)
+		if a != b {
+			return a > b
+		}
+		return strings.ToLower(result.Retailers[i].Retailer) < strings.ToLower(result.Retailers[j].Retailer)
+	})
+	result.Retailers = result.Retailers[:min(len(result.Retailers), simulationTopRetailers)]
+
+	return result
+}
+
+// histogram splits the range of points under either rule set into equal buckets
+func (sim *ruleSimulation) histogram() []models.HistogramBucket {
+	if len(sim.scores) == 0 {
+		return []models.HistogramBucket{}
+	}
+
+	low, high := sim.scores[0].current, sim.scores[0].current
+	for _, score := range sim.scores {
+		low = min(low, score.current, score.candidate)
+		high = max(high, score.current, score.candidate)
+	}
+	width := (high - low + simulationHistogramBuckets) / simulationHistogramBuckets // rounded up
+
+	var buckets []models.HistogramBucket
+	for from := low; from <= high; from += width {
+		buckets = append(buckets, models.HistogramBucket{From: from, To: from + width - 1})
+	}
+	for _, score := range sim.scores {
+		buckets[(score.current-low)/width].Current++
+		buckets[(score.candidate-low)/width].Candidate++
+	}
+	return buckets
+}
+
+func abs(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,89 @@
+package service
+
+import (
+	"github.com/RA341/receipt-processor-challenge/models"
+	"reflect"
+	"testing"
+)
+
+func TestReceiptService_SimulateRules(t *testing.T) {
+	db, _ := NewDB()
+	srv := NewReceiptService(db)
+	for _, name := range []string{"test 1", "test 2", "test 2"} {
+		if _, err := srv.NewReceipt(testMap[name].receipt); err != nil {
+			t.Fatalf("Failed to submit receipt: %v", err)
+		}
+	}
+
+	// double the round total bonus and drop the odd day bonus
+	set := DefaultRuleSet()
+	set.Rules[1].Points = 100
+	set.Rules[5].Disabled = true
+	candidate, err := CompileRules(set)
+	if err != nil {
+		t.Fatalf("Failed to compile rules: %v", err)
+	}
+
+	result, err := srv.SimulateRules(candidate, ReceiptQuery{Status: models.StatusCredited}, nil)
+	if err != nil {
+		t.Fatalf("Failed to simulate rules: %v", err)
+	}
+	// Target loses its odd day 6 points, each M&M receipt gains 50
+	if result.Receipts != 3 || result.CurrentPoints != 28+2*109 || result.Delta != 2*50-6 ||
+		result.Increased != 2 || result.Decreased != 1 {
+		t.Fatalf("Unexpected totals %+v", result)
+	}
+
+	rules := map[string]models.RuleDelta{}
+	for _, delta := range result.Rules {
+		rules[delta.Rule] = delta
+	}
+	if rules["roundTotal"] != (models.RuleDelta{Rule: "roundTotal", Current: 100, Candidate: 200, Delta: 100}) ||
+		rules["oddPurchaseDay"] != (models.RuleDelta{Rule: "oddPurchaseDay", Current: 6, Delta: -6}) ||
+		rules["retailerName"].Delta != 0 {
+		t.Fatalf("Unexpected rule deltas %+v", result.Rules)
+	}
+
+	expectedRetailers := []models.RetailerDelta{
+		{Retailer: "M&M Corner Market", Receipts: 2, Current: 218, Candidate: 318, Delta: 100},
+		{Retailer: "Target", Receipts: 1, Current: 28, Candidate: 22, Delta: -6},
+	}
+	if !reflect.DeepEqual(result.Retailers, expectedRetailers) {
+		t.Fatalf("Expected retailers %+v, got %+v", expectedRetailers, result.Retailers)
+	}
+
+	// points range from 22 to 159, in buckets of 14
+	var currentCount, candidateCount int64
+	for _, bucket := range result.Histogram {
+		currentCount += bucket.Current
+		candidateCount += bucket.Candidate
+	}
+	first, last := result.Histogram[0], result.Histogram[len(result.Histogram)-1]
+	if currentCount != 3 || candidateCount != 3 || first.From != 22 || first.Candidate != 1 || last.To < 159 || last.Candidate != 2 {
+		t.Fatalf("Unexpected histogram %+v", result.Histogram)
+	}
+
+	// nothing was rescored for real
+	if page, _ := db.ListReceipts(ReceiptQuery{}); page.Receipts[0].Points != 28 {
+		t.Fatalf("Expected the stored receipts to be left alone, got %d points", page.Receipts[0].Points)
+	}
+}
+
+func TestReceiptService_SimulateRules_Sample(t *testing.T) {
+	db, _ := NewDB()
+	srv := NewReceiptService(db)
+
+	result, err := srv.SimulateRules(DefaultRules(), ReceiptQuery{}, []models.Receipt{testMap["test 1"].receipt})
+	if err != nil {
+		t.Fatalf("Failed to simulate rules: %v", err)
+	}
+	if result.Receipts != 1 || result.CurrentPoints != 28 || result.Delta != 0 || len(result.Histogram) != 1 {
+		t.Fatalf("Unexpected simulation of the sample %+v", result)
+	}
+
+	invalid := testMap["test 1"].receipt
+	invalid.Total = "35"
+	if _, err := srv.SimulateRules(DefaultRules(), ReceiptQuery{}, []models.Receipt{invalid}); err == nil {
+		t.Fatalf("Expected an invalid sample receipt to fail")
+	}
+}
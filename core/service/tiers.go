@@ -0,0 +1,176 @@
+package service
+
+import (
+	"fmt"
+	"github.com/RA341/receipt-processor-challenge/config"
+	"github.com/RA341/receipt-processor-challenge/models"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// BaseTier is assigned to members below the lowest configured tier
+const BaseTier = "Base"
+
+type ledgerEntry struct {
+	at     time.Time
+	points int64
+}
+
+type memberState struct {
+	tier    config.TierConfig
+	ledger  []ledgerEntry
+	history []models.TierChange
+}
+
+// TierService tracks rolling 12-month points per member and evaluates their tier
+type TierService struct {
+	mu      sync.Mutex
+	tiers   []config.TierConfig // sorted by MinPoints ascending
+	members map[string]*memberState
+	now     func() time.Time
+}
+
+func NewTierService(tiers []config.TierConfig) *TierService {
+	sorted := make([]config.TierConfig, len(tiers))
+	copy(sorted, tiers)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].MinPoints < sorted[j].MinPoints
+	})
+
+	return &TierService{
+		tiers:   sorted,
+		members: map[string]*memberState{},
+		now:     time.Now,
+	}
+}
+
+// CurrentTier returns the tier the member is currently in, after dropping points older than 12 months
+func (ts *TierService) CurrentTier(memberId string) config.TierConfig {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	state, ok := ts.members[memberId]
+	if !ok {
+		return baseTier()
+	}
+	ts.evaluate(state, ts.now())
+	return state.tier
+}
+
+// Credit adds points to the member's ledger and re-evaluates their tier, negative points reverse an earlier credit
+func (ts *TierService) Credit(memberId string, points int64) {
+	ts.CreditAt(memberId, points, ts.now())
+}
+
+// CreditAt adds points earned at a past time, they leave the rolling window 12 months after it.
+// Points older than that never count
+func (ts *TierService) CreditAt(memberId string, points int64, at time.Time) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	state := ts.getOrCreate(memberId)
+	now := ts.now()
+	if at.After(now) {
+		at = now
+	}
+	state.ledger = append(state.ledger, ledgerEntry{at: at, points: points})
+	ts.evaluate(state, now)
+}
+
+// Reverse takes back points credited at creditedAt. It is dated like the credit, so the two leave the
+// rolling window together instead of the reversal outliving the points it took back
+func (ts *TierService) Reverse(memberId string, points int64, creditedAt time.Time) {
+	ts.CreditAt(memberId, -points, creditedAt)
+}
+
+// Member returns the member's tier, rolling points and tier history
+func (ts *TierService) Member(memberId string) (models.MemberTierResponse, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	state, ok := ts.members[memberId]
+	if !ok {
+		return models.MemberTierResponse{}, fmt.Errorf("unable to find member: %s", memberId)
+	}
+
+	rolling := ts.evaluate(state, ts.now())
+	history := make([]models.TierChange, len(state.history))
+	copy(history, state.history)
+
+	return models.MemberTierResponse{
+		MemberId:      memberId,
+		Tier:          state.tier.Name,
+		Multiplier:    state.tier.Multiplier,
+		RollingPoints: rolling,
+		History:       history,
+	}, nil
+}
+
+// evaluate drops ledger entries older than 12 months, moves the member to the
+// tier matching the remaining points and returns the rolling points
+func (ts *TierService) evaluate(state *memberState, now time.Time) int64 {
+	cutoff := now.AddDate(-1, 0, 0)
+	kept := state.ledger[:0]
+	var rolling int64 = 0
+	for _, entry := range state.ledger {
+		if entry.at.After(cutoff) {
+			kept = append(kept, entry)
+			rolling += entry.points
+		}
+	}
+	state.ledger = kept
+
+	newTier := ts.tierFor(rolling)
+	if newTier.Name != state.tier.Name {
+		state.history = append(state.history, models.TierChange{
+			From:      state.tier.Name,
+			To:        newTier.Name,
+			Points:    rolling,
+			ChangedAt: now,
+		})
+		state.tier = newTier
+	}
+
+	return rolling
+}
+
+func (ts *TierService) tierFor(points int64) config.TierConfig {
+	tier := baseTier()
+	for _, t := range ts.tiers {
+		if points >= t.MinPoints {
+			tier = t
+		}
+	}
+	return tier
+}
+
+func (ts *TierService) getOrCreate(memberId string) *memberState {
+	state, ok := ts.members[memberId]
+	if !ok {
+		state = &memberState{tier: baseTier()}
+		ts.members[memberId] = state
+	}
+	return state
+}
+
+func baseTier() config.TierConfig {
+	return config.TierConfig{Name: BaseTier, Multiplier: 1}
+}
+
+// tierMultiplierLine is the extra points earned by the tier multiplier as a breakdown line,
+// ok is false when the multiplier awards nothing
+func tierMultiplierLine(tier config.TierConfig, basePoints int64) (line models.BreakdownLine, ok bool) {
+	multiplied := int64(math.Round(float64(basePoints) * tier.Multiplier))
+	bonus := multiplied - basePoints
+	if bonus == 0 {
+		return models.BreakdownLine{}, false
+	}
+
+	return models.BreakdownLine{
+		Rule:   "tierMultiplier",
+		Points: bonus,
+		Detail: fmt.Sprintf("%s x%g", tier.Name, tier.Multiplier),
+	}, true
+}
@@ -0,0 +1,116 @@
+package service
+
+import (
+	"github.com/RA341/receipt-processor-challenge/config"
+	"github.com/RA341/receipt-processor-challenge/models"
+	"testing"
+	"time"
+)
+
+var testTiers = []config.TierConfig{
+	{Name: "Gold", MinPoints: 200, Multiplier: 2},
+	{Name: "Silver", MinPoints: 100, Multiplier: 1.5},
+}
+
+func TestTierService_Promotion(t *testing.T) {
+	ts := NewTierService(testTiers)
+
+	ts.Credit("member-1", 150)
+	if tier := ts.CurrentTier("member-1"); tier.Name != "Silver" {
+		t.Fatalf("Expected Silver but got %s", tier.Name)
+	}
+
+	ts.Credit("member-1", 60)
+	member, err := ts.Member("member-1")
+	if err != nil {
+		t.Fatalf("Failed to get member: %v", err)
+	}
+	if member.Tier != "Gold" || member.RollingPoints != 210 {
+		t.Fatalf("Expected Gold with 210 points but got %s with %d", member.Tier, member.RollingPoints)
+	}
+	if len(member.History) != 2 || member.History[0].To != "Silver" || member.History[1].To != "Gold" {
+		t.Fatalf("Unexpected tier history: %+v", member.History)
+	}
+}
+
+func TestTierService_RollingWindowExpiry(t *testing.T) {
+	ts := NewTierService(testTiers)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	ts.now = func() time.Time { return now }
+
+	ts.Credit("member-1", 250)
+	if tier := ts.CurrentTier("member-1"); tier.Name != "Gold" {
+		t.Fatalf("Expected Gold but got %s", tier.Name)
+	}
+
+	now = now.AddDate(1, 0, 1)
+	if tier := ts.CurrentTier("member-1"); tier.Name != BaseTier {
+		t.Fatalf("Expected %s once the points expired but got %s", BaseTier, tier.Name)
+	}
+	member, err := ts.Member("member-1")
+	if err != nil {
+		t.Fatalf("Failed to get member: %v", err)
+	}
+	if member.Tier != BaseTier || member.RollingPoints != 0 {
+		t.Fatalf("Expected %s with 0 points but got %s with %d", BaseTier, member.Tier, member.RollingPoints)
+	}
+}
+
+func TestTierService_ReverseWindowEdge(t *testing.T) {
+	ts := NewTierService(testTiers)
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := start
+	ts.now = func() time.Time { return now }
+
+	ts.Credit("member-1", 100) // voided in part later
+	now = start.AddDate(0, 6, 0)
+	ts.Credit("member-1", 100)
+
+	now = start.AddDate(0, 8, 0)
+	ts.Reverse("member-1", 40, start)
+	if member, _ := ts.Member("member-1"); member.RollingPoints != 160 || member.Tier != "Silver" {
+		t.Fatalf("Expected Silver with 160 points after the reversal, got %s with %d", member.Tier, member.RollingPoints)
+	}
+
+	// the first credit and its reversal expire together, the second credit alone keeps the member Silver
+	now = start.AddDate(1, 0, 1)
+	if member, _ := ts.Member("member-1"); member.RollingPoints != 100 || member.Tier != "Silver" {
+		t.Fatalf("Expected Silver with 100 points once the first credit expired, got %s with %d", member.Tier, member.RollingPoints)
+	}
+}
+
+func TestReceiptService_TierMultiplierLine(t *testing.T) {
+	db, _ := NewDB()
+	srv := NewReceiptService(db, WithTiers(NewTierService(testTiers)))
+
+	receipt := testMap["test 2"].receipt
+	receipt.MemberId = "member-1"
+
+	// first receipt: 109 points at the base tier promotes the member to Silver
+	if _, err := srv.NewReceipt(receipt); err != nil {
+		t.Fatalf("Failed to create receipt: %v", err)
+	}
+
+	id, err := srv.NewReceipt(receipt)
+	if err != nil {
+		t.Fatalf("Failed to create receipt: %v", err)
+	}
+
+	record, err := srv.GetReceiptById(id)
+	if err != nil {
+		t.Fatalf("Failed to get receipt: %v", err)
+	}
+
+	var multiplierLine *models.BreakdownLine
+	for i, line := range record.Breakdown {
+		if line.Rule == "tierMultiplier" {
+			multiplierLine = &record.Breakdown[i]
+		}
+	}
+	if multiplierLine == nil || multiplierLine.Points != 55 {
+		t.Fatalf("Expected a tierMultiplier line of 55 points but got %+v", record.Breakdown)
+	}
+	if record.Points != 164 || record.Tier != "Silver" {
+		t.Fatalf("Expected 164 points at Silver but got %d at %s", record.Points, record.Tier)
+	}
+}
@@ -0,0 +1,52 @@
+package service
+
+import (
+	"fmt"
+	"github.com/RA341/receipt-processor-challenge/models"
+	"regexp"
+	"time"
+)
+
+var (
+	retailerPattern = regexp.MustCompile(`^[\w\s\-&]+$`)
+	amountPattern   = regexp.MustCompile(`^\d+\.\d{2}$`)
+	memberIdPattern = regexp.MustCompile(`^\S+$`)
+)
+
+// ValidateReceipt checks the receipt against the API spec, before it is scored
+func ValidateReceipt(receipt models.Receipt) error {
+	if !retailerPattern.MatchString(receipt.Retailer) {
+		return fmt.Errorf("invalid retailer name: must contain only alphanumeric characters, spaces, hyphens, and ampersands")
+	}
+
+	if _, err := time.Parse(dateLayout, receipt.PurchaseDate); err != nil {
+		return fmt.Errorf("invalid purchaseDate format: must be YYYY-MM-DD")
+	}
+
+	if _, err := time.Parse(timeLayout, receipt.PurchaseTime); err != nil {
+		return fmt.Errorf("invalid purchaseTime format: must be in 24-hour format (HH:MM)")
+	}
+
+	if len(receipt.Items) < 1 {
+		return fmt.Errorf("at least one item is required")
+	}
+
+	for i, item := range receipt.Items {
+		if item.ShortDescription == "" {
+			return fmt.Errorf("item %d is missing a short description", i+1)
+		}
+		if !amountPattern.MatchString(item.Price) {
+			return fmt.Errorf("item %d has an invalid price format: must be in format 0.00", i+1)
+		}
+	}
+
+	if !amountPattern.MatchString(receipt.Total) {
+		return fmt.Errorf("invalid total format: must be in format 0.00")
+	}
+
+	if receipt.MemberId != "" && !memberIdPattern.MatchString(receipt.MemberId) {
+		return fmt.Errorf("invalid memberId: must not contain whitespace")
+	}
+
+	return nil
+}
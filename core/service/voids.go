@@ -0,0 +1,121 @@
+package service
+
+import (
+	"fmt"
+	"github.com/RA341/receipt-processor-challenge/models"
+	"math"
+	"strings"
+	"time"
+)
+
+// VoidReceipt takes back the points of a credited receipt. Without items the whole receipt is voided,
+// otherwise only the points the returned items earned, by rescoring the receipt without them
+func (s *ReceiptService) VoidReceipt(receiptId, actor, reason string, items []int) (models.ReceiptRecord, error) {
+	if strings.TrimSpace(reason) == "" {
+		return models.ReceiptRecord{}, fmt.Errorf("a reason is required to void a receipt")
+	}
+
+	s.voidMu.Lock()
+	defer s.voidMu.Unlock()
+
+	record, err := s.db.GetReceiptById(receiptId)
+	if err != nil {
+		return models.ReceiptRecord{}, err
+	}
+	if record.Status != models.StatusCredited {
+		return models.ReceiptRecord{}, fmt.Errorf("%w: only credited receipts can be voided, receipt is %s", ErrInvalidTransition, record.Status)
+	}
+
+	returned := returnedItems(record)
+	for _, index := range items {
+		if index < 0 || index >= len(record.Receipt.Items) {
+			return models.ReceiptRecord{}, fmt.Errorf("item %d does not exist", index)
+		}
+		if returned[index] {
+			return models.ReceiptRecord{}, fmt.Errorf("item %d was already returned", index)
+		}
+		returned[index] = true
+	}
+
+	before := record
+	now := time.Now()
+	void := models.Void{Reason: reason, Actor: actor, Items: items, At: now}
+	if len(items) == 0 || len(returned) == len(record.Receipt.Items) {
+		// the whole receipt is gone, take back whatever is left of it
+		void.Points = record.Points
+		if err := transition(&record, models.StatusVoided, now); err != nil {
+			return models.ReceiptRecord{}, err
+		}
+	} else {
+		void.Points = s.returnedPoints(record, returned)
+	}
+
+	record.Voids = append(record.Voids, void)
+	if void.Points != 0 {
+		record.Breakdown = append(record.Breakdown, models.BreakdownLine{
+			Rule:   "void",
+			Points: -void.Points,
+			Detail: reason,
+		})
+		record.Points -= void.Points
+	}
+
+	if err := s.db.UpdateReceipt(record); err != nil {
+		return models.ReceiptRecord{}, err
+	}
+	if record.Receipt.MemberId != "" && void.Points != 0 {
+		s.tiers.Reverse(record.Receipt.MemberId, void.Points, tierCreditTime(record))
+	}
+	// a partial void keeps the campaign points of the kept items counting against the caps
+	if record.Status == models.StatusVoided {
+		s.campaigns.Reverse(record.Id)
+	}
+	s.reports.Replace(before, record)
+	s.events.Publish(receiptEvent(models.EventReceiptVoided, record))
+
+	return record, nil
+}
+
+// returnedPoints is how many more points the receipt earned than it would without the returned items.
+// The base points are rescored and the campaign and tier points scale along with them
+func (s *ReceiptService) returnedPoints(record models.ReceiptRecord, returned map[int]bool) int64 {
+	scored := s.scoringInput(record)
+	basePoints := sumBreakdown(s.baseBreakdown(s.rulesFor(record), scored))
+
+	total, _ := parseCents(scored.Total)
+	kept := make([]models.Item, 0, len(scored.Items))
+	for i, item := range scored.Items {
+		if !returned[i] {
+			kept = append(kept, item)
+			continue
+		}
+		price, _ := parseCents(item.Price)
+		total -= price
+	}
+	scored.Items = kept
+	scored.Total = formatCents(max(total, 0))
+	remaining := sumBreakdown(s.baseBreakdown(s.rulesFor(record), scored))
+
+	if basePoints <= 0 {
+		return 0
+	}
+	// remaining counts every returned item, take off what earlier partial voids already took back
+	var voided int64 = 0
+	for _, void := range record.Voids {
+		voided += void.Points
+	}
+	originalPoints := record.Points + voided
+	points := int64(math.Round(float64(basePoints-remaining)*float64(originalPoints)/float64(basePoints))) - voided
+	return min(max(points, 0), record.Points)
+}
+
+// returnedItems are the items returned by earlier partial voids
+func returnedItems(record models.ReceiptRecord) map[int]bool {
+	returned := map[int]bool{}
+	for _, void := range record.Voids {
+		for _, index := range void.Items {
+			returned[index] = true
+		}
+	}
+	return returned
+}
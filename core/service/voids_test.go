@@ -0,0 +1,86 @@
+package service
+
+import (
+	"errors"
+	"github.com/RA341/receipt-processor-challenge/config"
+	"github.com/RA341/receipt-processor-challenge/models"
+	"testing"
+)
+
+func TestReceiptService_VoidReceipt(t *testing.T) {
+	tiers := NewTierService(config.Default().Tiers)
+	db, _ := NewDB()
+	srv := NewReceiptService(db, WithTiers(tiers))
+
+	receipt := testMap["test 1"].receipt
+	receipt.MemberId = "member-1"
+	id, err := srv.NewReceipt(receipt)
+	if err != nil {
+		t.Fatalf("Failed to submit receipt: %v", err)
+	}
+
+	if _, err := srv.VoidReceipt(id, "admin", "", nil); err == nil {
+		t.Fatalf("Expected a void without reason to fail")
+	}
+
+	// returning the pizza loses its 3 description points, the total stays off the quarter
+	record, err := srv.VoidReceipt(id, "admin", "returned pizza", []int{1})
+	if err != nil {
+		t.Fatalf("Failed to void item: %v", err)
+	}
+	if record.Status != models.StatusCredited || record.Points != 25 {
+		t.Fatalf("Expected 25 points left after the partial void, got %d (%s)", record.Points, record.Status)
+	}
+
+	// returning the Klarbrunn as well also loses a pair of items
+	record, err = srv.VoidReceipt(id, "admin", "returned drinks", []int{4})
+	if err != nil {
+		t.Fatalf("Failed to void item: %v", err)
+	}
+	if record.Points != 17 || record.Voids[1].Points != 8 {
+		t.Fatalf("Expected 17 points left after the second partial void, got %+v", record.Voids)
+	}
+	if _, err := srv.VoidReceipt(id, "admin", "returned pizza again", []int{1}); err == nil {
+		t.Fatalf("Expected voiding a returned item again to fail")
+	}
+
+	record, err = srv.VoidReceipt(id, "admin", "chargeback", nil)
+	if err != nil {
+		t.Fatalf("Failed to void receipt: %v", err)
+	}
+	if record.Status != models.StatusVoided || record.Points != 0 || sumBreakdown(record.Breakdown) != 0 {
+		t.Fatalf("Expected the receipt to be voided with no points left, got %+v", record)
+	}
+
+	member, _ := tiers.Member("member-1")
+	if member.RollingPoints != 0 {
+		t.Fatalf("Expected the member's points to be reversed, got %d", member.RollingPoints)
+	}
+
+	if _, err := srv.VoidReceipt(id, "admin", "again", nil); !errors.Is(err, ErrInvalidTransition) {
+		t.Fatalf("Expected voiding twice to fail, got %v", err)
+	}
+}
+
+func TestReceiptService_VoidReceipt_AfterRuleEdit(t *testing.T) {
+	db, _ := NewDB()
+	srv := NewReceiptService(db)
+
+	id, err := srv.NewReceipt(testMap["test 1"].receipt) // 28 points
+	if err != nil {
+		t.Fatalf("Failed to submit receipt: %v", err)
+	}
+	bonus := models.Rule{Name: "bigBasket", Type: RuleItemPairs, Points: 100, Every: 4}
+	if _, err := srv.EditRules(RuleEdit{Action: models.RuleCreate, Rule: bonus, Actor: "client:ops"}); err != nil {
+		t.Fatalf("Failed to create rule: %v", err)
+	}
+
+	// the refund is worked out with the rules the receipt was scored with, not the bonus it never earned
+	record, err := srv.VoidReceipt(id, "admin", "returned pizza", []int{1})
+	if err != nil {
+		t.Fatalf("Failed to void item: %v", err)
+	}
+	if record.RulesVersion == nil || *record.RulesVersion != 0 || record.Voids[0].Points != 3 {
+		t.Fatalf("Expected the pizza's 3 points back under rule version 0, got %+v", record.Voids)
+	}
+}
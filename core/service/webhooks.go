@@ -0,0 +1,433 @@
+package service
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/RA341/receipt-processor-challenge/config"
+	"github.com/RA341/receipt-processor-challenge/models"
+	u "github.com/RA341/receipt-processor-challenge/utils"
+	"github.com/google/uuid"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// WebhookSignatureHeader carries "t=<unix seconds>,v1=<hex HMAC-SHA256 of "<t>.<body>">"
+	WebhookSignatureHeader = "X-Webhook-Signature"
+	WebhookEventHeader     = "X-Webhook-Event"
+	// WebhookEventIdHeader is the same on every attempt, receivers can use it to drop duplicates
+	WebhookEventIdHeader = "X-Webhook-Event-Id"
+)
+
+var (
+	ErrWebhookNotFound  = errors.New("no webhook subscription for that id")
+	ErrDeliveryNotFound = errors.New("no webhook delivery for that id")
+)
+
+var webhookEventTypes = []string{
+	models.EventReceiptScored,
+	models.EventReceiptFlagged,
+	models.EventReceiptRejected,
+	models.EventReceiptVoided,
+}
+
+type webhookJob struct {
+	subscriptionId string
+	event          models.ReceiptEvent
+	attempt        int
+}
+
+type loggedDelivery struct {
+	delivery models.WebhookDelivery
+	// event is kept for manual redelivery
+	event models.ReceiptEvent
+}
+
+type webhookState struct {
+	subscription models.WebhookSubscription
+	deliveries   []loggedDelivery // oldest first
+}
+
+// WebhookDispatcher delivers receipt events to the subscribed urls on a pool of workers,
+// retrying failed deliveries with exponential backoff
+type WebhookDispatcher struct {
+	cfg    config.WebhooksConfig
+	client *http.Client
+	queue  chan webhookJob
+	wg     sync.WaitGroup
+
+	mu            sync.Mutex
+	closed        bool
+	subscriptions map[string]*webhookState
+	// retries are the failed deliveries waiting for their backoff, Close sends them right away
+	retries map[*time.Timer]webhookJob
+}
+
+func NewWebhookDispatcher(cfg config.WebhooksConfig) *WebhookDispatcher {
+	d := &WebhookDispatcher{
+		cfg:           cfg,
+		client:        &http.Client{Timeout: cfg.Timeout.Duration},
+		queue:         make(chan webhookJob, cfg.QueueSize),
+		subscriptions: map[string]*webhookState{},
+		retries:       map[*time.Timer]webhookJob{},
+	}
+	for i := 0; i < cfg.Workers; i++ {
+		d.wg.Add(1)
+		go d.work()
+	}
+	return d
+}
+
+// Close stops the workers once the queued deliveries finish, retries waiting for their
+// backoff are attempted right away and the ones that don't fit the queue are logged as failed
+func (d *WebhookDispatcher) Close() {
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return
+	}
+	d.closed = true
+
+	var dropped []webhookJob
+	for timer, job := range d.retries {
+		if !timer.Stop() {
+			continue // already running, it finds the dispatcher closed
+		}
+		select {
+		case d.queue <- job:
+		default:
+			dropped = append(dropped, job)
+		}
+	}
+	clear(d.retries)
+	close(d.queue)
+	d.mu.Unlock()
+
+	for _, job := range dropped {
+		d.log(job, 0, fmt.Errorf("webhook dispatcher closed before the retry"))
+	}
+	d.wg.Wait()
+}
+
+// Create adds a subscription with a new signing secret, which is only returned here
+func (d *WebhookDispatcher) Create(subscription models.WebhookSubscription) (models.WebhookSubscription, error) {
+	if err := validateWebhook(subscription); err != nil {
+		return models.WebhookSubscription{}, err
+	}
+
+	newUUID, err := uuid.NewUUID()
+	if err != nil {
+		return models.WebhookSubscription{}, err
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return models.WebhookSubscription{}, err
+	}
+
+	subscription.Id = newUUID.String()
+	subscription.Secret = "whsec_" + hex.EncodeToString(secret)
+	subscription.CreatedAt = time.Now()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.subscriptions[subscription.Id] = &webhookState{subscription: subscription}
+	return subscription, nil
+}
+
+// List returns the subscriptions without their secrets
+func (d *WebhookDispatcher) List() []models.WebhookSubscription {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	subscriptions := make([]models.WebhookSubscription, 0, len(d.subscriptions))
+	for _, state := range d.subscriptions {
+		subscriptions = append(subscriptions, withoutSecret(state.subscription))
+	}
+	sort.Slice(subscriptions, func(i, j int) bool {
+		return subscriptions[i].CreatedAt.Before(subscriptions[j].CreatedAt)
+	})
+	return subscriptions
+}
+
+func (d *WebhookDispatcher) Get(id string) (models.WebhookSubscription, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	state, ok := d.subscriptions[id]
+	if !ok {
+		return models.WebhookSubscription{}, ErrWebhookNotFound
+	}
+	return withoutSecret(state.subscription), nil
+}
+
+func (d *WebhookDispatcher) Delete(id string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.subscriptions[id]; !ok {
+		return ErrWebhookNotFound
+	}
+	delete(d.subscriptions, id)
+	return nil
+}
+
+// Deliveries returns the delivery log of the subscription, newest first
+func (d *WebhookDispatcher) Deliveries(id string) ([]models.WebhookDelivery, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	state, ok := d.subscriptions[id]
+	if !ok {
+		return nil, ErrWebhookNotFound
+	}
+
+	deliveries := make([]models.WebhookDelivery, 0, len(state.deliveries))
+	for i := len(state.deliveries) - 1; i >= 0; i-- {
+		deliveries = append(deliveries, state.deliveries[i].delivery)
+	}
+	return deliveries, nil
+}
+
+// Redeliver sends the event of a logged delivery again, starting a new series of attempts
+func (d *WebhookDispatcher) Redeliver(id, deliveryId string) error {
+	d.mu.Lock()
+	state, ok := d.subscriptions[id]
+	if !ok {
+		d.mu.Unlock()
+		return ErrWebhookNotFound
+	}
+	index := slices.IndexFunc(state.deliveries, func(logged loggedDelivery) bool {
+		return logged.delivery.Id == deliveryId
+	})
+	if index < 0 {
+		d.mu.Unlock()
+		return ErrDeliveryNotFound
+	}
+	event := state.deliveries[index].event
+	d.mu.Unlock()
+
+	return d.enqueue(webhookJob{subscriptionId: id, event: event, attempt: 1})
+}
+
+// Handle queues the event for every subscription that wants it, it is meant to be subscribed to the event bus
+func (d *WebhookDispatcher) Handle(event models.ReceiptEvent) {
+	d.mu.Lock()
+	var subscribed []string
+	for id, state := range d.subscriptions {
+		if len(state.subscription.Events) == 0 || slices.Contains(state.subscription.Events, event.Type) {
+			subscribed = append(subscribed, id)
+		}
+	}
+	d.mu.Unlock()
+
+	for _, id := range subscribed {
+		job := webhookJob{subscriptionId: id, event: event, attempt: 1}
+		if err := d.enqueue(job); err != nil {
+			slog.Warn("Unable to queue webhook delivery", slog.String("subscription", id), u.ErrLog(err))
+			d.log(job, 0, err)
+		}
+	}
+}
+
+// enqueue queues the job without blocking
+func (d *WebhookDispatcher) enqueue(job webhookJob) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.closed {
+		return fmt.Errorf("webhook dispatcher is closed")
+	}
+	select {
+	case d.queue <- job:
+		return nil
+	default:
+		return fmt.Errorf("webhook queue is full")
+	}
+}
+
+func (d *WebhookDispatcher) work() {
+	defer d.wg.Done()
+	for job := range d.queue {
+		d.deliver(job)
+	}
+}
+
+func (d *WebhookDispatcher) deliver(job webhookJob) {
+	d.mu.Lock()
+	state, ok := d.subscriptions[job.subscriptionId]
+	d.mu.Unlock()
+	if !ok {
+		return // deleted since the event was queued
+	}
+
+	statusCode, err := d.send(state.subscription, job.event)
+	d.log(job, statusCode, err)
+	if err == nil || job.attempt >= d.cfg.MaxAttempts {
+		return
+	}
+
+	retry := job
+	retry.attempt++
+	d.scheduleRetry(retry, d.backoff(job.attempt))
+}
+
+// scheduleRetry queues the job after the backoff, a retry failing after Close is logged as failed
+func (d *WebhookDispatcher) scheduleRetry(job webhookJob, backoff time.Duration) {
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		d.log(job, 0, fmt.Errorf("webhook dispatcher closed before the retry"))
+		return
+	}
+
+	var timer *time.Timer
+	// the callback takes the lock first, so the timer is registered before it runs
+	timer = time.AfterFunc(backoff, func() {
+		d.mu.Lock()
+		delete(d.retries, timer)
+		d.mu.Unlock()
+
+		if err := d.enqueue(job); err != nil {
+			slog.Warn("Unable to retry webhook delivery", slog.String("subscription", job.subscriptionId), u.ErrLog(err))
+			d.log(job, 0, err)
+		}
+	})
+	d.retries[timer] = job
+	d.mu.Unlock()
+}
+
+func (d *WebhookDispatcher) send(subscription models.WebhookSubscription, event models.ReceiptEvent) (statusCode int, err error) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, subscription.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookEventHeader, event.Type)
+	req.Header.Set(WebhookEventIdHeader, event.Id)
+	req.Header.Set(WebhookSignatureHeader, SignWebhookPayload(subscription.Secret, time.Now().Unix(), body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer func(Body io.ReadCloser) {
+		_, _ = io.Copy(io.Discard, io.LimitReader(Body, 64<<10))
+		_ = Body.Close()
+	}(resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("receiver returned %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// backoff is the wait after the attempt failed, doubling from RetryBackoff up to MaxBackoff
+func (d *WebhookDispatcher) backoff(attempt int) time.Duration {
+	wait := d.cfg.RetryBackoff.Duration
+	for i := 1; i < attempt && wait < d.cfg.MaxBackoff.Duration; i++ {
+		wait *= 2
+	}
+	return min(wait, d.cfg.MaxBackoff.Duration)
+}
+
+func (d *WebhookDispatcher) log(job webhookJob, statusCode int, err error) {
+	delivery := models.WebhookDelivery{
+		Id:             uuid.NewString(),
+		SubscriptionId: job.subscriptionId,
+		EventId:        job.event.Id,
+		EventType:      job.event.Type,
+		Attempt:        job.attempt,
+		StatusCode:     statusCode,
+		Success:        err == nil,
+		At:             time.Now(),
+	}
+	if err != nil {
+		delivery.Error = err.Error()
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	state, ok := d.subscriptions[job.subscriptionId]
+	if !ok {
+		return
+	}
+	state.deliveries = append(state.deliveries, loggedDelivery{delivery: delivery, event: job.event})
+	if overflow := len(state.deliveries) - d.cfg.LogSize; overflow > 0 {
+		state.deliveries = slices.Delete(state.deliveries, 0, overflow)
+	}
+}
+
+// SignWebhookPayload returns the signature header value for a body sent at timestamp
+func SignWebhookPayload(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+	return fmt.Sprintf("t=%d,v1=%s", timestamp, hex.EncodeToString(mac.Sum(nil)))
+}
+
+// VerifyWebhookSignature checks a signature header against the body, rejecting
+// signatures older than tolerance so captured requests can't be replayed later
+func VerifyWebhookSignature(secret, header string, body []byte, tolerance time.Duration) error {
+	var timestamp int64
+	var signature string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp, _ = strconv.ParseInt(value, 10, 64)
+		case "v1":
+			signature = value
+		}
+	}
+	if timestamp == 0 || signature == "" {
+		return fmt.Errorf("malformed webhook signature")
+	}
+
+	if age := time.Since(time.Unix(timestamp, 0)); age > tolerance || age < -tolerance {
+		return fmt.Errorf("webhook signature timestamp is outside the tolerance")
+	}
+
+	expected := SignWebhookPayload(secret, timestamp, body)
+	if !hmac.Equal([]byte(expected), []byte(fmt.Sprintf("t=%d,v1=%s", timestamp, signature))) {
+		return fmt.Errorf("webhook signature does not match")
+	}
+	return nil
+}
+
+func validateWebhook(subscription models.WebhookSubscription) error {
+	parsed, err := url.Parse(subscription.URL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("url must be an absolute http or https url")
+	}
+	for _, event := range subscription.Events {
+		if !slices.Contains(webhookEventTypes, event) {
+			return fmt.Errorf("unknown event type %q, must be one of %s", event, strings.Join(webhookEventTypes, ", "))
+		}
+	}
+	return nil
+}
+
+func withoutSecret(subscription models.WebhookSubscription) models.WebhookSubscription {
+	subscription.Secret = ""
+	return subscription
+}
@@ -0,0 +1,176 @@
+package service
+
+import (
+	"encoding/json"
+	"github.com/RA341/receipt-processor-challenge/config"
+	"github.com/RA341/receipt-processor-challenge/models"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestWebhookDispatcher() *WebhookDispatcher {
+	cfg := config.Default().Webhooks
+	cfg.RetryBackoff = config.Duration{Duration: time.Millisecond}
+	cfg.MaxBackoff = config.Duration{Duration: 5 * time.Millisecond}
+	cfg.MaxAttempts = 3
+	return NewWebhookDispatcher(cfg)
+}
+
+func waitForDeliveries(t *testing.T, webhooks *WebhookDispatcher, id string, count int) []models.WebhookDelivery {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		deliveries, err := webhooks.Deliveries(id)
+		if err != nil {
+			t.Fatalf("Failed to get deliveries: %v", err)
+		}
+		if len(deliveries) >= count {
+			return deliveries
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("Timed out waiting for %d deliveries", count)
+	return nil
+}
+
+func TestWebhookDispatcher_SignsAndRetries(t *testing.T) {
+	var secret string
+	var calls atomic.Int64
+	received := make(chan models.ReceiptEvent, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if err := VerifyWebhookSignature(secret, r.Header.Get(WebhookSignatureHeader), body, time.Minute); err != nil {
+			t.Errorf("Invalid signature: %v", err)
+		}
+		if VerifyWebhookSignature("whsec_other", r.Header.Get(WebhookSignatureHeader), body, time.Minute) == nil {
+			t.Errorf("Expected the signature to fail with another secret")
+		}
+
+		// fail the first attempt
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		var event models.ReceiptEvent
+		_ = json.Unmarshal(body, &event)
+		received <- event
+	}))
+	defer receiver.Close()
+
+	webhooks := newTestWebhookDispatcher()
+	defer webhooks.Close()
+	db, _ := NewDB()
+	srv := NewReceiptService(db, WithWebhookDispatcher(webhooks))
+
+	subscription, err := srv.CreateWebhook(models.WebhookSubscription{URL: receiver.URL, Events: []string{models.EventReceiptScored}})
+	if err != nil {
+		t.Fatalf("Failed to create webhook: %v", err)
+	}
+	secret = subscription.Secret
+
+	id, err := srv.NewReceipt(testMap["test 1"].receipt)
+	if err != nil {
+		t.Fatalf("Failed to submit receipt: %v", err)
+	}
+	// not subscribed to voids
+	if _, err := srv.VoidReceipt(id, "admin", "chargeback", nil); err != nil {
+		t.Fatalf("Failed to void receipt: %v", err)
+	}
+
+	select {
+	case event := <-received:
+		if event.Type != models.EventReceiptScored || event.ReceiptId != id || event.Points != testMap["test 1"].expectedPoints {
+			t.Fatalf("Unexpected event %+v", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for the webhook")
+	}
+
+	deliveries := waitForDeliveries(t, webhooks, subscription.Id, 2)
+	if len(deliveries) != 2 || !deliveries[0].Success || deliveries[0].Attempt != 2 || deliveries[1].StatusCode != http.StatusInternalServerError {
+		t.Fatalf("Expected a failed attempt followed by a successful one, got %+v", deliveries)
+	}
+
+	if err := srv.RedeliverWebhook(subscription.Id, deliveries[1].Id); err != nil {
+		t.Fatalf("Failed to redeliver: %v", err)
+	}
+	select {
+	case event := <-received:
+		if event.ReceiptId != id {
+			t.Fatalf("Unexpected redelivered event %+v", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for the redelivery")
+	}
+}
+
+func TestWebhookDispatcher_GivesUp(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer receiver.Close()
+
+	webhooks := newTestWebhookDispatcher()
+	defer webhooks.Close()
+	subscription, err := webhooks.Create(models.WebhookSubscription{URL: receiver.URL})
+	if err != nil {
+		t.Fatalf("Failed to create webhook: %v", err)
+	}
+
+	webhooks.Handle(models.ReceiptEvent{Id: "1", Type: models.EventReceiptVoided})
+	deliveries := waitForDeliveries(t, webhooks, subscription.Id, 3)
+
+	time.Sleep(50 * time.Millisecond)
+	if deliveries, _ := webhooks.Deliveries(subscription.Id); len(deliveries) != 3 || deliveries[0].Success {
+		t.Fatalf("Expected 3 failed attempts, got %+v", deliveries)
+	}
+	if deliveries[0].Attempt != 3 {
+		t.Fatalf("Expected the last attempt to be the third, got %+v", deliveries[0])
+	}
+}
+
+func TestWebhookDispatcher_CloseSendsRetries(t *testing.T) {
+	var calls atomic.Int64
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer receiver.Close()
+
+	cfg := config.Default().Webhooks
+	cfg.RetryBackoff = config.Duration{Duration: time.Hour}
+	webhooks := NewWebhookDispatcher(cfg)
+	subscription, err := webhooks.Create(models.WebhookSubscription{URL: receiver.URL})
+	if err != nil {
+		t.Fatalf("Failed to create webhook: %v", err)
+	}
+
+	webhooks.Handle(models.ReceiptEvent{Id: "1", Type: models.EventReceiptVoided})
+	waitForDeliveries(t, webhooks, subscription.Id, 1)
+
+	// the retry would wait an hour, closing sends it right away
+	webhooks.Close()
+	deliveries, _ := webhooks.Deliveries(subscription.Id)
+	if len(deliveries) != 2 || !deliveries[0].Success || deliveries[0].Attempt != 2 {
+		t.Fatalf("Expected the retry to be delivered on close, got %+v", deliveries)
+	}
+}
+
+func TestWebhookDispatcher_Validates(t *testing.T) {
+	webhooks := newTestWebhookDispatcher()
+	defer webhooks.Close()
+
+	for _, subscription := range []models.WebhookSubscription{
+		{URL: "ftp://example.com/hook"},
+		{URL: "/relative"},
+		{URL: "https://example.com/hook", Events: []string{"receipt.unknown"}},
+	} {
+		if _, err := webhooks.Create(subscription); err == nil {
+			t.Fatalf("Expected %+v to be rejected", subscription)
+		}
+	}
+}